@@ -0,0 +1,32 @@
+package client
+
+import (
+	"context"
+
+	iclient "code.selman.me/hauntty/internal/client"
+)
+
+// AttachOpts configures Attach. Cols and Rows are required; they are the
+// size of whatever renders the session's output.
+type AttachOpts = iclient.StreamOpts
+
+// Attachment is a session attached as an io.ReadWriteCloser. Reads start
+// with the session's current screen, VT-encoded, followed by its output.
+// Read returns an *ExitError once the session's process exits and io.EOF
+// once the attachment is detached or kicked. Writes are the session's
+// input. Read may run concurrently with Write and Resize.
+type Attachment = iclient.Stream
+
+// Attach attaches to the named session, creating it if needed, without
+// a terminal. ctx bounds only the attach itself. The Client belongs to
+// the Attachment afterwards; closing the Attachment detaches, leaving
+// the session running, and closes the Client.
+func (c *Client) Attach(ctx context.Context, opts AttachOpts) (*Attachment, error) {
+	var a *Attachment
+	err := c.c.Do(ctx, func() error {
+		var err error
+		a, err = c.c.AttachStream(opts)
+		return err
+	})
+	return a, err
+}
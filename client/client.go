@@ -0,0 +1,265 @@
+// Package client talks to a hauntty daemon: it lists, creates, drives
+// and inspects sessions, and attaches to them without a terminal.
+//
+// A Client is one connection to the daemon and serves one request at a
+// time. Every request takes a context; a request interrupted by its
+// context closes the Client, since the daemon's answer would otherwise
+// arrive for the next request.
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	iclient "code.selman.me/hauntty/internal/client"
+)
+
+// ProtocolVersion is the newest protocol version this package speaks.
+const ProtocolVersion = iclient.ProtocolVersion
+
+type Client struct {
+	c *iclient.Client
+}
+
+// Dial connects to the daemon listening on socketPath, or on the default
+// socket when socketPath is empty. It does not start a daemon.
+func Dial(ctx context.Context, socketPath string) (*Client, error) {
+	c, err := iclient.ConnectContext(ctx, socketPath)
+	if err != nil {
+		if iclient.DaemonUnavailable(err) {
+			return nil, fmt.Errorf("%w: %w", ErrDaemonUnavailable, err)
+		}
+		return nil, err
+	}
+	return &Client{c: c}, nil
+}
+
+func (c *Client) Close() error {
+	return c.c.Close()
+}
+
+// Protocol returns the protocol version negotiated with the daemon.
+func (c *Client) Protocol() uint8 {
+	return c.c.Protocol()
+}
+
+// Features returns the capabilities negotiated with the daemon.
+func (c *Client) Features() []Capability {
+	return c.c.Features()
+}
+
+func (c *Client) ListSessions(ctx context.Context, includeClients bool) ([]Session, error) {
+	var sessions []Session
+	err := c.c.Do(ctx, func() error {
+		var err error
+		sessions, err = c.c.ListSessions(includeClients)
+		return err
+	})
+	return sessions, err
+}
+
+// Status returns the daemon's status and, when name is not empty, the
+// named session's, live or dead.
+func (c *Client) Status(ctx context.Context, name string) (*Status, error) {
+	var status *Status
+	err := c.c.Do(ctx, func() error {
+		var err error
+		status, err = c.c.Status(name)
+		return err
+	})
+	return status, err
+}
+
+// CreateSession starts a session without attaching to it. An empty
+// opts.Name lets the daemon pick one.
+func (c *Client) CreateSession(ctx context.Context, opts CreateSessionOpts) (*CreatedSession, error) {
+	var created *CreatedSession
+	err := c.c.Do(ctx, func() error {
+		var err error
+		created, err = c.c.CreateSession(opts)
+		return err
+	})
+	return created, err
+}
+
+func (c *Client) Kill(ctx context.Context, name string) error {
+	return c.c.Do(ctx, func() error { return c.c.Kill(name) })
+}
+
+// Send writes data to the session's input.
+func (c *Client) Send(ctx context.Context, name string, data []byte) error {
+	return c.c.Do(ctx, func() error { return c.c.Send(name, data) })
+}
+
+func (c *Client) SendKey(ctx context.Context, name string, key KeyCode, mods Modifier) error {
+	return c.c.Do(ctx, func() error { return c.c.SendKey(name, key, mods) })
+}
+
+// Dump returns a reader over the session's rendered screen, live or
+// dead, streamed as the daemon renders it. ctx bounds reading it too,
+// and the Client serves nothing else until the reader returns an error
+// or io.EOF.
+func (c *Client) Dump(ctx context.Context, name string, format DumpFormat) (io.Reader, error) {
+	var r io.Reader
+	err := c.c.Do(ctx, func() error {
+		var err error
+		r, err = c.c.Dump(name, format)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &dumpReader{c: c, ctx: ctx, r: r}, nil
+}
+
+type dumpReader struct {
+	c   *Client
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *dumpReader) Read(p []byte) (n int, err error) {
+	err = r.c.c.Do(r.ctx, func() error {
+		n, err = r.r.Read(p)
+		return err
+	})
+	return n, err
+}
+
+// Watch blocks until the session's screen matches opts, reporting false
+// when opts.Timeout elapses first. A zero Timeout waits until ctx is
+// done, and a Timeout past ctx's deadline is capped to it, so the daemon
+// stops watching once ctx expires.
+func (c *Client) Watch(ctx context.Context, name string, opts WatchOpts) (bool, error) {
+	watch := iclient.WatchOpts{
+		Pattern: opts.Pattern,
+		Regex:   opts.Regex,
+		Row:     opts.Row - 1,
+		Timeout: opts.Timeout,
+		Stable:  opts.Stable,
+		Prompt:  opts.Prompt,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if until := time.Until(deadline); watch.Timeout == 0 || watch.Timeout > until {
+			watch.Timeout = until
+		}
+	}
+	var matched bool
+	err := c.c.Do(ctx, func() error {
+		var err error
+		matched, err = c.c.Watch(name, watch)
+		return err
+	})
+	return matched, err
+}
+
+// Search returns the lines of the named sessions, or of every live
+// session when names is empty, that match opts. A result that would
+// not fit in one response is truncated.
+func (c *Client) Search(ctx context.Context, names []string, opts SearchOpts) (*SearchResult, error) {
+	var result *SearchResult
+	err := c.c.Do(ctx, func() error {
+		var err error
+		result, err = c.c.Search(names, opts)
+		return err
+	})
+	return result, err
+}
+
+// History returns the commands a live session's shell has reported
+// through OSC 133 marks, oldest first.
+func (c *Client) History(ctx context.Context, name string) (*History, error) {
+	var history *History
+	err := c.c.Do(ctx, func() error {
+		var err error
+		history, err = c.c.History(name)
+		return err
+	})
+	return history, err
+}
+
+// Exec runs command at the prompt of a live session's shell and returns
+// its output and exit code. The shell must report OSC 133 marks. It
+// waits until ctx is done, leaving the command running if it has not
+// finished by then.
+func (c *Client) Exec(ctx context.Context, name, command string) (*ExecResult, error) {
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(time.Until(deadline), time.Millisecond)
+	}
+	var result *ExecResult
+	err := c.c.Do(ctx, func() error {
+		var err error
+		result, err = c.c.Exec(name, command, timeout)
+		return err
+	})
+	return result, err
+}
+
+func (c *Client) Kick(ctx context.Context, name, clientID string) error {
+	return c.c.Do(ctx, func() error { return c.c.Kick(name, clientID) })
+}
+
+// Monitor replaces the monitor settings of a live session.
+func (c *Client) Monitor(ctx context.Context, name string, settings MonitorSettings) error {
+	return c.c.Do(ctx, func() error { return c.c.Monitor(name, settings) })
+}
+
+// Upgrade replaces the daemon with the ht binary at path, an absolute
+// path, keeping its sessions running. It returns once the old daemon
+// has handed over; reconnect to reach the new one.
+func (c *Client) Upgrade(ctx context.Context, path string) error {
+	return c.c.Do(ctx, func() error { return c.c.Upgrade(path) })
+}
+
+// Prune deletes the saved state of dead sessions and returns how many
+// it deleted.
+func (c *Client) Prune(ctx context.Context) (uint32, error) {
+	var n uint32
+	err := c.c.Do(ctx, func() error {
+		var err error
+		n, err = c.c.Prune()
+		return err
+	})
+	return n, err
+}
+
+// StartLog logs the session's output to path, or to the daemon's log
+// directory when path is empty.
+func (c *Client) StartLog(ctx context.Context, name, path string, format LogFormat) error {
+	return c.c.Do(ctx, func() error { return c.c.StartLog(name, path, format) })
+}
+
+func (c *Client) StopLog(ctx context.Context, name string) error {
+	return c.c.Do(ctx, func() error { return c.c.StopLog(name) })
+}
+
+// StartRecording records the session as asciicast v2 to path.
+func (c *Client) StartRecording(ctx context.Context, name, path string) error {
+	return c.c.Do(ctx, func() error { return c.c.StartRecording(name, path) })
+}
+
+func (c *Client) StopRecording(ctx context.Context, name string) error {
+	return c.c.Do(ctx, func() error { return c.c.StopRecording(name) })
+}
+
+// Subscribe turns the Client into a stream of lifecycle events for the
+// named sessions, or every session when names is empty. Read them with
+// NextEvent; the Client serves no other request afterwards.
+func (c *Client) Subscribe(ctx context.Context, names []string) error {
+	return c.c.Do(ctx, func() error { return c.c.Subscribe(names) })
+}
+
+// NextEvent blocks until the daemon sends an event or ctx is done. It
+// returns io.EOF once the daemon closes the stream.
+func (c *Client) NextEvent(ctx context.Context) (Event, error) {
+	var ev Event
+	err := c.c.Do(ctx, func() error {
+		var err error
+		ev, err = c.c.NextEvent()
+		return err
+	})
+	return ev, err
+}
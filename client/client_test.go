@@ -0,0 +1,196 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/client"
+	"code.selman.me/hauntty/internal/protocol"
+	"gotest.tools/v3/assert"
+)
+
+// serveDaemon accepts one connection, negotiates like the daemon and
+// hands the connection to serve.
+func serveDaemon(t *testing.T, serve func(*protocol.Conn) error) (string, <-chan error) {
+	t.Helper()
+	dir, err := os.MkdirTemp("/tmp", "ht-sdk-")
+	assert.NilError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "hauntty.sock")
+	ln, err := net.Listen("unix", sock)
+	assert.NilError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		pc := protocol.NewConn(conn)
+		hello, err := pc.AcceptHandshake()
+		if err != nil {
+			done <- err
+			return
+		}
+		version, caps := protocol.Negotiate(hello)
+		if err := pc.WriteHandshakeReply(version, "server-revision", caps); err != nil {
+			done <- err
+			return
+		}
+		done <- serve(pc)
+	}()
+	return sock, done
+}
+
+func TestDialReportsDaemonUnavailable(t *testing.T) {
+	_, err := client.Dial(t.Context(), filepath.Join(t.TempDir(), "missing.sock"))
+	assert.Assert(t, errors.Is(err, client.ErrDaemonUnavailable), err)
+}
+
+func TestServerErrorsMatchSentinels(t *testing.T) {
+	sock, done := serveDaemon(t, func(pc *protocol.Conn) error {
+		for _, message := range []string{"session not found", "session already exists"} {
+			if _, err := pc.ReadMessage(); err != nil {
+				return err
+			}
+			if err := pc.WriteMessage(&protocol.Error{Message: message}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer c.Close()
+
+	err = c.Kill(t.Context(), "missing")
+	assert.Assert(t, errors.Is(err, client.ErrSessionNotFound), err)
+	var serverErr *client.ServerError
+	assert.Assert(t, errors.As(err, &serverErr))
+	assert.Equal(t, serverErr.Op, "kill")
+
+	_, err = c.CreateSession(t.Context(), client.CreateSessionOpts{Name: "taken"})
+	assert.Assert(t, errors.Is(err, client.ErrSessionExists), err)
+	assert.Assert(t, !errors.Is(err, client.ErrSessionNotFound))
+	assert.NilError(t, <-done)
+}
+
+func TestWatchSendsRowsAndTimeout(t *testing.T) {
+	var got []protocol.Watch
+	sock, done := serveDaemon(t, func(pc *protocol.Conn) error {
+		for range 2 {
+			msg, err := pc.ReadMessage()
+			if err != nil {
+				return err
+			}
+			got = append(got, *msg.(*protocol.Watch))
+			if err := pc.WriteMessage(&protocol.WatchResponse{Matched: true}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer c.Close()
+
+	// Without a deadline a zero Timeout goes out as zero, which the
+	// daemon takes as no timeout.
+	_, err = c.Watch(context.Background(), "build", client.WatchOpts{Pattern: "ok"})
+	assert.NilError(t, err)
+	_, err = c.Watch(context.Background(), "build", client.WatchOpts{Pattern: "ok", Row: 3, Timeout: time.Second})
+	assert.NilError(t, err)
+	assert.NilError(t, <-done)
+	assert.DeepEqual(t, got, []protocol.Watch{
+		{Name: "build", Pattern: "ok", Row: -1},
+		{Name: "build", Pattern: "ok", Row: 2, Timeout: 1000},
+	})
+}
+
+func TestContextInterruptsRequest(t *testing.T) {
+	sock, done := serveDaemon(t, func(pc *protocol.Conn) error {
+		if _, err := pc.ReadMessage(); err != nil {
+			return err
+		}
+		// Never answer; the client gives up and hangs up.
+		_, err := pc.ReadMessage()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	})
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.ListSessions(ctx, false)
+	assert.Assert(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.NilError(t, <-done)
+}
+
+func TestAttachStreamsOutputAndInput(t *testing.T) {
+	sock, done := serveDaemon(t, func(pc *protocol.Conn) error {
+		msg, err := pc.ReadMessage()
+		if err != nil {
+			return err
+		}
+		attach := msg.(*protocol.Attach)
+		assert.Equal(t, attach.Cols, uint16(100))
+		assert.Equal(t, attach.Rows, uint16(30))
+		if err := pc.WriteAttached(&protocol.Attached{Name: "demo", PID: 7, ClientID: "1", ScreenDump: []byte("screen|")}); err != nil {
+			return err
+		}
+		if err := pc.WriteMessage(&protocol.Output{Data: []byte("output")}); err != nil {
+			return err
+		}
+		msg, err = pc.ReadMessage()
+		if err != nil {
+			return err
+		}
+		assert.DeepEqual(t, msg, &protocol.Input{Data: []byte("ls\r")})
+		msg, err = pc.ReadMessage()
+		if err != nil {
+			return err
+		}
+		assert.DeepEqual(t, msg, &protocol.Resize{Cols: 120, Rows: 40})
+		return pc.WriteMessage(&protocol.Exited{ExitCode: 3})
+	})
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	a, err := c.Attach(t.Context(), client.AttachOpts{Name: "demo", Cols: 100, Rows: 30})
+	assert.NilError(t, err)
+	defer a.Close()
+	assert.Equal(t, a.Name, "demo")
+	assert.Equal(t, a.PID, uint32(7))
+
+	buf := make([]byte, len("screen|output"))
+	_, err = io.ReadFull(a, buf)
+	assert.NilError(t, err)
+	assert.Equal(t, string(buf), "screen|output")
+
+	_, err = a.Write([]byte("ls\r"))
+	assert.NilError(t, err)
+	assert.NilError(t, a.Resize(120, 40))
+
+	_, err = a.Read(buf)
+	var exitErr *client.ExitError
+	assert.Assert(t, errors.As(err, &exitErr), err)
+	assert.Equal(t, exitErr.Code, 3)
+	assert.NilError(t, <-done)
+}
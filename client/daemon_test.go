@@ -0,0 +1,301 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/client"
+	"code.selman.me/hauntty/daemon"
+	"gotest.tools/v3/assert"
+)
+
+// startDaemon serves an in-process daemon with cfg on a fresh socket
+// until the test ends and connects a Client to it.
+func startDaemon(t *testing.T, cfg daemon.Config) (string, *client.Client) {
+	t.Helper()
+	dir, err := os.MkdirTemp("/tmp", "ht-sdk-")
+	assert.NilError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "hauntty.sock")
+	ln, err := net.Listen("unix", sock)
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	srv, err := daemon.New(ctx, cfg,
+		daemon.WithListener(ln),
+		daemon.WithStateDir(filepath.Join(dir, "state")),
+		daemon.WithLogger(slog.New(slog.DiscardHandler)),
+		daemon.WithSignals(),
+	)
+	assert.NilError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+
+	c := dial(t, sock)
+	return sock, c
+}
+
+func dial(t *testing.T, sock string) *client.Client {
+	t.Helper()
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func ephemeralConfig() daemon.Config {
+	cfg := daemon.DefaultConfig()
+	cfg.StatePersistence = false
+	return cfg
+}
+
+func createSession(t *testing.T, c *client.Client, name string, command ...string) {
+	t.Helper()
+	_, err := c.CreateSession(t.Context(), client.CreateSessionOpts{Name: name, Command: command})
+	assert.NilError(t, err)
+}
+
+func waitFor(t *testing.T, c *client.Client, name string, opts client.WatchOpts) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	matched, err := c.Watch(ctx, name, opts)
+	assert.NilError(t, err)
+	assert.Assert(t, matched, "%s never showed %q", name, opts.Pattern)
+}
+
+func TestDaemonNegotiatesNewestProtocol(t *testing.T) {
+	_, c := startDaemon(t, ephemeralConfig())
+	assert.Equal(t, c.Protocol(), uint8(client.ProtocolVersion))
+	assert.Assert(t, slices.Contains(c.Features(), client.Capability("chunked-dump")), c.Features())
+}
+
+func TestSendKeysAndDump(t *testing.T) {
+	_, c := startDaemon(t, ephemeralConfig())
+	createSession(t, c, "typing", "/bin/sh", "-c", "stty -echo; echo ready; exec cat")
+	waitFor(t, c, "typing", client.WatchOpts{Pattern: "ready"})
+
+	assert.NilError(t, c.Send(t.Context(), "typing", []byte("typed")))
+	key, err := client.ParseKey("enter")
+	assert.NilError(t, err)
+	assert.Equal(t, key, client.KeyInput{Code: client.KeyEnter})
+	assert.NilError(t, c.SendKey(t.Context(), "typing", key.Code, key.Mods))
+	waitFor(t, c, "typing", client.WatchOpts{Pattern: "typed"})
+
+	r, err := c.Dump(t.Context(), "typing", client.DumpPlain)
+	assert.NilError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "ready\ntyped")
+}
+
+func TestDumpStreamsScrollback(t *testing.T) {
+	_, c := startDaemon(t, ephemeralConfig())
+	// A narrow screen keeps every line within the default scrollback.
+	_, err := c.CreateSession(t.Context(), client.CreateSessionOpts{
+		Name:    "long",
+		Command: []string{"/bin/sh", "-c", "i=1; while [ $i -le 5000 ]; do echo line-$i; i=$((i+1)); done; sleep 30"},
+		Cols:    20,
+		Rows:    5,
+	})
+	assert.NilError(t, err)
+	waitFor(t, c, "long", client.WatchOpts{Pattern: "line-5000"})
+
+	r, err := c.Dump(t.Context(), "long", client.DumpPlain|client.DumpFlagScrollback)
+	assert.NilError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NilError(t, err)
+	lines := strings.Split(string(data), "\n")
+	assert.Equal(t, len(lines), 5000)
+	for i, line := range lines {
+		assert.Equal(t, line, fmt.Sprintf("line-%d", i+1))
+	}
+
+	// The stream was read to its end, so the Client serves requests again.
+	_, err = c.ListSessions(t.Context(), false)
+	assert.NilError(t, err)
+}
+
+// markedShell runs each line it reads as a shell with OSC 133
+// integration would, marking its prompt and each command.
+const markedShell = `prompt() { printf '\033]133;A\007$ \033]133;B\007'; }
+prompt
+while read -r line; do
+	printf '\033]133;C\007'
+	eval "$line"
+	printf '\033]133;D;%s\007' $?
+	prompt
+done`
+
+func TestExecHistoryAndSearch(t *testing.T) {
+	_, c := startDaemon(t, ephemeralConfig())
+	createSession(t, c, "shell", "/bin/sh", "-c", markedShell)
+	waitFor(t, c, "shell", client.WatchOpts{Prompt: true})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	result, err := c.Exec(ctx, "shell", "echo found-it; false")
+	assert.NilError(t, err)
+	assert.Equal(t, string(result.Output), "found-it")
+	assert.Equal(t, *result.ExitCode, int32(1))
+	assert.Assert(t, !result.TimedOut)
+
+	history, err := c.History(t.Context(), "shell")
+	assert.NilError(t, err)
+	assert.Assert(t, history.AtPrompt)
+	assert.Equal(t, len(history.Commands), 1)
+	assert.Equal(t, *history.Commands[0].ExitCode, int32(1))
+
+	found, err := c.Search(t.Context(), nil, client.SearchOpts{Pattern: "^found-it$", Regex: true})
+	assert.NilError(t, err)
+	assert.Equal(t, len(found.Matches), 1)
+	assert.Equal(t, found.Matches[0].Session, "shell")
+	assert.Equal(t, found.Matches[0].Text, "found-it")
+}
+
+func TestKickDetachesClient(t *testing.T) {
+	sock, c := startDaemon(t, ephemeralConfig())
+	a, err := dial(t, sock).Attach(t.Context(), client.AttachOpts{
+		Name:    "shared",
+		Command: []string{"/bin/cat"},
+		Cols:    80,
+		Rows:    24,
+	})
+	assert.NilError(t, err)
+	defer a.Close()
+
+	sessions, err := c.ListSessions(t.Context(), true)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions), 1)
+	assert.Equal(t, len(sessions[0].Clients), 1)
+	assert.NilError(t, c.Kick(t.Context(), "shared", sessions[0].Clients[0].ClientID))
+
+	buf := make([]byte, 4096)
+	for {
+		if _, err := a.Read(buf); err != nil {
+			break
+		}
+	}
+	sessions, err = c.ListSessions(t.Context(), true)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions[0].Clients), 0)
+}
+
+func TestMonitorReplacesSettings(t *testing.T) {
+	_, c := startDaemon(t, ephemeralConfig())
+	createSession(t, c, "watched", "/bin/cat")
+
+	settings := client.MonitorSettings{Bell: true, Silence: 30, Pattern: "ERROR"}
+	assert.NilError(t, c.Monitor(t.Context(), "watched", settings))
+	status, err := c.Status(t.Context(), "watched")
+	assert.NilError(t, err)
+	assert.Equal(t, status.Session.Monitor, settings)
+}
+
+func TestUpgradeRefusedByEmbeddedDaemon(t *testing.T) {
+	_, c := startDaemon(t, ephemeralConfig())
+	err := c.Upgrade(t.Context(), "/bin/true")
+	var serverErr *client.ServerError
+	assert.Assert(t, errors.As(err, &serverErr), err)
+	assert.Equal(t, serverErr.Message, "this daemon cannot upgrade in place")
+}
+
+func TestPruneDeletesDeadSessions(t *testing.T) {
+	_, c := startDaemon(t, daemon.DefaultConfig())
+	createSession(t, c, "done", "/bin/sh", "-c", "exit 0")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sessions, err := c.ListSessions(t.Context(), false)
+		assert.NilError(t, err)
+		if len(sessions) == 1 && sessions[0].State == client.SessionStateDead {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session did not die: %+v", sessions)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	n, err := c.Prune(t.Context())
+	assert.NilError(t, err)
+	assert.Equal(t, n, uint32(1))
+	sessions, err := c.ListSessions(t.Context(), false)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions), 0)
+}
+
+func TestLogAndRecordOutput(t *testing.T) {
+	_, c := startDaemon(t, ephemeralConfig())
+	createSession(t, c, "taped", "/bin/sh", "-c", "stty -echo; echo ready; exec cat")
+	waitFor(t, c, "taped", client.WatchOpts{Pattern: "ready"})
+
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "taped.log")
+	castPath := filepath.Join(dir, "taped.cast")
+	assert.NilError(t, c.StartLog(t.Context(), "taped", logPath, client.LogPlain))
+	assert.NilError(t, c.StartRecording(t.Context(), "taped", castPath))
+	assert.NilError(t, c.Send(t.Context(), "taped", []byte("on-tape\r")))
+	waitFor(t, c, "taped", client.WatchOpts{Pattern: "on-tape"})
+	assert.NilError(t, c.StopLog(t.Context(), "taped"))
+	assert.NilError(t, c.StopRecording(t.Context(), "taped"))
+
+	log, err := os.ReadFile(logPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(log), "ready\non-tape\n")
+	cast, err := os.ReadFile(castPath)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasPrefix(string(cast), `{"version":2`), string(cast))
+	assert.Assert(t, strings.Contains(string(cast), "on-tape"), string(cast))
+}
+
+func TestSubscribeStreamsEvents(t *testing.T) {
+	sock, c := startDaemon(t, ephemeralConfig())
+	events := dial(t, sock)
+	assert.NilError(t, events.Subscribe(t.Context(), []string{"brief"}))
+
+	createSession(t, c, "brief", "/bin/sh", "-c", "exit 3")
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	created, err := events.NextEvent(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, created.Kind, "created")
+	assert.Equal(t, created.Session, "brief")
+	exited, err := events.NextEvent(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, exited.Kind, "exited")
+	assert.Equal(t, *exited.ExitCode, int32(3))
+	assert.Equal(t, exited.PID, created.PID)
+}
+
+func TestCanceledContextUnblocksRequest(t *testing.T) {
+	sock, _ := startDaemon(t, ephemeralConfig())
+	events := dial(t, sock)
+	assert.NilError(t, events.Subscribe(t.Context(), nil))
+
+	// No session ever starts, so only the context ends the wait.
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := events.NextEvent(ctx)
+	assert.Assert(t, errors.Is(err, context.Canceled), err)
+	assert.Assert(t, time.Since(start) < 5*time.Second)
+
+	// The interrupted request closed the Client.
+	_, err = events.NextEvent(t.Context())
+	assert.Assert(t, err != nil)
+}
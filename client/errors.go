@@ -0,0 +1,27 @@
+package client
+
+import (
+	"errors"
+
+	iclient "code.selman.me/hauntty/internal/client"
+)
+
+// ServerError is the daemon refusing a request. Match it against the
+// Err values below with errors.Is, or inspect it with errors.As.
+type ServerError = iclient.ServerError
+
+// ExitError is returned by Attachment.Read once the session's process
+// exits.
+type ExitError = iclient.ExitError
+
+var (
+	// ErrDaemonUnavailable means no daemon is listening on the socket.
+	ErrDaemonUnavailable = errors.New("daemon unavailable")
+	ErrSessionNotFound   = iclient.ErrSessionNotFound
+	// ErrSessionExists also covers a name held by a dead session's saved
+	// state; CreateSessionOpts.Force discards that state.
+	ErrSessionExists  = iclient.ErrSessionExists
+	ErrClientNotFound = iclient.ErrClientNotFound
+	// ErrNotNegotiated means the daemon is too old for the request.
+	ErrNotNegotiated = iclient.ErrNotNegotiated
+)
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"time"
+
+	iclient "code.selman.me/hauntty/internal/client"
+)
+
+// The types below are shared with the ht command; their JSON field
+// names are the schema of ht's --json output and stay stable.
+
+type (
+	Session         = iclient.Session
+	SessionClient   = iclient.SessionClient
+	SessionExit     = iclient.SessionExit
+	SessionState    = iclient.SessionState
+	Status          = iclient.Status
+	DaemonStatus    = iclient.DaemonStatus
+	SessionStatus   = iclient.SessionStatus
+	CreatedSession  = iclient.CreatedSession
+	Event           = iclient.Event
+	SearchMatch     = iclient.SearchMatch
+	SearchResult    = iclient.SearchResult
+	MonitorSettings = iclient.MonitorSettings
+	History         = iclient.History
+	ShellCommand    = iclient.ShellCommand
+	ExecResult      = iclient.ExecResult
+	Capability      = iclient.Capability
+)
+
+const (
+	SessionStateRunning = iclient.SessionStateRunning
+	SessionStateDead    = iclient.SessionStateDead
+)
+
+type CreateSessionOpts = iclient.CreateSessionOpts
+
+// WatchOpts is what Client.Watch waits for.
+type WatchOpts struct {
+	Pattern string
+	Regex   bool
+	// Row restricts matching to one visible row, counting from 1 at
+	// the top; zero matches the whole screen.
+	Row int
+	// Timeout zero waits until ctx is done or the session exits.
+	Timeout time.Duration
+	// Stable also requires the screen to stay unchanged this long.
+	Stable time.Duration
+	// Prompt also requires the shell to be at its prompt, as its OSC 133
+	// marks report; Pattern may then be empty.
+	Prompt bool
+}
+
+type SearchOpts = iclient.SearchOpts
+
+type DumpFormat = iclient.DumpFormat
+
+const (
+	DumpPlain = iclient.DumpPlain
+	DumpVT    = iclient.DumpVT
+	DumpHTML  = iclient.DumpHTML
+	// DumpFlagUnwrap joins soft-wrapped lines and DumpFlagScrollback
+	// includes scrollback; both are ORed into a format.
+	DumpFlagUnwrap     = iclient.DumpFlagUnwrap
+	DumpFlagScrollback = iclient.DumpFlagScrollback
+	// DumpFlagLastCommand limits the dump to the output of the latest
+	// shell command; the shell must emit OSC 133 marks.
+	DumpFlagLastCommand = iclient.DumpFlagLastCommand
+)
+
+type LogFormat = iclient.LogFormat
+
+const (
+	LogDefault = iclient.LogDefault
+	LogRaw     = iclient.LogRaw
+	LogPlain   = iclient.LogPlain
+)
+
+type RestartPolicy = iclient.RestartPolicy
+
+const (
+	RestartDefault   = iclient.RestartDefault
+	RestartNever     = iclient.RestartNever
+	RestartOnFailure = iclient.RestartOnFailure
+	RestartAlways    = iclient.RestartAlways
+)
+
+type (
+	KeyCode  = iclient.KeyCode
+	Modifier = iclient.Modifier
+	KeyInput = iclient.KeyInput
+)
+
+const (
+	KeyEnter     = iclient.KeyEnter
+	KeyEscape    = iclient.KeyEscape
+	KeyTab       = iclient.KeyTab
+	KeyBackspace = iclient.KeyBackspace
+	KeyUp        = iclient.KeyUp
+	KeyDown      = iclient.KeyDown
+	KeyLeft      = iclient.KeyLeft
+	KeyRight     = iclient.KeyRight
+	KeyHome      = iclient.KeyHome
+	KeyEnd       = iclient.KeyEnd
+	KeyPageUp    = iclient.KeyPageUp
+	KeyPageDown  = iclient.KeyPageDown
+	KeyInsert    = iclient.KeyInsert
+	KeyDelete    = iclient.KeyDelete
+	KeyF1        = iclient.KeyF1
+	KeyF2        = iclient.KeyF2
+	KeyF3        = iclient.KeyF3
+	KeyF4        = iclient.KeyF4
+	KeyF5        = iclient.KeyF5
+	KeyF6        = iclient.KeyF6
+	KeyF7        = iclient.KeyF7
+	KeyF8        = iclient.KeyF8
+	KeyF9        = iclient.KeyF9
+	KeyF10       = iclient.KeyF10
+	KeyF11       = iclient.KeyF11
+	KeyF12       = iclient.KeyF12
+)
+
+const (
+	ModShift = iclient.ModShift
+	ModCtrl  = iclient.ModCtrl
+	ModAlt   = iclient.ModAlt
+	ModSuper = iclient.ModSuper
+)
+
+// ParseKey parses key notation such as "enter", "ctrl+c" or "alt+f4", as
+// `ht send --key` does.
+func ParseKey(notation string) (KeyInput, error) {
+	return iclient.ParseKeyNotation(notation)
+}
@@ -82,8 +82,11 @@ func completionDynamicTopics() map[string]string {
 	return map[string]string{
 		"attach":  "live_sessions",
 		"dump":    "dumpable_sessions",
+		"exec":    "live_sessions",
+		"history": "live_sessions",
 		"kill":    "live_sessions",
 		"kick":    "live_sessions",
+		"monitor": "live_sessions",
 		"restore": "dead_sessions",
 		"send":    "live_sessions",
 		"status":  "sessions",
@@ -1,9 +1,17 @@
 package e2e_test
 
 import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"gotest.tools/v3/assert"
 	"gotest.tools/v3/golden"
 	"gotest.tools/v3/icmd"
 
@@ -90,6 +98,118 @@ func TestWaitRegex(t *testing.T) {
 	bad.Assert(t, icmd.Expected{ExitCode: 1, Err: "ht: error: invalid regex: error parsing regexp: missing closing ]: `[`\n"})
 }
 
+func TestShellMarksHistory(t *testing.T) {
+	cfg := config.Default()
+	cfg.Daemon.AutoExit = true
+	e := setup(t, cfg)
+
+	// A shell with OSC 133 integration would write these marks around
+	// its prompt and each command.
+	script := `prompt() { printf '\033]133;A\007$ \033]133;B\007'; }
+prompt; sleep 1
+printf 'false\r\n\033]133;C\007first\r\n\033]133;D;1\007'; prompt
+printf 'echo second\r\n\033]133;C;cmdline_url=echo%%20second\007second\r\n'; sleep 1
+printf '\033]133;D;0\007'; prompt
+sleep 30`
+	created := e.run("new", "marks", "--", "/bin/sh", "-c", script)
+	created.Assert(t, icmd.Success)
+
+	notYet := e.run("wait", "marks", "second", "--prompt", "-t", "500")
+	notYet.Assert(t, icmd.Expected{ExitCode: 1, Err: "timeout waiting for \"second\"\n"})
+
+	prompt := e.run("wait", "marks", "second", "--prompt", "-t", "5000")
+	prompt.Assert(t, icmd.Success)
+
+	history := e.run("history", "marks", "--format", "{{.AtPrompt}}{{range .Commands}}|{{.Command}}:{{.OutputRows}}{{end}}")
+	history.Assert(t, icmd.Expected{ExitCode: 0, Out: "true|false:1|echo second:1\n"})
+
+	last := e.run("dump", "marks", "--last-command")
+	last.Assert(t, icmd.Success)
+	assert.Equal(t, last.Stdout(), "second")
+
+	none := e.run("history", "marks-missing")
+	none.Assert(t, icmd.Expected{ExitCode: 1, Err: "session not found"})
+}
+
+func TestExecAtPrompt(t *testing.T) {
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not installed")
+	}
+
+	cfg := config.Default()
+	cfg.Daemon.AutoExit = true
+	e := setup(t, cfg)
+
+	// Plain bash reporting OSC 133 marks from its prompt variables.
+	created := e.run("new", "exec-shell", "--", "env",
+		`PS1=\[\e]133;A\a\]$ \[\e]133;B\a\]`,
+		`PS0=\e]133;C\a`,
+		`PROMPT_COMMAND=printf '\033]133;D;%s\007' $?`,
+		bashPath, "--norc", "--noprofile", "-i")
+	created.Assert(t, icmd.Success)
+	e.waitForCommandSuccess("wait", "exec-shell", "--prompt", "-t", "5000")
+
+	echo := e.run("exec", "exec-shell", "--", "echo", "one;", "echo", "two")
+	echo.Assert(t, icmd.Expected{ExitCode: 0})
+	assert.Equal(t, echo.Stdout(), "one\ntwo\n")
+
+	fail := e.run("exec", "exec-shell", "--", "echo oops; exit_code() { return 3; }; exit_code")
+	fail.Assert(t, icmd.Expected{ExitCode: 3})
+	assert.Equal(t, fail.Stdout(), "oops\n")
+
+	timeout := e.run("exec", "exec-shell", "-t", "200", "--", "sleep", "2")
+	timeout.Assert(t, icmd.Expected{ExitCode: 124, Err: "timeout: command still running in session \"exec-shell\"\n"})
+
+	busy := e.run("exec", "exec-shell", "--", "true")
+	busy.Assert(t, icmd.Expected{ExitCode: 1, Err: "the shell is not at its prompt"})
+
+	noMarks := e.run("new", "exec-plain", "--", "/bin/sh")
+	noMarks.Assert(t, icmd.Success)
+	plain := e.run("exec", "exec-plain", "--", "true")
+	plain.Assert(t, icmd.Expected{ExitCode: 1, Err: "is shell integration enabled?"})
+}
+
+func TestScriptDrivesSession(t *testing.T) {
+	cfg := config.Default()
+	cfg.Daemon.AutoExit = true
+	e := setup(t, cfg)
+
+	created := e.run("new", "scripted", "--", "/bin/sh")
+	created.Assert(t, icmd.Success)
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "screen.txt")
+	file := filepath.Join(dir, "ok.ht")
+	assert.NilError(t, os.WriteFile(file, []byte(`# Drive a plain shell.
+send "echo value-$((40 + 2))\r"
+wait -e -t 5s 'value-[0-9]+'
+assert value-42
+assert --not value-43
+send 'sleep 5'
+key enter
+wait -t 200ms never-printed else interrupt
+fail should have timed out
+interrupt:
+key ctrl+c
+send "echo after\r"
+wait --stable 100 after
+dump `+out+`
+`), 0o644))
+	ok := e.run("script", "-s", "scripted", file)
+	ok.Assert(t, icmd.Success)
+	screen, err := os.ReadFile(out)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(screen), "value-42"), string(screen))
+	assert.Assert(t, strings.Contains(string(screen), "after"), string(screen))
+
+	failing := filepath.Join(dir, "fail.ht")
+	assert.NilError(t, os.WriteFile(failing, []byte("session scripted\n\nassert value-43\n"), 0o644))
+	fail := e.run("script", failing)
+	fail.Assert(t, icmd.Expected{ExitCode: 1, Err: failing + ":3: assert value-43: assertion failed\n--- screen of \"scripted\" ---\n"})
+	assert.Assert(t, strings.Contains(fail.Stderr(), "value-42"), fail.Stderr())
+}
+
 func TestDumpPlain(t *testing.T) {
 	cfg := config.Default()
 	cfg.Client.DetachKeybind = "ctrl+]"
@@ -147,6 +267,34 @@ func TestDumpFormats(t *testing.T) {
 	golden.Assert(t, html.Stdout(), "dump_html.golden")
 }
 
+func TestDumpScrollbackIsContiguous(t *testing.T) {
+	cfg := config.Default()
+	cfg.Daemon.DefaultScrollback = 10000000
+	e := setup(t, cfg)
+
+	// Full-width lines, so the scrollback dump is as large as the
+	// terminal keeps.
+	created := e.run("new", "big", "--", "/bin/sh", "-c", "seq -f '%079g' 1 5000; echo seq-done; sleep 30")
+	created.Assert(t, icmd.Success)
+	wait := e.run("wait", "big", "seq-done", "-t", "10000")
+	wait.Assert(t, icmd.Success)
+
+	dump := e.run("dump", "big", "--scrollback")
+	dump.Assert(t, icmd.Success)
+	lines := strings.Split(dump.Stdout(), "\n")
+	done := slices.Index(lines, "seq-done")
+	assert.Assert(t, done > 100, "scrollback missing from dump")
+	first, err := strconv.Atoi(lines[0])
+	assert.NilError(t, err)
+	for i, line := range lines[:done] {
+		assert.Equal(t, line, fmt.Sprintf("%079d", first+i))
+	}
+	assert.Equal(t, lines[done-1], fmt.Sprintf("%079d", 5000))
+
+	kill := e.run("kill", "big")
+	kill.Assert(t, icmd.Success)
+}
+
 func TestDumpDeadSessionPreservesFormats(t *testing.T) {
 	cfg := config.Default()
 	cfg.Client.DetachKeybind = "ctrl+]"
@@ -1,6 +1,7 @@
 package e2e_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"regexp"
@@ -14,6 +15,7 @@ import (
 	"code.selman.me/hauntty/internal/termtest"
 	"code.selman.me/hauntty/libghostty"
 	"gotest.tools/v3/assert"
+	"gotest.tools/v3/golden"
 	"gotest.tools/v3/icmd"
 )
 
@@ -56,7 +58,7 @@ func TestListSessionsFiltering(t *testing.T) {
 		rows := make([][]string, len(lines))
 		for i, line := range lines {
 			rows[i] = splitCols.Split(strings.TrimRight(line, " "), -1)
-			if len(rows[i]) == 6 {
+			if len(rows[i]) == 8 {
 				rows[i] = append(rows[i][:3], append([]string{""}, rows[i][3:]...)...)
 			}
 		}
@@ -86,6 +88,10 @@ func TestListSessionsFiltering(t *testing.T) {
 		if s.SavedAt != 0 {
 			saved = time.Unix(int64(s.SavedAt), 0).Format("2006-01-02 15:04:05")
 		}
+		exit := "-"
+		if s.ExitCode != nil {
+			exit = strconv.Itoa(int(*s.ExitCode))
+		}
 		return []string{
 			s.Name,
 			string(s.State),
@@ -94,20 +100,22 @@ func TestListSessionsFiltering(t *testing.T) {
 			pid,
 			created,
 			saved,
+			exit,
+			"-",
 		}
 	}
 
 	list := e.run("list")
 	list.Assert(t, icmd.Expected{ExitCode: 0})
 	assert.DeepEqual(t, parseRows(list.Stdout()), [][]string{
-		{"NAME", "STATE", "SIZE", "CWD", "PID", "CREATED", "SAVED"},
+		{"NAME", "STATE", "SIZE", "CWD", "PID", "CREATED", "SAVED", "EXIT", "ALERTS"},
 		formatRow(rowsByName["alive"]),
 	})
 
 	listAll := e.run("list", "-a")
 	listAll.Assert(t, icmd.Expected{ExitCode: 0})
 	assert.DeepEqual(t, parseRows(listAll.Stdout()), [][]string{
-		{"NAME", "STATE", "SIZE", "CWD", "PID", "CREATED", "SAVED"},
+		{"NAME", "STATE", "SIZE", "CWD", "PID", "CREATED", "SAVED", "EXIT", "ALERTS"},
 		formatRow(rowsByName["alive"]),
 		formatRow(rowsByName["dead"]),
 	})
@@ -131,6 +139,7 @@ func TestStatusSessionContext(t *testing.T) {
 	e.waitAttachedPrompt(sh)
 	sh.Type("$HT_BIN status\n")
 	sh.WaitFor("session:  status-session")
+	sh.WaitFor("protocol 9, features: watch,log,record,search,client-info")
 	sh.Key(libghostty.KeyBracketRight, libghostty.ModCtrl)
 	sh.WaitFor("detached")
 }
@@ -157,3 +166,51 @@ func TestCommandsWithoutDaemon(t *testing.T) {
 	status := e.run("status")
 	status.Assert(t, icmd.Expected{ExitCode: 1, Err: message})
 }
+
+func TestJSONOutput(t *testing.T) {
+	cfg := config.Default()
+	cfg.Daemon.AutoExit = true
+	cfg.Daemon.StatePersistence = true
+	e := setup(t, cfg)
+
+	live := e.run("new", "json-live", "--", "/bin/sh", "-c", "sleep 30")
+	live.Assert(t, icmd.Success)
+	dead := e.run("new", "json-dead", "--", "/bin/sh", "-c", "exit 0")
+	dead.Assert(t, icmd.Success)
+	e.waitForStateFile("json-dead")
+
+	list := e.run("list", "--all", "--json")
+	list.Assert(t, icmd.Success)
+	golden.Assert(t, normalizeJSON(t, list.Stdout()), "list_json.golden")
+
+	format := e.run("list", "--all", "--format", "{{.Name}} {{.State}} {{len .Clients}}")
+	format.Assert(t, icmd.Expected{ExitCode: 0, Out: "json-live running 0\njson-dead dead 0\n"})
+
+	status := e.run("status", "--json")
+	status.Assert(t, icmd.Success)
+	golden.Assert(t, normalizeJSON(t, status.Stdout()), "status_json.golden")
+
+	prune := e.run("prune", "--json")
+	prune.Assert(t, icmd.Expected{ExitCode: 0, Out: "{\n  \"pruned\": 1\n}\n"})
+
+	kill := e.run("kill", "json-live")
+	kill.Assert(t, icmd.Success)
+}
+
+var jsonVaryingRE = regexp.MustCompile(`"(pid|created_at|saved_at|exited_at|cwd|uptime|socket_path|version)": ("[^"]*"|[0-9]+)`)
+
+// normalizeJSON replaces values that vary between runs, keeping the keys,
+// their order and whether a value is zero.
+func normalizeJSON(t *testing.T, out string) string {
+	t.Helper()
+
+	var v any
+	assert.NilError(t, json.Unmarshal([]byte(out), &v))
+	return jsonVaryingRE.ReplaceAllStringFunc(out, func(field string) string {
+		m := jsonVaryingRE.FindStringSubmatch(field)
+		if (m[2] == "0" || m[2] == `""`) && m[1] != "uptime" {
+			return field
+		}
+		return `"` + m[1] + `": <` + m[1] + `>`
+	})
+}
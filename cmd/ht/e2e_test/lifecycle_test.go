@@ -1,9 +1,11 @@
 package e2e_test
 
 import (
+	"encoding/json"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -199,6 +201,43 @@ func TestDaemonAutoExit(t *testing.T) {
 	}
 }
 
+func TestDaemonUpgradeKeepsSessions(t *testing.T) {
+	cfg := config.Default()
+	cfg.Daemon.AutoExit = true
+	e := setup(t, cfg)
+
+	created := e.run("new", "upgrade-session", "--", "/bin/sh", "-c", "echo before-upgrade; exec cat")
+	created.Assert(t, icmd.Expected{ExitCode: 0, Out: "created session \"upgrade-session\""})
+	e.run("wait", "upgrade-session", "before-upgrade", "-t", "5000").Assert(t, icmd.Success)
+	before := e.run("status", "--json")
+	before.Assert(t, icmd.Success)
+
+	upgrade := e.run("daemon", "upgrade", "--binary", htBin)
+	upgrade.Assert(t, icmd.Expected{ExitCode: 0, Out: "1 session(s) running"})
+
+	after := e.run("status", "--json")
+	after.Assert(t, icmd.Success)
+	var beforeStatus, afterStatus struct {
+		Daemon struct {
+			PID int `json:"pid"`
+		} `json:"daemon"`
+	}
+	assert.NilError(t, json.Unmarshal([]byte(before.Stdout()), &beforeStatus))
+	assert.NilError(t, json.Unmarshal([]byte(after.Stdout()), &afterStatus))
+	assert.Equal(t, afterStatus.Daemon.PID, beforeStatus.Daemon.PID)
+
+	dump := e.run("dump", "upgrade-session")
+	dump.Assert(t, icmd.Success)
+	assert.Assert(t, strings.Contains(dump.Stdout(), "before-upgrade"), dump.Stdout())
+
+	e.run("send", "upgrade-session", "after-upgrade").Assert(t, icmd.Success)
+	e.run("send", "upgrade-session", "--key", "enter").Assert(t, icmd.Success)
+	e.run("wait", "upgrade-session", "after-upgrade", "-t", "5000").Assert(t, icmd.Success)
+
+	kill := e.run("kill", "upgrade-session")
+	kill.Assert(t, icmd.Expected{ExitCode: 0, Out: "killed session \"upgrade-session\"\n"})
+}
+
 func TestNewCreatesSession(t *testing.T) {
 	cfg := config.Default()
 	cfg.Daemon.AutoExit = true
@@ -234,6 +273,27 @@ func TestNewWithCommand(t *testing.T) {
 	kill.Assert(t, icmd.Expected{ExitCode: 0, Out: "killed session \"new-command\"\n"})
 }
 
+func TestNewWithSize(t *testing.T) {
+	cfg := config.Default()
+	cfg.Daemon.AutoExit = true
+	e := setup(t, cfg)
+
+	created := e.run("new", "sized", "--size", "100x30", "--resize-policy", "fixed", "--", "/bin/sh", "-c", "stty size; exec cat")
+	created.Assert(t, icmd.Expected{ExitCode: 0, Out: "created session \"sized\""})
+
+	wait := e.run("wait", "sized", "30 100", "-t", "5000")
+	wait.Assert(t, icmd.Success)
+	status := e.run("status", "sized")
+	status.Assert(t, icmd.Success)
+	assert.Assert(t, strings.Contains(status.Stdout(), "size:     100x30\n"), status.Stdout())
+
+	bad := e.run("new", "bad-size", "--size", "100")
+	bad.Assert(t, icmd.Expected{ExitCode: 1, Out: `--size: invalid size "100", want WIDTHxHEIGHT`})
+
+	kill := e.run("kill", "sized")
+	kill.Assert(t, icmd.Expected{ExitCode: 0, Out: "killed session \"sized\"\n"})
+}
+
 func TestNewUsesGhosttyBashIntegration(t *testing.T) {
 	bashPath, err := exec.LookPath("bash")
 	if err != nil {
@@ -408,6 +468,37 @@ func TestRestoreDeadSession(t *testing.T) {
 	e.waitHostPrompt(restoreSh)
 }
 
+func TestRestoreRerunsOriginalCommand(t *testing.T) {
+	cfg := config.Default()
+	cfg.Daemon.StatePersistence = true
+	e := setup(t, cfg)
+
+	daemon := e.term([]string{htBin, "daemon"})
+	daemon.WaitFor("daemon listening")
+
+	workDir := t.TempDir()
+	script := `echo run >> runs; echo "runs=$(( $(wc -l < runs) ))"; exit 3`
+	created := icmd.RunCmd(
+		icmd.Command(htBin, "new", "rerun", "--", "/bin/sh", "-c", script),
+		icmd.WithEnv(append(os.Environ(), e.env()...)...),
+		icmd.Dir(workDir),
+	)
+	created.Assert(t, icmd.Success)
+	e.waitForStateFile("rerun")
+
+	status := e.waitForCommandSuccess("status", "rerun")
+	assert.Assert(t, strings.Contains(status.Stdout(), "state:    dead\n"), status.Stdout())
+	assert.Assert(t, strings.Contains(status.Stdout(), "cwd:      "+workDir+"\n"), status.Stdout())
+	assert.Assert(t, strings.Contains(status.Stdout(), "command:  /bin/sh -c "+script+"\n"), status.Stdout())
+	assert.Assert(t, strings.Contains(status.Stdout(), "exit:     3 at "), status.Stdout())
+
+	restoreSh := e.term([]string{"/bin/sh"}, termtest.WithEnv("PS1=$ ", "SHELL=/bin/sh"))
+	e.waitHostPrompt(restoreSh)
+	restoreSh.Type("$HT_BIN restore rerun\n")
+	restoreSh.WaitFor("runs=2")
+	restoreSh.WaitFor("session exited")
+}
+
 func TestRestoreDeadSessionAfterHostOutput(t *testing.T) {
 	cfg := config.Default()
 	cfg.Client.DetachKeybind = "ctrl+]"
@@ -472,3 +563,151 @@ func TestRestoreRunningSessionFails(t *testing.T) {
 	kill := e.run("kill", "running")
 	kill.Assert(t, icmd.Expected{ExitCode: 0})
 }
+
+func TestUpDownWorkspace(t *testing.T) {
+	cfg := config.Default()
+	cfg.Daemon.AutoExit = true
+	e := setup(t, cfg)
+
+	dir := t.TempDir()
+	assert.NilError(t, os.Mkdir(filepath.Join(dir, "api"), 0o755))
+	workspace := filepath.Join(dir, "hauntty.toml")
+	assert.NilError(t, os.WriteFile(workspace, []byte(`[[session]]
+name = "ws-db"
+command = ["/bin/sh", "-c", "sleep 0.3; echo db-ready; sleep 30"]
+wait = { pattern = "db-ready", timeout = 5000 }
+
+[[session]]
+name = "ws-api"
+command = ["/bin/sh", "-c", "echo \"api port=$PORT dir=$(basename \"$PWD\")\"; sleep 30"]
+cwd = "api"
+env = { PORT = "8080" }
+`), 0o644))
+
+	up := e.run("up", workspace)
+	up.Assert(t, icmd.Success)
+	assert.Assert(t, strings.Contains(up.Stdout(), "created session \"ws-db\""))
+	assert.Assert(t, strings.Contains(up.Stdout(), "created session \"ws-api\""))
+
+	dump := e.run("dump", "ws-db")
+	dump.Assert(t, icmd.Expected{ExitCode: 0, Out: "db-ready"})
+
+	wait := e.run("wait", "ws-api", "api port=8080 dir=api", "-t", "5000")
+	wait.Assert(t, icmd.Success)
+
+	again := e.run("up", workspace)
+	again.Assert(t, icmd.Expected{
+		ExitCode: 0,
+		Out:      "session \"ws-db\" already running\nsession \"ws-api\" already running\n",
+	})
+
+	down := e.run("down", workspace)
+	down.Assert(t, icmd.Expected{
+		ExitCode: 0,
+		Out:      "killed session \"ws-api\"\nkilled session \"ws-db\"\n",
+	})
+}
+
+func TestNewRestartOnFailure(t *testing.T) {
+	cfg := config.Default()
+	cfg.Daemon.StatePersistence = true
+	cfg.Daemon.Restart.MaxRetries = 2
+	cfg.Daemon.Restart.BackoffMS = 50
+	e := setup(t, cfg)
+
+	created := e.run("new", "flaky", "--restart", "on-failure", "--", "/bin/sh", "-c", "echo run; sleep 0.2; exit 3")
+	created.Assert(t, icmd.Expected{ExitCode: 0, Out: "created session \"flaky\""})
+
+	e.waitForStateFile("flaky")
+
+	status := e.run("status", "flaky")
+	status.Assert(t, icmd.Success)
+	assert.Assert(t, strings.Contains(status.Stdout(), "state:    dead\n"), status.Stdout())
+	assert.Assert(t, strings.Contains(status.Stdout(), "exit:     3 at "), status.Stdout())
+
+	dump := e.run("dump", "flaky")
+	dump.Assert(t, icmd.Success)
+	assert.Equal(t, strings.Count(dump.Stdout(), "run"), 3, dump.Stdout())
+	assert.Assert(t, strings.Contains(dump.Stdout(), "[hauntty] exited with code 3, restarting (1/2)"), dump.Stdout())
+	assert.Assert(t, strings.Contains(dump.Stdout(), "[hauntty] exited with code 3, restarting (2/2)"), dump.Stdout())
+}
+
+func TestRunExitsWithCommandCode(t *testing.T) {
+	e := setup(t, nil)
+
+	run := e.run("run", "-n", "job", "--", "/bin/sh", "-c", "echo job-output; exit 3")
+	run.Assert(t, icmd.Expected{ExitCode: 3, Out: "job-output", Err: `running in session "job"`})
+
+	again := e.run("run", "-n", "job", "--", "/bin/true")
+	again.Assert(t, icmd.Expected{ExitCode: 1, Err: `session "job" already exists; use ht run --resume job`})
+
+	e.waitForStateFile("job")
+	resumed := e.run("run", "--resume", "job")
+	resumed.Assert(t, icmd.Expected{ExitCode: 3})
+}
+
+func TestRunDetachAndWaitExit(t *testing.T) {
+	e := setup(t, nil)
+
+	started := e.run("run", "-d", "-n", "bg", "--", "/bin/sh", "-c", "echo bg-output; sleep 0.5; exit 4")
+	started.Assert(t, icmd.Expected{ExitCode: 0, Out: `started session "bg"`})
+
+	pending := e.run("wait", "bg", "--exit", "-t", "50")
+	pending.Assert(t, icmd.Expected{ExitCode: 124, Err: `timeout: session "bg" still running`})
+
+	wait := e.run("wait", "bg", "--exit")
+	wait.Assert(t, icmd.Expected{ExitCode: 4})
+
+	resumed := e.run("run", "--resume", "bg")
+	resumed.Assert(t, icmd.Expected{ExitCode: 4})
+
+	missing := e.run("wait", "nope", "--exit")
+	missing.Assert(t, icmd.Expected{ExitCode: 2, Err: "session not found: nope"})
+}
+
+func TestRunResumeFollowsRunningSession(t *testing.T) {
+	e := setup(t, nil)
+
+	started := e.run("run", "-d", "-n", "follow", "--", "/bin/sh", "-c", "echo first; read line; echo got-$line; sleep 1; exit 5")
+	started.Assert(t, icmd.Success)
+	e.run("wait", "follow", "first", "-t", "5000").Assert(t, icmd.Success)
+
+	resume := icmd.StartCmd(icmd.Cmd{
+		Command: []string{htBin, "run", "--resume", "follow"},
+		Env:     append(os.Environ(), e.env()...),
+	})
+	e.run("send", "follow", "again\n").Assert(t, icmd.Success)
+	result := icmd.WaitOnCmd(5*time.Second, resume)
+	result.Assert(t, icmd.Expected{ExitCode: 5, Out: "got-again"})
+}
+
+func TestEventsStreamsLifecycle(t *testing.T) {
+	e := setup(t, nil)
+
+	daemon := e.term([]string{htBin, "daemon"})
+	daemon.WaitFor("daemon listening")
+
+	events := e.term([]string{htBin, "events", "-s", "watched"}, termtest.WithSize(120, 24))
+
+	// The subscription races with the first create; recreate the
+	// session until the stream shows it.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		created := e.run("new", "-f", "watched", "--", "/bin/sh", "-c", "sleep 30")
+		created.Assert(t, icmd.Success)
+		time.Sleep(100 * time.Millisecond)
+		if strings.Contains(events.Screen(), "created") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("events never showed created:\n%s", events.Screen())
+		}
+		e.run("kill", "watched").Assert(t, icmd.Success)
+	}
+
+	e.run("new", "other").Assert(t, icmd.Success)
+	e.run("kill", "watched").Assert(t, icmd.Success)
+	events.WaitFor("exited    watched exit=129 (SIGHUP)")
+	assert.Assert(t, !strings.Contains(events.Screen(), "other"), events.Screen())
+	e.run("kill", "other").Assert(t, icmd.Success)
+}
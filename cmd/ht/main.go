@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"cmp"
 	"context"
 	"errors"
@@ -9,8 +10,10 @@ import (
 	"log/slog"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
@@ -18,6 +21,7 @@ import (
 	"time"
 
 	hauntty "code.selman.me/hauntty"
+	"code.selman.me/hauntty/internal/asciicast"
 	"code.selman.me/hauntty/internal/client"
 	"code.selman.me/hauntty/internal/config"
 	"code.selman.me/hauntty/internal/daemon"
@@ -32,13 +36,25 @@ type CLI struct {
 	Socket     string            `help:"Unix socket path override." env:"HAUNTTY_SOCKET"`
 	Attach     AttachCmd         `cmd:"" aliases:"a" help:"Attach to a session (create if needed)."`
 	New        NewCmd            `cmd:"" help:"Create a session without attaching."`
+	Run        RunCmd            `cmd:"" help:"Run a command in a session, stream its output and exit with its code."`
 	Restore    RestoreCmd        `cmd:"" help:"Restore a dead session from saved state."`
+	Up         UpCmd             `cmd:"" help:"Create the sessions defined in a workspace file."`
+	Down       DownCmd           `cmd:"" help:"Kill the sessions defined in a workspace file."`
 	List       ListCmd           `cmd:"" aliases:"ls" help:"List sessions."`
 	Kill       KillCmd           `cmd:"" help:"Kill a session."`
 	Send       SendCmd           `cmd:"" help:"Send input to a session."`
+	Exec       ExecCmd           `cmd:"" help:"Run a command at a session's shell prompt and print its output."`
 	Dump       DumpCmd           `cmd:"" help:"Dump session contents."`
+	History    HistoryCmd        `cmd:"" help:"List the shell commands a session has run."`
 	Kick       KickCmd           `cmd:"" help:"Disconnect a specific attached client."`
+	Monitor    MonitorCmd        `cmd:"" help:"Set what a detached session raises alerts for."`
+	Log        LogCmd            `cmd:"" help:"Start or stop logging session output to disk."`
+	Record     RecordCmd         `cmd:"" help:"Start or stop recording a session as asciicast v2."`
+	Play       PlayCmd           `cmd:"" help:"Play an asciicast recording in this terminal."`
 	Wait       WaitCmd           `cmd:"" help:"Wait for session output to match a pattern."`
+	Script     ScriptCmd         `cmd:"" help:"Run a script of send, wait and assert steps over one connection."`
+	Grep       GrepCmd           `cmd:"" help:"Search session screens and scrollback."`
+	Events     EventsCmd         `cmd:"" help:"Stream session and client lifecycle events."`
 	Status     StatusCmd         `cmd:"" aliases:"st" help:"Show daemon and session status."`
 	Prune      PruneCmd          `cmd:"" help:"Delete dead session state files."`
 	Init       InitCmd           `cmd:"" help:"Create default config file."`
@@ -49,9 +65,11 @@ type CLI struct {
 }
 
 type AttachCmd struct {
-	Name     string   `arg:"" optional:"" help:"Session name."`
-	Command  []string `arg:"" optional:"" help:"Command to run."`
-	ReadOnly bool     `short:"r" help:"Attach in read-only mode (no input forwarded)."`
+	Name      string   `arg:"" optional:"" help:"Session name."`
+	Command   []string `arg:"" optional:"" help:"Command to run."`
+	ReadOnly  bool     `short:"r" help:"Attach in read-only mode (no input forwarded)."`
+	Log       string   `type:"path" help:"Log output of a newly created session to this file."`
+	LogFormat string   `enum:"default,raw,plain" default:"default" help:"Log format (default, raw, plain)."`
 }
 
 func (cmd *AttachCmd) Run(cfg *config.Config) error {
@@ -82,22 +100,48 @@ func (cmd *AttachCmd) Run(cfg *config.Config) error {
 
 	command := resolveDefaultCommand(cmd.Command, cfg)
 
+	var notices <-chan string
+	if cfg.Client.ShowAlerts {
+		// The attached session raises no alerts, so every alert is
+		// about another session.
+		notices = watchAlerts(cfg.Daemon.SocketPath)
+	}
+
 	return c.RunAttach(client.AttachOpts{
 		Name:      cmd.Name,
 		Command:   command,
 		DetachKey: dk,
 		Metadata:  attachMetadataFunc(cfg.Client.ForwardEnv, os.LookupEnv),
 		ReadOnly:  cmd.ReadOnly,
+		LogPath:   cmd.Log,
+		LogFormat: logRequestFormat(cmd.LogFormat),
+		Notices:   notices,
 	})
 }
 
 type NewCmd struct {
-	Name    string   `arg:"" optional:"" help:"Session name."`
-	Command []string `arg:"" optional:"" help:"Command to run."`
-	Force   bool     `short:"f" help:"Overwrite dead session state if it exists."`
+	Name         string      `arg:"" optional:"" help:"Session name."`
+	Command      []string    `arg:"" optional:"" help:"Command to run."`
+	Force        bool        `short:"f" help:"Overwrite dead session state if it exists."`
+	Log          string      `type:"path" help:"Log session output to this file."`
+	LogFormat    string      `enum:"default,raw,plain" default:"default" help:"Log format (default, raw, plain)."`
+	Restart      string      `enum:"default,never,on-failure,always" default:"default" help:"Restart the command when it exits (default, never, on-failure, always)."`
+	Size         config.Size `placeholder:"COLSxROWS" help:"Terminal size until a client attaches (default 80x24)."`
+	PixelSize    config.Size `placeholder:"WIDTHxHEIGHT" help:"Terminal size in pixels; requires --size."`
+	ResizePolicy string      `enum:"default,smallest,largest,first,last,fixed" default:"default" help:"Resize policy as clients attach (default, smallest, largest, first, last, fixed)."`
+}
+
+func (cmd *NewCmd) validate() error {
+	if cmd.PixelSize != (config.Size{}) && cmd.Size == (config.Size{}) {
+		return fmt.Errorf("--pixel-size requires --size")
+	}
+	return nil
 }
 
 func (cmd *NewCmd) Run(cfg *config.Config) error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
 	if err := ensureDaemon(cfg.Daemon.SocketPath); err != nil {
 		return err
 	}
@@ -113,11 +157,19 @@ func (cmd *NewCmd) Run(cfg *config.Config) error {
 	}
 
 	created, err := c.CreateSession(client.CreateSessionOpts{
-		Name:    cmd.Name,
-		Command: resolveDefaultCommand(cmd.Command, cfg),
-		Env:     collectForwardedEnv(cfg.Client.ForwardEnv, os.LookupEnv),
-		CWD:     cwd,
-		Force:   cmd.Force,
+		Name:         cmd.Name,
+		Command:      resolveDefaultCommand(cmd.Command, cfg),
+		Env:          collectForwardedEnv(cfg.Client.ForwardEnv, os.LookupEnv),
+		CWD:          cwd,
+		Force:        cmd.Force,
+		LogPath:      cmd.Log,
+		LogFormat:    logRequestFormat(cmd.LogFormat),
+		Restart:      restartRequestPolicy(cmd.Restart),
+		Cols:         cmd.Size.Width,
+		Rows:         cmd.Size.Height,
+		Xpixel:       cmd.PixelSize.Width,
+		Ypixel:       cmd.PixelSize.Height,
+		ResizePolicy: resizeRequestPolicy(cmd.ResizePolicy),
 	})
 	if err != nil {
 		return err
@@ -127,8 +179,290 @@ func (cmd *NewCmd) Run(cfg *config.Config) error {
 	return nil
 }
 
+type RunCmd struct {
+	Command []string    `arg:"" optional:"" help:"Command to run."`
+	Name    string      `short:"n" help:"Session name (default: generated)."`
+	Resume  string      `placeholder:"NAME" help:"Stream a session started by ht run again."`
+	Detach  bool        `short:"d" help:"Return once the session starts; get its exit code with ht wait --exit."`
+	Size    config.Size `placeholder:"COLSxROWS" help:"Terminal size (default: this terminal's, or 80x24)."`
+}
+
+func (cmd *RunCmd) validate() error {
+	if cmd.Resume != "" {
+		if len(cmd.Command) > 0 || cmd.Name != "" || cmd.Detach {
+			return fmt.Errorf("--resume takes no command, --name or --detach")
+		}
+		return nil
+	}
+	if len(cmd.Command) == 0 {
+		return fmt.Errorf("run requires a command")
+	}
+	return nil
+}
+
+func (cmd *RunCmd) Run(cfg *config.Config) error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+	if cmd.Resume == "" {
+		if err := ensureDaemon(cfg.Daemon.SocketPath); err != nil {
+			return err
+		}
+	}
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("get cwd: %w", err)
+	}
+	size := cmd.Size
+	if size == (config.Size{}) {
+		size = config.Size{Width: 80, Height: 24}
+		if ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ); err == nil && ws.Col > 0 && ws.Row > 0 {
+			size = config.Size{Width: ws.Col, Height: ws.Row}
+		}
+	}
+
+	if cmd.Detach {
+		created, err := c.CreateSession(client.CreateSessionOpts{
+			Name:    cmd.Name,
+			Command: cmd.Command,
+			Env:     collectForwardedEnv(cfg.Client.ForwardEnv, os.LookupEnv),
+			CWD:     cwd,
+			Cols:    size.Width,
+			Rows:    size.Height,
+		})
+		if errors.Is(err, client.ErrSessionExists) {
+			return runExistsError(cmd.Name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("started session %q (pid %d)\n", created.Name, created.PID)
+		return nil
+	}
+
+	name := cmd.Resume
+	if name != "" {
+		status, err := c.Status(name)
+		if err != nil {
+			return err
+		}
+		if status.Session == nil {
+			return fmt.Errorf("session not found: %s", name)
+		}
+		if status.Session.State == client.SessionStateDead {
+			return runExitError(name, status.Session.SessionExit)
+		}
+	}
+	stream, err := c.AttachStream(client.StreamOpts{
+		Name:     cmp.Or(name, cmd.Name),
+		Command:  cmd.Command,
+		Cols:     size.Width,
+		Rows:     size.Height,
+		Env:      collectForwardedEnv(cfg.Client.ForwardEnv, os.LookupEnv),
+		CWD:      cwd,
+		ReadOnly: true,
+	})
+	if cmd.Resume == "" && errors.Is(err, client.ErrSessionExists) {
+		return runExistsError(cmd.Name)
+	}
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	if cmd.Resume == "" && !stream.Created {
+		return runExistsError(stream.Name)
+	}
+	if stream.Created {
+		fmt.Fprintf(os.Stderr, "[hauntty] running in session %q (pid %d)\n", stream.Name, stream.PID)
+	}
+	return followRun(stream)
+}
+
+func runExistsError(name string) error {
+	return fmt.Errorf("session %q already exists; use ht run --resume %s", name, name)
+}
+
+// followRun copies the session's output to stdout until its command
+// exits. An interrupt detaches instead; the command keeps running.
+func followRun(stream *client.Stream) error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	detached := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			detached <- sig
+			stream.Close()
+		case <-stop:
+		}
+	}()
+
+	_, err := io.Copy(os.Stdout, stream)
+	if exitErr, ok := errors.AsType[*client.ExitError](err); ok {
+		return &commandExitError{code: exitErr.Code}
+	}
+	if err != nil {
+		return err
+	}
+	var sig os.Signal
+	select {
+	case sig = <-detached:
+	default:
+		return fmt.Errorf("session %q: connection to the daemon lost", stream.Name)
+	}
+	code := 1
+	if s, ok := sig.(syscall.Signal); ok {
+		code = 128 + int(s)
+	}
+	return &commandExitError{
+		code:   code,
+		stderr: fmt.Sprintf("[hauntty] detached, session %q keeps running; resume with: ht run --resume %s\n", stream.Name, stream.Name),
+	}
+}
+
+// runExitError turns a session's exit status into ht's own. A dead
+// session without one, such as a job the daemon saved while it was
+// still running, is an error rather than a success.
+func runExitError(name string, exit client.SessionExit) error {
+	if exit.ExitCode == nil {
+		return &commandExitError{code: 2, stderr: fmt.Sprintf("error: session %q ended without an exit status\n", name)}
+	}
+	if *exit.ExitCode == 0 {
+		return nil
+	}
+	return &commandExitError{code: int(*exit.ExitCode)}
+}
+
+type UpCmd struct {
+	File  string `arg:"" optional:"" type:"path" default:"hauntty.toml" help:"Workspace file."`
+	Force bool   `short:"f" help:"Overwrite dead session state if it exists."`
+}
+
+func (cmd *UpCmd) Run(cfg *config.Config) error {
+	ws, err := config.LoadWorkspace(cmd.File)
+	if err != nil {
+		return err
+	}
+
+	if err := ensureDaemon(cfg.Daemon.SocketPath); err != nil {
+		return err
+	}
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	running, err := runningSessionNames(c)
+	if err != nil {
+		return err
+	}
+
+	forwarded := collectForwardedEnv(cfg.Client.ForwardEnv, os.LookupEnv)
+	for _, st := range ws.Sessions {
+		if running[st.Name] {
+			fmt.Printf("session %q already running\n", st.Name)
+			continue
+		}
+		created, err := c.CreateSession(client.CreateSessionOpts{
+			Name:         st.Name,
+			Command:      resolveDefaultCommand(st.Command, cfg),
+			Env:          append(slices.Clone(forwarded), st.EnvList()...),
+			CWD:          st.CWD,
+			Scrollback:   st.Scrollback,
+			Force:        cmd.Force,
+			Restart:      restartRequestPolicy(string(st.Restart)),
+			Cols:         st.Size.Width,
+			Rows:         st.Size.Height,
+			Xpixel:       st.PixelSize.Width,
+			Ypixel:       st.PixelSize.Height,
+			ResizePolicy: string(st.ResizePolicy),
+		})
+		if err != nil {
+			return fmt.Errorf("create session %q: %w", st.Name, err)
+		}
+		fmt.Printf("created session %q (pid %d)\n", created.Name, created.PID)
+
+		if !st.Wait.Enabled() {
+			continue
+		}
+		matched, err := c.Watch(st.Name, client.WatchOpts{
+			Pattern: st.Wait.Pattern,
+			Regex:   st.Wait.Regex,
+			Row:     -1,
+			Timeout: st.Wait.TimeoutDuration(),
+			Stable:  time.Duration(st.Wait.Stable) * time.Millisecond,
+		})
+		if err != nil {
+			return fmt.Errorf("wait for session %q: %w", st.Name, err)
+		}
+		if !matched {
+			return fmt.Errorf("session %q: %s", st.Name, strings.TrimSuffix(waitTimeoutMessage(st.Wait.Pattern, false), "\n"))
+		}
+	}
+	return nil
+}
+
+type DownCmd struct {
+	File string `arg:"" optional:"" type:"path" default:"hauntty.toml" help:"Workspace file."`
+}
+
+func (cmd *DownCmd) Run(cfg *config.Config) error {
+	ws, err := config.LoadWorkspace(cmd.File)
+	if err != nil {
+		return err
+	}
+
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	running, err := runningSessionNames(c)
+	if err != nil {
+		return err
+	}
+
+	// Kill in reverse so sessions go down before the ones they wait on.
+	for _, st := range slices.Backward(ws.Sessions) {
+		if !running[st.Name] {
+			continue
+		}
+		if err := c.Kill(st.Name); err != nil {
+			return fmt.Errorf("kill session %q: %w", st.Name, err)
+		}
+		fmt.Printf("killed session %q\n", st.Name)
+	}
+	return nil
+}
+
+func runningSessionNames(c *client.Client) (map[string]bool, error) {
+	sessions, err := c.ListSessions(false)
+	if err != nil {
+		return nil, err
+	}
+	running := make(map[string]bool, len(sessions))
+	for _, s := range sessions {
+		if s.State == client.SessionStateRunning {
+			running[s.Name] = true
+		}
+	}
+	return running, nil
+}
+
 type ListCmd struct {
 	All bool `short:"a" help:"Show all sessions including dead."`
+	outputFlags
 }
 
 func (cmd *ListCmd) Run(cfg *config.Config) error {
@@ -141,11 +475,15 @@ func (cmd *ListCmd) Run(cfg *config.Config) error {
 	}
 	defer c.Close()
 
-	sessions, err := c.ListSessions(false)
+	sessions, err := c.ListSessions(!cmd.text())
 	if err != nil {
 		return err
 	}
 
+	if !cmd.text() {
+		return cmd.writeSessions(os.Stdout, filterSessions(sessions, cmd.All))
+	}
+
 	home, err := os.UserHomeDir()
 	if err != nil {
 		slog.Debug("resolve home dir", "err", err)
@@ -160,8 +498,18 @@ func (cmd *ListCmd) Run(cfg *config.Config) error {
 	return writeSessionRows(os.Stdout, rows)
 }
 
+func filterSessions(sessions []client.Session, showAll bool) []client.Session {
+	out := make([]client.Session, 0, len(sessions))
+	for _, s := range sessions {
+		if showAll || s.State != client.SessionStateDead {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
 func sessionListRows(sessions []client.Session, showAll bool, home string) [][]string {
-	rows := [][]string{{"NAME", "STATE", "SIZE", "CWD", "PID", "CREATED", "SAVED"}}
+	rows := [][]string{{"NAME", "STATE", "SIZE", "CWD", "PID", "CREATED", "SAVED", "EXIT", "ALERTS"}}
 	for _, s := range sessions {
 		if !showAll && s.State == client.SessionStateDead {
 			continue
@@ -178,6 +526,8 @@ func sessionListRows(sessions []client.Session, showAll bool, home string) [][]s
 			formatSessionPID(s.PID),
 			formatSessionTimestamp(s.CreatedAt),
 			formatSessionTimestamp(s.SavedAt),
+			formatSessionExit(s.SessionExit),
+			formatAlerts(s.Alerts),
 		})
 	}
 	return rows
@@ -186,7 +536,7 @@ func sessionListRows(sessions []client.Session, showAll bool, home string) [][]s
 func writeSessionRows(w io.Writer, rows [][]string) error {
 	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
 	for _, row := range rows {
-		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", row[0], row[1], row[2], row[3], row[4], row[5], row[6]); err != nil {
+		if _, err := fmt.Fprintln(tw, strings.Join(row, "\t")); err != nil {
 			return err
 		}
 	}
@@ -200,6 +550,23 @@ func formatSessionPID(pid uint32) string {
 	return strconv.FormatUint(uint64(pid), 10)
 }
 
+func formatSessionExit(exit client.SessionExit) string {
+	if exit.ExitCode == nil {
+		return "-"
+	}
+	if exit.ExitSignal != "" {
+		return fmt.Sprintf("%d (%s)", *exit.ExitCode, exit.ExitSignal)
+	}
+	return strconv.Itoa(int(*exit.ExitCode))
+}
+
+func formatAlerts(alerts []string) string {
+	if len(alerts) == 0 {
+		return "-"
+	}
+	return strings.Join(alerts, ",")
+}
+
 func formatSessionTimestamp(ts uint32) string {
 	if ts == 0 {
 		return "-"
@@ -259,11 +626,45 @@ func (cmd *SendCmd) Run(cfg *config.Config) error {
 	return nil
 }
 
+type ExecCmd struct {
+	Name    string   `arg:"" help:"Session name."`
+	Command []string `arg:"" help:"Command line to type at the prompt; words are joined with spaces."`
+	Timeout int      `short:"t" help:"Give up after this many milliseconds, leaving the command running (default: wait forever)."`
+}
+
+func (cmd *ExecCmd) Run(cfg *config.Config) error {
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	result, err := c.Exec(cmd.Name, strings.Join(cmd.Command, " "), time.Duration(max(cmd.Timeout, 0))*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	if result.TimedOut {
+		return &commandExitError{code: 124, stderr: fmt.Sprintf("timeout: command still running in session %q\n", cmd.Name)}
+	}
+	if len(result.Output) > 0 && !bytes.HasSuffix(result.Output, []byte("\n")) {
+		result.Output = append(result.Output, '\n')
+	}
+	if _, err := os.Stdout.Write(result.Output); err != nil {
+		return err
+	}
+	if result.ExitCode == nil || *result.ExitCode == 0 {
+		return nil
+	}
+	return &commandExitError{code: int(*result.ExitCode)}
+}
+
 type DumpCmd struct {
-	Name       string `arg:"" optional:"" help:"Session name (default: current session)."`
-	Format     string `enum:"plain,vt,html" default:"plain" help:"Output format (plain, vt, html)."`
-	Join       bool   `short:"J" help:"Join soft-wrapped lines."`
-	Scrollback bool   `short:"S" help:"Include scrollback history."`
+	Name        string `arg:"" optional:"" help:"Session name (default: current session)."`
+	Format      string `enum:"plain,vt,html" default:"plain" help:"Output format (plain, vt, html)."`
+	Join        bool   `short:"J" help:"Join soft-wrapped lines."`
+	Scrollback  bool   `short:"S" help:"Include scrollback history."`
+	LastCommand bool   `short:"L" help:"Dump only the output of the last shell command (needs OSC 133 shell integration)."`
+	JSON        bool   `name:"json" help:"Print the dump and its options as JSON."`
 }
 
 func (cmd *DumpCmd) Run(cfg *config.Config) error {
@@ -275,6 +676,9 @@ func (cmd *DumpCmd) Run(cfg *config.Config) error {
 	}
 
 	format := dumpRequestFormat(cmd.Format, cmd.Join, cmd.Scrollback)
+	if cmd.LastCommand {
+		format |= client.DumpFlagLastCommand
+	}
 
 	c, err := client.Connect(cfg.Daemon.SocketPath)
 	if err != nil {
@@ -282,12 +686,26 @@ func (cmd *DumpCmd) Run(cfg *config.Config) error {
 	}
 	defer c.Close()
 
-	data, err := c.Dump(cmd.Name, format)
+	r, err := c.Dump(cmd.Name, format)
 	if err != nil {
 		return err
 	}
-	_, err = os.Stdout.Write(data)
-	return err
+	if !cmd.JSON {
+		_, err = io.Copy(os.Stdout, r)
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return outputFlags{JSON: true}.write(os.Stdout, dumpResult{
+		Name:        cmd.Name,
+		Format:      cmd.Format,
+		Join:        cmd.Join,
+		Scrollback:  cmd.Scrollback,
+		LastCommand: cmd.LastCommand,
+		Data:        string(data),
+	})
 }
 
 func dumpRequestFormat(format string, join, scrollback bool) client.DumpFormat {
@@ -307,9 +725,71 @@ func dumpRequestFormat(format string, join, scrollback bool) client.DumpFormat {
 	return value
 }
 
+type HistoryCmd struct {
+	Name string `arg:"" optional:"" help:"Session name (default: current session)."`
+	outputFlags
+}
+
+func (cmd *HistoryCmd) Run(cfg *config.Config) error {
+	if cmd.Name == "" {
+		cmd.Name = os.Getenv("HAUNTTY_SESSION")
+		if cmd.Name == "" {
+			return fmt.Errorf("session name required (or run inside a hauntty session)")
+		}
+	}
+
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	history, err := c.History(cmd.Name)
+	if err != nil {
+		return err
+	}
+	if !cmd.text() {
+		return cmd.write(os.Stdout, history)
+	}
+	if len(history.Commands) == 0 {
+		fmt.Fprintln(os.Stderr, "no commands recorded; is shell integration enabled?")
+		return nil
+	}
+	return writeSessionRows(os.Stdout, historyRows(history.Commands))
+}
+
+func historyRows(commands []client.ShellCommand) [][]string {
+	rows := [][]string{{"STARTED", "DURATION", "EXIT", "OUTPUT", "COMMAND"}}
+	for _, c := range commands {
+		duration, exit := "running", "-"
+		if c.FinishedAt != 0 {
+			duration = (time.Duration(c.DurationMS) * time.Millisecond).String()
+		}
+		if c.ExitCode != nil {
+			exit = strconv.Itoa(int(*c.ExitCode))
+		}
+		output := "-"
+		switch {
+		case c.Trimmed:
+			output = "trimmed"
+		case c.OutputRows > 0:
+			output = fmt.Sprintf("%d-%d", c.OutputStartRow, c.OutputStartRow+c.OutputRows-1)
+		}
+		rows = append(rows, []string{
+			formatSessionTimestamp(c.StartedAt),
+			duration,
+			exit,
+			output,
+			c.Command,
+		})
+	}
+	return rows
+}
+
 type RestoreCmd struct {
-	Name     string `arg:"" help:"Session name to restore."`
-	ReadOnly bool   `short:"r" help:"Attach in read-only mode."`
+	Name     string   `arg:"" help:"Session name to restore."`
+	Command  []string `arg:"" optional:"" help:"Command to run instead of the session's original command."`
+	ReadOnly bool     `short:"r" help:"Attach in read-only mode."`
 }
 
 func (cmd *RestoreCmd) Run(cfg *config.Config) error {
@@ -341,6 +821,7 @@ func (cmd *RestoreCmd) Run(cfg *config.Config) error {
 		Metadata:  attachMetadataFunc(cfg.Client.ForwardEnv, os.LookupEnv),
 		ReadOnly:  cmd.ReadOnly,
 		Restore:   true,
+		Command:   cmd.Command,
 	})
 }
 
@@ -363,7 +844,63 @@ func (cmd *KickCmd) Run(cfg *config.Config) error {
 	return nil
 }
 
-type StatusCmd struct{}
+type MonitorCmd struct {
+	Name     string        `arg:"" help:"Session name."`
+	Bell     bool          `help:"Alert on bells and OSC 9/777 notifications."`
+	Activity bool          `help:"Alert on any output."`
+	Silence  time.Duration `help:"Alert once output stops for this long (e.g. 30s)."`
+	Pattern  string        `help:"Alert when an output line matches this regex."`
+}
+
+// Run replaces the session's monitor settings; with no flags it turns
+// monitoring off.
+func (cmd *MonitorCmd) Run(cfg *config.Config) error {
+	if cmd.Silence < 0 || (cmd.Silence > 0 && cmd.Silence < time.Second) {
+		return fmt.Errorf("--silence must be at least 1s")
+	}
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	settings := client.MonitorSettings{
+		Bell:     cmd.Bell,
+		Activity: cmd.Activity,
+		Silence:  uint32(cmd.Silence / time.Second),
+		Pattern:  cmd.Pattern,
+	}
+	if err := c.Monitor(cmd.Name, settings); err != nil {
+		return err
+	}
+	fmt.Printf("monitoring session %q: %s\n", cmd.Name, formatMonitor(settings))
+	return nil
+}
+
+func formatMonitor(m client.MonitorSettings) string {
+	var parts []string
+	if m.Bell {
+		parts = append(parts, "bell")
+	}
+	if m.Activity {
+		parts = append(parts, "activity")
+	}
+	if m.Silence > 0 {
+		parts = append(parts, "silence "+(time.Duration(m.Silence)*time.Second).String())
+	}
+	if m.Pattern != "" {
+		parts = append(parts, fmt.Sprintf("pattern %q", m.Pattern))
+	}
+	if len(parts) == 0 {
+		return "off"
+	}
+	return strings.Join(parts, ", ")
+}
+
+type StatusCmd struct {
+	Name string `arg:"" optional:"" help:"Session name, live or dead (default: current session)."`
+	outputFlags
+}
 
 func (cmd *StatusCmd) Run(cfg *config.Config) error {
 	c, err := client.Connect(cfg.Daemon.SocketPath)
@@ -375,10 +912,20 @@ func (cmd *StatusCmd) Run(cfg *config.Config) error {
 	}
 	defer c.Close()
 
-	resp, err := c.Status(os.Getenv("HAUNTTY_SESSION"))
+	name := cmd.Name
+	if name == "" {
+		name = os.Getenv("HAUNTTY_SESSION")
+	}
+	resp, err := c.Status(name)
 	if err != nil {
 		return err
 	}
+	if cmd.Name != "" && resp.Session == nil {
+		return fmt.Errorf("session not found: %s", cmd.Name)
+	}
+	if !cmd.text() {
+		return cmd.write(os.Stdout, resp)
+	}
 
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -403,20 +950,62 @@ func (cmd *StatusCmd) Run(cfg *config.Config) error {
 		fmt.Printf("state:    %s\n", s.State)
 		fmt.Printf("size:     %dx%d\n", s.Cols, s.Rows)
 		fmt.Printf("cwd:      %s\n", cwd)
-		fmt.Printf("pid:      %d\n", s.PID)
+		fmt.Printf("pid:      %s\n", formatSessionPID(s.PID))
+		if len(s.Command) > 0 {
+			fmt.Printf("command:  %s\n", strings.Join(s.Command, " "))
+		}
+		if s.ExitCode != nil {
+			fmt.Printf("exit:     %s at %s\n", formatSessionExit(s.SessionExit), formatSessionTimestamp(s.ExitedAt))
+		}
+		if s.Restart == client.RestartOnFailure.String() || s.Restart == client.RestartAlways.String() {
+			fmt.Printf("restart:  %s (%d restarts)\n", s.Restart, s.Restarts)
+		}
+		fmt.Printf("monitor:  %s\n", formatMonitor(s.Monitor))
+		if len(s.Alerts) > 0 {
+			fmt.Printf("alerts:   %s\n", formatAlerts(s.Alerts))
+		}
 		fmt.Printf("clients:  %d\n", len(s.Clients))
+		if s.SlowKicks > 0 {
+			fmt.Printf("          %d kicked for falling behind\n", s.SlowKicks)
+		}
 		for _, cl := range s.Clients {
 			ro := ""
 			if cl.ReadOnly {
 				ro = " (read-only)"
 			}
-			fmt.Printf("  [%s]:   %s%s\n", cl.ClientID, cl.Version, ro)
+			fmt.Printf("  [%s]:   %s pid=%s%s\n", cl.ClientID, cl.Version, formatSessionPID(cl.PID), ro)
+			if cl.Protocol != 0 {
+				fmt.Printf("          %s\n", formatClientFeatures(cl))
+			}
+			if cl.Resyncs > 0 {
+				fmt.Printf("          %s\n", formatClientLag(cl))
+			}
 		}
 	}
 
 	return nil
 }
 
+func formatClientFeatures(cl client.SessionClient) string {
+	compat := ""
+	if cl.Protocol < client.ProtocolVersion {
+		compat = " (compat)"
+	}
+	features := make([]string, len(cl.Features))
+	for i, f := range cl.Features {
+		features[i] = string(f)
+	}
+	return fmt.Sprintf("protocol %d%s, features: %s", cl.Protocol, compat, cmp.Or(strings.Join(features, ","), "none"))
+}
+
+func formatClientLag(cl client.SessionClient) string {
+	times := "times"
+	if cl.Resyncs == 1 {
+		times = "time"
+	}
+	return fmt.Sprintf("fell behind %d %s, resynced (%d bytes dropped)", cl.Resyncs, times, cl.Dropped)
+}
+
 func formatUptime(seconds uint32) string {
 	d := time.Duration(seconds) * time.Second
 	h := int(d.Hours())
@@ -431,20 +1020,176 @@ func formatUptime(seconds uint32) string {
 	return fmt.Sprintf("%ds", s)
 }
 
-type WaitCmd struct {
-	Name     string `arg:"" help:"Session name."`
-	Pattern  string `arg:"" help:"Pattern to match."`
-	Regex    bool   `short:"e" help:"Use regex matching."`
-	Timeout  int    `short:"t" default:"30000" help:"Timeout in milliseconds."`
-	Row      int    `default:"-1" help:"Only check specific row (0-indexed)."`
-	Interval int    `default:"100" help:"Poll interval in milliseconds."`
+type LogCmd struct {
+	Start LogStartCmd `cmd:"" help:"Start logging a session's output."`
+	Stop  LogStopCmd  `cmd:"" help:"Stop logging a session's output."`
 }
 
-func (cmd *WaitCmd) Run(cfg *config.Config) error {
-	match, err := compileWaitMatcher(cmd.Pattern, cmd.Regex)
+type LogStartCmd struct {
+	Name   string `arg:"" help:"Session name."`
+	Path   string `arg:"" optional:"" type:"path" help:"Log file (default: <session_log.dir>/<name>.log)."`
+	Format string `enum:"default,raw,plain" default:"default" help:"Log format (default, raw, plain)."`
+}
+
+func (cmd *LogStartCmd) Run(cfg *config.Config) error {
+	c, err := client.Connect(cfg.Daemon.SocketPath)
 	if err != nil {
 		return err
 	}
+	defer c.Close()
+
+	if err := c.StartLog(cmd.Name, cmd.Path, logRequestFormat(cmd.Format)); err != nil {
+		return err
+	}
+	fmt.Printf("logging session %q\n", cmd.Name)
+	return nil
+}
+
+type LogStopCmd struct {
+	Name string `arg:"" help:"Session name."`
+}
+
+func (cmd *LogStopCmd) Run(cfg *config.Config) error {
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.StopLog(cmd.Name); err != nil {
+		return err
+	}
+	fmt.Printf("stopped logging session %q\n", cmd.Name)
+	return nil
+}
+
+func logRequestFormat(format string) client.LogFormat {
+	switch format {
+	case "raw":
+		return client.LogRaw
+	case "plain":
+		return client.LogPlain
+	default:
+		return client.LogDefault
+	}
+}
+
+// resizeRequestPolicy leaves the daemon's resize_policy for "default".
+func resizeRequestPolicy(policy string) string {
+	if policy == "default" {
+		return ""
+	}
+	return policy
+}
+
+func restartRequestPolicy(policy string) client.RestartPolicy {
+	switch policy {
+	case "never":
+		return client.RestartNever
+	case "on-failure":
+		return client.RestartOnFailure
+	case "always":
+		return client.RestartAlways
+	default:
+		return client.RestartDefault
+	}
+}
+
+type RecordCmd struct {
+	Name   string `arg:"" help:"Session name."`
+	Output string `short:"o" type:"path" help:"Recording file (.cast)." xor:"record"`
+	Stop   bool   `help:"Stop the running recording." xor:"record"`
+}
+
+func (cmd *RecordCmd) Run(cfg *config.Config) error {
+	if cmd.Output == "" && !cmd.Stop {
+		return fmt.Errorf("either -o/--output or --stop is required")
+	}
+
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if cmd.Stop {
+		if err := c.StopRecording(cmd.Name); err != nil {
+			return err
+		}
+		fmt.Printf("stopped recording session %q\n", cmd.Name)
+		return nil
+	}
+	if err := c.StartRecording(cmd.Name, cmd.Output); err != nil {
+		return err
+	}
+	fmt.Printf("recording session %q to %s\n", cmd.Name, cmd.Output)
+	return nil
+}
+
+type PlayCmd struct {
+	File      string        `arg:"" type:"existingfile" help:"Recording file (.cast)."`
+	Speed     float64       `short:"s" default:"1" help:"Playback speed multiplier."`
+	IdleLimit time.Duration `help:"Cap pauses between events (default: the recording's idle_time_limit)."`
+}
+
+func (cmd *PlayCmd) Run() error {
+	if cmd.Speed <= 0 {
+		return fmt.Errorf("--speed must be positive")
+	}
+
+	f, err := os.Open(cmd.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec, err := asciicast.NewDecoder(f)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Print("\x1b[?25l")
+	err = asciicast.Play(ctx, dec, os.Stdout, asciicast.PlayOptions{Speed: cmd.Speed, IdleLimit: cmd.IdleLimit})
+	fmt.Print("\x1b[?25h\r\n")
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+type WaitCmd struct {
+	Name    string `arg:"" help:"Session name."`
+	Pattern string `arg:"" optional:"" help:"Pattern to match."`
+	Regex   bool   `short:"e" help:"Use regex matching."`
+	Timeout *int   `short:"t" help:"Timeout in milliseconds (default 30000; 0 waits forever). With --exit, none unless given."`
+	Row     int    `default:"-1" help:"Only check specific row (0-indexed)."`
+	Stable  int    `help:"Also require the screen to stay unchanged for this many milliseconds."`
+	Exit    bool   `help:"Wait for the session's command to exit and exit with its code, or 124 on timeout."`
+	Prompt  bool   `help:"Also require the shell to be at its prompt (needs OSC 133 shell integration)."`
+
+	// Interval is accepted so older scripts keep parsing; the daemon
+	// now reports matches as the screen changes instead of being polled.
+	Interval int `hidden:"" help:"Deprecated and ignored."`
+}
+
+func (cmd *WaitCmd) Run(cfg *config.Config) error {
+	if cmd.Exit {
+		if cmd.Pattern != "" || cmd.Stable > 0 || cmd.Prompt {
+			return fmt.Errorf("--exit takes no pattern, --stable or --prompt")
+		}
+		return waitSessionExit(cfg.Daemon.SocketPath, cmd.Name, cmd.timeout(0))
+	}
+	if cmd.Pattern == "" && cmd.Stable <= 0 && !cmd.Prompt {
+		return fmt.Errorf("wait requires a pattern, --stable, --prompt or --exit")
+	}
+	if cmd.Regex {
+		if _, err := regexp.Compile(cmd.Pattern); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	}
 
 	c, err := client.Connect(cfg.Daemon.SocketPath)
 	if err != nil {
@@ -452,50 +1197,266 @@ func (cmd *WaitCmd) Run(cfg *config.Config) error {
 	}
 	defer c.Close()
 
-	deadline := time.Now().Add(time.Duration(cmd.Timeout) * time.Millisecond)
+	matched, err := c.Watch(cmd.Name, client.WatchOpts{
+		Pattern: cmd.Pattern,
+		Regex:   cmd.Regex,
+		Row:     cmd.Row,
+		Timeout: cmd.timeout(config.DefaultWaitTimeout),
+		Stable:  time.Duration(max(cmd.Stable, 0)) * time.Millisecond,
+		Prompt:  cmd.Prompt,
+	})
+	if err != nil {
+		return &commandExitError{code: 2, stderr: fmt.Sprintf("error: %v\n", err)}
+	}
+	if !matched {
+		return &commandExitError{code: 1, stderr: waitTimeoutMessage(cmd.Pattern, cmd.Prompt)}
+	}
+	return nil
+}
+
+// timeout returns the -t value, or def when it was not given.
+func (cmd *WaitCmd) timeout(def time.Duration) time.Duration {
+	if cmd.Timeout == nil {
+		return def
+	}
+	return time.Duration(*cmd.Timeout) * time.Millisecond
+}
+
+// waitSessionExit blocks until the named session's command exits and
+// returns its exit code as ht's own, or 124 when timeout runs out.
+// Status is polled alongside the event stream so an exit that came
+// before the subscription is noticed.
+func waitSessionExit(socketPath, name string, timeout time.Duration) error {
+	fail := func(err error) error {
+		return &commandExitError{code: 2, stderr: fmt.Sprintf("error: %v\n", err)}
+	}
+	events, err := client.Connect(socketPath)
+	if err != nil {
+		return fail(err)
+	}
+	defer events.Close()
+	if err := events.Subscribe([]string{name}); err != nil {
+		return fail(err)
+	}
+	c, err := client.Connect(socketPath)
+	if err != nil {
+		return fail(err)
+	}
+	defer c.Close()
+
+	exited := make(chan client.SessionExit, 1)
+	go func() {
+		for {
+			ev, err := events.NextEvent()
+			if err != nil {
+				return
+			}
+			if ev.Kind == "exited" && ev.Session == name {
+				exited <- ev.SessionExit
+				return
+			}
+		}
+	}()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	poll := time.NewTicker(500 * time.Millisecond)
+	defer poll.Stop()
 	for {
-		data, err := c.Dump(cmd.Name, client.DumpPlain)
+		// Without persistence an exited session is gone by the time
+		// Status runs, so an exit already reported wins over the lookup.
+		select {
+		case exit := <-exited:
+			return runExitError(name, exit)
+		default:
+		}
+		status, err := c.Status(name)
 		if err != nil {
-			return &commandExitError{code: 2, stderr: fmt.Sprintf("error: %v\n", err)}
+			return fail(err)
 		}
+		if status.Session == nil {
+			return fail(fmt.Errorf("session not found: %s", name))
+		}
+		if status.Session.State == client.SessionStateDead {
+			return runExitError(name, status.Session.SessionExit)
+		}
+		select {
+		case exit := <-exited:
+			return runExitError(name, exit)
+		case <-poll.C:
+		case <-deadline:
+			return &commandExitError{code: 124, stderr: fmt.Sprintf("timeout: session %q still running\n", name)}
+		}
+	}
+}
 
-		content := waitContent(string(data), cmd.Row)
+func waitTimeoutMessage(pattern string, prompt bool) string {
+	switch {
+	case pattern == "" && prompt:
+		return "timeout waiting for the prompt\n"
+	case pattern == "":
+		return "timeout waiting for screen to settle\n"
+	}
+	return fmt.Sprintf("timeout waiting for %q\n", pattern)
+}
 
-		if match(content) {
-			return nil
-		}
+type GrepCmd struct {
+	Pattern    string   `arg:"" help:"Pattern to search for."`
+	Names      []string `arg:"" optional:"" help:"Sessions to search (default: all live sessions)."`
+	Regex      bool     `short:"e" help:"Use regex matching."`
+	IgnoreCase bool     `short:"i" help:"Match case-insensitively."`
+	All        bool     `short:"a" help:"Also search dead sessions."`
+	MaxCount   int      `short:"m" help:"Stop after this many matches (default: as many as fit in one response)."`
+}
 
-		if time.Now().After(deadline) {
-			return &commandExitError{code: 1, stderr: fmt.Sprintf("timeout waiting for %q\n", cmd.Pattern)}
+func (cmd *GrepCmd) Run(cfg *config.Config) error {
+	pattern, regex := grepPattern(cmd.Pattern, cmd.Regex, cmd.IgnoreCase)
+	if regex {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return &commandExitError{code: 2, stderr: fmt.Sprintf("error: invalid regex: %v\n", err)}
 		}
+	}
+
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return &commandExitError{code: 2, stderr: fmt.Sprintf("error: %v\n", err)}
+	}
+	defer c.Close()
 
-		time.Sleep(time.Duration(cmd.Interval) * time.Millisecond)
+	result, err := c.Search(cmd.Names, client.SearchOpts{Pattern: pattern, Regex: regex, Dead: cmd.All, Limit: cmd.MaxCount})
+	if err != nil {
+		return &commandExitError{code: 2, stderr: fmt.Sprintf("error: %v\n", err)}
+	}
+	for _, m := range result.Matches {
+		fmt.Printf("%s:%d:%s\n", m.Session, m.Line, m.Text)
 	}
+	if result.Truncated && (cmd.MaxCount <= 0 || len(result.Matches) < cmd.MaxCount) {
+		fmt.Fprintf(os.Stderr, "more lines matched than fit in one response; showed the first %d\n", len(result.Matches))
+	}
+	if len(result.Matches) == 0 {
+		return &commandExitError{code: 1}
+	}
+	return nil
 }
 
-func compileWaitMatcher(pattern string, regex bool) (func(string) bool, error) {
-	if regex {
-		re, err := regexp.Compile(pattern)
+type EventsCmd struct {
+	outputFlags
+	Session []string `short:"s" help:"Only show events for this session (repeatable)."`
+}
+
+func (cmd *EventsCmd) Run(cfg *config.Config) error {
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Subscribe(cmd.Session); err != nil {
+		return err
+	}
+	for {
+		ev, err := c.NextEvent()
 		if err != nil {
-			return nil, fmt.Errorf("invalid regex: %w", err)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
 		}
-		return re.MatchString, nil
+		if ev.Dropped > 0 {
+			fmt.Fprintf(os.Stderr, "[hauntty] %d events dropped\n", ev.Dropped)
+		}
+		if !cmd.text() {
+			if err := cmd.writeEvent(os.Stdout, ev); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Println(formatEvent(ev))
+	}
+}
+
+func formatEvent(ev client.Event) string {
+	line := fmt.Sprintf("%s %-9s %s", formatSessionTimestamp(ev.Time), ev.Kind, ev.Session)
+	switch {
+	case ev.ExitCode != nil:
+		line += " exit=" + formatSessionExit(ev.SessionExit)
+	case ev.ClientID != "":
+		line += " client=" + ev.ClientID
+	case ev.PID != 0:
+		line += fmt.Sprintf(" pid=%d", ev.PID)
+	case ev.Message != "":
+		line += " " + strconv.Quote(ev.Message)
+	}
+	return line
+}
+
+// alertNotice describes a monitor event for an attached client's
+// notification, or reports false for lifecycle events.
+func alertNotice(ev client.Event) (string, bool) {
+	switch ev.Kind {
+	case "bell", "activity", "silence":
+		return fmt.Sprintf("%s in session %s", ev.Kind, ev.Session), true
+	case "notify":
+		return fmt.Sprintf("%s: %s", ev.Session, ev.Message), true
+	case "match":
+		return fmt.Sprintf("match in session %s: %s", ev.Session, ev.Message), true
+	default:
+		return "", false
 	}
-	return func(s string) bool { return strings.Contains(s, pattern) }, nil
 }
 
-func waitContent(content string, row int) string {
-	if row < 0 {
-		return content
+// watchAlerts streams other sessions' alerts to the returned channel
+// until the daemon closes the connection. It returns nil when the
+// daemon cannot subscribe.
+func watchAlerts(socketPath string) <-chan string {
+	c, err := client.Connect(socketPath)
+	if err != nil {
+		slog.Debug("connect for alerts", "err", err)
+		return nil
 	}
-	lines := strings.Split(content, "\n")
-	if row >= len(lines) {
-		return ""
+	if err := c.Subscribe(nil); err != nil {
+		slog.Debug("subscribe for alerts", "err", err)
+		c.Close()
+		return nil
+	}
+	notices := make(chan string, 16)
+	go func() {
+		defer c.Close()
+		for {
+			ev, err := c.NextEvent()
+			if err != nil {
+				return
+			}
+			if text, ok := alertNotice(ev); ok {
+				select {
+				case notices <- text:
+				default:
+				}
+			}
+		}
+	}()
+	return notices
+}
+
+// grepPattern folds --ignore-case into the pattern, turning a literal
+// pattern into a quoted regex when needed.
+func grepPattern(pattern string, regex, ignoreCase bool) (string, bool) {
+	if !ignoreCase {
+		return pattern, regex
 	}
-	return lines[row]
+	if !regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	return "(?i)" + pattern, true
 }
 
-type PruneCmd struct{}
+type PruneCmd struct {
+	outputFlags
+}
 
 func (cmd *PruneCmd) Run(cfg *config.Config) error {
 	c, err := client.Connect(cfg.Daemon.SocketPath)
@@ -508,6 +1469,9 @@ func (cmd *PruneCmd) Run(cfg *config.Config) error {
 	if err != nil {
 		return err
 	}
+	if !cmd.text() {
+		return cmd.write(os.Stdout, pruneResult{Pruned: count})
+	}
 	if count == 0 {
 		fmt.Println("no dead sessions to prune")
 	} else {
@@ -553,9 +1517,13 @@ func (cmd *ConfigCmd) Run(cfg *config.Config) error {
 }
 
 type DaemonCmd struct {
-	AutoExit bool   `help:"Exit when last session dies."`
-	Detach   bool   `short:"d" help:"Run daemon in background and exit."`
-	LogFile  string `name:"log-file" help:"Daemon log file path (detach mode only)."`
+	AutoExit  bool   `help:"Exit when last session dies."`
+	Detach    bool   `short:"d" help:"Run daemon in background and exit."`
+	LogFile   string `name:"log-file" help:"Daemon log file path (detach mode only)."`
+	UpgradeFD int    `name:"upgrade-fd" hidden:"" default:"-1" help:"Resume the sessions handed over on this descriptor."`
+
+	Start   DaemonStartCmd   `cmd:"" default:"withargs" hidden:"" help:"Start daemon in foreground."`
+	Upgrade DaemonUpgradeCmd `cmd:"" help:"Replace the running daemon with a new ht binary, keeping its sessions."`
 }
 
 func (cmd *DaemonCmd) validate() error {
@@ -565,7 +1533,9 @@ func (cmd *DaemonCmd) validate() error {
 	return nil
 }
 
-func (cmd *DaemonCmd) Run(cfg *config.Config) error {
+type DaemonStartCmd struct{}
+
+func (*DaemonStartCmd) Run(cfg *config.Config, cmd *DaemonCmd) error {
 	if err := cmd.validate(); err != nil {
 		return err
 	}
@@ -578,8 +1548,16 @@ func (cmd *DaemonCmd) Run(cfg *config.Config) error {
 		cfg.Daemon.AutoExit = true
 	}
 
+	opts := []daemon.Option{daemon.WithUpgrade(func(fd int) []string {
+		args := daemonStartArgs(cfg.Daemon.SocketPath, cfg.Daemon.AutoExit)
+		return append(args, "--upgrade-fd", strconv.Itoa(fd))
+	})}
+	if cmd.UpgradeFD >= 0 {
+		opts = append(opts, daemon.WithHandover(os.NewFile(uintptr(cmd.UpgradeFD), "handover")))
+	}
+
 	ctx := context.Background()
-	srv, err := daemon.New(ctx, &cfg.Daemon, cfg.Session.ResizePolicy)
+	srv, err := daemon.New(ctx, &cfg.Daemon, cfg.Session.ResizePolicy, opts...)
 	if err != nil {
 		return fmt.Errorf("init daemon: %w", err)
 	}
@@ -587,6 +1565,68 @@ func (cmd *DaemonCmd) Run(cfg *config.Config) error {
 	return srv.Listen()
 }
 
+type DaemonUpgradeCmd struct {
+	Binary string `type:"existingfile" help:"ht binary to run (default: this one)."`
+}
+
+func (cmd *DaemonUpgradeCmd) Run(cfg *config.Config) error {
+	path := cmd.Binary
+	if path == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("find executable: %w", err)
+		}
+		path = exe
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return err
+	}
+	err = c.Upgrade(path)
+	c.Close()
+	if err != nil {
+		return err
+	}
+
+	status, err := waitUpgradedDaemon(cfg.Daemon.SocketPath, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("daemon upgraded to %s (pid %d), %d session(s) running\n",
+		status.Daemon.Version, status.Daemon.PID, status.Daemon.RunningCount)
+	return nil
+}
+
+// waitUpgradedDaemon polls the socket until the upgraded daemon answers.
+// Connections made while the old daemon hands over are cut by its exec.
+func waitUpgradedDaemon(socketPath string, timeout time.Duration) (*client.Status, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := daemonStatus(socketPath)
+		if err == nil {
+			return status, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("upgraded daemon did not come up: %w", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func daemonStatus(socketPath string) (*client.Status, error) {
+	c, err := client.Connect(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.Status("")
+}
+
 var alwaysForwardEnv = []string{
 	"TERM",
 	"SHELL",
@@ -51,6 +51,31 @@ func TestConfigCommandHelp(t *testing.T) {
 	assert.Equal(t, configNode.Help, "Print current configuration.")
 }
 
+func TestWaitCmdAcceptsDeprecatedInterval(t *testing.T) {
+	var cli CLI
+	parser, err := kong.New(&cli)
+	assert.NilError(t, err)
+
+	_, err = parser.Parse([]string{"wait", "demo", "ready", "--interval", "50"})
+	assert.NilError(t, err)
+	assert.Equal(t, cli.Wait.Pattern, "ready")
+}
+
+func TestFormatFlagParsesTemplateUpFront(t *testing.T) {
+	var cli CLI
+	parser, err := kong.New(&cli)
+	assert.NilError(t, err)
+
+	_, err = parser.Parse([]string{"list", "--format", "{{.Name"})
+	assert.ErrorContains(t, err, "--format: parse template")
+
+	_, err = parser.Parse([]string{"list", "--format", "{{.Name}}"})
+	assert.NilError(t, err)
+	var out bytes.Buffer
+	assert.NilError(t, cli.List.writeSessions(&out, []client.Session{{Name: "a"}, {Name: "b"}}))
+	assert.Equal(t, out.String(), "a\nb\n")
+}
+
 func TestWaitCmdReportsConnectError(t *testing.T) {
 	sock := t.TempDir()
 	cfg := config.Default()
@@ -66,6 +91,33 @@ func TestWaitCmdReportsConnectError(t *testing.T) {
 	assert.Equal(t, exitErr.stderr, "error: connect to daemon: dial unix "+sock+": connect: socket operation on non-socket\n")
 }
 
+func TestRunExitError(t *testing.T) {
+	code := func(c int32) *int32 { return &c }
+	tests := []struct {
+		exit   client.SessionExit
+		code   int
+		stderr string
+	}{
+		{client.SessionExit{ExitCode: code(3)}, 3, ""},
+		{client.SessionExit{}, 2, "error: session \"job\" ended without an exit status\n"},
+	}
+	assert.NilError(t, runExitError("job", client.SessionExit{ExitCode: code(0)}))
+	for _, tt := range tests {
+		exitErr, ok := errors.AsType[*commandExitError](runExitError("job", tt.exit))
+		assert.Assert(t, ok)
+		assert.Equal(t, exitErr.code, tt.code)
+		assert.Equal(t, exitErr.stderr, tt.stderr)
+	}
+}
+
+func TestNewCmdValidate(t *testing.T) {
+	cmd := NewCmd{PixelSize: config.Size{Width: 900, Height: 700}}
+	assert.Error(t, cmd.validate(), "--pixel-size requires --size")
+
+	cmd.Size = config.Size{Width: 100, Height: 30}
+	assert.NilError(t, cmd.validate())
+}
+
 func TestDaemonCmdValidate(t *testing.T) {
 	t.Run("rejects log file without detach", func(t *testing.T) {
 		cmd := DaemonCmd{LogFile: "/tmp/daemon.log"}
@@ -187,6 +239,7 @@ func TestEnsureDaemonDetachedReportsEarlyChildExit(t *testing.T) {
 }
 
 func TestSessionListRows(t *testing.T) {
+	exitCode := int32(143)
 	sessions := []client.Session{
 		{
 			Name:      "live",
@@ -196,28 +249,47 @@ func TestSessionListRows(t *testing.T) {
 			CWD:       "/home/alice/src/project",
 			PID:       42,
 			CreatedAt: 1700000000,
+			Alerts:    []string{"bell", "activity"},
 		},
 		{
-			Name:    "dead",
-			State:   client.SessionStateDead,
-			Cols:    100,
-			Rows:    40,
-			CWD:     "/tmp/dead",
-			SavedAt: 1700000100,
+			Name:       "dead",
+			State:      client.SessionStateDead,
+			Cols:       100,
+			Rows:       40,
+			CWD:        "/tmp/dead",
+			SavedAt:    1700000100,
+			ExitCode:   &exitCode,
+			ExitSignal: "SIGTERM",
 		},
 	}
 
 	rows := sessionListRows(sessions, false, "/home/alice")
 	assert.DeepEqual(t, rows, [][]string{
-		{"NAME", "STATE", "SIZE", "CWD", "PID", "CREATED", "SAVED"},
-		{"live", "running", "80x24", "~/src/project", "42", time.Unix(1700000000, 0).Format("2006-01-02 15:04:05"), "-"},
+		{"NAME", "STATE", "SIZE", "CWD", "PID", "CREATED", "SAVED", "EXIT", "ALERTS"},
+		{"live", "running", "80x24", "~/src/project", "42", time.Unix(1700000000, 0).Format("2006-01-02 15:04:05"), "-", "-", "bell,activity"},
 	})
 
 	rows = sessionListRows(sessions, true, "/home/alice")
 	assert.DeepEqual(t, rows, [][]string{
-		{"NAME", "STATE", "SIZE", "CWD", "PID", "CREATED", "SAVED"},
-		{"live", "running", "80x24", "~/src/project", "42", time.Unix(1700000000, 0).Format("2006-01-02 15:04:05"), "-"},
-		{"dead", "dead", "100x40", "/tmp/dead", "-", "-", time.Unix(1700000100, 0).Format("2006-01-02 15:04:05")},
+		{"NAME", "STATE", "SIZE", "CWD", "PID", "CREATED", "SAVED", "EXIT", "ALERTS"},
+		{"live", "running", "80x24", "~/src/project", "42", time.Unix(1700000000, 0).Format("2006-01-02 15:04:05"), "-", "-", "bell,activity"},
+		{"dead", "dead", "100x40", "/tmp/dead", "-", "-", time.Unix(1700000100, 0).Format("2006-01-02 15:04:05"), "143 (SIGTERM)", "-"},
+	})
+}
+
+func TestHistoryRows(t *testing.T) {
+	code := int32(2)
+	rows := historyRows([]client.ShellCommand{
+		{Command: "make test", StartedAt: 1700000000, FinishedAt: 1700000002, DurationMS: 1500, ExitCode: &code, OutputStartRow: 3, OutputRows: 4},
+		{Command: "cat big", StartedAt: 1700000010, FinishedAt: 1700000010, Trimmed: true},
+		{Command: "sleep 60", StartedAt: 1700000020},
+	})
+
+	assert.DeepEqual(t, rows, [][]string{
+		{"STARTED", "DURATION", "EXIT", "OUTPUT", "COMMAND"},
+		{time.Unix(1700000000, 0).Format("2006-01-02 15:04:05"), "1.5s", "2", "3-6", "make test"},
+		{time.Unix(1700000010, 0).Format("2006-01-02 15:04:05"), "0s", "-", "trimmed", "cat big"},
+		{time.Unix(1700000020, 0).Format("2006-01-02 15:04:05"), "running", "-", "-", "sleep 60"},
 	})
 }
 
@@ -232,28 +304,11 @@ func TestDumpRequestFormat(t *testing.T) {
 	assert.Equal(t, format, client.DumpHTML|client.DumpFlagUnwrap|client.DumpFlagScrollback)
 }
 
-func TestCompileWaitMatcher(t *testing.T) {
-	match, err := compileWaitMatcher("ready", false)
-	assert.NilError(t, err)
-	assert.Equal(t, match("daemon ready"), true)
-	assert.Equal(t, match("daemon waiting"), false)
-
-	match, err = compileWaitMatcher("^ready-[0-9]+$", true)
-	assert.NilError(t, err)
-	assert.Equal(t, match("ready-42"), true)
-	assert.Equal(t, match("ready-now"), false)
-
-	_, err = compileWaitMatcher("(", true)
-	assert.Error(t, err, "invalid regex: error parsing regexp: missing closing ): `(`")
-}
-
-func TestWaitContent(t *testing.T) {
-	content := "alpha\nbeta\ngamma"
-
-	assert.Equal(t, waitContent(content, -1), "alpha\nbeta\ngamma")
-	assert.Equal(t, waitContent(content, 0), "alpha")
-	assert.Equal(t, waitContent(content, 1), "beta")
-	assert.Equal(t, waitContent(content, 9), "")
+func TestWaitTimeoutMessage(t *testing.T) {
+	assert.Equal(t, waitTimeoutMessage("ready", false), "timeout waiting for \"ready\"\n")
+	assert.Equal(t, waitTimeoutMessage("ready", true), "timeout waiting for \"ready\"\n")
+	assert.Equal(t, waitTimeoutMessage("", false), "timeout waiting for screen to settle\n")
+	assert.Equal(t, waitTimeoutMessage("", true), "timeout waiting for the prompt\n")
 }
 
 func TestInitCmdCreatesConfig(t *testing.T) {
@@ -316,3 +371,45 @@ func TestConfigCmdWritesToml(t *testing.T) {
 
 	assert.Equal(t, got.String(), want.String())
 }
+
+func TestLogRequestFormat(t *testing.T) {
+	assert.Equal(t, logRequestFormat("default"), client.LogDefault)
+	assert.Equal(t, logRequestFormat("raw"), client.LogRaw)
+	assert.Equal(t, logRequestFormat("plain"), client.LogPlain)
+}
+
+func TestGrepPattern(t *testing.T) {
+	pattern, regex := grepPattern("a.b", false, false)
+	assert.Equal(t, pattern, "a.b")
+	assert.Equal(t, regex, false)
+
+	pattern, regex = grepPattern("a.b", false, true)
+	assert.Equal(t, pattern, `(?i)a\.b`)
+	assert.Equal(t, regex, true)
+
+	pattern, regex = grepPattern("a.b", true, true)
+	assert.Equal(t, pattern, "(?i)a.b")
+	assert.Equal(t, regex, true)
+}
+
+func TestAlertNotice(t *testing.T) {
+	for _, tc := range []struct {
+		ev   client.Event
+		want string
+		ok   bool
+	}{
+		{client.Event{Kind: "activity", Session: "build"}, "activity in session build", true},
+		{client.Event{Kind: "notify", Session: "build", Message: "tests passed"}, "build: tests passed", true},
+		{client.Event{Kind: "match", Session: "build", Message: "FAIL pkg"}, "match in session build: FAIL pkg", true},
+		{client.Event{Kind: "attached", Session: "build"}, "", false},
+	} {
+		got, ok := alertNotice(tc.ev)
+		assert.Equal(t, ok, tc.ok, tc.ev.Kind)
+		assert.Equal(t, got, tc.want, tc.ev.Kind)
+	}
+}
+
+func TestFormatMonitor(t *testing.T) {
+	assert.Equal(t, formatMonitor(client.MonitorSettings{}), "off")
+	assert.Equal(t, formatMonitor(client.MonitorSettings{Bell: true, Silence: 90, Pattern: "FAIL"}), `bell, silence 1m30s, pattern "FAIL"`)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/template"
+
+	"code.selman.me/hauntty/internal/client"
+)
+
+// outputFlags selects machine-readable output. The JSON schema is the
+// json tags of the client types and is documented in the README.
+type outputFlags struct {
+	JSON   bool           `name:"json" help:"Print JSON." xor:"output"`
+	Format formatTemplate `placeholder:"TEMPLATE" help:"Format output with a Go template." xor:"output"`
+}
+
+// formatTemplate is the --format template, parsed when the flag is, so
+// an invalid one fails before the command does anything.
+type formatTemplate struct {
+	*template.Template
+}
+
+func (f *formatTemplate) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		f.Template = nil
+		return nil
+	}
+	tmpl, err := template.New("format").Parse(string(text))
+	if err != nil {
+		return fmt.Errorf("parse template: %w", err)
+	}
+	f.Template = tmpl
+	return nil
+}
+
+func (o outputFlags) text() bool {
+	return !o.JSON && o.Format.Template == nil
+}
+
+// write prints v as indented JSON or through the --format template.
+func (o outputFlags) write(w io.Writer, v any) error {
+	if o.JSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(v)
+	}
+	if err := o.Format.Execute(w, v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeSessions prints sessions as one JSON array, or runs the --format
+// template once per session.
+func (o outputFlags) writeSessions(w io.Writer, sessions []client.Session) error {
+	if o.JSON {
+		return o.write(w, sessions)
+	}
+	for _, s := range sessions {
+		if err := o.write(w, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEvent prints one event per line: compact JSON, so the stream is
+// JSON Lines, or the --format template.
+func (o outputFlags) writeEvent(w io.Writer, ev client.Event) error {
+	if !o.JSON {
+		return o.write(w, ev)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(ev)
+}
+
+type pruneResult struct {
+	Pruned uint32 `json:"pruned"`
+}
+
+type dumpResult struct {
+	Name        string `json:"name"`
+	Format      string `json:"format"`
+	Join        bool   `json:"join"`
+	Scrollback  bool   `json:"scrollback"`
+	LastCommand bool   `json:"last_command"`
+	Data        string `json:"data"`
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.selman.me/hauntty/internal/client"
+	"code.selman.me/hauntty/internal/config"
+)
+
+// defaultScriptWait is how long a script's wait step waits without -t,
+// as with `ht wait`.
+const defaultScriptWait = 30 * time.Second
+
+type ScriptCmd struct {
+	File    string `arg:"" help:"Script file, or - for stdin."`
+	Session string `short:"s" help:"Session to drive until a session step (default: current session)."`
+}
+
+func (cmd *ScriptCmd) Run(cfg *config.Config) error {
+	var (
+		r    io.Reader = os.Stdin
+		name           = "stdin"
+	)
+	if cmd.File != "-" {
+		f, err := os.Open(cmd.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r, name = f, cmd.File
+	}
+	s, err := parseScript(name, r)
+	if err != nil {
+		return err
+	}
+
+	session := cmd.Session
+	if session == "" {
+		session = os.Getenv("HAUNTTY_SESSION")
+	}
+	c, err := client.Connect(cfg.Daemon.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	runner := &scriptRunner{c: c, session: session}
+	return runner.run(s)
+}
+
+// script is a parsed ht script: its steps in order and the step index
+// each label points at.
+type script struct {
+	name   string
+	steps  []scriptStep
+	labels map[string]int
+}
+
+type scriptOp string
+
+const (
+	scriptSession scriptOp = "session"
+	scriptSend    scriptOp = "send"
+	scriptKey     scriptOp = "key"
+	scriptWait    scriptOp = "wait"
+	scriptAssert  scriptOp = "assert"
+	scriptDump    scriptOp = "dump"
+	scriptSleep   scriptOp = "sleep"
+	scriptGoto    scriptOp = "goto"
+	scriptFail    scriptOp = "fail"
+	scriptExit    scriptOp = "exit"
+)
+
+// scriptStep is one line of a script. text is the session name, the
+// text to send, the pattern, the dump file, the label or the failure
+// message, depending on op.
+type scriptStep struct {
+	line   int
+	source string
+	op     scriptOp
+	text   string
+	keys   []client.KeyInput
+	watch  client.WatchOpts
+	not    bool
+	format client.DumpFormat
+	sleep  time.Duration
+	code   int
+	// label is where goto jumps, and where wait jumps when it times
+	// out; a wait without one fails the script instead.
+	label string
+}
+
+func parseScript(name string, r io.Reader) (*script, error) {
+	s := &script{name: name, labels: make(map[string]int)}
+	gotos := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		source := strings.TrimSpace(scanner.Text())
+		args, err := splitScriptLine(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if label, ok := strings.CutSuffix(args[0], ":"); ok && len(args) == 1 {
+			if _, dup := s.labels[label]; dup || label == "" {
+				return nil, fmt.Errorf("%s:%d: duplicate or empty label %q", name, line, label)
+			}
+			s.labels[label] = len(s.steps)
+			continue
+		}
+		step, err := parseScriptStep(args)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		step.line = line
+		step.source = source
+		if step.label != "" {
+			if _, ok := gotos[step.label]; !ok {
+				gotos[step.label] = line
+			}
+		}
+		s.steps = append(s.steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	for label, line := range gotos {
+		if _, ok := s.labels[label]; !ok {
+			return nil, fmt.Errorf("%s:%d: unknown label %q", name, line, label)
+		}
+	}
+	return s, nil
+}
+
+func parseScriptStep(args []string) (scriptStep, error) {
+	step := scriptStep{op: scriptOp(args[0])}
+	args = args[1:]
+	switch step.op {
+	case scriptSession:
+		if len(args) != 1 {
+			return step, fmt.Errorf("session takes a session name")
+		}
+		step.text = args[0]
+	case scriptSend:
+		if len(args) == 0 {
+			return step, fmt.Errorf("send requires text")
+		}
+		step.text = strings.Join(args, "")
+	case scriptKey:
+		if len(args) == 0 {
+			return step, fmt.Errorf("key requires a key")
+		}
+		for _, arg := range args {
+			ki, err := client.ParseKeyNotation(arg)
+			if err != nil {
+				return step, err
+			}
+			step.keys = append(step.keys, ki)
+		}
+	case scriptWait, scriptAssert:
+		return parseScriptMatch(step, args)
+	case scriptDump:
+		return parseScriptDump(step, args)
+	case scriptSleep:
+		if len(args) != 1 {
+			return step, fmt.Errorf("sleep takes a duration")
+		}
+		d, err := parseScriptDuration(args[0])
+		if err != nil {
+			return step, err
+		}
+		step.sleep = d
+	case scriptGoto:
+		if len(args) != 1 {
+			return step, fmt.Errorf("goto takes a label")
+		}
+		step.label = args[0]
+	case scriptFail:
+		step.text = strings.Join(args, " ")
+	case scriptExit:
+		if len(args) > 1 {
+			return step, fmt.Errorf("exit takes at most an exit code")
+		}
+		if len(args) == 1 {
+			code, err := strconv.Atoi(args[0])
+			if err != nil || code < 0 || code > 255 {
+				return step, fmt.Errorf("invalid exit code %q", args[0])
+			}
+			step.code = code
+		}
+	default:
+		return step, fmt.Errorf("unknown step %q", step.op)
+	}
+	return step, nil
+}
+
+// parseScriptMatch parses the flags wait and assert share with `ht
+// wait`, the pattern and, for wait, a trailing "else LABEL".
+func parseScriptMatch(step scriptStep, args []string) (scriptStep, error) {
+	step.watch = client.WatchOpts{Row: -1, Timeout: defaultScriptWait}
+	if step.op == scriptWait && len(args) >= 2 && args[len(args)-2] == "else" {
+		step.label = args[len(args)-1]
+		args = args[:len(args)-2]
+	}
+	var pattern []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(pattern) > 0 || !strings.HasPrefix(arg, "-") {
+			pattern = append(pattern, arg)
+			continue
+		}
+		if step.op != scriptWait && (arg == "--prompt" || arg == "--stable" || arg == "-t" || arg == "--timeout") {
+			return step, fmt.Errorf("%s applies to wait", arg)
+		}
+		if step.op != scriptAssert && arg == "--not" {
+			return step, fmt.Errorf("--not applies to assert")
+		}
+		var err error
+		switch arg {
+		case "--":
+			pattern = append(pattern, args[i+1:]...)
+			i = len(args)
+		case "-e", "--regex":
+			step.watch.Regex = true
+		case "--not":
+			step.not = true
+		case "--prompt":
+			step.watch.Prompt = true
+		case "--row", "--stable", "-t", "--timeout":
+			if i+1 >= len(args) {
+				return step, fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			switch arg {
+			case "--row":
+				step.watch.Row, err = strconv.Atoi(args[i])
+				if err != nil || step.watch.Row < 0 {
+					err = fmt.Errorf("invalid row %q", args[i])
+				}
+			case "--stable":
+				step.watch.Stable, err = parseScriptDuration(args[i])
+			default:
+				step.watch.Timeout, err = parseScriptDuration(args[i])
+			}
+		default:
+			return step, fmt.Errorf("unknown %s flag %q", step.op, arg)
+		}
+		if err != nil {
+			return step, err
+		}
+	}
+	step.text = strings.Join(pattern, " ")
+	step.watch.Pattern = step.text
+	switch {
+	case step.text != "":
+	case step.op == scriptAssert:
+		return step, fmt.Errorf("assert requires a pattern")
+	case step.watch.Stable == 0 && !step.watch.Prompt:
+		return step, fmt.Errorf("wait requires a pattern, --stable or --prompt")
+	}
+	if step.watch.Regex {
+		if _, err := regexp.Compile(step.text); err != nil {
+			return step, fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+	return step, nil
+}
+
+func parseScriptDump(step scriptStep, args []string) (scriptStep, error) {
+	format, join, scrollback := "plain", false, false
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--format":
+			if i+1 >= len(args) {
+				return step, fmt.Errorf("--format needs a value")
+			}
+			i++
+			format = args[i]
+			if format != "plain" && format != "vt" && format != "html" {
+				return step, fmt.Errorf("invalid dump format %q", format)
+			}
+		case "-J", "--join":
+			join = true
+		case "-S", "--scrollback":
+			scrollback = true
+		default:
+			if strings.HasPrefix(arg, "-") || step.text != "" {
+				return step, fmt.Errorf("unexpected dump argument %q", arg)
+			}
+			step.text = arg
+		}
+	}
+	if step.text == "" {
+		return step, fmt.Errorf("dump requires a file")
+	}
+	step.format = dumpRequestFormat(format, join, scrollback)
+	return step, nil
+}
+
+// parseScriptDuration reads a Go duration such as 500ms or 2s, or a bare
+// number of milliseconds as `ht wait` takes.
+func parseScriptDuration(s string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(s); err == nil && ms >= 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// splitScriptLine splits a line into words like a shell would: words are
+// separated by blanks, single quotes keep text as is, double quotes
+// take Go escapes such as \r, \t and \x1b, and # starts a comment.
+func splitScriptLine(line string) ([]string, error) {
+	var (
+		words []string
+		word  strings.Builder
+		in    bool
+	)
+	for i := 0; i < len(line); i++ {
+		switch b := line[i]; {
+		case b == ' ' || b == '\t':
+			if in {
+				words = append(words, word.String())
+				word.Reset()
+				in = false
+			}
+		case b == '#' && !in:
+			return words, nil
+		case b == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			in = true
+		case b == '"':
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, errors.New("unterminated double quote")
+			}
+			text, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string %s", line[i:end+1])
+			}
+			word.WriteString(text)
+			i = end
+			in = true
+		default:
+			word.WriteByte(b)
+			in = true
+		}
+	}
+	if in {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// scriptRunner runs a script's steps over one connection.
+type scriptRunner struct {
+	c       *client.Client
+	session string
+}
+
+// scriptFailure is a step that did not hold; the runner reports it with
+// the screen it failed on.
+type scriptFailure struct {
+	message string
+}
+
+func (f *scriptFailure) Error() string { return f.message }
+
+func (r *scriptRunner) run(s *script) error {
+	for pc := 0; pc < len(s.steps); pc++ {
+		step := &s.steps[pc]
+		next, err := r.step(s, step)
+		var failure *scriptFailure
+		switch {
+		case errors.As(err, &failure):
+			return &commandExitError{code: 1, stderr: r.report(s, step, failure.message)}
+		case errors.As(err, new(*commandExitError)):
+			return err
+		case err != nil:
+			return fmt.Errorf("%s:%d: %w", s.name, step.line, err)
+		}
+		if next >= 0 {
+			pc = next - 1
+		}
+	}
+	return nil
+}
+
+// step runs one step and returns the index of the step to jump to, or
+// -1 to carry on with the next one.
+func (r *scriptRunner) step(s *script, step *scriptStep) (int, error) {
+	switch step.op {
+	case scriptSession:
+		r.session = step.text
+		return -1, nil
+	case scriptGoto:
+		return s.labels[step.label], nil
+	case scriptSleep:
+		time.Sleep(step.sleep)
+		return -1, nil
+	case scriptFail:
+		message := step.text
+		if message == "" {
+			message = "fail"
+		}
+		return -1, &scriptFailure{message: message}
+	case scriptExit:
+		if step.code == 0 {
+			return len(s.steps), nil
+		}
+		return -1, &commandExitError{code: step.code}
+	}
+
+	if r.session == "" {
+		return -1, fmt.Errorf("no session; use -s, a session step, or run inside a hauntty session")
+	}
+	switch step.op {
+	case scriptSend:
+		return -1, r.c.Send(r.session, []byte(step.text))
+	case scriptKey:
+		for _, ki := range step.keys {
+			if err := r.c.SendKey(r.session, ki.Code, ki.Mods); err != nil {
+				return -1, err
+			}
+		}
+		return -1, nil
+	case scriptWait:
+		matched, err := r.c.Watch(r.session, step.watch)
+		if err != nil || matched {
+			return -1, err
+		}
+		if step.label != "" {
+			return s.labels[step.label], nil
+		}
+		return -1, &scriptFailure{message: "timeout after " + step.watch.Timeout.String()}
+	case scriptAssert:
+		screen, err := r.screen(client.DumpPlain)
+		if err != nil {
+			return -1, err
+		}
+		matched, err := scriptMatches(step, string(screen))
+		if err != nil {
+			return -1, err
+		}
+		if matched == step.not {
+			return -1, &scriptFailure{message: "assertion failed"}
+		}
+		return -1, nil
+	case scriptDump:
+		data, err := r.screen(step.format)
+		if err != nil {
+			return -1, err
+		}
+		return -1, os.WriteFile(step.text, data, 0o644)
+	}
+	return -1, fmt.Errorf("unknown step %q", step.op)
+}
+
+func (r *scriptRunner) screen(format client.DumpFormat) ([]byte, error) {
+	rd, err := r.c.Dump(r.session, format)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rd)
+}
+
+// scriptMatches checks an assert step against a plain screen dump.
+func scriptMatches(step *scriptStep, screen string) (bool, error) {
+	if step.watch.Row >= 0 {
+		lines := strings.Split(screen, "\n")
+		screen = ""
+		if step.watch.Row < len(lines) {
+			screen = lines[step.watch.Row]
+		}
+	}
+	if !step.watch.Regex {
+		return strings.Contains(screen, step.text), nil
+	}
+	re, err := regexp.Compile(step.text)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(screen), nil
+}
+
+// report describes a failed step followed by the session's screen.
+func (r *scriptRunner) report(s *script, step *scriptStep, message string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:%d: %s: %s\n", s.name, step.line, step.source, message)
+	if r.session == "" {
+		return b.String()
+	}
+	screen, err := r.screen(client.DumpPlain)
+	if err != nil {
+		fmt.Fprintf(&b, "screen of %q unavailable: %v\n", r.session, err)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "--- screen of %q ---\n%s\n---\n", r.session, strings.TrimRight(string(screen), "\n"))
+	return b.String()
+}
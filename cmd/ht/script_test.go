@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/internal/client"
+	"gotest.tools/v3/assert"
+)
+
+func TestSplitScriptLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"# comment", nil},
+		{"send hello   world", []string{"send", "hello", "world"}},
+		{`send "make test\r"`, []string{"send", "make test\r"}},
+		{`send 'a "b" \r'`, []string{"send", `a "b" \r`}},
+		{`wait -e 'v[0-9]+' # trailing`, []string{"wait", "-e", "v[0-9]+"}},
+		{`send pre"\t"post`, []string{"send", "pre\tpost"}},
+		{`send "say \"hi\""`, []string{"send", `say "hi"`}},
+		{`send "#not a comment"`, []string{"send", "#not a comment"}},
+	}
+	for _, tt := range tests {
+		got, err := splitScriptLine(tt.line)
+		assert.NilError(t, err, tt.line)
+		assert.DeepEqual(t, got, tt.want)
+	}
+
+	_, err := splitScriptLine(`send "open`)
+	assert.Error(t, err, "unterminated double quote")
+	_, err = splitScriptLine(`send 'open`)
+	assert.Error(t, err, "unterminated single quote")
+	_, err = splitScriptLine(`send "\q"`)
+	assert.ErrorContains(t, err, "invalid quoted string")
+}
+
+func TestParseScript(t *testing.T) {
+	s, err := parseScript("build.ht", strings.NewReader(`# Run the tests and keep the output.
+session build
+send "make test\r"
+retry:
+wait -e --row 2 -t 5s 'PASS|FAIL' else slow
+assert --not FAIL
+key ctrl+c enter
+dump out.txt --format vt -S
+wait --prompt --stable 200
+goto done
+slow:
+sleep 500
+fail tests took too long
+done:
+exit 3
+`))
+	assert.NilError(t, err)
+
+	assert.DeepEqual(t, s.labels, map[string]int{"retry": 2, "slow": 8, "done": 10})
+	ops := make([]scriptOp, 0, len(s.steps))
+	for _, step := range s.steps {
+		ops = append(ops, step.op)
+	}
+	assert.DeepEqual(t, ops, []scriptOp{
+		scriptSession, scriptSend, scriptWait, scriptAssert, scriptKey, scriptDump,
+		scriptWait, scriptGoto, scriptSleep, scriptFail, scriptExit,
+	})
+
+	wait := s.steps[2]
+	assert.Equal(t, wait.line, 5)
+	assert.Equal(t, wait.label, "slow")
+	assert.DeepEqual(t, wait.watch, client.WatchOpts{Pattern: "PASS|FAIL", Regex: true, Row: 2, Timeout: 5 * time.Second})
+
+	assert.Equal(t, s.steps[1].text, "make test\r")
+	assert.Equal(t, s.steps[3].not, true)
+	assert.DeepEqual(t, s.steps[4].keys, []client.KeyInput{{Code: client.KeyCode('c'), Mods: client.ModCtrl}, {Code: client.KeyEnter}})
+	assert.Equal(t, s.steps[5].text, "out.txt")
+	assert.Equal(t, s.steps[5].format, client.DumpVT|client.DumpFlagScrollback)
+	assert.DeepEqual(t, s.steps[6].watch, client.WatchOpts{Row: -1, Prompt: true, Stable: 200 * time.Millisecond, Timeout: defaultScriptWait})
+	assert.Equal(t, s.steps[8].sleep, 500*time.Millisecond)
+	assert.Equal(t, s.steps[9].text, "tests took too long")
+	assert.Equal(t, s.steps[10].code, 3)
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{"type hello", `s.ht:1: unknown step "type"`},
+		{"\nwait", "s.ht:2: wait requires a pattern, --stable or --prompt"},
+		{"assert --prompt ready", "s.ht:1: --prompt applies to wait"},
+		{"wait --not ready", "s.ht:1: --not applies to assert"},
+		{"wait -e [", "s.ht:1: invalid regex: error parsing regexp: missing closing ]: `[`"},
+		{"wait -t", "s.ht:1: -t needs a value"},
+		{"wait --row -1 ready", `s.ht:1: invalid row "-1"`},
+		{"wait --rows 1 ready", `s.ht:1: unknown wait flag "--rows"`},
+		{"wait -t soon ready", `s.ht:1: invalid duration "soon"`},
+		{"wait ready else nowhere", `s.ht:1: unknown label "nowhere"`},
+		{"a:\na:", `s.ht:2: duplicate or empty label "a"`},
+		{"key ctrl+nope", `s.ht:1: unknown key: "nope"`},
+		{"dump", "s.ht:1: dump requires a file"},
+		{"dump out.txt --format pdf", `s.ht:1: invalid dump format "pdf"`},
+		{"exit 256", `s.ht:1: invalid exit code "256"`},
+	}
+	for _, tt := range tests {
+		_, err := parseScript("s.ht", strings.NewReader(tt.script))
+		assert.Error(t, err, tt.want, tt.script)
+	}
+}
+
+func TestScriptMatches(t *testing.T) {
+	screen := "$ make\nok  pkg 0.1s\n$ "
+	tests := []struct {
+		step scriptStep
+		want bool
+	}{
+		{scriptStep{text: "pkg", watch: client.WatchOpts{Row: -1}}, true},
+		{scriptStep{text: "pkg", watch: client.WatchOpts{Row: 0}}, false},
+		{scriptStep{text: `^ok\s+pkg`, watch: client.WatchOpts{Row: 1, Regex: true}}, true},
+		{scriptStep{text: "pkg", watch: client.WatchOpts{Row: 9}}, false},
+	}
+	for _, tt := range tests {
+		got, err := scriptMatches(&tt.step, screen)
+		assert.NilError(t, err)
+		assert.Equal(t, got, tt.want, tt.step.text)
+	}
+}
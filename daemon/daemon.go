@@ -0,0 +1,102 @@
+// Package daemon runs a hauntty daemon inside another program.
+//
+// A Server from this package behaves like `ht daemon`: clients, including
+// the ht command and code.selman.me/hauntty/client, talk to it over its
+// listener. Options replace what `ht daemon` takes from the environment:
+// the socket, the state directory, the logger and signal handling.
+package daemon
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+
+	"code.selman.me/hauntty/internal/config"
+	idaemon "code.selman.me/hauntty/internal/daemon"
+)
+
+// Config is the [daemon] section of hauntty's config file.
+type Config = config.DaemonConfig
+
+type (
+	SessionLogConfig = config.SessionLogConfig
+	RestartConfig    = config.RestartConfig
+	HooksConfig      = config.HooksConfig
+	MonitorConfig    = config.MonitorConfig
+	LogFormat        = config.LogFormat
+	RestartPolicy    = config.RestartPolicy
+	ResizePolicy     = config.ResizePolicy
+	SlowClientPolicy = config.SlowClientPolicy
+)
+
+const (
+	LogFormatRaw   = config.LogFormatRaw
+	LogFormatPlain = config.LogFormatPlain
+
+	RestartNever     = config.RestartNever
+	RestartOnFailure = config.RestartOnFailure
+	RestartAlways    = config.RestartAlways
+
+	ResizePolicySmallest = config.ResizePolicySmallest
+	ResizePolicyLargest  = config.ResizePolicyLargest
+	ResizePolicyFirst    = config.ResizePolicyFirst
+	ResizePolicyLast     = config.ResizePolicyLast
+	ResizePolicyFixed    = config.ResizePolicyFixed
+
+	SlowClientResync = config.SlowClientResync
+	SlowClientKick   = config.SlowClientKick
+)
+
+// DefaultConfig returns the daemon configuration ht uses when the config
+// file leaves it unset.
+func DefaultConfig() Config {
+	return config.Default().Daemon
+}
+
+type (
+	Server = idaemon.Server
+	Option = idaemon.Option
+)
+
+// New returns a Server for cfg. Run it with Server.Serve; it shuts down
+// when Serve's context is done, or on Server.Shutdown, and Serve returns
+// once sessions are closed, hooks have run and the daemon's files are
+// removed. New rejects a cfg the config file loader would reject; start
+// from DefaultConfig to get valid values for the settings left unset.
+func New(ctx context.Context, cfg Config, opts ...Option) (*Server, error) {
+	return idaemon.New(ctx, &cfg, config.Default().Session.ResizePolicy, opts...)
+}
+
+// WithListener serves ln instead of the configured socket. The server
+// then takes no lock and writes no PID file, and shutting down closes ln
+// but leaves any socket file to the caller. Closing ln yourself shuts
+// the server down too. Connections that are not Unix sockets skip the
+// peer UID check.
+func WithListener(ln net.Listener) Option {
+	return idaemon.WithListener(ln)
+}
+
+// WithStateDir saves dead session state under dir instead of
+// $XDG_STATE_HOME/hauntty/sessions. It matters only with
+// Config.StatePersistence set.
+func WithStateDir(dir string) Option {
+	return idaemon.WithStateDir(dir)
+}
+
+// WithLogger logs to l instead of slog's default logger.
+func WithLogger(l *slog.Logger) Option {
+	return idaemon.WithLogger(l)
+}
+
+// WithSignals shuts the server down on the given signals instead of
+// SIGTERM and SIGINT. Passing none leaves signal handling to the caller.
+func WithSignals(sigs ...os.Signal) Option {
+	return idaemon.WithSignals(sigs...)
+}
+
+// WithResizePolicy sets how sessions size themselves when several
+// clients of different sizes are attached. The default is smallest.
+func WithResizePolicy(p ResizePolicy) Option {
+	return idaemon.WithResizePolicy(p)
+}
@@ -0,0 +1,352 @@
+package daemon_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/client"
+	"code.selman.me/hauntty/daemon"
+	"gotest.tools/v3/assert"
+)
+
+// startServer serves an in-process daemon on a fresh socket and returns
+// the socket path and a stop function that cancels Serve and returns its
+// result.
+func startServer(t *testing.T, cfg daemon.Config, opts ...daemon.Option) (string, func() error) {
+	t.Helper()
+	dir, err := os.MkdirTemp("/tmp", "ht-embed-")
+	assert.NilError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "hauntty.sock")
+	ln, err := net.Listen("unix", sock)
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	opts = append([]daemon.Option{
+		daemon.WithListener(ln),
+		daemon.WithStateDir(filepath.Join(dir, "state")),
+		daemon.WithLogger(slog.New(slog.DiscardHandler)),
+		daemon.WithSignals(),
+	}, opts...)
+	srv, err := daemon.New(ctx, cfg, opts...)
+	assert.NilError(t, err)
+
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ctx) }()
+	stop := sync.OnceValue(func() error {
+		cancel()
+		select {
+		case err := <-served:
+			return err
+		case <-time.After(5 * time.Second):
+			return errors.New("serve did not return after cancel")
+		}
+	})
+	t.Cleanup(func() { _ = stop() })
+	return sock, stop
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	cfg := daemon.DefaultConfig()
+	cfg.Restart.Policy = daemon.RestartAlways
+	cfg.Restart.BackoffMS = 0
+	_, err := daemon.New(t.Context(), cfg)
+	assert.Error(t, err, "daemon: restart.backoff_ms must be > 0")
+
+	_, err = daemon.New(t.Context(), daemon.Config{})
+	assert.ErrorContains(t, err, "daemon: invalid")
+
+	_, err = daemon.New(t.Context(), daemon.DefaultConfig(), daemon.WithResizePolicy("bogus"))
+	assert.Error(t, err, `daemon: invalid resize policy "bogus"`)
+}
+
+func TestServeRunsSessionsUntilCanceled(t *testing.T) {
+	cfg := daemon.DefaultConfig()
+	cfg.StatePersistence = false
+	sock, stop := startServer(t, cfg)
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer c.Close()
+
+	created, err := c.CreateSession(t.Context(), client.CreateSessionOpts{
+		Name:    "embedded",
+		Command: []string{"/bin/sh", "-c", "echo embedded-ok; sleep 30"},
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, created.Name, "embedded")
+
+	ctx, cancelWatch := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancelWatch()
+	matched, err := c.Watch(ctx, "embedded", client.WatchOpts{Pattern: "embedded-ok"})
+	assert.NilError(t, err)
+	assert.Assert(t, matched)
+
+	status, err := c.Status(t.Context(), "")
+	assert.NilError(t, err)
+	assert.Equal(t, status.Daemon.SocketPath, sock)
+	assert.Equal(t, status.Daemon.RunningCount, uint32(1))
+
+	assert.NilError(t, stop())
+	_, err = c.ListSessions(t.Context(), false)
+	assert.Assert(t, err != nil)
+}
+
+func TestWatchWithoutTimeoutWaitsForMatch(t *testing.T) {
+	cfg := daemon.DefaultConfig()
+	cfg.StatePersistence = false
+	sock, _ := startServer(t, cfg)
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer c.Close()
+	_, err = c.CreateSession(t.Context(), client.CreateSessionOpts{
+		Name:    "late",
+		Command: []string{"/bin/sh", "-c", "sleep 0.3; echo late-ok; sleep 30"},
+	})
+	assert.NilError(t, err)
+
+	matched, err := c.Watch(context.Background(), "late", client.WatchOpts{Pattern: "late-ok"})
+	assert.NilError(t, err)
+	assert.Assert(t, matched)
+}
+
+func TestServeSavesStateInStateDir(t *testing.T) {
+	stateDir := t.TempDir()
+	cfg := daemon.DefaultConfig()
+	sock, _ := startServer(t, cfg, daemon.WithStateDir(stateDir))
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer c.Close()
+
+	_, err = c.CreateSession(t.Context(), client.CreateSessionOpts{
+		Name:    "short",
+		Command: []string{"/bin/sh", "-c", "exit 4"},
+	})
+	assert.NilError(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(stateDir, "short.state")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dead session state was not saved in the state dir")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	status, err := c.Status(t.Context(), "short")
+	assert.NilError(t, err)
+	assert.Equal(t, status.Session.State, client.SessionStateDead)
+	assert.Equal(t, *status.Session.ExitCode, int32(4))
+}
+
+func TestAttachToEmbeddedServer(t *testing.T) {
+	cfg := daemon.DefaultConfig()
+	cfg.StatePersistence = false
+	sock, _ := startServer(t, cfg)
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	a, err := c.Attach(t.Context(), client.AttachOpts{
+		Name:    "cat",
+		Command: []string{"/bin/cat"},
+		Cols:    80,
+		Rows:    24,
+	})
+	assert.NilError(t, err)
+	defer a.Close()
+	assert.Assert(t, a.Created)
+
+	_, err = a.Write([]byte("echoed-back\r"))
+	assert.NilError(t, err)
+
+	var got strings.Builder
+	buf := make([]byte, 4096)
+	for !strings.Contains(got.String(), "echoed-back\r\necho") {
+		n, err := a.Read(buf)
+		got.Write(buf[:n])
+		if errors.Is(err, io.EOF) {
+			t.Fatalf("stream ended early: %q", got.String())
+		}
+		assert.NilError(t, err)
+		if strings.Count(got.String(), "echoed-back") >= 2 {
+			break
+		}
+	}
+}
+
+func TestHooksRunOnSessionExit(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "exited")
+	cfg := daemon.DefaultConfig()
+	cfg.StatePersistence = false
+	cfg.Hooks.OnExited = `echo "$HAUNTTY_SESSION $HAUNTTY_PID $HAUNTTY_EXIT_CODE" > ` + out
+	sock, _ := startServer(t, cfg)
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer c.Close()
+	created, err := c.CreateSession(t.Context(), client.CreateSessionOpts{
+		Name:    "build",
+		Command: []string{"/bin/sh", "-c", "exit 3"},
+	})
+	assert.NilError(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, err := os.ReadFile(out); err == nil && len(data) > 0 {
+			assert.Equal(t, string(data), fmt.Sprintf("build %d 3\n", created.PID))
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("exit hook did not run")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestServeReturnsAfterShutdownFinishes(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "shutdown")
+	cfg := daemon.DefaultConfig()
+	cfg.StatePersistence = false
+	cfg.Hooks.OnShutdown = "sleep 0.2; echo done > " + out
+	_, stop := startServer(t, cfg)
+
+	assert.NilError(t, stop())
+	data, err := os.ReadFile(out)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "done\n")
+}
+
+func TestDetachedSessionAnswersTerminalQueries(t *testing.T) {
+	cfg := daemon.DefaultConfig()
+	cfg.StatePersistence = false
+	sock, _ := startServer(t, cfg)
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer c.Close()
+	// The shell sends a status report request and prints the reply
+	// with ESC made visible.
+	_, err = c.CreateSession(t.Context(), client.CreateSessionOpts{
+		Name:    "query",
+		Command: []string{"/bin/sh", "-c", `stty -icanon -echo min 1; printf '\033[5n'; head -c 4 | tr '\033' E; sleep 30`},
+	})
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	matched, err := c.Watch(ctx, "query", client.WatchOpts{Pattern: "E[0n"})
+	assert.NilError(t, err)
+	assert.Assert(t, matched)
+}
+
+func TestSubscribeSeesKilledSessionEnd(t *testing.T) {
+	cfg := daemon.DefaultConfig()
+	cfg.StatePersistence = false
+	sock, _ := startServer(t, cfg)
+
+	events, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer events.Close()
+	assert.NilError(t, events.Subscribe(t.Context(), []string{"victim"}))
+
+	attacher, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	a, err := attacher.Attach(t.Context(), client.AttachOpts{
+		Name:    "victim",
+		Command: []string{"/bin/cat"},
+		Cols:    80,
+		Rows:    24,
+	})
+	assert.NilError(t, err)
+	defer a.Close()
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer c.Close()
+	assert.NilError(t, c.Kill(t.Context(), "victim"))
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	var kinds []string
+	var exited client.Event
+	for len(kinds) < 4 {
+		ev, err := events.NextEvent(ctx)
+		assert.NilError(t, err)
+		kinds = append(kinds, ev.Kind)
+		if ev.Kind == "exited" {
+			exited = ev
+		}
+	}
+	assert.DeepEqual(t, kinds, []string{"created", "attached", "detached", "exited"})
+	assert.Equal(t, exited.PID, a.PID)
+	assert.Equal(t, exited.ExitSignal, "SIGHUP")
+}
+
+func TestHooksRunForSessionsEndedByShutdown(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "events")
+	cfg := daemon.DefaultConfig()
+	cfg.StatePersistence = false
+	cfg.Hooks.OnDetached = `echo "$HAUNTTY_EVENT $HAUNTTY_SESSION" >> ` + out
+	cfg.Hooks.OnExited = `sleep 0.1; echo "$HAUNTTY_EVENT $HAUNTTY_SESSION" >> ` + out
+	sock, stop := startServer(t, cfg)
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer c.Close()
+	a, err := c.Attach(t.Context(), client.AttachOpts{
+		Name:    "lingering",
+		Command: []string{"/bin/cat"},
+		Cols:    80,
+		Rows:    24,
+	})
+	assert.NilError(t, err)
+	defer a.Close()
+
+	assert.NilError(t, stop())
+	data, err := os.ReadFile(out)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "detached lingering\nexited lingering\n")
+}
+
+func TestHooksRunOnKilledSession(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "exited")
+	cfg := daemon.DefaultConfig()
+	cfg.StatePersistence = false
+	cfg.Hooks.OnExited = `echo "$HAUNTTY_SESSION $HAUNTTY_PID $HAUNTTY_EXIT_SIGNAL" > ` + out
+	sock, _ := startServer(t, cfg)
+
+	c, err := client.Dial(t.Context(), sock)
+	assert.NilError(t, err)
+	defer c.Close()
+	created, err := c.CreateSession(t.Context(), client.CreateSessionOpts{
+		Name:    "doomed",
+		Command: []string{"/bin/cat"},
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, c.Kill(t.Context(), "doomed"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, err := os.ReadFile(out); err == nil && len(data) > 0 {
+			assert.Equal(t, string(data), fmt.Sprintf("doomed %d SIGHUP\n", created.PID))
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("exit hook did not run")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,191 @@
+// Package asciicast reads and writes asciicast v2 recordings.
+//
+// A recording is a JSON header line followed by one JSON array per event:
+// [seconds, type, data]. See https://docs.asciinema.org/manual/asciicast/v2/.
+package asciicast
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+const Version = 2
+
+type Header struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+}
+
+type EventType string
+
+const (
+	EventOutput EventType = "o"
+	EventInput  EventType = "i"
+	EventResize EventType = "r"
+	EventMarker EventType = "m"
+)
+
+type Event struct {
+	Time time.Duration
+	Type EventType
+	Data string
+}
+
+// Resize parses the COLSxROWS payload of a resize event.
+func (e Event) Resize() (uint16, uint16, error) {
+	var cols, rows uint16
+	if _, err := fmt.Sscanf(e.Data, "%dx%d", &cols, &rows); err != nil {
+		return 0, 0, fmt.Errorf("asciicast: invalid resize %q", e.Data)
+	}
+	return cols, rows, nil
+}
+
+// Encoder writes events as they happen. Output split inside a UTF-8
+// sequence is held back until the rest of the sequence arrives, since
+// event data must be valid UTF-8.
+type Encoder struct {
+	w       io.Writer
+	pending []byte
+}
+
+func NewEncoder(w io.Writer, header Header) (*Encoder, error) {
+	header.Version = Version
+	line, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("asciicast: encode header: %w", err)
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+	return &Encoder{w: w}, nil
+}
+
+// ResumeEncoder continues a recording whose header and earlier events w
+// already holds.
+func ResumeEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (e *Encoder) Output(at time.Duration, data []byte) error {
+	if len(e.pending) > 0 {
+		data = append(e.pending, data...)
+		e.pending = nil
+	}
+	if tail := incompleteUTF8Tail(data); tail > 0 {
+		e.pending = append([]byte(nil), data[len(data)-tail:]...)
+		data = data[:len(data)-tail]
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return e.write(Event{Time: at, Type: EventOutput, Data: string(data)})
+}
+
+func (e *Encoder) Resize(at time.Duration, cols, rows uint16) error {
+	return e.write(Event{Time: at, Type: EventResize, Data: fmt.Sprintf("%dx%d", cols, rows)})
+}
+
+// Flush writes any held-back partial UTF-8 sequence, which encodes as
+// U+FFFD.
+func (e *Encoder) Flush(at time.Duration) error {
+	if len(e.pending) == 0 {
+		return nil
+	}
+	data := e.pending
+	e.pending = nil
+	return e.write(Event{Time: at, Type: EventOutput, Data: string(data)})
+}
+
+func (e *Encoder) write(ev Event) error {
+	line, err := json.Marshal([]any{float64(ev.Time.Microseconds()) / 1e6, ev.Type, ev.Data})
+	if err != nil {
+		return fmt.Errorf("asciicast: encode event: %w", err)
+	}
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
+
+// incompleteUTF8Tail reports how many trailing bytes of data start a
+// UTF-8 sequence that is not finished yet.
+func incompleteUTF8Tail(data []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(data); i++ {
+		b := data[len(data)-i]
+		if utf8.RuneStart(b) {
+			if b >= utf8.RuneSelf && !utf8.FullRune(data[len(data)-i:]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+type Decoder struct {
+	Header  Header
+	scanner *bufio.Scanner
+	line    int
+}
+
+// maxLineSize bounds one event line; daemon recordings never exceed a
+// PTY batch per event.
+const maxLineSize = 16 << 20
+
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	d := &Decoder{scanner: scanner}
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("asciicast: read header: %w", err)
+		}
+		return nil, fmt.Errorf("asciicast: missing header")
+	}
+	d.line++
+	if err := json.Unmarshal(scanner.Bytes(), &d.Header); err != nil {
+		return nil, fmt.Errorf("asciicast: decode header: %w", err)
+	}
+	if d.Header.Version != Version {
+		return nil, fmt.Errorf("asciicast: unsupported version %d", d.Header.Version)
+	}
+	return d, nil
+}
+
+// Next returns the next event, or io.EOF after the last one.
+func (d *Decoder) Next() (Event, error) {
+	for d.scanner.Scan() {
+		d.line++
+		if len(d.scanner.Bytes()) == 0 {
+			continue
+		}
+		var fields []json.RawMessage
+		if err := json.Unmarshal(d.scanner.Bytes(), &fields); err != nil || len(fields) != 3 {
+			return Event{}, fmt.Errorf("asciicast: line %d: invalid event", d.line)
+		}
+		var seconds float64
+		var ev Event
+		if err := json.Unmarshal(fields[0], &seconds); err != nil {
+			return Event{}, fmt.Errorf("asciicast: line %d: invalid time: %w", d.line, err)
+		}
+		if err := json.Unmarshal(fields[1], &ev.Type); err != nil {
+			return Event{}, fmt.Errorf("asciicast: line %d: invalid type: %w", d.line, err)
+		}
+		if err := json.Unmarshal(fields[2], &ev.Data); err != nil {
+			return Event{}, fmt.Errorf("asciicast: line %d: invalid data: %w", d.line, err)
+		}
+		ev.Time = time.Duration(seconds * float64(time.Second))
+		return ev, nil
+	}
+	if err := d.scanner.Err(); err != nil {
+		return Event{}, fmt.Errorf("asciicast: read event: %w", err)
+	}
+	return Event{}, io.EOF
+}
@@ -0,0 +1,83 @@
+package asciicast
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestEncoderWritesHeaderAndEvents(t *testing.T) {
+	var buf bytes.Buffer
+	enc, err := NewEncoder(&buf, Header{Width: 80, Height: 24, Timestamp: 1700000000, Title: "demo"})
+	assert.NilError(t, err)
+
+	assert.NilError(t, enc.Output(250*time.Millisecond, []byte("hi\r\n")))
+	assert.NilError(t, enc.Resize(1500*time.Millisecond, 100, 40))
+
+	assert.Equal(t, buf.String(), `{"version":2,"width":80,"height":24,"timestamp":1700000000,"title":"demo"}
+[0.25,"o","hi\r\n"]
+[1.5,"r","100x40"]
+`)
+}
+
+func TestEncoderHoldsSplitUTF8(t *testing.T) {
+	var buf bytes.Buffer
+	enc, err := NewEncoder(&buf, Header{Width: 80, Height: 24})
+	assert.NilError(t, err)
+
+	euro := []byte("€")
+	assert.NilError(t, enc.Output(0, append([]byte("a"), euro[:2]...)))
+	assert.NilError(t, enc.Output(time.Second, euro[2:]))
+	assert.NilError(t, enc.Flush(2*time.Second))
+
+	dec, err := NewDecoder(&buf)
+	assert.NilError(t, err)
+	ev, err := dec.Next()
+	assert.NilError(t, err)
+	assert.Equal(t, ev.Data, "a")
+	ev, err = dec.Next()
+	assert.NilError(t, err)
+	assert.Equal(t, ev.Data, "€")
+	_, err = dec.Next()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestDecoderReadsEvents(t *testing.T) {
+	dec, err := NewDecoder(strings.NewReader(`{"version":2,"width":20,"height":5,"idle_time_limit":1.5}
+[0.1,"o","one"]
+
+[2.25,"r","30x10"]
+`))
+	assert.NilError(t, err)
+	assert.Equal(t, dec.Header.Width, 20)
+	assert.Equal(t, dec.Header.IdleTimeLimit, 1.5)
+
+	ev, err := dec.Next()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ev, Event{Time: 100 * time.Millisecond, Type: EventOutput, Data: "one"})
+
+	ev, err = dec.Next()
+	assert.NilError(t, err)
+	cols, rows, err := ev.Resize()
+	assert.NilError(t, err)
+	assert.Equal(t, ev.Time, 2250*time.Millisecond)
+	assert.Equal(t, cols, uint16(30))
+	assert.Equal(t, rows, uint16(10))
+
+	_, err = dec.Next()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestDecoderRejectsBadInput(t *testing.T) {
+	_, err := NewDecoder(strings.NewReader(`{"version":1,"width":20,"height":5}`))
+	assert.Error(t, err, "asciicast: unsupported version 1")
+
+	dec, err := NewDecoder(strings.NewReader("{\"version\":2,\"width\":20,\"height\":5}\n[0.1,\"o\"]\n"))
+	assert.NilError(t, err)
+	_, err = dec.Next()
+	assert.Error(t, err, "asciicast: line 2: invalid event")
+}
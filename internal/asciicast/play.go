@@ -0,0 +1,152 @@
+package asciicast
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"code.selman.me/hauntty/libghostty"
+)
+
+// frameInterval is the shortest gap worth rendering on its own; closer
+// events are applied together and drawn as one frame.
+const frameInterval = 16 * time.Millisecond
+
+type PlayOptions struct {
+	// Speed scales playback; values <= 0 play at normal speed.
+	Speed float64
+	// IdleLimit caps pauses between events. Zero uses the recording's
+	// idle_time_limit, if any.
+	IdleLimit time.Duration
+}
+
+// Play replays a recording into a terminal sized like the recording and
+// draws each frame of its screen to out.
+func Play(ctx context.Context, d *Decoder, out io.Writer, opts PlayOptions) error {
+	term, err := libghostty.NewTerminal(
+		libghostty.WithSize(uint16(d.Header.Width), uint16(d.Header.Height)),
+		libghostty.WithMaxScrollbackLines(0),
+	)
+	if err != nil {
+		return err
+	}
+	defer term.Close()
+
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	idleLimit := opts.IdleLimit
+	if idleLimit == 0 && d.Header.IdleTimeLimit > 0 {
+		idleLimit = time.Duration(d.Header.IdleTimeLimit * float64(time.Second))
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	var last time.Duration
+	dirty := false
+	for {
+		ev, err := d.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		delay := max(ev.Time-last, 0)
+		if idleLimit > 0 {
+			delay = min(delay, idleLimit)
+		}
+		delay = time.Duration(float64(delay) / speed)
+		last = ev.Time
+
+		if delay >= frameInterval {
+			if dirty {
+				if err := drawFrame(term, out); err != nil {
+					return err
+				}
+				dirty = false
+			}
+			timer.Reset(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		switch ev.Type {
+		case EventOutput:
+			term.VTWrite([]byte(ev.Data))
+			dirty = true
+		case EventResize:
+			cols, rows, err := ev.Resize()
+			if err != nil {
+				return err
+			}
+			if err := term.Resize(cols, rows, 0, 0); err != nil {
+				return err
+			}
+			dirty = true
+		}
+	}
+
+	if dirty {
+		return drawFrame(term, out)
+	}
+	return nil
+}
+
+// drawFrame repaints out with the terminal's active screen and cursor.
+func drawFrame(term *libghostty.Terminal, out io.Writer) error {
+	cols, err := term.Cols()
+	if err != nil {
+		return err
+	}
+	rows, err := term.Rows()
+	if err != nil {
+		return err
+	}
+	start, err := term.GridRef(libghostty.Point{Tag: libghostty.PointTagActive})
+	if err != nil {
+		return err
+	}
+	end, err := term.GridRef(libghostty.Point{Tag: libghostty.PointTagActive, X: cols - 1, Y: uint32(rows - 1)})
+	if err != nil {
+		return err
+	}
+	formatter, err := libghostty.NewFormatter(term,
+		libghostty.WithFormatterFormat(libghostty.FormatterFormatVT),
+		libghostty.WithFormatterTrim(true),
+		libghostty.WithFormatterSelection(&libghostty.Selection{Start: *start, End: *end}),
+	)
+	if err != nil {
+		return err
+	}
+	defer formatter.Close()
+	screen, err := formatter.Format()
+	if err != nil {
+		return err
+	}
+	cursorCol, err := term.CursorX()
+	if err != nil {
+		return err
+	}
+	cursorRow, err := term.CursorY()
+	if err != nil {
+		return err
+	}
+
+	var frame bytes.Buffer
+	frame.WriteString("\x1b[H\x1b[2J")
+	frame.Write(screen)
+	fmt.Fprintf(&frame, "\x1b[0m\x1b[%d;%dH", cursorRow+1, cursorCol+1)
+	_, err = out.Write(frame.Bytes())
+	return err
+}
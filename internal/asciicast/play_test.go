@@ -0,0 +1,48 @@
+package asciicast
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestPlayDrawsFinalScreen(t *testing.T) {
+	dec, err := NewDecoder(strings.NewReader(`{"version":2,"width":20,"height":3}
+[0.0,"o","first\r\n"]
+[0.5,"r","30x3"]
+[60.0,"o","\u001b[1msecond\u001b[0m"]
+`))
+	assert.NilError(t, err)
+
+	var out bytes.Buffer
+	start := time.Now()
+	err = Play(t.Context(), dec, &out, PlayOptions{Speed: 10, IdleLimit: time.Second})
+	assert.NilError(t, err)
+	assert.Assert(t, time.Since(start) < 5*time.Second)
+
+	frames := strings.Split(out.String(), "\x1b[H\x1b[2J")
+	// Frames are drawn before each pause and once at the end.
+	assert.Equal(t, len(frames), 4)
+	assert.Assert(t, strings.Contains(frames[1], "first"))
+	last := frames[3]
+	assert.Assert(t, strings.Contains(last, "first\r\n"))
+	assert.Assert(t, strings.Contains(last, "second"))
+	assert.Assert(t, strings.HasSuffix(last, "\x1b[0m\x1b[2;7H"))
+}
+
+func TestPlayStopsOnCancel(t *testing.T) {
+	dec, err := NewDecoder(strings.NewReader(`{"version":2,"width":20,"height":3}
+[0.0,"o","first"]
+[30.0,"o","second"]
+`))
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	err = Play(ctx, dec, &bytes.Buffer{}, PlayOptions{})
+	assert.Equal(t, err, context.DeadlineExceeded)
+}
@@ -8,6 +8,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"time"
 
@@ -55,6 +56,11 @@ type AttachOpts struct {
 	Metadata  AttachMetadataFunc
 	ReadOnly  bool
 	Restore   bool
+	LogPath   string
+	LogFormat LogFormat
+	// Notices are shown as desktop notifications (OSC 9) while
+	// attached, such as alerts from other sessions.
+	Notices <-chan string
 }
 
 func (c *Client) RunAttach(opts AttachOpts) error {
@@ -93,6 +99,9 @@ func (c *Client) RunAttach(opts AttachOpts) error {
 	var (
 		mu   sync.Mutex
 		done = make(chan struct{})
+		// outMu orders notices with session output on stdout.
+		outMu   sync.Mutex
+		stopped bool
 	)
 
 	if !opts.ReadOnly {
@@ -105,9 +114,29 @@ func (c *Client) RunAttach(opts AttachOpts) error {
 		return err
 	}
 
+	if opts.Notices != nil {
+		go func() {
+			for {
+				select {
+				case text := <-opts.Notices:
+					outMu.Lock()
+					if !stopped {
+						os.Stdout.Write(noticeSequence(text))
+					}
+					outMu.Unlock()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
 	for {
 		msg, err := c.conn.ReadMessage()
 		if err != nil {
+			outMu.Lock()
+			stopped = true
+			outMu.Unlock()
 			close(done)
 			if err == io.EOF || isConnClosed(err) {
 				restoreHostTerminal(fd, oldState, "[hauntty] detached\n")
@@ -116,7 +145,13 @@ func (c *Client) RunAttach(opts AttachOpts) error {
 			_ = term.Restore(fd, oldState)
 			return fmt.Errorf("read message: %w", err)
 		}
-		if err := handleAttachMessage(fd, oldState, msg); err != nil {
+		outMu.Lock()
+		err = handleAttachMessage(fd, oldState, msg)
+		if err != nil {
+			stopped = true
+		}
+		outMu.Unlock()
+		if err != nil {
 			close(done)
 			return err
 		}
@@ -143,6 +178,8 @@ func attachRequestFromOpts(fd int, opts AttachOpts) (*protocol.Attach, error) {
 		Scrollback: 0,
 		ReadOnly:   opts.ReadOnly,
 		Restore:    opts.Restore,
+		LogPath:    opts.LogPath,
+		LogFormat:  opts.LogFormat,
 	}, nil
 }
 
@@ -293,6 +330,18 @@ func handleAttachMessage(fd int, oldState *term.State, msg protocol.Message) err
 	return nil
 }
 
+// noticeSequence wraps text in an OSC 9 notification, dropping control
+// characters that would end the sequence early.
+func noticeSequence(text string) []byte {
+	clean := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0) {
+			return -1
+		}
+		return r
+	}, text)
+	return []byte("\x1b]9;" + clean + "\x1b\\")
+}
+
 func restoreHostTerminal(fd int, oldState *term.State, message string) {
 	// Use 1047 (not 1049) to exit alt screen: 1047 just switches the
 	// buffer without restoring the saved cursor, so session content on
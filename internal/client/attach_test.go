@@ -15,10 +15,12 @@ import (
 
 func TestAttachRequestFromOpts(t *testing.T) {
 	opts := AttachOpts{
-		Name:     "demo",
-		Command:  []string{"sh", "-lc", "echo hi"},
-		ReadOnly: true,
-		Restore:  true,
+		Name:      "demo",
+		Command:   []string{"sh", "-lc", "echo hi"},
+		ReadOnly:  true,
+		Restore:   true,
+		LogPath:   "/tmp/demo.log",
+		LogFormat: LogPlain,
 		Metadata: func(fd int) (AttachMetadata, error) {
 			assert.Equal(t, fd, 7)
 			return AttachMetadata{
@@ -46,6 +48,8 @@ func TestAttachRequestFromOpts(t *testing.T) {
 		Scrollback: 0,
 		ReadOnly:   true,
 		Restore:    true,
+		LogPath:    "/tmp/demo.log",
+		LogFormat:  protocol.LogPlain,
 	})
 }
 
@@ -236,3 +240,8 @@ func makePipe() (r, w int, err error) {
 	}
 	return fds[0], fds[1], nil
 }
+
+func TestNoticeSequenceDropsControlCharacters(t *testing.T) {
+	assert.Equal(t, string(noticeSequence("activity in session build")), "\x1b]9;activity in session build\x1b\\")
+	assert.Equal(t, string(noticeSequence("a\x07b\x1b]c\u009cd")), "\x1b]9;ab]cd\x1b\\")
+}
@@ -1,9 +1,14 @@
 package client
 
 import (
+	"bytes"
 	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"time"
 
 	hauntty "code.selman.me/hauntty"
 	"code.selman.me/hauntty/internal/config"
@@ -16,8 +21,14 @@ type Client struct {
 }
 
 func Connect(socketPath string) (*Client, error) {
+	return ConnectContext(context.Background(), socketPath)
+}
+
+// ConnectContext is Connect with ctx bounding the dial and the handshake.
+func ConnectContext(ctx context.Context, socketPath string) (*Client, error) {
 	sock := cmp.Or(socketPath, config.SocketPath())
-	nc, err := net.Dial("unix", sock)
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "unix", sock)
 	if err != nil {
 		return nil, fmt.Errorf("connect to daemon: %w", err)
 	}
@@ -25,26 +36,72 @@ func Connect(socketPath string) (*Client, error) {
 		conn:    protocol.NewConn(nc),
 		netConn: nc,
 	}
-	accepted, serverRev, err := c.conn.Handshake(protocol.ProtocolVersion, hauntty.Version())
+	var (
+		accepted  uint8
+		serverRev string
+	)
+	err = c.Do(ctx, func() error {
+		var err error
+		accepted, serverRev, err = c.conn.Handshake(protocol.NewHello(hauntty.Version()))
+		return err
+	})
 	if err != nil {
 		nc.Close()
 		return nil, fmt.Errorf("handshake: %w", err)
 	}
-	if accepted != protocol.ProtocolVersion {
+	if accepted < protocol.MinProtocolVersion || accepted > protocol.ProtocolVersion {
 		nc.Close()
 		clientRev := hauntty.Version()
 		if serverRev != "" && serverRev != clientRev {
 			return nil, fmt.Errorf("revision mismatch: client=%s server=%s (restart the daemon)", clientRev, serverRev)
 		}
-		return nil, fmt.Errorf("protocol version mismatch: server accepted %d, expected %d", accepted, protocol.ProtocolVersion)
+		return nil, fmt.Errorf("protocol version mismatch: server accepted %d, expected %d-%d", accepted, protocol.MinProtocolVersion, protocol.ProtocolVersion)
 	}
 	return c, nil
 }
 
+// Protocol returns the protocol version negotiated with the daemon.
+func (c *Client) Protocol() uint8 {
+	return c.conn.Version()
+}
+
+// Features returns the capabilities negotiated with the daemon.
+func (c *Client) Features() []Capability {
+	return c.conn.Capabilities()
+}
+
 func (c *Client) Close() error {
 	return c.netConn.Close()
 }
 
+// Do runs fn, which talks to the daemon over the client's connection,
+// and interrupts it once ctx is done. An interrupted request leaves a
+// response half read, so the connection is closed and Do returns the
+// context's cause.
+func (c *Client) Do(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return context.Cause(ctx)
+	}
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(interrupted)
+		// A deadline in the past fails blocked reads and writes.
+		_ = c.netConn.SetDeadline(time.Unix(1, 0))
+	})
+	err := fn()
+	if stop() {
+		return err
+	}
+	<-interrupted
+	if err == nil {
+		// fn finished before the deadline took effect.
+		_ = c.netConn.SetDeadline(time.Time{})
+		return nil
+	}
+	c.Close()
+	return context.Cause(ctx)
+}
+
 type SessionState = protocol.SessionState
 
 const (
@@ -52,46 +109,97 @@ const (
 	SessionStateDead    = protocol.SessionStateDead
 )
 
+// The JSON field names of SessionClient, Session and Status are the
+// schema of ht's --json output and must stay stable.
+
 type SessionClient struct {
-	ClientID string
-	ReadOnly bool
-	Version  string
+	ClientID string `json:"id"`
+	ReadOnly bool   `json:"read_only"`
+	Version  string `json:"version"`
+	PID      uint32 `json:"pid"`
+	// Protocol and Features are what the client negotiated with the
+	// daemon; Protocol is 0 when the daemon does not report them.
+	Protocol uint8        `json:"protocol"`
+	Features []Capability `json:"features"`
+	// Resyncs counts how often the client fell too far behind the
+	// session's output and was sent a fresh screen instead, and Dropped
+	// the output bytes it skipped.
+	Resyncs uint32 `json:"resyncs"`
+	Dropped uint64 `json:"dropped_bytes"`
 }
 
 type Session struct {
-	Name      string
-	State     SessionState
-	Cols      uint16
-	Rows      uint16
-	CWD       string
-	PID       uint32
-	CreatedAt uint32
-	SavedAt   uint32
-	Clients   []SessionClient
+	Name  string       `json:"name"`
+	State SessionState `json:"state"`
+	Cols  uint16       `json:"cols"`
+	Rows  uint16       `json:"rows"`
+	CWD   string       `json:"cwd"`
+	PID   uint32       `json:"pid"`
+	// CreatedAt and SavedAt are Unix seconds, 0 when unknown.
+	CreatedAt uint32 `json:"created_at"`
+	SavedAt   uint32 `json:"saved_at"`
+	SessionExit
+	// Command is the command the session was started with; empty means
+	// the default shell.
+	Command []string        `json:"command"`
+	Clients []SessionClient `json:"clients"`
+	// Alerts names the monitor alerts raised since a client was last
+	// attached: bell, activity, silence or match.
+	Alerts []string `json:"alerts"`
+}
+
+// MonitorSettings selects what a session raises alerts for while no
+// client is attached. Silence is in seconds; 0 turns it off.
+type MonitorSettings struct {
+	Bell     bool   `json:"bell"`
+	Activity bool   `json:"activity"`
+	Silence  uint32 `json:"silence_seconds"`
+	Pattern  string `json:"pattern"`
+}
+
+// SessionExit describes how a session's process exited.
+type SessionExit struct {
+	// ExitCode is nil until the session's process has exited.
+	ExitCode *int32 `json:"exit_code"`
+	// ExitSignal names the signal that killed the process, if any.
+	ExitSignal string `json:"exit_signal"`
+	// ExitedAt is Unix seconds, 0 until the process has exited.
+	ExitedAt uint32 `json:"exited_at"`
 }
 
 type DaemonStatus struct {
-	PID          uint32
-	Uptime       uint32
-	SocketPath   string
-	RunningCount uint32
-	DeadCount    uint32
-	Version      string
+	PID          uint32 `json:"pid"`
+	Uptime       uint32 `json:"uptime"`
+	SocketPath   string `json:"socket_path"`
+	RunningCount uint32 `json:"running_count"`
+	DeadCount    uint32 `json:"dead_count"`
+	Version      string `json:"version"`
 }
 
 type SessionStatus struct {
-	Name    string
-	State   SessionState
-	Cols    uint16
-	Rows    uint16
-	PID     uint32
-	CWD     string
-	Clients []SessionClient
+	Name  string       `json:"name"`
+	State SessionState `json:"state"`
+	Cols  uint16       `json:"cols"`
+	Rows  uint16       `json:"rows"`
+	PID   uint32       `json:"pid"`
+	CWD   string       `json:"cwd"`
+	SessionExit
+	Command []string `json:"command"`
+	// Restart is the session's restart policy and Restarts how often it
+	// has rerun the command.
+	Restart  string `json:"restart"`
+	Restarts uint32 `json:"restarts"`
+	// SlowKicks counts clients disconnected for falling behind the
+	// session's output.
+	SlowKicks uint32          `json:"slow_kicks"`
+	Clients   []SessionClient `json:"clients"`
+	Monitor   MonitorSettings `json:"monitor"`
+	Alerts    []string        `json:"alerts"`
 }
 
 type Status struct {
-	Daemon  DaemonStatus
-	Session *SessionStatus
+	Daemon  DaemonStatus   `json:"daemon"`
+	Session *SessionStatus `json:"session"`
 }
 
 type DumpFormat = protocol.DumpFormat
@@ -103,8 +211,33 @@ const (
 	DumpFormatMask     = protocol.DumpFormatMask
 	DumpFlagUnwrap     = protocol.DumpFlagUnwrap
 	DumpFlagScrollback = protocol.DumpFlagScrollback
+	// DumpFlagLastCommand dumps only the output of the session's latest
+	// shell command.
+	DumpFlagLastCommand = protocol.DumpFlagLastCommand
+)
+
+type LogFormat = protocol.LogFormat
+
+const (
+	LogDefault = protocol.LogDefault
+	LogRaw     = protocol.LogRaw
+	LogPlain   = protocol.LogPlain
 )
 
+type RestartPolicy = protocol.RestartPolicy
+
+const (
+	RestartDefault   = protocol.RestartDefault
+	RestartNever     = protocol.RestartNever
+	RestartOnFailure = protocol.RestartOnFailure
+	RestartAlways    = protocol.RestartAlways
+)
+
+type Capability = protocol.Capability
+
+// ProtocolVersion is the newest protocol version this build speaks.
+const ProtocolVersion = protocol.ProtocolVersion
+
 type CreatedSession struct {
 	Name string
 	PID  uint32
@@ -117,16 +250,40 @@ type CreateSessionOpts struct {
 	CWD        string
 	Scrollback uint32
 	Force      bool
+	LogPath    string
+	LogFormat  LogFormat
+	Restart    RestartPolicy
+	// Cols and Rows size the session until clients attach; zero leaves
+	// the daemon's default. Xpixel and Ypixel are the size in pixels.
+	Cols   uint16
+	Rows   uint16
+	Xpixel uint16
+	Ypixel uint16
+	// ResizePolicy overrides the daemon's resize_policy for the session:
+	// smallest, largest, first, last or fixed.
+	ResizePolicy string
 }
 
 func (c *Client) CreateSession(opts CreateSessionOpts) (*CreatedSession, error) {
+	sized := opts.Cols != 0 || opts.Rows != 0 || opts.Xpixel != 0 || opts.Ypixel != 0 || opts.ResizePolicy != ""
+	if sized && !c.conn.Has(protocol.CapSessionSize) {
+		return nil, &protocol.CapabilityError{Type: protocol.TypeCreate, Capability: protocol.CapSessionSize}
+	}
 	created, err := request[*protocol.Created](c, "create", &protocol.Create{
-		Name:       opts.Name,
-		Command:    opts.Command,
-		Env:        opts.Env,
-		CWD:        opts.CWD,
-		Scrollback: opts.Scrollback,
-		Force:      opts.Force,
+		Name:         opts.Name,
+		Command:      opts.Command,
+		Env:          opts.Env,
+		CWD:          opts.CWD,
+		Scrollback:   opts.Scrollback,
+		Force:        opts.Force,
+		LogPath:      opts.LogPath,
+		LogFormat:    opts.LogFormat,
+		Restart:      opts.Restart,
+		Cols:         opts.Cols,
+		Rows:         opts.Rows,
+		Xpixel:       opts.Xpixel,
+		Ypixel:       opts.Ypixel,
+		ResizePolicy: opts.ResizePolicy,
 	})
 	if err != nil {
 		return nil, err
@@ -135,7 +292,16 @@ func (c *Client) CreateSession(opts CreateSessionOpts) (*CreatedSession, error)
 }
 
 func (c *Client) attach(req *protocol.Attach) (*protocol.Attached, error) {
-	return request[*protocol.Attached](c, "attach", req)
+	attached, err := request[*protocol.Attached](c, "attach", req)
+	if err != nil || !c.conn.Has(protocol.CapChunkedDump) {
+		return attached, err
+	}
+	// Reattaching clears the terminal and then paints the screen, so it
+	// is collected whole here; only Dump hands out the stream.
+	if attached.ScreenDump, err = io.ReadAll(&dumpReader{conn: c.conn, op: "attach"}); err != nil {
+		return nil, err
+	}
+	return attached, nil
 }
 
 func (c *Client) ListSessions(includeClients bool) ([]Session, error) {
@@ -158,12 +324,309 @@ func (c *Client) SendKey(name string, keyCode KeyCode, mods Modifier) error {
 	return requestOK(c, "send key", &protocol.SendKey{Name: name, Key: keyCode, Mods: mods})
 }
 
-func (c *Client) Dump(name string, format DumpFormat) ([]byte, error) {
-	resp, err := request[*protocol.DumpResponse](c, "dump", &protocol.Dump{Name: name, Format: protocol.DumpFormat(format)})
+// Dump returns a reader over the session's rendered screen. The daemon
+// streams large dumps in chunks, so the reader must be drained before
+// the client is used again.
+func (c *Client) Dump(name string, format DumpFormat) (io.Reader, error) {
+	if format&DumpFlagLastCommand != 0 && !c.conn.Has(protocol.CapCommands) {
+		return nil, &protocol.CapabilityError{Type: protocol.TypeDump, Capability: protocol.CapCommands}
+	}
+	req := &protocol.Dump{Name: name, Format: protocol.DumpFormat(format)}
+	if !c.conn.Has(protocol.CapChunkedDump) {
+		resp, err := request[*protocol.DumpResponse](c, "dump", req)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(resp.Data), nil
+	}
+	if err := c.conn.WriteMessage(req); err != nil {
+		return nil, fmt.Errorf("send dump: %w", err)
+	}
+	r := &dumpReader{conn: c.conn, op: "dump"}
+	// Surface request errors, such as an unknown session, from Dump
+	// rather than from the first Read.
+	if r.err = r.next(); r.err != nil && r.err != io.EOF {
+		return nil, r.err
+	}
+	return r, nil
+}
+
+// dumpReader reads a DumpChunk stream up to its DumpEnd.
+type dumpReader struct {
+	conn *protocol.Conn
+	op   string
+	buf  []byte
+	err  error
+}
+
+func (r *dumpReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next reads one message of the stream into buf, returning io.EOF at
+// DumpEnd.
+func (r *dumpReader) next() error {
+	msg, err := r.conn.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("read %s response: %w", r.op, err)
+	}
+	switch m := msg.(type) {
+	case *protocol.DumpChunk:
+		r.buf = m.Data
+		return nil
+	case *protocol.DumpEnd:
+		return io.EOF
+	case *protocol.Error:
+		return &ServerError{Op: r.op, Message: m.Message}
+	default:
+		return fmt.Errorf("unexpected response type: 0x%02x", msg.Type())
+	}
+}
+
+type WatchOpts struct {
+	Pattern string
+	Regex   bool
+	// Row restricts matching to one visible row; negative matches the
+	// whole screen.
+	Row int
+	// Timeout zero waits until the session exits.
+	Timeout time.Duration
+	Stable  time.Duration
+	// Prompt also requires the shell to be at its prompt, as its OSC 133
+	// marks report; Pattern may then be empty.
+	Prompt bool
+}
+
+// Watch blocks until the session screen matches opts, reporting false
+// when opts.Timeout elapses first.
+func (c *Client) Watch(name string, opts WatchOpts) (bool, error) {
+	if opts.Prompt && !c.conn.Has(protocol.CapCommands) {
+		return false, &protocol.CapabilityError{Type: protocol.TypeWatch, Capability: protocol.CapCommands}
+	}
+	timeout := uint32(opts.Timeout.Milliseconds())
+	if timeout == 0 && opts.Timeout > 0 {
+		// A timeout under a millisecond must not turn into no timeout.
+		timeout = 1
+	}
+	resp, err := request[*protocol.WatchResponse](c, "watch", &protocol.Watch{
+		Name:    name,
+		Pattern: opts.Pattern,
+		Regex:   opts.Regex,
+		Row:     int32(opts.Row),
+		Timeout: timeout,
+		Stable:  uint32(opts.Stable.Milliseconds()),
+		Prompt:  opts.Prompt,
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.Matched, nil
+}
+
+// Event is a session or client lifecycle event. Kind is one of created,
+// restored, exited, restarted, attached, detached or kicked, or one of
+// the monitor alerts bell, activity, silence, notify or match.
+type Event struct {
+	Kind    string `json:"event"`
+	Session string `json:"session"`
+	// Time is Unix seconds.
+	Time     uint32 `json:"time"`
+	PID      uint32 `json:"pid"`
+	ClientID string `json:"client_id"`
+	SessionExit
+	// Message is the text of a notify event or the line a match event
+	// matched.
+	Message string `json:"message"`
+	// Dropped counts events lost since the previous one because the
+	// subscriber fell behind.
+	Dropped uint32 `json:"dropped"`
+}
+
+// Subscribe turns the connection into an event stream for the named
+// sessions, or every session when names is empty. Read events with
+// NextEvent; the client cannot send other requests afterwards.
+func (c *Client) Subscribe(names []string) error {
+	return requestOK(c, "subscribe", &protocol.Subscribe{Names: names})
+}
+
+// NextEvent blocks until the daemon sends an event. It returns io.EOF
+// once the daemon closes the stream.
+func (c *Client) NextEvent() (Event, error) {
+	msg, err := c.conn.ReadMessage()
+	if err != nil {
+		return Event{}, err
+	}
+	ev, ok := msg.(*protocol.Event)
+	if !ok {
+		return Event{}, fmt.Errorf("unexpected event type: 0x%02x", msg.Type())
+	}
+	return Event{
+		Kind:        ev.Kind.String(),
+		Session:     ev.Session,
+		Time:        ev.Time,
+		PID:         ev.PID,
+		ClientID:    ev.ClientID,
+		SessionExit: sessionExitFromProtocol(ev.Exit),
+		Message:     ev.Message,
+		Dropped:     ev.Dropped,
+	}, nil
+}
+
+// ShellCommand is a command the session's shell reported through its
+// OSC 133 marks. Times are Unix seconds; FinishedAt is 0 while it runs
+// and ExitCode is nil unless the shell reported one. The output spans
+// OutputRows rows from OutputStartRow, counted from the oldest
+// scrollback row, until Trimmed is set.
+type ShellCommand struct {
+	Command        string `json:"command"`
+	StartedAt      uint32 `json:"started_at"`
+	FinishedAt     uint32 `json:"finished_at"`
+	DurationMS     uint32 `json:"duration_ms"`
+	ExitCode       *int32 `json:"exit_code"`
+	OutputStartRow uint32 `json:"output_start_row"`
+	OutputRows     uint32 `json:"output_rows"`
+	Trimmed        bool   `json:"trimmed"`
+}
+
+type History struct {
+	// AtPrompt is set while the shell waits for input at its prompt.
+	AtPrompt bool           `json:"at_prompt"`
+	Commands []ShellCommand `json:"commands"`
+}
+
+// History returns the commands a live session's shell has run, oldest
+// first. The shell must emit OSC 133 marks; the history is kept in
+// memory only while the session runs.
+func (c *Client) History(name string) (*History, error) {
+	if !c.conn.Has(protocol.CapCommands) {
+		return nil, &protocol.CapabilityError{Type: protocol.TypeHistory, Capability: protocol.CapCommands}
+	}
+	resp, err := request[*protocol.HistoryResponse](c, "history", &protocol.History{Name: name})
 	if err != nil {
 		return nil, err
 	}
-	return resp.Data, nil
+	history := &History{AtPrompt: resp.AtPrompt, Commands: make([]ShellCommand, 0, len(resp.Commands))}
+	for _, cmd := range resp.Commands {
+		sc := ShellCommand{
+			Command:        cmd.Command,
+			StartedAt:      cmd.StartedAt,
+			FinishedAt:     cmd.FinishedAt,
+			DurationMS:     cmd.Duration,
+			OutputStartRow: cmd.StartRow,
+			OutputRows:     cmd.Rows,
+			Trimmed:        cmd.Trimmed,
+		}
+		if cmd.HasExit {
+			code := cmd.ExitCode
+			sc.ExitCode = &code
+		}
+		history.Commands = append(history.Commands, sc)
+	}
+	return history, nil
+}
+
+// ExecResult is how a command run by Exec finished. ExitCode is nil
+// when the shell did not report one. TimedOut means the command was
+// still running at the timeout; it keeps running and Output is empty.
+type ExecResult struct {
+	Output   []byte
+	ExitCode *int32
+	TimedOut bool
+}
+
+// Exec types command at the prompt of a live session's shell and waits
+// for it to finish, at most timeout if it is positive. The shell must
+// report OSC 133 marks and be at its prompt. Output is the command's
+// output as plain text with soft-wrapped lines joined.
+func (c *Client) Exec(name, command string, timeout time.Duration) (*ExecResult, error) {
+	if !c.conn.Has(protocol.CapExec) {
+		return nil, &protocol.CapabilityError{Type: protocol.TypeExec, Capability: protocol.CapExec}
+	}
+	resp, err := request[*protocol.ExecResponse](c, "exec", &protocol.Exec{
+		Name:    name,
+		Command: command,
+		Timeout: uint32(timeout.Milliseconds()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &ExecResult{TimedOut: resp.TimedOut}
+	if resp.TimedOut {
+		return result, nil
+	}
+	if resp.HasExit {
+		code := resp.ExitCode
+		result.ExitCode = &code
+	}
+	// Daemons with CapExec also stream dumps, so the output always
+	// follows as chunks.
+	if result.Output, err = io.ReadAll(&dumpReader{conn: c.conn, op: "exec"}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type SearchMatch = protocol.SearchMatch
+
+type SearchOpts struct {
+	Pattern string
+	Regex   bool
+	// Dead also searches the saved state of dead sessions when no
+	// session names are given.
+	Dead bool
+	// Limit caps the matches returned; zero returns as many as fit in
+	// one response.
+	Limit int
+}
+
+type SearchResult struct {
+	Matches []SearchMatch
+	// Truncated is set when more lines matched than were returned.
+	Truncated bool
+}
+
+// Search returns the scrollback and screen lines of the named sessions,
+// or of every live session when names is empty, that match opts.
+func (c *Client) Search(names []string, opts SearchOpts) (*SearchResult, error) {
+	resp, err := request[*protocol.SearchResponse](c, "search", &protocol.Search{
+		Pattern: opts.Pattern,
+		Regex:   opts.Regex,
+		Names:   names,
+		Dead:    opts.Dead,
+		Limit:   uint32(max(opts.Limit, 0)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SearchResult{Matches: resp.Matches, Truncated: resp.Truncated}, nil
+}
+
+// StartLog starts logging the session's output to path, replacing any
+// log already running. An empty path uses the daemon's log directory.
+func (c *Client) StartLog(name, path string, format LogFormat) error {
+	return requestOK(c, "start log", &protocol.Log{Name: name, Enable: true, Path: path, Format: format})
+}
+
+func (c *Client) StopLog(name string) error {
+	return requestOK(c, "stop log", &protocol.Log{Name: name})
+}
+
+// StartRecording records the session as asciicast v2 to path, replacing
+// any recording already running.
+func (c *Client) StartRecording(name, path string) error {
+	return requestOK(c, "start recording", &protocol.Record{Name: name, Enable: true, Path: path})
+}
+
+func (c *Client) StopRecording(name string) error {
+	return requestOK(c, "stop recording", &protocol.Record{Name: name})
 }
 
 func (c *Client) Prune() (uint32, error) {
@@ -186,6 +649,31 @@ func (c *Client) Kick(name, clientID string) error {
 	return requestOK(c, "kick", &protocol.Kick{Name: name, ClientID: clientID})
 }
 
+// Monitor replaces the monitor settings of a live session.
+func (c *Client) Monitor(name string, settings MonitorSettings) error {
+	return requestOK(c, "monitor", &protocol.Monitor{Name: name, Settings: protocol.MonitorSettings(settings)})
+}
+
+// Upgrade has the daemon re-exec the ht binary at path, which takes over
+// its sessions. The daemon does not reply on success: the exec closes
+// the connection.
+func (c *Client) Upgrade(path string) error {
+	if err := c.conn.WriteMessage(&protocol.Upgrade{Path: path}); err != nil {
+		return fmt.Errorf("send upgrade: %w", err)
+	}
+	resp, err := c.conn.ReadMessage()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read upgrade response: %w", err)
+	}
+	if serverErr, ok := resp.(*protocol.Error); ok {
+		return &ServerError{Op: "upgrade", Message: serverErr.Message}
+	}
+	return fmt.Errorf("unexpected response type: 0x%02x", resp.Type())
+}
+
 func (c *Client) Detach() error {
 	return c.conn.WriteMessage(&protocol.Detach{})
 }
@@ -203,13 +691,20 @@ func statusFromProtocol(resp *protocol.StatusResponse) *Status {
 	}
 	if resp.Session != nil {
 		status.Session = &SessionStatus{
-			Name:    resp.Session.Name,
-			State:   SessionState(resp.Session.State),
-			Cols:    resp.Session.Cols,
-			Rows:    resp.Session.Rows,
-			PID:     resp.Session.PID,
-			CWD:     resp.Session.CWD,
-			Clients: sessionClientsFromProtocol(resp.Session.Clients),
+			Name:        resp.Session.Name,
+			State:       SessionState(resp.Session.State),
+			Cols:        resp.Session.Cols,
+			Rows:        resp.Session.Rows,
+			PID:         resp.Session.PID,
+			CWD:         resp.Session.CWD,
+			SessionExit: sessionExitFromProtocol(resp.Session.Exit),
+			Command:     resp.Session.Command,
+			Restart:     resp.Session.Restart.String(),
+			Restarts:    resp.Session.Restarts,
+			SlowKicks:   resp.Session.SlowKicks,
+			Clients:     sessionClientsFromProtocol(resp.Session.Clients),
+			Monitor:     MonitorSettings(resp.Session.Monitor),
+			Alerts:      resp.Session.Alerts.Names(),
 		}
 	}
 	return status
@@ -219,20 +714,31 @@ func sessionsFromProtocol(sessions []protocol.Session) []Session {
 	out := make([]Session, len(sessions))
 	for i, session := range sessions {
 		out[i] = Session{
-			Name:      session.Name,
-			State:     SessionState(session.State),
-			Cols:      session.Cols,
-			Rows:      session.Rows,
-			CWD:       session.CWD,
-			PID:       session.PID,
-			CreatedAt: session.CreatedAt,
-			SavedAt:   session.SavedAt,
-			Clients:   sessionClientsFromProtocol(session.Clients),
+			Name:        session.Name,
+			State:       SessionState(session.State),
+			Cols:        session.Cols,
+			Rows:        session.Rows,
+			CWD:         session.CWD,
+			PID:         session.PID,
+			CreatedAt:   session.CreatedAt,
+			SavedAt:     session.SavedAt,
+			SessionExit: sessionExitFromProtocol(session.Exit),
+			Command:     session.Command,
+			Clients:     sessionClientsFromProtocol(session.Clients),
+			Alerts:      session.Alerts.Names(),
 		}
 	}
 	return out
 }
 
+func sessionExitFromProtocol(exit protocol.SessionExit) SessionExit {
+	if !exit.Exited {
+		return SessionExit{}
+	}
+	code := exit.Code
+	return SessionExit{ExitCode: &code, ExitSignal: exit.Signal, ExitedAt: exit.At}
+}
+
 func sessionClientsFromProtocol(clients []protocol.SessionClient) []SessionClient {
 	out := make([]SessionClient, len(clients))
 	for i, client := range clients {
@@ -240,6 +746,11 @@ func sessionClientsFromProtocol(clients []protocol.SessionClient) []SessionClien
 			ClientID: client.ClientID,
 			ReadOnly: client.ReadOnly,
 			Version:  client.Version,
+			PID:      client.PID,
+			Protocol: client.Protocol,
+			Features: client.Features,
+			Resyncs:  client.Resyncs,
+			Dropped:  client.Dropped,
 		}
 	}
 	return out
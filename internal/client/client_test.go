@@ -1,6 +1,8 @@
 package client
 
 import (
+	"bytes"
+	"io"
 	"net"
 	"os"
 	"testing"
@@ -31,16 +33,16 @@ func TestCreateSessionSendsCreateRequest(t *testing.T) {
 		defer conn.Close()
 
 		pc := protocol.NewConn(conn)
-		clientVer, clientRev, err := pc.AcceptHandshake()
+		hello, err := pc.AcceptHandshake()
 		if err != nil {
 			done <- err
 			return
 		}
-		if clientVer != protocol.ProtocolVersion || clientRev == "" {
+		if hello.MaxVersion != protocol.ProtocolVersion || hello.Revision == "" {
 			done <- os.ErrInvalid
 			return
 		}
-		if err := pc.WriteVersionReply(clientVer, clientRev); err != nil {
+		if err := pc.WriteHandshakeReply(hello.MaxVersion, hello.Revision, hello.Capabilities); err != nil {
 			done <- err
 			return
 		}
@@ -99,8 +101,12 @@ func TestStatusFromProtocol(t *testing.T) {
 			PID:   100,
 			CWD:   "/tmp/demo",
 			Clients: []protocol.SessionClient{
-				{ClientID: "1", ReadOnly: true, Version: "client-v1"},
+				{ClientID: "1", ReadOnly: true, Version: "client-v1", PID: 4001},
 			},
+			Restart:  protocol.RestartOnFailure,
+			Restarts: 2,
+			Monitor:  protocol.MonitorSettings{Bell: true, Silence: 30},
+			Alerts:   protocol.AlertBell | protocol.AlertSilence,
 		},
 	})
 
@@ -114,15 +120,19 @@ func TestStatusFromProtocol(t *testing.T) {
 			Version:      "v1",
 		},
 		Session: &SessionStatus{
-			Name:  "demo",
-			State: SessionStateRunning,
-			Cols:  80,
-			Rows:  24,
-			PID:   100,
-			CWD:   "/tmp/demo",
+			Name:     "demo",
+			State:    SessionStateRunning,
+			Cols:     80,
+			Rows:     24,
+			PID:      100,
+			CWD:      "/tmp/demo",
+			Restart:  "on-failure",
+			Restarts: 2,
 			Clients: []SessionClient{
-				{ClientID: "1", ReadOnly: true, Version: "client-v1"},
+				{ClientID: "1", ReadOnly: true, Version: "client-v1", PID: 4001},
 			},
+			Monitor: MonitorSettings{Bell: true, Silence: 30},
+			Alerts:  []string{"bell", "silence"},
 		},
 	})
 }
@@ -149,12 +159,12 @@ func TestConnectAcceptsRevisionMismatchWhenProtocolMatches(t *testing.T) {
 		defer conn.Close()
 
 		pc := protocol.NewConn(conn)
-		clientVer, _, err := pc.AcceptHandshake()
+		hello, err := pc.AcceptHandshake()
 		if err != nil {
 			done <- err
 			return
 		}
-		err = pc.WriteVersionReply(clientVer, "different-revision")
+		err = pc.WriteHandshakeReply(hello.MaxVersion, "different-revision", hello.Capabilities)
 		done <- err
 	}()
 
@@ -163,3 +173,83 @@ func TestConnectAcceptsRevisionMismatchWhenProtocolMatches(t *testing.T) {
 	assert.NilError(t, c.Close())
 	assert.NilError(t, <-done)
 }
+
+// serveHandshake accepts one connection, negotiates like the daemon and
+// hands the connection to serve.
+func serveHandshake(t *testing.T, serve func(*protocol.Conn) error) (string, <-chan error) {
+	t.Helper()
+	sock, ln := newProbeListener(t)
+	t.Cleanup(func() { ln.Close() })
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		pc := protocol.NewConn(conn)
+		hello, err := pc.AcceptHandshake()
+		if err != nil {
+			done <- err
+			return
+		}
+		version, caps := protocol.Negotiate(hello)
+		if err := pc.WriteHandshakeReply(version, "server-revision", caps); err != nil {
+			done <- err
+			return
+		}
+		done <- serve(pc)
+	}()
+	return sock, done
+}
+
+func TestDumpStreamsChunks(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789\n"), protocol.DumpChunkSize/5)
+	sock, done := serveHandshake(t, func(pc *protocol.Conn) error {
+		msg, err := pc.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if _, ok := msg.(*protocol.Dump); !ok {
+			return os.ErrInvalid
+		}
+		if err := pc.WriteDump(data); err != nil {
+			return err
+		}
+		// The client is usable again once the stream is drained.
+		if _, err := pc.ReadMessage(); err != nil {
+			return err
+		}
+		return pc.WriteMessage(&protocol.OK{})
+	})
+
+	c, err := Connect(sock)
+	assert.NilError(t, err)
+	defer c.Close()
+	r, err := c.Dump("demo", DumpPlain)
+	assert.NilError(t, err)
+	got, err := io.ReadAll(r)
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got, data))
+	assert.NilError(t, c.Kill("demo"))
+	assert.NilError(t, <-done)
+}
+
+func TestDumpReturnsServerError(t *testing.T) {
+	sock, done := serveHandshake(t, func(pc *protocol.Conn) error {
+		if _, err := pc.ReadMessage(); err != nil {
+			return err
+		}
+		return pc.WriteMessage(&protocol.Error{Message: "session not found"})
+	})
+
+	c, err := Connect(sock)
+	assert.NilError(t, err)
+	defer c.Close()
+	_, err = c.Dump("missing", DumpPlain)
+	assert.Error(t, err, "dump: session not found")
+	assert.NilError(t, <-done)
+}
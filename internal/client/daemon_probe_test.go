@@ -37,12 +37,12 @@ func TestProbeDaemonAcceptsHandshakeServer(t *testing.T) {
 		defer conn.Close()
 
 		pc := protocol.NewConn(conn)
-		clientVer, _, err := pc.AcceptHandshake()
+		hello, err := pc.AcceptHandshake()
 		if err != nil {
 			done <- err
 			return
 		}
-		done <- pc.WriteVersionReply(clientVer, "server-revision")
+		done <- pc.WriteHandshakeReply(hello.MaxVersion, "server-revision", hello.Capabilities)
 	}()
 
 	running, err := ProbeDaemon(sock)
@@ -66,18 +66,18 @@ func TestProbeDaemonRejectsVersionMismatch(t *testing.T) {
 		defer conn.Close()
 
 		pc := protocol.NewConn(conn)
-		_, _, err = pc.AcceptHandshake()
+		_, err = pc.AcceptHandshake()
 		if err != nil {
 			done <- err
 			return
 		}
-		done <- pc.WriteVersionReply(0, "")
+		done <- pc.WriteHandshakeReply(0, "", nil)
 	}()
 
 	running, err := ProbeDaemon(sock)
 
 	assert.Equal(t, running, false)
-	assert.Error(t, err, "protocol version mismatch: server accepted 0, expected 8")
+	assert.Error(t, err, "protocol version mismatch: server accepted 0, expected 8-9")
 	assert.NilError(t, <-done)
 }
 
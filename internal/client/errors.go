@@ -1,6 +1,24 @@
 package client
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"code.selman.me/hauntty/internal/protocol"
+)
+
+// Errors a ServerError matches with errors.Is, by the daemon's message.
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionExists also covers a name held by a dead session's saved
+	// state.
+	ErrSessionExists  = errors.New("session already exists")
+	ErrClientNotFound = errors.New("client not found")
+	// ErrNotNegotiated also matches requests refused before they were
+	// sent, because the daemon did not announce the capability.
+	ErrNotNegotiated = protocol.ErrNotNegotiated
+)
 
 type ExitError struct {
 	Code int
@@ -23,3 +41,17 @@ type ServerError struct {
 func (e *ServerError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Op, e.Message)
 }
+
+func (e *ServerError) Is(target error) bool {
+	switch target {
+	case ErrSessionNotFound:
+		return strings.HasPrefix(e.Message, "session not found")
+	case ErrSessionExists:
+		return e.Message == "session already exists" || strings.HasPrefix(e.Message, "dead session state exists")
+	case ErrClientNotFound:
+		return e.Message == "client not found"
+	case ErrNotNegotiated:
+		return strings.HasPrefix(e.Message, "capability ") && strings.HasSuffix(e.Message, " not negotiated")
+	}
+	return false
+}
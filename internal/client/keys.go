@@ -173,12 +173,12 @@ func ParseDetachKey(notation string) (DetachKey, error) {
 		return DetachKey{}, fmt.Errorf("encode detach key: %w", err)
 	}
 	defer term.Close()
-	encoder, err := libghostty.NewKeyEncoder()
+	encoder, err := libghostty.NewKeyEncoder(term)
 	if err != nil {
 		return DetachKey{}, fmt.Errorf("encode detach key: %w", err)
 	}
 	defer encoder.Close()
-	event, err := libghostty.NewKeyEvent()
+	event, err := libghostty.NewKeyEvent(term)
 	if err != nil {
 		return DetachKey{}, fmt.Errorf("encode detach key: %w", err)
 	}
@@ -226,7 +226,9 @@ func encodeDetachKey(encoder *libghostty.KeyEncoder, event *libghostty.KeyEvent,
 	event.SetComposing(false)
 	event.SetUnshiftedCodepoint(rune(code))
 	event.SetUTF8(text)
-	encoder.SetOptFromTerminal(term)
+	if err := encoder.SetOptFromTerminal(term); err != nil {
+		return nil, err
+	}
 	return encoder.Encode(event)
 }
 
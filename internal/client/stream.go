@@ -0,0 +1,137 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"code.selman.me/hauntty/internal/protocol"
+)
+
+// StreamOpts configures an attach without a terminal.
+type StreamOpts struct {
+	Name string
+	// Command is used only when the attach creates the session.
+	Command  []string
+	Cols     uint16
+	Rows     uint16
+	Env      []string
+	CWD      string
+	ReadOnly bool
+	// Restore restarts a dead session from its saved state.
+	Restore bool
+}
+
+// Stream is an attached session read and written as a byte stream.
+// Reads return the VT-encoded screen the session had when the stream
+// attached, then the session's output; writes are sent as input.
+type Stream struct {
+	Name     string
+	PID      uint32
+	ClientID string
+	// Created reports whether the attach created the session.
+	Created bool
+
+	c        *Client
+	readOnly bool
+
+	rmu sync.Mutex
+	buf []byte
+	err error
+}
+
+// AttachStream attaches to the named session, creating it if needed. The
+// client's connection belongs to the stream afterwards; closing the
+// stream detaches and closes the client.
+func (c *Client) AttachStream(opts StreamOpts) (*Stream, error) {
+	if opts.Cols == 0 || opts.Rows == 0 {
+		return nil, fmt.Errorf("attach size required")
+	}
+	attached, err := c.attach(&protocol.Attach{
+		Name:     opts.Name,
+		Command:  opts.Command,
+		Cols:     opts.Cols,
+		Rows:     opts.Rows,
+		Env:      opts.Env,
+		CWD:      opts.CWD,
+		ReadOnly: opts.ReadOnly,
+		Restore:  opts.Restore,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{
+		Name:     attached.Name,
+		PID:      attached.PID,
+		ClientID: attached.ClientID,
+		Created:  attached.Created,
+		c:        c,
+		readOnly: opts.ReadOnly,
+		buf:      attached.ScreenDump,
+	}, nil
+}
+
+// Read returns session output. Once the session's process exits it
+// returns an *ExitError with the exit code; once the stream is detached,
+// kicked or closed it returns io.EOF.
+func (s *Stream) Read(p []byte) (int, error) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.buf, s.err = s.next()
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *Stream) next() ([]byte, error) {
+	msg, err := s.c.conn.ReadMessage()
+	if err != nil {
+		if errors.Is(err, io.EOF) || isConnClosed(err) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("read message: %w", err)
+	}
+	switch m := msg.(type) {
+	case *protocol.Output:
+		return m.Data, nil
+	case *protocol.Exited:
+		return nil, &ExitError{Code: int(m.ExitCode)}
+	case *protocol.Error:
+		return nil, &ServerError{Op: "attach", Message: m.Message}
+	default:
+		return nil, nil
+	}
+}
+
+// Write sends p to the session as input. Read-only streams discard it.
+func (s *Stream) Write(p []byte) (int, error) {
+	if s.readOnly || len(p) == 0 {
+		return len(p), nil
+	}
+	if err := s.c.conn.WriteMessage(&protocol.Input{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Resize reports a new terminal size for this client; the session's
+// resize policy decides what the session uses.
+func (s *Stream) Resize(cols, rows uint16) error {
+	if s.readOnly {
+		return nil
+	}
+	return s.c.conn.WriteMessage(&protocol.Resize{Cols: cols, Rows: rows})
+}
+
+// Close detaches from the session, which keeps running, and closes the
+// client.
+func (s *Stream) Close() error {
+	_ = s.c.Detach()
+	return s.c.Close()
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
@@ -16,16 +19,102 @@ type Config struct {
 }
 
 type DaemonConfig struct {
-	SocketPath               string `toml:"socket_path"`
-	AutoExit                 bool   `toml:"auto_exit"`
-	DefaultScrollback        uint32 `toml:"default_scrollback"`
-	StatePersistence         bool   `toml:"state_persistence"`
-	StatePersistenceInterval int    `toml:"state_persistence_interval"`
+	SocketPath               string           `toml:"socket_path"`
+	AutoExit                 bool             `toml:"auto_exit"`
+	DefaultScrollback        uint32           `toml:"default_scrollback"`
+	StatePersistence         bool             `toml:"state_persistence"`
+	StatePersistenceInterval int              `toml:"state_persistence_interval"`
+	SessionLog               SessionLogConfig `toml:"session_log"`
+	Restart                  RestartConfig    `toml:"restart"`
+	SlowClient               SlowClientPolicy `toml:"slow_client"`
+	Hooks                    HooksConfig      `toml:"hooks"`
+	Monitor                  MonitorConfig    `toml:"monitor"`
 }
 
+type LogFormat string
+
+const (
+	LogFormatRaw   LogFormat = "raw"
+	LogFormatPlain LogFormat = "plain"
+)
+
+// SessionLogConfig controls per-session output logs. Sessions log to
+// Dir/<name>.log when Dir is set; explicit --log paths work without it.
+type SessionLogConfig struct {
+	Dir       string    `toml:"dir"`
+	Format    LogFormat `toml:"format"`
+	MaxSizeMB int       `toml:"max_size_mb"`
+	MaxFiles  int       `toml:"max_files"`
+}
+
+type RestartPolicy string
+
+const (
+	RestartNever     RestartPolicy = "never"
+	RestartOnFailure RestartPolicy = "on-failure"
+	RestartAlways    RestartPolicy = "always"
+)
+
+// RestartConfig controls whether sessions rerun their command when it
+// exits. Policy is the default for sessions created without --restart.
+type RestartConfig struct {
+	Policy       RestartPolicy `toml:"policy"`
+	MaxRetries   int           `toml:"max_retries"`
+	BackoffMS    int           `toml:"backoff_ms"`
+	MaxBackoffMS int           `toml:"max_backoff_ms"`
+}
+
+// HooksConfig names shell commands the daemon runs on lifecycle events.
+// Empty commands are skipped. Hooks run in the background and are killed
+// once they run longer than TimeoutMS.
+type HooksConfig struct {
+	OnCreated  string `toml:"on_created"`
+	OnExited   string `toml:"on_exited"`
+	OnRestored string `toml:"on_restored"`
+	OnAttached string `toml:"on_attached"`
+	// OnDetached also runs for kicked clients.
+	OnDetached string `toml:"on_detached"`
+	OnShutdown string `toml:"on_shutdown"`
+	// OnAlert is the notification hook: it runs for every monitor alert
+	// (bell, activity, silence, notify and match).
+	OnAlert   string `toml:"on_alert"`
+	TimeoutMS int    `toml:"timeout_ms"`
+}
+
+// MonitorConfig is the monitor setting new sessions start with; `ht
+// monitor` changes it per session. Monitors raise alerts only while no
+// client is attached.
+type MonitorConfig struct {
+	// Bell alerts on BEL and on OSC 9 and 777 notifications.
+	Bell bool `toml:"bell"`
+	// Activity alerts on the first output.
+	Activity bool `toml:"activity"`
+	// SilenceSeconds alerts once output stops for this long; 0 disables
+	// it.
+	SilenceSeconds int `toml:"silence_seconds"`
+	// Pattern alerts on output lines matching this regular expression.
+	Pattern string `toml:"pattern"`
+}
+
+// SlowClientPolicy decides what happens to an attached client that falls
+// so far behind the session's output that its queue fills up. Either way
+// the session and its other clients keep going.
+type SlowClientPolicy string
+
+const (
+	// SlowClientResync drops the client's queued output and sends it a
+	// fresh screen once it has room.
+	SlowClientResync SlowClientPolicy = "resync"
+	// SlowClientKick disconnects the client.
+	SlowClientKick SlowClientPolicy = "kick"
+)
+
 type ClientConfig struct {
 	DetachKeybind string   `toml:"detach_keybind"`
 	ForwardEnv    []string `toml:"forward_env"`
+	// ShowAlerts has an attached client raise a terminal notification
+	// for monitor alerts from other sessions.
+	ShowAlerts bool `toml:"show_alerts"`
 }
 
 type ResizePolicy string
@@ -35,8 +124,55 @@ const (
 	ResizePolicyLargest  ResizePolicy = "largest"
 	ResizePolicyFirst    ResizePolicy = "first"
 	ResizePolicyLast     ResizePolicy = "last"
+	// ResizePolicyFixed keeps a session at the size it was created with,
+	// whatever the size of the clients attached.
+	ResizePolicyFixed ResizePolicy = "fixed"
 )
 
+func (p ResizePolicy) Valid() bool {
+	switch p {
+	case ResizePolicySmallest, ResizePolicyLargest, ResizePolicyFirst, ResizePolicyLast, ResizePolicyFixed:
+		return true
+	}
+	return false
+}
+
+// Size is a width and height written WIDTHxHEIGHT, such as a session's
+// size in cells ("120x40") or in pixels. The zero Size means unset.
+type Size struct {
+	Width  uint16
+	Height uint16
+}
+
+func ParseSize(s string) (Size, error) {
+	w, h, ok := strings.Cut(s, "x")
+	if !ok {
+		return Size{}, fmt.Errorf("invalid size %q, want WIDTHxHEIGHT", s)
+	}
+	width, err := strconv.ParseUint(w, 10, 16)
+	if err != nil || width == 0 {
+		return Size{}, fmt.Errorf("invalid size %q, want WIDTHxHEIGHT", s)
+	}
+	height, err := strconv.ParseUint(h, 10, 16)
+	if err != nil || height == 0 {
+		return Size{}, fmt.Errorf("invalid size %q, want WIDTHxHEIGHT", s)
+	}
+	return Size{Width: uint16(width), Height: uint16(height)}, nil
+}
+
+func (s *Size) UnmarshalText(text []byte) error {
+	size, err := ParseSize(string(text))
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
+
+func (s Size) String() string {
+	return fmt.Sprintf("%dx%d", s.Width, s.Height)
+}
+
 type SessionConfig struct {
 	DefaultCommand string       `toml:"default_command"`
 	ResizePolicy   ResizePolicy `toml:"resize_policy"`
@@ -48,6 +184,24 @@ func Default() *Config {
 			DefaultScrollback:        10000,
 			StatePersistence:         true,
 			StatePersistenceInterval: 30,
+			SessionLog: SessionLogConfig{
+				Format:    LogFormatRaw,
+				MaxSizeMB: 64,
+				MaxFiles:  3,
+			},
+			Restart: RestartConfig{
+				Policy:       RestartNever,
+				MaxRetries:   5,
+				BackoffMS:    1000,
+				MaxBackoffMS: 30000,
+			},
+			SlowClient: SlowClientResync,
+			Hooks: HooksConfig{
+				TimeoutMS: 10000,
+			},
+			Monitor: MonitorConfig{
+				Bell: true,
+			},
 		},
 		Client: ClientConfig{
 			// TODO: ctrl+; requires kitty keyboard protocol, consider ctrl+]
@@ -87,11 +241,54 @@ func LoadFrom(path string) (*Config, error) {
 }
 
 func (c *Config) validate() error {
-	switch c.Session.ResizePolicy {
-	case ResizePolicySmallest, ResizePolicyLargest, ResizePolicyFirst, ResizePolicyLast:
-	default:
+	if !c.Session.ResizePolicy.Valid() {
 		return fmt.Errorf("invalid resize_policy %q", c.Session.ResizePolicy)
 	}
+	return c.Daemon.Validate()
+}
+
+// Validate reports the first daemon setting LoadFrom would reject.
+func (c *DaemonConfig) Validate() error {
+	if c.StatePersistence && c.StatePersistenceInterval <= 0 {
+		return fmt.Errorf("state_persistence_interval must be > 0 when state persistence is enabled")
+	}
+	switch c.SessionLog.Format {
+	case LogFormatRaw, LogFormatPlain:
+	default:
+		return fmt.Errorf("invalid session_log.format %q", c.SessionLog.Format)
+	}
+	if c.SessionLog.MaxSizeMB < 0 || c.SessionLog.MaxFiles < 0 {
+		return fmt.Errorf("session_log.max_size_mb and session_log.max_files must be >= 0")
+	}
+	switch c.Restart.Policy {
+	case RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("invalid restart.policy %q", c.Restart.Policy)
+	}
+	if c.Restart.MaxRetries < 0 {
+		return fmt.Errorf("restart.max_retries must be >= 0")
+	}
+	// A zero delay would respawn a failing command in a tight loop.
+	if c.Restart.BackoffMS <= 0 {
+		return fmt.Errorf("restart.backoff_ms must be > 0")
+	}
+	if c.Restart.MaxBackoffMS < c.Restart.BackoffMS {
+		return fmt.Errorf("restart.max_backoff_ms must be >= restart.backoff_ms")
+	}
+	switch c.SlowClient {
+	case SlowClientResync, SlowClientKick:
+	default:
+		return fmt.Errorf("invalid slow_client %q", c.SlowClient)
+	}
+	if c.Hooks.TimeoutMS <= 0 {
+		return fmt.Errorf("hooks.timeout_ms must be > 0")
+	}
+	if c.Monitor.SilenceSeconds < 0 {
+		return fmt.Errorf("monitor.silence_seconds must be >= 0")
+	}
+	if _, err := regexp.Compile(c.Monitor.Pattern); err != nil {
+		return fmt.Errorf("invalid monitor.pattern: %w", err)
+	}
 	return nil
 }
 
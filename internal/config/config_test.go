@@ -17,6 +17,9 @@ func TestDefaults(t *testing.T) {
 	assert.Equal(t, cfg.Session.DefaultCommand, "")
 	assert.DeepEqual(t, cfg.Client.ForwardEnv, []string{"COLORTERM", "GHOSTTY_RESOURCES_DIR", "GHOSTTY_BIN_DIR"})
 	assert.Equal(t, cfg.Session.ResizePolicy, ResizePolicySmallest)
+	assert.Equal(t, cfg.Daemon.SessionLog.Format, LogFormatRaw)
+	assert.Equal(t, cfg.Daemon.Restart.Policy, RestartNever)
+	assert.Equal(t, cfg.Daemon.SlowClient, SlowClientResync)
 }
 
 func TestLoadMissing(t *testing.T) {
@@ -87,12 +90,25 @@ resize_policy = "bogus"
 	assert.Error(t, err, "config: "+path+": invalid resize_policy \"bogus\"")
 }
 
+func TestParseSize(t *testing.T) {
+	size, err := ParseSize("120x40")
+	assert.NilError(t, err)
+	assert.Equal(t, size, Size{Width: 120, Height: 40})
+	assert.Equal(t, size.String(), "120x40")
+
+	for _, bad := range []string{"", "120", "x40", "120x", "0x40", "120x0", "70000x40", "-1x40", "120X40"} {
+		_, err := ParseSize(bad)
+		assert.ErrorContains(t, err, "invalid size", bad)
+	}
+}
+
 func TestLoadValidResizePolicies(t *testing.T) {
 	policies := []ResizePolicy{
 		ResizePolicySmallest,
 		ResizePolicyLargest,
 		ResizePolicyFirst,
 		ResizePolicyLast,
+		ResizePolicyFixed,
 	}
 	for _, p := range policies {
 		t.Run(string(p), func(t *testing.T) {
@@ -108,3 +124,138 @@ func TestLoadValidResizePolicies(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadSessionLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	err := os.WriteFile(path, []byte(`[daemon.session_log]
+dir = "/var/log/hauntty"
+format = "plain"
+max_files = 0
+`), 0o600)
+	assert.NilError(t, err)
+
+	cfg, err := LoadFrom(path)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cfg.Daemon.SessionLog, SessionLogConfig{
+		Dir:       "/var/log/hauntty",
+		Format:    LogFormatPlain,
+		MaxSizeMB: 64,
+		MaxFiles:  0,
+	})
+}
+
+func TestLoadInvalidSessionLogFormat(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	err := os.WriteFile(path, []byte(`[daemon.session_log]
+format = "html"
+`), 0o600)
+	assert.NilError(t, err)
+
+	_, err = LoadFrom(path)
+	assert.Error(t, err, "config: "+path+": invalid session_log.format \"html\"")
+}
+
+func TestLoadRestart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	err := os.WriteFile(path, []byte(`[daemon.restart]
+policy = "on-failure"
+max_retries = 0
+`), 0o600)
+	assert.NilError(t, err)
+
+	cfg, err := LoadFrom(path)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cfg.Daemon.Restart, RestartConfig{
+		Policy:       RestartOnFailure,
+		MaxRetries:   0,
+		BackoffMS:    1000,
+		MaxBackoffMS: 30000,
+	})
+}
+
+func TestLoadInvalidRestartPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	err := os.WriteFile(path, []byte(`[daemon.restart]
+policy = "sometimes"
+`), 0o600)
+	assert.NilError(t, err)
+
+	_, err = LoadFrom(path)
+	assert.Error(t, err, "config: "+path+": invalid restart.policy \"sometimes\"")
+}
+
+func TestLoadInvalidRestartBackoff(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{"backoff_ms = 0", "restart.backoff_ms must be > 0"},
+		{"max_backoff_ms = 0", "restart.max_backoff_ms must be >= restart.backoff_ms"},
+		{"backoff_ms = 5000\nmax_backoff_ms = 1000", "restart.max_backoff_ms must be >= restart.backoff_ms"},
+		{"max_retries = -1", "restart.max_retries must be >= 0"},
+	}
+	for _, tt := range tests {
+		assert.NilError(t, os.WriteFile(path, []byte("[daemon.restart]\n"+tt.config+"\n"), 0o600))
+		_, err := LoadFrom(path)
+		assert.Error(t, err, "config: "+path+": "+tt.want, tt.config)
+	}
+}
+
+func TestLoadSlowClient(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	assert.NilError(t, os.WriteFile(path, []byte("[daemon]\nslow_client = \"kick\"\n"), 0o600))
+	cfg, err := LoadFrom(path)
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.Daemon.SlowClient, SlowClientKick)
+
+	assert.NilError(t, os.WriteFile(path, []byte("[daemon]\nslow_client = \"wait\"\n"), 0o600))
+	_, err = LoadFrom(path)
+	assert.Error(t, err, "config: "+path+": invalid slow_client \"wait\"")
+}
+
+func TestLoadHooks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	assert.NilError(t, os.WriteFile(path, []byte(`[daemon.hooks]
+on_exited = "notify-send \"$HAUNTTY_SESSION exited\""
+`), 0o600))
+	cfg, err := LoadFrom(path)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cfg.Daemon.Hooks, HooksConfig{
+		OnExited:  `notify-send "$HAUNTTY_SESSION exited"`,
+		TimeoutMS: 10000,
+	})
+
+	assert.NilError(t, os.WriteFile(path, []byte("[daemon.hooks]\ntimeout_ms = 0\n"), 0o600))
+	_, err = LoadFrom(path)
+	assert.Error(t, err, "config: "+path+": hooks.timeout_ms must be > 0")
+}
+
+func TestLoadMonitor(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	assert.NilError(t, os.WriteFile(path, []byte(`[daemon.monitor]
+activity = true
+silence_seconds = 30
+pattern = "FAIL|panic:"
+`), 0o600))
+	cfg, err := LoadFrom(path)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cfg.Daemon.Monitor, MonitorConfig{
+		Bell:           true,
+		Activity:       true,
+		SilenceSeconds: 30,
+		Pattern:        "FAIL|panic:",
+	})
+
+	assert.NilError(t, os.WriteFile(path, []byte("[daemon.monitor]\npattern = \"(\"\n"), 0o600))
+	_, err = LoadFrom(path)
+	assert.ErrorContains(t, err, "invalid monitor.pattern")
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Workspace is a set of session templates brought up together by
+// `ht up`, read from hauntty.toml by default.
+type Workspace struct {
+	Sessions []SessionTemplate `toml:"session"`
+}
+
+// SessionTemplate describes one session of a workspace. CWD is relative
+// to the workspace file's directory, which is also the default.
+type SessionTemplate struct {
+	Name       string            `toml:"name"`
+	Command    []string          `toml:"command"`
+	CWD        string            `toml:"cwd"`
+	Env        map[string]string `toml:"env"`
+	Scrollback uint32            `toml:"scrollback"`
+	// Size and PixelSize are zero for the daemon's default size.
+	Size      Size `toml:"size"`
+	PixelSize Size `toml:"pixel_size"`
+	// Restart and ResizePolicy are empty for the daemon's configured
+	// policy.
+	Restart      RestartPolicy `toml:"restart"`
+	ResizePolicy ResizePolicy  `toml:"resize_policy"`
+	Wait         WaitTemplate  `toml:"wait"`
+}
+
+// WaitTemplate is a readiness check run after the session is created.
+// It is skipped when Pattern is empty and Stable is 0.
+type WaitTemplate struct {
+	Pattern string `toml:"pattern"`
+	Regex   bool   `toml:"regex"`
+	// Timeout and Stable are milliseconds, as with `ht wait`: a nil
+	// Timeout waits DefaultWaitTimeout and 0 waits forever.
+	Timeout *int `toml:"timeout"`
+	Stable  int  `toml:"stable"`
+}
+
+// DefaultWaitTimeout is how long `ht wait` and a workspace wait without
+// a timeout wait for a match.
+const DefaultWaitTimeout = 30 * time.Second
+
+// TimeoutDuration returns Timeout, or DefaultWaitTimeout when it is not
+// set; zero means no timeout.
+func (w WaitTemplate) TimeoutDuration() time.Duration {
+	if w.Timeout == nil {
+		return DefaultWaitTimeout
+	}
+	return time.Duration(*w.Timeout) * time.Millisecond
+}
+
+func (w WaitTemplate) Enabled() bool {
+	return w.Pattern != "" || w.Stable > 0
+}
+
+// EnvList returns Env as KEY=VALUE pairs sorted by key.
+func (t SessionTemplate) EnvList() []string {
+	keys := make([]string, 0, len(t.Env))
+	for k := range t.Env {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	env := make([]string, len(keys))
+	for i, k := range keys {
+		env[i] = k + "=" + t.Env[k]
+	}
+	return env
+}
+
+func LoadWorkspace(path string) (*Workspace, error) {
+	var ws Workspace
+	md, err := toml.DecodeFile(path, &ws)
+	if err != nil {
+		return nil, fmt.Errorf("workspace: parse %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("workspace: %s: unknown key %q", path, undecoded[0].String())
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("workspace: %w", err)
+	}
+	dir := filepath.Dir(abs)
+	for i := range ws.Sessions {
+		st := &ws.Sessions[i]
+		if !filepath.IsAbs(st.CWD) {
+			st.CWD = filepath.Join(dir, st.CWD)
+		}
+	}
+
+	if err := ws.validate(); err != nil {
+		return nil, fmt.Errorf("workspace: %s: %w", path, err)
+	}
+	return &ws, nil
+}
+
+func (w *Workspace) validate() error {
+	if len(w.Sessions) == 0 {
+		return fmt.Errorf("no sessions defined")
+	}
+	seen := make(map[string]bool, len(w.Sessions))
+	for i, st := range w.Sessions {
+		if st.Name == "" {
+			return fmt.Errorf("session %d: name is required", i+1)
+		}
+		if seen[st.Name] {
+			return fmt.Errorf("session %q defined twice", st.Name)
+		}
+		seen[st.Name] = true
+		switch st.Restart {
+		case "", RestartNever, RestartOnFailure, RestartAlways:
+		default:
+			return fmt.Errorf("session %q: invalid restart %q", st.Name, st.Restart)
+		}
+		if st.ResizePolicy != "" && !st.ResizePolicy.Valid() {
+			return fmt.Errorf("session %q: invalid resize_policy %q", st.Name, st.ResizePolicy)
+		}
+		if st.PixelSize != (Size{}) && st.Size == (Size{}) {
+			return fmt.Errorf("session %q: pixel_size requires size", st.Name)
+		}
+		if (st.Wait.Timeout != nil && *st.Wait.Timeout < 0) || st.Wait.Stable < 0 {
+			return fmt.Errorf("session %q: wait.timeout and wait.stable must be >= 0", st.Name)
+		}
+		if st.Wait.Regex {
+			if _, err := regexp.Compile(st.Wait.Pattern); err != nil {
+				return fmt.Errorf("session %q: invalid wait.pattern: %w", st.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func writeWorkspace(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hauntty.toml")
+	assert.NilError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadWorkspace(t *testing.T) {
+	path := writeWorkspace(t, `[[session]]
+name = "db"
+command = ["psql"]
+cwd = "/srv/db"
+wait = { pattern = "=#" }
+
+[[session]]
+name = "api"
+command = ["go", "run", "./cmd/api"]
+cwd = "services/api"
+scrollback = 50000
+restart = "on-failure"
+size = "120x40"
+pixel_size = "960x640"
+resize_policy = "fixed"
+env = { PORT = "8080", DEBUG = "1" }
+
+[session.wait]
+pattern = 'listening on :\d+'
+regex = true
+timeout = 60000
+stable = 200
+`)
+
+	ws, err := LoadWorkspace(path)
+	assert.NilError(t, err)
+	dir := filepath.Dir(path)
+	assert.DeepEqual(t, ws, &Workspace{Sessions: []SessionTemplate{
+		{
+			Name:    "db",
+			Command: []string{"psql"},
+			CWD:     "/srv/db",
+			Wait:    WaitTemplate{Pattern: "=#"},
+		},
+		{
+			Name:         "api",
+			Command:      []string{"go", "run", "./cmd/api"},
+			CWD:          filepath.Join(dir, "services/api"),
+			Env:          map[string]string{"PORT": "8080", "DEBUG": "1"},
+			Scrollback:   50000,
+			Size:         Size{Width: 120, Height: 40},
+			PixelSize:    Size{Width: 960, Height: 640},
+			Restart:      RestartOnFailure,
+			ResizePolicy: ResizePolicyFixed,
+			Wait:         WaitTemplate{Pattern: `listening on :\d+`, Regex: true, Timeout: new(60000), Stable: 200},
+		},
+	}})
+	assert.DeepEqual(t, ws.Sessions[1].EnvList(), []string{"DEBUG=1", "PORT=8080"})
+	assert.Equal(t, ws.Sessions[0].Wait.Enabled(), true)
+	assert.Equal(t, ws.Sessions[0].Wait.TimeoutDuration(), DefaultWaitTimeout)
+	assert.Equal(t, ws.Sessions[1].Wait.TimeoutDuration(), time.Minute)
+}
+
+func TestLoadWorkspaceZeroWaitTimeoutWaitsForever(t *testing.T) {
+	path := writeWorkspace(t, `[[session]]
+name = "build"
+wait = { pattern = "done", timeout = 0 }
+`)
+
+	ws, err := LoadWorkspace(path)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ws.Sessions[0].Wait, WaitTemplate{Pattern: "done", Timeout: new(0)})
+	assert.Equal(t, ws.Sessions[0].Wait.TimeoutDuration(), time.Duration(0))
+}
+
+func TestLoadWorkspaceDefaultsCWDToFileDir(t *testing.T) {
+	path := writeWorkspace(t, `[[session]]
+name = "shell"
+`)
+
+	ws, err := LoadWorkspace(path)
+	assert.NilError(t, err)
+	assert.Equal(t, ws.Sessions[0].CWD, filepath.Dir(path))
+	assert.Equal(t, ws.Sessions[0].Wait.Enabled(), false)
+}
+
+func TestLoadWorkspaceErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"empty", ``, "no sessions defined"},
+		{"missing name", "[[session]]\ncommand = [\"top\"]\n", "session 1: name is required"},
+		{"duplicate", "[[session]]\nname = \"a\"\n[[session]]\nname = \"a\"\n", `session "a" defined twice`},
+		{"bad regex", "[[session]]\nname = \"a\"\nwait = { pattern = \"(\", regex = true }\n", `session "a": invalid wait.pattern`},
+		{"bad restart", "[[session]]\nname = \"a\"\nrestart = \"sometimes\"\n", `session "a": invalid restart "sometimes"`},
+		{"bad size", "[[session]]\nname = \"a\"\nsize = \"wide\"\n", `invalid size "wide"`},
+		{"bad resize policy", "[[session]]\nname = \"a\"\nresize_policy = \"auto\"\n", `session "a": invalid resize_policy "auto"`},
+		{"pixel size without size", "[[session]]\nname = \"a\"\npixel_size = \"960x640\"\n", `session "a": pixel_size requires size`},
+		{"unknown key", "[[session]]\nname = \"a\"\ncmd = [\"top\"]\n", `unknown key "session.cmd"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadWorkspace(writeWorkspace(t, tt.content))
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
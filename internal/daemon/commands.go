@@ -0,0 +1,411 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.selman.me/hauntty/internal/protocol"
+	"code.selman.me/hauntty/libghostty"
+)
+
+// maxCommandHistory bounds the commands a session remembers; the oldest
+// are dropped first.
+const maxCommandHistory = 1000
+
+var (
+	errNoShellMarks = errors.New("no shell prompt marks seen; is shell integration enabled?")
+	errNotAtPrompt  = errors.New("the shell is not at its prompt; a command is running")
+	errExecRunning  = errors.New("another exec is running in this session")
+)
+
+// shellCommand is one command the shell reported. output and end track
+// the cursor at its C and D marks, and seq numbers it from 1.
+type shellCommand struct {
+	seq      uint64
+	command  string
+	started  time.Time
+	finished time.Time
+	hasExit  bool
+	exitCode int32
+	output   *libghostty.TrackedGridRef
+	end      *libghostty.TrackedGridRef
+}
+
+func (c *shellCommand) close() {
+	c.output.Close()
+	c.end.Close()
+}
+
+// commandTracker follows the OSC 133 semantic prompt marks that shell
+// integration writes: A starts the prompt, B ends it where the command
+// line begins, C starts the command's output and D ends it with the
+// exit status. Positions are tracked cells of the terminal, so they
+// stay put as the screen scrolls. Only feedLoop records marks, which
+// outputScanner finds; the rest may be called from anywhere. A nil
+// tracker records nothing.
+type commandTracker struct {
+	mu     sync.Mutex
+	closed bool
+	seen   bool
+	prompt bool
+	// exec is set from beginExec until endExec.
+	exec bool
+	// prompts and started count the prompts and commands seen so far.
+	prompts  uint64
+	started  uint64
+	input    *libghostty.TrackedGridRef
+	current  *shellCommand
+	commands []*shellCommand
+	// changed is closed and replaced after every mark.
+	changed chan struct{}
+}
+
+func newCommandTracker() *commandTracker {
+	return &commandTracker{changed: make(chan struct{})}
+}
+
+// oscShellMark returns the mark, the OSC 133 payload after "133;", if
+// payload is one. A command line longer than the scanner keeps is cut.
+func oscShellMark(payload []byte) (string, bool) {
+	return strings.CutPrefix(string(payload), "133;")
+}
+
+// mark records one mark. term has been fed everything written before
+// it.
+func (c *commandTracker) mark(term *terminalState, mark string, now time.Time) error {
+	kind, params, _ := strings.Cut(mark, ";")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	defer c.notifyLocked()
+	switch kind {
+	case "A", "N", "P":
+		// P also marks continuation and right prompts; only a primary
+		// one starts a new prompt.
+		if k, ok := markOption(params, "k"); kind == "P" && ok && k != "i" {
+			return nil
+		}
+		// A prompt without a D mark means the command's end went
+		// unreported.
+		err := c.finishLocked(term, now, false, 0)
+		c.seen = true
+		c.prompt = true
+		c.prompts++
+		return err
+	case "B":
+		ref, _, err := term.trackCursor()
+		if err != nil {
+			return err
+		}
+		c.input.Close()
+		c.input = ref
+		c.seen = true
+		c.prompt = true
+	case "C":
+		if err := c.finishLocked(term, now, false, 0); err != nil {
+			return err
+		}
+		ref, cursor, err := term.trackCursor()
+		if err != nil {
+			return err
+		}
+		command := markCommandLine(params)
+		if command == "" {
+			command = c.inputTextLocked(term, cursor)
+		}
+		c.input.Close()
+		c.input = nil
+		c.seen = true
+		c.prompt = false
+		c.started++
+		c.current = &shellCommand{seq: c.started, command: command, started: now, output: ref}
+	case "D":
+		status, _, _ := strings.Cut(params, ";")
+		code, err := strconv.ParseInt(status, 10, 32)
+		return c.finishLocked(term, now, err == nil, int32(code))
+	}
+	return nil
+}
+
+func (c *commandTracker) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// finishLocked ends the running command, if any, at the cursor.
+func (c *commandTracker) finishLocked(term *terminalState, now time.Time, hasExit bool, code int32) error {
+	cmd := c.current
+	if cmd == nil {
+		return nil
+	}
+	c.current = nil
+	cmd.finished = now
+	cmd.hasExit = hasExit
+	cmd.exitCode = code
+	ref, _, err := term.trackCursor()
+	cmd.end = ref
+	c.commands = append(c.commands, cmd)
+	if len(c.commands) > maxCommandHistory {
+		c.commands[0].close()
+		c.commands[0] = nil
+		c.commands = c.commands[1:]
+	}
+	return err
+}
+
+// inputTextLocked reads the command line the user typed, from the B mark
+// up to the cursor at the C mark.
+func (c *commandTracker) inputTextLocked(term *terminalState, cursor libghostty.Point) string {
+	if c.input == nil {
+		return ""
+	}
+	start, ok, err := c.input.Point(libghostty.PointTagScreen)
+	if err != nil || !ok {
+		return ""
+	}
+	text, err := term.screenText(start, cursor)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(text)
+}
+
+// markCommandLine returns the command line a C mark carries in its
+// cmdline_url or cmdline option, if any.
+func markCommandLine(params string) string {
+	if v, ok := markOption(params, "cmdline_url"); ok {
+		if command, err := url.PathUnescape(v); err == nil {
+			return command
+		}
+		return v
+	}
+	v, _ := markOption(params, "cmdline")
+	return v
+}
+
+// markOption looks up key among a mark's key=value options.
+func markOption(params, key string) (string, bool) {
+	for opt := range strings.SplitSeq(params, ";") {
+		if v, ok := strings.CutPrefix(opt, key+"="); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// atPrompt reports whether the shell is waiting at its prompt.
+func (c *commandTracker) atPrompt() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.prompt
+}
+
+// history returns the recorded commands, oldest first, the running one
+// last, and whether the shell is at its prompt.
+func (c *commandTracker) history(term *terminalState) ([]protocol.ShellCommand, bool, error) {
+	if c == nil {
+		return nil, false, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	commands := c.commands
+	if c.current != nil {
+		commands = append(commands[:len(commands):len(commands)], c.current)
+	}
+	out := make([]protocol.ShellCommand, 0, len(commands))
+	for _, cmd := range commands {
+		pc := protocol.ShellCommand{
+			Command:   cmd.command,
+			StartedAt: uint32(cmd.started.Unix()),
+			HasExit:   cmd.hasExit,
+			ExitCode:  cmd.exitCode,
+		}
+		if !cmd.finished.IsZero() {
+			pc.FinishedAt = uint32(cmd.finished.Unix())
+			pc.Duration = uint32(cmd.finished.Sub(cmd.started).Milliseconds())
+		}
+		rows, ok, err := cmd.outputRows(term)
+		if err != nil {
+			return nil, false, err
+		}
+		switch {
+		case !ok:
+			pc.Trimmed = true
+		case rows != nil:
+			pc.StartRow = rows.first
+			pc.Rows = rows.last - rows.first + 1
+		}
+		out = append(out, pc)
+	}
+	return out, c.prompt, nil
+}
+
+// lastOutput returns the output rows of the latest command, running or
+// not; nil rows mean it printed nothing.
+func (c *commandTracker) lastOutput(term *terminalState) (*screenRows, error) {
+	if c == nil {
+		return nil, fmt.Errorf("no commands recorded; is shell integration enabled?")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cmd := c.current
+	if cmd == nil && len(c.commands) > 0 {
+		cmd = c.commands[len(c.commands)-1]
+	}
+	if cmd == nil {
+		return nil, fmt.Errorf("no commands recorded; is shell integration enabled?")
+	}
+	rows, ok, err := cmd.outputRows(term)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("output of %q has left the scrollback", cmd.command)
+	}
+	return rows, nil
+}
+
+// outputRows locates the command's output on the screen, up to the
+// cursor while it runs. It reports false once the output has been
+// trimmed from the scrollback, and nil rows when there is none.
+func (cmd *shellCommand) outputRows(term *terminalState) (*screenRows, bool, error) {
+	start, ok, err := cmd.output.Point(libghostty.PointTagScreen)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	var end libghostty.Point
+	if cmd.end != nil {
+		if end, ok, err = cmd.end.Point(libghostty.PointTagScreen); err != nil || !ok {
+			return nil, false, err
+		}
+	} else {
+		ref, cursor, err := term.trackCursor()
+		if err != nil {
+			return nil, false, err
+		}
+		ref.Close()
+		end = cursor
+	}
+	// The end mark sits on the line after the output unless the output
+	// did not end in a newline.
+	if end.X == 0 {
+		if end.Y == 0 {
+			return nil, true, nil
+		}
+		end.Y--
+	}
+	if end.Y < start.Y {
+		return nil, true, nil
+	}
+	return &screenRows{first: start.Y, last: end.Y}, true, nil
+}
+
+// execMark is where the tracker stood when exec typed a command.
+type execMark struct {
+	prompts uint64
+	started uint64
+}
+
+// execResult is how an exec'd command finished.
+type execResult struct {
+	rows     *screenRows
+	hasExit  bool
+	exitCode int32
+}
+
+// beginExec checks that the shell is at its prompt, ready for exec to
+// type a command, and that no other exec is running, and marks the
+// point to wait from. Callers that succeed must call endExec.
+func (c *commandTracker) beginExec() (execMark, error) {
+	if c == nil {
+		return execMark{}, errNoShellMarks
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.closed:
+		return execMark{}, fmt.Errorf("session closed")
+	case !c.seen:
+		return execMark{}, errNoShellMarks
+	case !c.prompt:
+		return execMark{}, errNotAtPrompt
+	case c.exec:
+		return execMark{}, errExecRunning
+	}
+	c.exec = true
+	return execMark{prompts: c.prompts, started: c.started}, nil
+}
+
+// endExec lets the next exec begin.
+func (c *commandTracker) endExec() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exec = false
+}
+
+// execResult returns the result of the first command started after from
+// once it has finished. Until then it returns a channel closed on the
+// next mark.
+func (c *commandTracker) execResult(term *terminalState, from execMark) (*execResult, <-chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, nil, fmt.Errorf("session closed")
+	}
+	if c.started == from.started {
+		if c.prompts > from.prompts {
+			return nil, nil, fmt.Errorf("the shell returned to its prompt without running the command")
+		}
+		return nil, c.changed, nil
+	}
+	seq := from.started + 1
+	if c.current != nil && c.current.seq == seq {
+		return nil, c.changed, nil
+	}
+	for _, cmd := range slices.Backward(c.commands) {
+		if cmd.seq != seq {
+			continue
+		}
+		rows, ok, err := cmd.outputRows(term)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("output of %q has left the scrollback", cmd.command)
+		}
+		return &execResult{rows: rows, hasExit: cmd.hasExit, exitCode: cmd.exitCode}, nil, nil
+	}
+	return nil, nil, fmt.Errorf("the command has left the history")
+}
+
+// close releases the tracked cells; call it before the terminal closes.
+func (c *commandTracker) close() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.notifyLocked()
+	c.input.Close()
+	c.input = nil
+	if c.current != nil {
+		c.current.close()
+		c.current = nil
+	}
+	for _, cmd := range c.commands {
+		cmd.close()
+	}
+	c.commands = nil
+}
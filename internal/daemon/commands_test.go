@@ -0,0 +1,171 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/internal/protocol"
+	"gotest.tools/v3/assert"
+)
+
+// feedCommands feeds chunks through a fresh tracker and terminal.
+func feedCommands(t *testing.T, chunks ...string) (*commandTracker, *terminalState) {
+	t.Helper()
+	term, err := newTerminalState(40, 10, 100)
+	assert.NilError(t, err)
+	c := newCommandTracker()
+	t.Cleanup(func() {
+		c.close()
+		term.close()
+	})
+	var s outputScanner
+	for _, chunk := range chunks {
+		assert.NilError(t, s.feed(term, []byte(chunk), outputSinks{commands: c}))
+	}
+	return c, term
+}
+
+func TestCommandTrackerRecordsCommands(t *testing.T) {
+	c, term := feedCommands(t,
+		"\x1b]133;A\x07$ \x1b]133;B\x07",
+		"make test\r\n\x1b]133;C\x07",
+		"ok pkg\r\nFAIL pkg2\r\n\x1b]133;D;2\x07",
+		"\x1b]133;A\x07$ \x1b]133;B\x07",
+	)
+
+	commands, atPrompt, err := c.history(term)
+	assert.NilError(t, err)
+	assert.Equal(t, atPrompt, true)
+	assert.Equal(t, len(commands), 1)
+	got := commands[0]
+	assert.Equal(t, got.Command, "make test")
+	assert.Equal(t, got.HasExit, true)
+	assert.Equal(t, got.ExitCode, int32(2))
+	assert.Assert(t, got.FinishedAt >= got.StartedAt)
+	assert.Equal(t, got.StartRow, uint32(1))
+	assert.Equal(t, got.Rows, uint32(2))
+
+	rows, err := c.lastOutput(term)
+	assert.NilError(t, err)
+	format := terminalDumpFormat(protocol.DumpPlain)
+	format.rows = rows
+	dump, err := term.dumpScreen(format)
+	assert.NilError(t, err)
+	assert.Equal(t, string(dump.Data), "ok pkg\nFAIL pkg2")
+}
+
+func TestCommandTrackerTracksRunningCommand(t *testing.T) {
+	c, term := feedCommands(t,
+		"\x1b]133;A\x1b\\$ \x1b]133;B\x1b\\",
+		"\x1b]133;C;cmdline_url=sleep%2010\x1b\\",
+		"working",
+	)
+
+	commands, atPrompt, err := c.history(term)
+	assert.NilError(t, err)
+	assert.Equal(t, atPrompt, false)
+	assert.DeepEqual(t, commands, []protocol.ShellCommand{{
+		Command:   "sleep 10",
+		StartedAt: commands[0].StartedAt,
+		StartRow:  0,
+		Rows:      1,
+	}})
+}
+
+func TestCommandTrackerHandlesSplitMarks(t *testing.T) {
+	c, term := feedCommands(t,
+		"\x1b]13", "3;A\x07$ \x1b]133;B\x1b", "\\ls\r\n",
+		"\x1b]133;C\x07\x1b]133;D\x07",
+	)
+
+	commands, _, err := c.history(term)
+	assert.NilError(t, err)
+	assert.Equal(t, len(commands), 1)
+	assert.Equal(t, commands[0].Command, "ls")
+	assert.Equal(t, commands[0].HasExit, false)
+	assert.Equal(t, commands[0].Rows, uint32(0))
+
+	rows, err := c.lastOutput(term)
+	assert.NilError(t, err)
+	assert.Assert(t, rows == nil)
+}
+
+func TestCommandTrackerIgnoresOtherSequences(t *testing.T) {
+	c, term := feedCommands(t,
+		"\x1b]0;133;A\x07\x1bP133;A\x1b\\\x1b[1;2H\x1b]7;file:///tmp\x07",
+		"\x1b]133;P;k=c\x07",
+	)
+
+	commands, atPrompt, err := c.history(term)
+	assert.NilError(t, err)
+	assert.Equal(t, len(commands), 0)
+	assert.Equal(t, atPrompt, false)
+
+	_, err = c.lastOutput(term)
+	assert.ErrorContains(t, err, "no commands recorded")
+}
+
+func TestScreenWatchPromptWaitsForPrompt(t *testing.T) {
+	w, err := newScreenWatch(&protocol.Watch{Row: -1, Prompt: true})
+	assert.NilError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	assert.Equal(t, w.observe("$ make", false, now), false)
+	assert.Equal(t, w.observe("$ make\n$ ", true, now), true)
+}
+
+func TestCommandTrackerExec(t *testing.T) {
+	c, term := feedCommands(t)
+
+	_, err := c.beginExec()
+	assert.ErrorIs(t, err, errNoShellMarks)
+
+	var s outputScanner
+	feed := func(chunk string) {
+		t.Helper()
+		assert.NilError(t, s.feed(term, []byte(chunk), outputSinks{commands: c}))
+	}
+	feed("\x1b]133;A\x07$ \x1b]133;B\x07")
+	from, err := c.beginExec()
+	assert.NilError(t, err)
+	_, err = c.beginExec()
+	assert.ErrorIs(t, err, errExecRunning)
+
+	res, changed, err := c.execResult(term, from)
+	assert.NilError(t, err)
+	assert.Assert(t, res == nil)
+
+	feed("make\r\n\x1b]133;C\x07")
+	select {
+	case <-changed:
+	default:
+		t.Fatal("changed not closed by a mark")
+	}
+	_, err = c.beginExec()
+	assert.ErrorIs(t, err, errNotAtPrompt)
+	res, _, err = c.execResult(term, from)
+	assert.NilError(t, err)
+	assert.Assert(t, res == nil)
+
+	feed("built\r\n\x1b]133;D;3\x07\x1b]133;A\x07$ \x1b]133;B\x07")
+	res, _, err = c.execResult(term, from)
+	assert.NilError(t, err)
+	assert.Equal(t, *res.rows, screenRows{first: 1, last: 1})
+	assert.Equal(t, res.hasExit, true)
+	assert.Equal(t, res.exitCode, int32(3))
+
+	c.endExec()
+	_, err = c.beginExec()
+	assert.NilError(t, err)
+}
+
+func TestCommandTrackerExecWithoutCommand(t *testing.T) {
+	c, term := feedCommands(t, "\x1b]133;A\x07$ \x1b]133;B\x07")
+	from, err := c.beginExec()
+	assert.NilError(t, err)
+
+	var s outputScanner
+	assert.NilError(t, s.feed(term, []byte("\r\n\x1b]133;A\x07$ \x1b]133;B\x07"), outputSinks{commands: c}))
+	_, _, err = c.execResult(term, from)
+	assert.ErrorContains(t, err, "returned to its prompt without running the command")
+}
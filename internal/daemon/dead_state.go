@@ -3,6 +3,7 @@ package daemon
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 
 	"code.selman.me/hauntty/internal/protocol"
@@ -15,7 +16,7 @@ func (s *Server) reserveSessionName(name string) (string, error) {
 
 	reserved := s.liveSessionNames()
 	if s.persister != nil {
-		dead, err := listDeadSessions(reserved)
+		dead, err := s.persister.listDead(reserved)
 		if err != nil {
 			return "", err
 		}
@@ -42,7 +43,7 @@ func (s *Server) deadSessionNames() ([]string, error) {
 	if s.persister == nil {
 		return nil, nil
 	}
-	return listDeadSessions(s.liveSessionNames())
+	return s.persister.listDead(s.liveSessionNames())
 }
 
 func (s *Server) deadSessionRows() ([]protocol.Session, error) {
@@ -66,25 +67,20 @@ func (s *Server) deadSessionRows() ([]protocol.Session, error) {
 			Cols:    state.Cols,
 			Rows:    state.Rows,
 			SavedAt: uint32(state.SavedAt.Unix()),
+			CWD:     state.CWD,
+			Exit:    state.protocolExit(),
+			Command: state.Command,
 		})
 	}
 	return rows, nil
 }
 
-func (s *Server) dumpDeadSession(name string, format protocol.DumpFormat) ([]byte, bool, error) {
+func (s *Server) dumpDeadSession(w io.Writer, name string, format protocol.DumpFormat) (bool, error) {
 	state, exists, err := s.readDeadSession(name)
-	if err != nil {
-		return nil, false, err
-	}
-	if !exists {
-		return nil, false, nil
-	}
-
-	data, err := dumpDeadTerminalState(state, s.defaultScrollback, format)
-	if err != nil {
-		return nil, false, err
+	if err != nil || !exists {
+		return false, err
 	}
-	return data, true, nil
+	return true, dumpDeadTerminalState(w, state, s.defaultScrollback, format)
 }
 
 func (s *Server) pruneDeadSessions() (uint32, error) {
@@ -108,7 +104,7 @@ func (s *Server) readDeadSession(name string) (*sessionState, bool, error) {
 		return nil, false, nil
 	}
 
-	state, err := loadState(name)
+	state, err := s.persister.load(name)
 	if err == nil {
 		return state, true, nil
 	}
@@ -122,14 +118,14 @@ func (s *Server) removeDeadSession(name string) error {
 	if s.persister == nil {
 		return nil
 	}
-	return cleanState(name)
+	return s.persister.remove(name)
 }
 
 func (s *Server) writeDeadSession(name string, state *sessionState) error {
 	if s.persister == nil {
 		return nil
 	}
-	return writeState(name, state)
+	return s.persister.write(name, state)
 }
 
 func (s *Server) prepareCreateDeadSession(name string, force bool) error {
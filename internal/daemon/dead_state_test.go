@@ -1,19 +1,22 @@
 package daemon
 
 import (
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
+	"code.selman.me/hauntty/internal/protocol"
 	"gotest.tools/v3/assert"
 )
 
 func TestReserveSessionName_ProvidedName(t *testing.T) {
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  make(map[string]*Session),
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	got, err := srv.reserveSessionName("my-session")
@@ -34,8 +37,9 @@ func TestReserveSessionName_GeneratesName(t *testing.T) {
 	}()
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  make(map[string]*Session),
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	got, err := srv.reserveSessionName("")
@@ -45,6 +49,7 @@ func TestReserveSessionName_GeneratesName(t *testing.T) {
 
 func TestLiveSessionNames(t *testing.T) {
 	srv := &Server{
+		log: slog.Default(),
 		sessions: map[string]*Session{
 			"alpha": {},
 			"beta":  {},
@@ -58,6 +63,7 @@ func TestLiveSessionNames(t *testing.T) {
 
 func TestLiveSessionNames_Empty(t *testing.T) {
 	srv := &Server{
+		log:      slog.Default(),
 		sessions: make(map[string]*Session),
 	}
 
@@ -78,8 +84,9 @@ func TestDeadSessionNames(t *testing.T) {
 	})
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  map[string]*Session{"live": {}},
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	got, err := srv.deadSessionNames()
@@ -96,8 +103,9 @@ func TestDeadSessionNames_ExcludesLive(t *testing.T) {
 	})
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  map[string]*Session{"sess": {}},
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	got, err := srv.deadSessionNames()
@@ -107,6 +115,7 @@ func TestDeadSessionNames_ExcludesLive(t *testing.T) {
 
 func TestDeadSessionNames_NilPersister(t *testing.T) {
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  make(map[string]*Session),
 		persister: nil,
 	}
@@ -125,8 +134,9 @@ func TestPrepareCreateDeadSession_NoForceErrorsWhenStateExists(t *testing.T) {
 	})
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  make(map[string]*Session),
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	err := srv.prepareCreateDeadSession("existing", false)
@@ -137,8 +147,9 @@ func TestPrepareCreateDeadSession_NoForceNoState(t *testing.T) {
 	t.Setenv("XDG_STATE_HOME", t.TempDir())
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  make(map[string]*Session),
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	err := srv.prepareCreateDeadSession("nonexistent", false)
@@ -155,8 +166,9 @@ func TestPrepareCreateDeadSession_ForceRemovesState(t *testing.T) {
 	})
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  make(map[string]*Session),
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	err := srv.prepareCreateDeadSession("existing", true)
@@ -177,8 +189,9 @@ func TestPrepareRestoreDeadSessionReturnsState(t *testing.T) {
 	writeDeadSessionState(t, "dead", want)
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  make(map[string]*Session),
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	got, err := srv.prepareRestoreDeadSession("dead")
@@ -195,8 +208,9 @@ func TestPrepareRestoreDeadSessionErrorsWhenRunning(t *testing.T) {
 	})
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  map[string]*Session{"sess": {}},
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	got, err := srv.prepareRestoreDeadSession("sess")
@@ -208,8 +222,9 @@ func TestPrepareRestoreDeadSessionErrorsWhenMissing(t *testing.T) {
 	t.Setenv("XDG_STATE_HOME", t.TempDir())
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  make(map[string]*Session),
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	got, err := srv.prepareRestoreDeadSession("missing")
@@ -226,8 +241,9 @@ func TestRollbackRestoreDeadSessionRewritesState(t *testing.T) {
 	}
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  make(map[string]*Session),
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	err := srv.rollbackRestoreDeadSession("dead", state, os.ErrInvalid)
@@ -253,8 +269,9 @@ func TestPruneDeadSessions(t *testing.T) {
 	})
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  make(map[string]*Session),
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	count, err := srv.pruneDeadSessions()
@@ -270,11 +287,43 @@ func TestPruneDeadSessions_Empty(t *testing.T) {
 	t.Setenv("XDG_STATE_HOME", t.TempDir())
 
 	srv := &Server{
+		log:       slog.Default(),
 		sessions:  make(map[string]*Session),
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	count, err := srv.pruneDeadSessions()
 	assert.NilError(t, err)
 	assert.Equal(t, count, uint32(0))
 }
+
+func TestRestoreLaunchUsesSavedCommandAndCWD(t *testing.T) {
+	dir := t.TempDir()
+	state := &sessionState{
+		CWD:     dir,
+		Command: []string{"make", "watch"},
+		Env:     []string{"PORT=3000", "MODE=dev"},
+	}
+
+	command, cwd, env := restoreLaunch(state, &protocol.Attach{
+		CWD: "/client/cwd",
+		Env: []string{"MODE=prod", "TERM=xterm"},
+	})
+	assert.DeepEqual(t, command, []string{"make", "watch"})
+	assert.Equal(t, cwd, dir)
+	assert.DeepEqual(t, env, mergeEnv(state.Env, []string{"MODE=prod", "TERM=xterm"}))
+}
+
+func TestRestoreLaunchOverrides(t *testing.T) {
+	state := &sessionState{
+		CWD:     filepath.Join(t.TempDir(), "gone"),
+		Command: []string{"make", "watch"},
+	}
+
+	command, cwd, _ := restoreLaunch(state, &protocol.Attach{
+		Command: []string{"bash"},
+		CWD:     "/client/cwd",
+	})
+	assert.DeepEqual(t, command, []string{"bash"})
+	assert.Equal(t, cwd, "/client/cwd")
+}
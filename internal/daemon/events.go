@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.selman.me/hauntty/internal/protocol"
+)
+
+// eventSubscriberBuffer bounds the events queued for one subscriber. A
+// subscriber that falls further behind loses events instead of stalling
+// the sessions that publish them.
+const eventSubscriberBuffer = 256
+
+// eventHub fans lifecycle events out to `ht events` subscribers.
+// A nil hub drops every event.
+type eventHub struct {
+	mu   sync.RWMutex
+	subs map[*eventSubscriber]struct{}
+}
+
+type eventSubscriber struct {
+	names   []string
+	ch      chan protocol.Event
+	dropped atomic.Uint32
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[*eventSubscriber]struct{})}
+}
+
+func (h *eventHub) subscribe(names []string) *eventSubscriber {
+	sub := &eventSubscriber{
+		names: names,
+		ch:    make(chan protocol.Event, eventSubscriberBuffer),
+	}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+// publish queues ev for every interested subscriber without blocking.
+func (h *eventHub) publish(ev protocol.Event) {
+	if h == nil {
+		return
+	}
+	if ev.Time == 0 {
+		ev.Time = uint32(time.Now().Unix())
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		if len(sub.names) > 0 && !slices.Contains(sub.names, ev.Session) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// handleSubscribe streams events to conn until the subscriber closes the
+// connection, sends anything else, or the daemon shuts down.
+func (s *Server) handleSubscribe(conn *protocol.Conn, msg *protocol.Subscribe) {
+	sub := s.events.subscribe(msg.Names)
+	defer s.events.unsubscribe(sub)
+	s.writeOK(conn)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		_, _ = conn.ReadMessage()
+	}()
+
+	for {
+		select {
+		case ev := <-sub.ch:
+			if ev.Kind.IsMonitor() && !conn.Has(protocol.CapMonitor) {
+				// Older clients cannot decode monitor events.
+				continue
+			}
+			ev.Dropped = sub.dropped.Swap(0)
+			if err := conn.WriteMessage(&ev); err != nil {
+				s.log.Debug("write event", "err", err)
+				return
+			}
+		case <-closed:
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"log/slog"
+	"testing"
+
+	"code.selman.me/hauntty/internal/protocol"
+	"gotest.tools/v3/assert"
+)
+
+func TestEventHubFiltersByName(t *testing.T) {
+	hub := newEventHub()
+	all := hub.subscribe(nil)
+	web := hub.subscribe([]string{"web"})
+
+	hub.publish(protocol.Event{Kind: protocol.EventCreated, Session: "db", Time: 1})
+	hub.publish(protocol.Event{Kind: protocol.EventCreated, Session: "web", Time: 2})
+
+	assert.DeepEqual(t, <-all.ch, protocol.Event{Kind: protocol.EventCreated, Session: "db", Time: 1})
+	assert.DeepEqual(t, <-all.ch, protocol.Event{Kind: protocol.EventCreated, Session: "web", Time: 2})
+	assert.DeepEqual(t, <-web.ch, protocol.Event{Kind: protocol.EventCreated, Session: "web", Time: 2})
+	assert.Equal(t, len(web.ch), 0)
+}
+
+func TestEventHubDropsForSlowSubscriber(t *testing.T) {
+	hub := newEventHub()
+	sub := hub.subscribe(nil)
+
+	for range eventSubscriberBuffer + 3 {
+		hub.publish(protocol.Event{Kind: protocol.EventAttached, Session: "s"})
+	}
+	assert.Equal(t, len(sub.ch), eventSubscriberBuffer)
+	assert.Equal(t, sub.dropped.Load(), uint32(3))
+
+	hub.unsubscribe(sub)
+	hub.publish(protocol.Event{Kind: protocol.EventDetached, Session: "s"})
+	assert.Equal(t, len(sub.ch), eventSubscriberBuffer)
+}
+
+func TestNilEventHubIgnoresPublish(t *testing.T) {
+	var hub *eventHub
+	hub.publish(protocol.Event{Kind: protocol.EventExited, Session: "s"})
+}
+
+func TestHandleConnStreamsSubscribedEvents(t *testing.T) {
+	srv := &Server{log: slog.Default(), sessions: make(map[string]*Session), ctx: t.Context(), events: newEventHub()}
+	conn := protocol.NewConn(serveTestConn(t, srv))
+
+	_, _, err := conn.Handshake(protocol.NewHello("test"))
+	assert.NilError(t, err)
+	assert.NilError(t, conn.WriteMessage(&protocol.Subscribe{Names: []string{"web"}}))
+	msg, err := conn.ReadMessage()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, msg, &protocol.OK{})
+
+	srv.events.publish(protocol.Event{Kind: protocol.EventCreated, Session: "db", Time: 1})
+	srv.events.publish(protocol.Event{Kind: protocol.EventKicked, Session: "web", Time: 2, ClientID: "3"})
+	msg, err = conn.ReadMessage()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, msg, &protocol.Event{Kind: protocol.EventKicked, Session: "web", Time: 2, ClientID: "3"})
+}
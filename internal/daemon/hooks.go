@@ -0,0 +1,205 @@
+package daemon
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
+)
+
+const defaultHookTimeout = 10 * time.Second
+
+// maxRunningHooks bounds the hook commands running at once. Events that
+// arrive while every slot is busy are skipped rather than piling up
+// processes.
+const maxRunningHooks = 16
+
+// hookShutdown is the hook event for daemon shutdown; it has no
+// protocol event.
+const hookShutdown = "shutdown"
+
+// hookRunner runs the configured hook commands for lifecycle events. It
+// reads events from its own subscription, so a slow hook never holds up
+// the session that published the event.
+type hookRunner struct {
+	cfg     config.HooksConfig
+	timeout time.Duration
+	log     *slog.Logger
+	events  *eventHub
+	sub     *eventSubscriber
+	stop    chan struct{}
+	done    chan struct{}
+	running sync.WaitGroup
+	slots   chan struct{}
+	dropped atomic.Uint64
+}
+
+// newHookRunner returns nil when no hook is configured.
+func newHookRunner(cfg config.HooksConfig, events *eventHub, log *slog.Logger) *hookRunner {
+	if cfg.OnCreated == "" && cfg.OnExited == "" && cfg.OnRestored == "" &&
+		cfg.OnAttached == "" && cfg.OnDetached == "" && cfg.OnShutdown == "" &&
+		cfg.OnAlert == "" {
+		return nil
+	}
+	timeout := defaultHookTimeout
+	if cfg.TimeoutMS > 0 {
+		timeout = time.Duration(cfg.TimeoutMS) * time.Millisecond
+	}
+	return &hookRunner{
+		cfg:     cfg,
+		timeout: timeout,
+		log:     log,
+		events:  events,
+		sub:     events.subscribe(nil),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		slots:   make(chan struct{}, maxRunningHooks),
+	}
+}
+
+func (h *hookRunner) start() {
+	go func() {
+		defer close(h.done)
+		for {
+			select {
+			case ev := <-h.sub.ch:
+				if n := h.sub.dropped.Swap(0); n > 0 {
+					h.log.Warn("hooks fell behind, skipped events", "count", n)
+				}
+				if n := h.dropped.Swap(0); n > 0 {
+					h.log.Warn("too many hooks running, skipped events", "count", n)
+				}
+				h.dispatch(ev)
+			case <-h.stop:
+				// Events published before shutdown still get their hooks.
+				for range len(h.sub.ch) {
+					h.dispatch(<-h.sub.ch)
+				}
+				return
+			}
+		}
+	}()
+}
+
+func (h *hookRunner) command(kind protocol.EventKind) string {
+	switch kind {
+	case protocol.EventCreated:
+		return h.cfg.OnCreated
+	case protocol.EventExited:
+		return h.cfg.OnExited
+	case protocol.EventRestored:
+		return h.cfg.OnRestored
+	case protocol.EventAttached:
+		return h.cfg.OnAttached
+	case protocol.EventDetached, protocol.EventKicked:
+		return h.cfg.OnDetached
+	case protocol.EventBell, protocol.EventActivity, protocol.EventSilence,
+		protocol.EventNotify, protocol.EventMatch:
+		return h.cfg.OnAlert
+	default:
+		return ""
+	}
+}
+
+func (h *hookRunner) dispatch(ev protocol.Event) {
+	command := h.command(ev.Kind)
+	if command == "" {
+		return
+	}
+	select {
+	case h.slots <- struct{}{}:
+	default:
+		h.dropped.Add(1)
+		return
+	}
+	h.running.Go(func() {
+		defer func() { <-h.slots }()
+		h.run(command, ev.Kind.String(), hookEnv(ev))
+	})
+}
+
+// hookEnv describes ev to a hook. Variables that do not apply to the
+// event are left unset.
+func hookEnv(ev protocol.Event) []string {
+	env := []string{"HAUNTTY_EVENT=" + ev.Kind.String(), "HAUNTTY_SESSION=" + ev.Session}
+	if ev.PID != 0 {
+		env = append(env, "HAUNTTY_PID="+strconv.FormatUint(uint64(ev.PID), 10))
+	}
+	if ev.ClientID != "" {
+		env = append(env, "HAUNTTY_CLIENT_ID="+ev.ClientID)
+	}
+	if ev.Message != "" {
+		env = append(env, "HAUNTTY_MESSAGE="+ev.Message)
+	}
+	if ev.Exit.Exited {
+		env = append(env, "HAUNTTY_EXIT_CODE="+strconv.Itoa(int(ev.Exit.Code)))
+		if ev.Exit.Signal != "" {
+			env = append(env, "HAUNTTY_EXIT_SIGNAL="+ev.Exit.Signal)
+		}
+	}
+	return env
+}
+
+// run runs command with sh, killing its process group once it outlives
+// the hook timeout.
+func (h *hookRunner) run(command, event string, env []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		h.log.Warn("hook timed out", "event", event, "command", command, "timeout", h.timeout, "output", string(out))
+		return
+	}
+	if err != nil {
+		h.log.Warn("hook failed", "event", event, "command", command, "err", err, "output", string(out))
+		return
+	}
+	h.log.Debug("hook ran", "event", event, "command", command, "duration", time.Since(start))
+}
+
+// shutdown stops reading events, runs the shutdown hook and waits for
+// every hook still running.
+func (h *hookRunner) shutdown() {
+	if h == nil {
+		return
+	}
+	h.stopReading()
+	if h.cfg.OnShutdown != "" {
+		h.run(h.cfg.OnShutdown, hookShutdown, []string{"HAUNTTY_EVENT=" + hookShutdown})
+	}
+	h.running.Wait()
+}
+
+// discard stops reading events and waits for every hook still running,
+// without running the shutdown hook; the daemon never started.
+func (h *hookRunner) discard() {
+	if h == nil {
+		return
+	}
+	h.stopReading()
+	h.running.Wait()
+}
+
+func (h *hookRunner) stopReading() {
+	close(h.stop)
+	<-h.done
+	h.events.unsubscribe(h.sub)
+}
@@ -0,0 +1,107 @@
+package daemon
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
+	"gotest.tools/v3/assert"
+)
+
+func TestHookEnv(t *testing.T) {
+	env := hookEnv(protocol.Event{
+		Kind:    protocol.EventExited,
+		Session: "build",
+		PID:     42,
+		Exit:    protocol.SessionExit{Exited: true, Code: 130, Signal: "SIGINT"},
+	})
+	assert.DeepEqual(t, env, []string{
+		"HAUNTTY_EVENT=exited",
+		"HAUNTTY_SESSION=build",
+		"HAUNTTY_PID=42",
+		"HAUNTTY_EXIT_CODE=130",
+		"HAUNTTY_EXIT_SIGNAL=SIGINT",
+	})
+
+	env = hookEnv(protocol.Event{Kind: protocol.EventAttached, Session: "web", ClientID: "4"})
+	assert.DeepEqual(t, env, []string{"HAUNTTY_EVENT=attached", "HAUNTTY_SESSION=web", "HAUNTTY_CLIENT_ID=4"})
+}
+
+func TestNewHookRunnerWithoutHooks(t *testing.T) {
+	h := newHookRunner(config.HooksConfig{TimeoutMS: 1000}, newEventHub(), slog.Default())
+	assert.Assert(t, h == nil)
+	h.shutdown()
+}
+
+func waitForFile(t *testing.T, path string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
+			return string(data)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hook did not write %s", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHookRunnerRunsEventHooks(t *testing.T) {
+	dir := t.TempDir()
+	hub := newEventHub()
+	h := newHookRunner(config.HooksConfig{
+		OnCreated:  `echo "$HAUNTTY_EVENT $HAUNTTY_SESSION $HAUNTTY_PID" > ` + filepath.Join(dir, "created"),
+		OnDetached: `echo "$HAUNTTY_EVENT $HAUNTTY_CLIENT_ID" > ` + filepath.Join(dir, "detached"),
+		OnShutdown: `echo "$HAUNTTY_EVENT" > ` + filepath.Join(dir, "shutdown"),
+		TimeoutMS:  5000,
+	}, hub, slog.Default())
+	h.start()
+
+	hub.publish(protocol.Event{Kind: protocol.EventCreated, Session: "build", PID: 42})
+	hub.publish(protocol.Event{Kind: protocol.EventAttached, Session: "build", ClientID: "1"})
+	hub.publish(protocol.Event{Kind: protocol.EventKicked, Session: "build", ClientID: "1"})
+
+	assert.Equal(t, waitForFile(t, filepath.Join(dir, "created")), "created build 42\n")
+	assert.Equal(t, waitForFile(t, filepath.Join(dir, "detached")), "kicked 1\n")
+
+	h.shutdown()
+	data, err := os.ReadFile(filepath.Join(dir, "shutdown"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "shutdown\n")
+}
+
+func TestHookRunnerKillsHookAfterTimeout(t *testing.T) {
+	hub := newEventHub()
+	h := newHookRunner(config.HooksConfig{OnExited: "sleep 30", TimeoutMS: 50}, hub, slog.Default())
+	h.start()
+	hub.publish(protocol.Event{Kind: protocol.EventExited, Session: "s"})
+
+	stopped := make(chan struct{})
+	go func() {
+		h.shutdown()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hook outlived its timeout")
+	}
+}
+
+func TestHookRunnerSkipsEventsWhileEverySlotIsBusy(t *testing.T) {
+	hub := newEventHub()
+	h := newHookRunner(config.HooksConfig{OnExited: "sleep 30", TimeoutMS: 2000}, hub, slog.Default())
+	h.start()
+	defer h.shutdown()
+
+	for range maxRunningHooks + 3 {
+		h.dispatch(protocol.Event{Kind: protocol.EventExited, Session: "s"})
+	}
+	assert.Equal(t, len(h.slots), maxRunningHooks)
+	assert.Equal(t, h.dropped.Load(), uint64(3))
+}
@@ -0,0 +1,214 @@
+package daemon
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
+)
+
+// sessionMonitor raises alerts from a session's output while no client
+// is attached. feedLoop hands it every PTY chunk and what outputScanner
+// finds in it, and the run loop tells it when clients come and go. A
+// nil monitor ignores everything.
+type sessionMonitor struct {
+	name   string
+	events *eventHub
+
+	mu       sync.Mutex
+	settings protocol.MonitorSettings
+	pattern  *regexp.Regexp
+	attached bool
+	closed   bool
+	alerts   protocol.MonitorAlerts
+	silence  *time.Timer
+}
+
+func monitorSettings(cfg config.MonitorConfig) protocol.MonitorSettings {
+	return protocol.MonitorSettings{
+		Bell:     cfg.Bell,
+		Activity: cfg.Activity,
+		Silence:  uint32(max(cfg.SilenceSeconds, 0)),
+		Pattern:  cfg.Pattern,
+	}
+}
+
+func newSessionMonitor(name string, events *eventHub) *sessionMonitor {
+	return &sessionMonitor{name: name, events: events}
+}
+
+// set replaces the monitor settings. Alerts already raised stay.
+func (m *sessionMonitor) set(settings protocol.MonitorSettings) error {
+	var pattern *regexp.Regexp
+	if settings.Pattern != "" {
+		var err error
+		if pattern, err = regexp.Compile(settings.Pattern); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settings = settings
+	m.pattern = pattern
+	if settings.Silence == 0 && m.silence != nil {
+		m.silence.Stop()
+	}
+	return nil
+}
+
+func (m *sessionMonitor) state() (protocol.MonitorSettings, protocol.MonitorAlerts) {
+	if m == nil {
+		return protocol.MonitorSettings{}, 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.settings, m.alerts
+}
+
+// setAttached records whether any client is attached. Attaching clears
+// the alerts: someone is looking at the session now.
+func (m *sessionMonitor) setAttached(attached bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.attached == attached {
+		return
+	}
+	m.attached = attached
+	if attached {
+		m.alerts = 0
+		if m.silence != nil {
+			m.silence.Stop()
+		}
+	}
+}
+
+// close stops the silence timer; the session publishes nothing more.
+func (m *sessionMonitor) close() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	if m.silence != nil {
+		m.silence.Stop()
+	}
+}
+
+// observe notes a chunk of PTY output, before feedLoop applies it.
+func (m *sessionMonitor) observe() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.attached || m.closed {
+		return
+	}
+	if m.settings.Activity {
+		m.raise(protocol.AlertActivity, protocol.EventActivity, "")
+	}
+	if m.settings.Silence > 0 {
+		d := time.Duration(m.settings.Silence) * time.Second
+		if m.silence == nil {
+			m.silence = time.AfterFunc(d, m.silent)
+		} else {
+			m.silence.Reset(d)
+		}
+	}
+}
+
+func (m *sessionMonitor) silent() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.attached || m.closed || m.settings.Silence == 0 {
+		return
+	}
+	m.raise(protocol.AlertSilence, protocol.EventSilence, "")
+}
+
+// bell handles a BEL in the output.
+func (m *sessionMonitor) bell() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.attached || m.closed || !m.settings.Bell {
+		return
+	}
+	m.raise(protocol.AlertBell, protocol.EventBell, "")
+}
+
+// osc handles an OSC string in the output. It publishes every
+// notification, since programs send them on purpose; bells and matches
+// alert once until a client attaches.
+func (m *sessionMonitor) osc(payload []byte) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.attached || m.closed || !m.settings.Bell {
+		return
+	}
+	if text, ok := oscNotification(string(payload)); ok {
+		m.alerts |= protocol.AlertBell
+		m.events.publish(protocol.Event{Kind: protocol.EventNotify, Session: m.name, Message: text})
+	}
+}
+
+// line handles a line of output text.
+func (m *sessionMonitor) line(line []byte) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.attached || m.closed || m.pattern == nil {
+		return
+	}
+	if m.pattern.Match(line) {
+		m.raise(protocol.AlertMatch, protocol.EventMatch, string(line))
+	}
+}
+
+// raise sets alert and publishes kind the first time it is raised.
+// Callers hold mu.
+func (m *sessionMonitor) raise(alert protocol.MonitorAlerts, kind protocol.EventKind, message string) {
+	if m.alerts&alert != 0 {
+		return
+	}
+	m.alerts |= alert
+	m.events.publish(protocol.Event{Kind: kind, Session: m.name, Message: message})
+}
+
+// oscNotification returns the text of an OSC 9 or OSC 777 notify
+// payload. OSC 9 payloads that start with a number are ConEmu
+// extensions such as progress reports, not notifications.
+func oscNotification(payload string) (string, bool) {
+	if text, ok := strings.CutPrefix(payload, "9;"); ok {
+		number, _, _ := strings.Cut(text, ";")
+		if number == "" || strings.Trim(number, "0123456789") == "" {
+			return "", false
+		}
+		return text, true
+	}
+	if rest, ok := strings.CutPrefix(payload, "777;notify;"); ok {
+		title, body, _ := strings.Cut(rest, ";")
+		if body == "" {
+			return title, title != ""
+		}
+		if title == "" {
+			return body, true
+		}
+		return title + ": " + body, true
+	}
+	return "", false
+}
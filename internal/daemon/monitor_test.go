@@ -0,0 +1,120 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/internal/protocol"
+	"gotest.tools/v3/assert"
+)
+
+func newTestMonitor(t *testing.T, settings protocol.MonitorSettings) (*sessionMonitor, *eventSubscriber) {
+	t.Helper()
+	hub := newEventHub()
+	sub := hub.subscribe(nil)
+	m := newSessionMonitor("build", hub)
+	assert.NilError(t, m.set(settings))
+	t.Cleanup(m.close)
+	return m, sub
+}
+
+// observeOutput hands data to m the way feedLoop does.
+func observeOutput(m *sessionMonitor, s *outputScanner, data string) {
+	m.observe()
+	for _, b := range []byte(data) {
+		ev := s.scan(b)
+		switch {
+		case ev == nil:
+		case ev.kind == seenBell:
+			m.bell()
+		case ev.kind == seenLine:
+			m.line(ev.line)
+		case ev.kind == seenOSC:
+			m.osc(ev.payload)
+		}
+	}
+}
+
+// monitorEvents returns the events published so far.
+func monitorEvents(sub *eventSubscriber) []protocol.Event {
+	var events []protocol.Event
+	for range len(sub.ch) {
+		ev := <-sub.ch
+		ev.Time = 0
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestOSCNotification(t *testing.T) {
+	for _, tc := range []struct {
+		payload string
+		want    string
+		ok      bool
+	}{
+		{"9;build done", "build done", true},
+		{"9;4;1;50", "", false},
+		{"9;1", "", false},
+		{"777;notify;make;build done", "make: build done", true},
+		{"777;notify;build done", "build done", true},
+		{"777;notify;;body", "body", true},
+		{"0;window title", "", false},
+	} {
+		got, ok := oscNotification(tc.payload)
+		assert.Equal(t, ok, tc.ok, tc.payload)
+		assert.Equal(t, got, tc.want, tc.payload)
+	}
+}
+
+func TestSessionMonitorRaisesOnlyWhileDetached(t *testing.T) {
+	m, sub := newTestMonitor(t, protocol.MonitorSettings{Bell: true, Activity: true, Pattern: "FAIL"})
+
+	var s outputScanner
+	m.setAttached(true)
+	observeOutput(m, &s, "\x07FAIL\n")
+	assert.Equal(t, len(monitorEvents(sub)), 0)
+
+	m.setAttached(false)
+	observeOutput(m, &s, "ok\n\x07")
+	observeOutput(m, &s, "\x07FAIL: pkg\n")
+	assert.DeepEqual(t, monitorEvents(sub), []protocol.Event{
+		{Kind: protocol.EventActivity, Session: "build"},
+		{Kind: protocol.EventBell, Session: "build"},
+		{Kind: protocol.EventMatch, Session: "build", Message: "FAIL: pkg"},
+	})
+	_, alerts := m.state()
+	assert.Equal(t, alerts, protocol.AlertBell|protocol.AlertActivity|protocol.AlertMatch)
+
+	m.setAttached(true)
+	_, alerts = m.state()
+	assert.Equal(t, alerts, protocol.MonitorAlerts(0))
+}
+
+func TestSessionMonitorPublishesEveryNotification(t *testing.T) {
+	m, sub := newTestMonitor(t, protocol.MonitorSettings{Bell: true})
+
+	observeOutput(m, &outputScanner{}, "\x1b]9;one\x07\x1b]9;two\x07")
+	assert.DeepEqual(t, monitorEvents(sub), []protocol.Event{
+		{Kind: protocol.EventNotify, Session: "build", Message: "one"},
+		{Kind: protocol.EventNotify, Session: "build", Message: "two"},
+	})
+}
+
+func TestSessionMonitorSilence(t *testing.T) {
+	m, sub := newTestMonitor(t, protocol.MonitorSettings{Silence: 1})
+
+	observeOutput(m, &outputScanner{}, "working")
+	select {
+	case ev := <-sub.ch:
+		assert.Equal(t, ev.Kind, protocol.EventSilence)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no silence alert")
+	}
+	_, alerts := m.state()
+	assert.Equal(t, alerts, protocol.AlertSilence)
+}
+
+func TestSessionMonitorRejectsInvalidPattern(t *testing.T) {
+	m := newSessionMonitor("build", nil)
+	assert.ErrorContains(t, m.set(protocol.MonitorSettings{Pattern: "("}), "missing closing )")
+}
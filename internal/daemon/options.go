@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"log/slog"
+	"net"
+	"os"
+
+	"code.selman.me/hauntty/internal/config"
+)
+
+// Option configures a Server beyond its DaemonConfig, for programs that
+// run the daemon in-process.
+type Option func(*Server)
+
+// WithListener serves ln instead of the configured socket. The server
+// then takes no lock and writes no PID file, and Shutdown closes ln but
+// leaves any socket file to the caller. Connections that are not Unix
+// sockets skip the peer UID check.
+func WithListener(ln net.Listener) Option {
+	return func(s *Server) {
+		s.listener = ln
+	}
+}
+
+// WithStateDir saves dead session state under dir instead of
+// $XDG_STATE_HOME/hauntty/sessions.
+func WithStateDir(dir string) Option {
+	return func(s *Server) {
+		s.stateDir = dir
+	}
+}
+
+// WithLogger logs to l instead of slog's default logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) {
+		s.log = l
+	}
+}
+
+// WithSignals shuts the server down on the given signals instead of
+// SIGTERM and SIGINT. Passing none leaves signals to the caller.
+func WithSignals(sigs ...os.Signal) Option {
+	return func(s *Server) {
+		s.signals = sigs
+	}
+}
+
+// WithResizePolicy overrides the resize policy passed to New.
+func WithResizePolicy(p config.ResizePolicy) Option {
+	return func(s *Server) {
+		s.resizePolicy = p
+	}
+}
+
+// WithUpgrade lets the server upgrade in place: it re-execs the new
+// binary with the arguments args returns for the descriptor carrying
+// the handover.
+func WithUpgrade(args func(fd int) []string) Option {
+	return func(s *Server) {
+		s.upgradeArgs = args
+	}
+}
+
+// WithHandover resumes from what an upgrading daemon passed on f instead
+// of listening on the configured socket.
+func WithHandover(f *os.File) Option {
+	return func(s *Server) {
+		s.handover = f
+	}
+}
@@ -0,0 +1,29 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+)
+
+type peerCred struct {
+	uid int
+	pid int
+}
+
+func unixPeerCred(conn *net.UnixConn) (peerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return peerCred{}, fmt.Errorf("peer credentials: %w", err)
+	}
+	var cred peerCred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = socketPeerCred(int(fd))
+	}); err != nil {
+		return peerCred{}, fmt.Errorf("peer credentials: %w", err)
+	}
+	if credErr != nil {
+		return peerCred{}, fmt.Errorf("peer credentials: %w", credErr)
+	}
+	return cred, nil
+}
@@ -0,0 +1,16 @@
+package daemon
+
+import "golang.org/x/sys/unix"
+
+func socketPeerCred(fd int) (peerCred, error) {
+	cred, err := unix.GetsockoptXucred(fd, unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	if err != nil {
+		return peerCred{}, err
+	}
+	// xucred carries no PID; LOCAL_PEERPID reports the connecting process.
+	pid, err := unix.GetsockoptInt(fd, unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+	if err != nil {
+		return peerCred{}, err
+	}
+	return peerCred{uid: int(cred.Uid), pid: pid}, nil
+}
@@ -0,0 +1,11 @@
+package daemon
+
+import "golang.org/x/sys/unix"
+
+func socketPeerCred(fd int) (peerCred, error) {
+	cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return peerCred{}, err
+	}
+	return peerCred{uid: int(cred.Uid), pid: int(cred.Pid)}, nil
+}
@@ -0,0 +1,22 @@
+package daemon
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	"gotest.tools/v3/assert"
+)
+
+func TestSocketPeerCredReportsSocketPair(t *testing.T) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, unix.Close(fds[0]))
+		assert.NilError(t, unix.Close(fds[1]))
+	})
+
+	cred, err := socketPeerCred(fds[0])
+	assert.NilError(t, err)
+	assert.Equal(t, cred, peerCred{uid: os.Getuid(), pid: os.Getpid()})
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+var errPeerCredUnsupported = fmt.Errorf("peer credentials on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+
+func socketPeerCred(int) (peerCred, error) {
+	return peerCred{}, errPeerCredUnsupported
+}
@@ -0,0 +1,36 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestUnixPeerCredReportsDialingProcess(t *testing.T) {
+	dir, err := os.MkdirTemp("/tmp", "ht-peercred-")
+	assert.NilError(t, err)
+	t.Cleanup(func() { assert.NilError(t, os.RemoveAll(dir)) })
+
+	listener, err := net.Listen("unix", filepath.Join(dir, "hauntty.sock"))
+	assert.NilError(t, err)
+	t.Cleanup(func() { assert.NilError(t, listener.Close()) })
+
+	dialed, err := net.Dial("unix", listener.Addr().String())
+	assert.NilError(t, err)
+	t.Cleanup(func() { assert.NilError(t, dialed.Close()) })
+
+	accepted, err := listener.Accept()
+	assert.NilError(t, err)
+	t.Cleanup(func() { assert.NilError(t, accepted.Close()) })
+
+	cred, err := unixPeerCred(accepted.(*net.UnixConn))
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+	assert.NilError(t, err)
+	assert.Equal(t, cred, peerCred{uid: os.Getuid(), pid: os.Getpid()})
+}
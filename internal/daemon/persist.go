@@ -14,15 +14,35 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
 )
 
 // State file format: [HTST magic 4B][version u8][cols u16][rows u16]
 // [saved_at u64][snapshot_length u32][snapshot...]
+//
+// Version 3 appends the session's exit status and launch parameters:
+// [exited u8][exit_code i32][exit_signal str][exited_at u64][cwd str]
+// [command strs][env strs], where str is [length u32][bytes...] and strs
+// is [count u32][str...]. Version 2 files decode with these unset.
+//
+// Version 4 appends the session's resize policy, restart mode and
+// monitor settings: [resize_policy str][restart u8][monitored u8], then
+// [bell u8][activity u8][silence u32][pattern str] when monitored is 1.
+// Older files decode with the policy empty, the restart mode
+// RestartDefault and no monitor settings, each meaning the daemon's.
 var stateMagic = [4]byte{'H', 'T', 'S', 'T'}
 
 const (
-	// Bump this with any change to the pinned Ghostty snapshot format.
-	stateVersion          = 2
+	// Bump this with any change to the HTST record layout, including
+	// the pinned Ghostty snapshot format it carries. Version 2 is the
+	// base record, the oldest still read; 3 added the exit status and
+	// launch parameters; 4 added the resize policy, restart mode and
+	// monitor settings.
+	stateVersion          = 4
+	stateVersionNoResize  = 3
+	stateVersionNoLaunch  = 2
 	maxStateSnapshotBytes = 128 << 20
 )
 
@@ -31,23 +51,58 @@ type sessionState struct {
 	Rows     uint16
 	SavedAt  time.Time
 	Snapshot []byte
+
+	// Exit status, set once the session's process has exited.
+	Exited     bool
+	ExitCode   int32
+	ExitSignal string
+	ExitedAt   time.Time
+
+	// Launch parameters as requested; an empty Command means the
+	// default shell.
+	CWD     string
+	Command []string
+	Env     []string
+
+	// ResizePolicy is the session's own policy; empty means the
+	// daemon-wide one. A fixed session keeps Cols x Rows on restore.
+	ResizePolicy config.ResizePolicy
+	// Restart is the session's restart mode; RestartDefault means the
+	// daemon's configured policy.
+	Restart protocol.RestartPolicy
+	// Monitor is the session's monitor settings; nil means the daemon's.
+	Monitor *protocol.MonitorSettings
+}
+
+func (s *sessionState) protocolExit() protocol.SessionExit {
+	if !s.Exited {
+		return protocol.SessionExit{}
+	}
+	exit := protocol.SessionExit{Exited: true, Code: s.ExitCode, Signal: s.ExitSignal}
+	if !s.ExitedAt.IsZero() {
+		exit.At = uint32(s.ExitedAt.Unix())
+	}
+	return exit
 }
 
 type persister struct {
 	mu       sync.Mutex
 	sessions func() map[string]*Session
+	// dir holds one <name>.state file per saved session.
 	dir      string
 	interval time.Duration
+	log      *slog.Logger
 	ctx      context.Context
 	cancel   context.CancelFunc
 }
 
-func newPersister(sessions func() map[string]*Session, interval time.Duration) *persister {
+func newPersister(sessions func() map[string]*Session, dir string, interval time.Duration, log *slog.Logger) *persister {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &persister{
 		sessions: sessions,
-		dir:      stateDir(),
+		dir:      dir,
 		interval: interval,
+		log:      log,
 		ctx:      ctx,
 		cancel:   cancel,
 	}
@@ -71,7 +126,7 @@ func (p *persister) loop() {
 			return
 		case <-ticker.C:
 			if err := p.saveAll(); err != nil {
-				slog.Warn("persist: periodic save failed", "err", err)
+				p.log.Warn("persist: periodic save failed", "err", err)
 			}
 		}
 	}
@@ -113,12 +168,28 @@ func (p *persister) saveSession(name string, s *Session) error {
 		Rows:     rows,
 		SavedAt:  time.Now(),
 		Snapshot: snapshot,
+		CWD:      s.launchCWD,
+		Command:  s.command,
+		Env:      s.env,
+
+		ResizePolicy: s.resizePolicy,
+		Restart:      s.restart.mode,
+	}
+	if s.monitor != nil {
+		settings, _ := s.monitor.state()
+		state.Monitor = &settings
+	}
+	if exit, ok := s.exitStatus(); ok {
+		state.Exited = true
+		state.ExitCode = exit.code
+		state.ExitSignal = exit.signal
+		state.ExitedAt = exit.at
 	}
 	return writeStateInDir(p.dir, name, state)
 }
 
-func writeState(name string, state *sessionState) error {
-	return writeStateInDir(stateDir(), name, state)
+func (p *persister) write(name string, state *sessionState) error {
+	return writeStateInDir(p.dir, name, state)
 }
 
 func writeStateInDir(dir string, name string, state *sessionState) error {
@@ -168,11 +239,57 @@ func encodeState(s *sessionState) ([]byte, error) {
 		return nil, err
 	}
 	buf.Write(s.Snapshot)
+
+	var exited uint8
+	if s.Exited {
+		exited = 1
+	}
+	var exitedAt uint64
+	if !s.ExitedAt.IsZero() {
+		exitedAt = uint64(s.ExitedAt.Unix())
+	}
+	for _, v := range []any{exited, s.ExitCode} {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	writeStateString(&buf, s.ExitSignal)
+	if err := binary.Write(&buf, binary.BigEndian, exitedAt); err != nil {
+		return nil, err
+	}
+	writeStateString(&buf, s.CWD)
+	writeStateStrings(&buf, s.Command)
+	writeStateStrings(&buf, s.Env)
+	writeStateString(&buf, string(s.ResizePolicy))
+	buf.WriteByte(uint8(s.Restart))
+	if s.Monitor == nil {
+		buf.WriteByte(0)
+		return buf.Bytes(), nil
+	}
+	buf.WriteByte(1)
+	for _, v := range []any{s.Monitor.Bell, s.Monitor.Activity, s.Monitor.Silence} {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	writeStateString(&buf, s.Monitor.Pattern)
 	return buf.Bytes(), nil
 }
 
-func loadState(name string) (*sessionState, error) {
-	path := filepath.Join(stateDir(), name+".state")
+func writeStateString(buf *bytes.Buffer, v string) {
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(v)))
+	buf.WriteString(v)
+}
+
+func writeStateStrings(buf *bytes.Buffer, v []string) {
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(v)))
+	for _, s := range v {
+		writeStateString(buf, s)
+	}
+}
+
+func (p *persister) load(name string) (*sessionState, error) {
+	path := filepath.Join(p.dir, name+".state")
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -194,7 +311,7 @@ func decodeState(data []byte) (*sessionState, error) {
 	if err != nil {
 		return nil, fmt.Errorf("persist: read version: %w", err)
 	}
-	if version != stateVersion {
+	if version < stateVersionNoLaunch || version > stateVersion {
 		return nil, fmt.Errorf("persist: unsupported version %d", version)
 	}
 
@@ -225,17 +342,122 @@ func decodeState(data []byte) (*sessionState, error) {
 		return nil, fmt.Errorf("persist: read snapshot: %w", err)
 	}
 
-	return &sessionState{
+	state := &sessionState{
 		Cols:     cols,
 		Rows:     rows,
 		SavedAt:  time.Unix(int64(savedAtUnix), 0),
 		Snapshot: snapshot,
-	}, nil
+	}
+	if version == stateVersionNoLaunch {
+		return state, nil
+	}
+	if err := decodeStateLaunch(dec, state); err != nil {
+		return nil, err
+	}
+	if version == stateVersionNoResize {
+		return state, nil
+	}
+	policy, err := readStateString(dec)
+	if err != nil {
+		return nil, fmt.Errorf("persist: read resize_policy: %w", err)
+	}
+	state.ResizePolicy = config.ResizePolicy(policy)
+	if err := binary.Read(dec, binary.BigEndian, &state.Restart); err != nil {
+		return nil, fmt.Errorf("persist: read restart: %w", err)
+	}
+	monitored, err := dec.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("persist: read monitored: %w", err)
+	}
+	if monitored == 0 {
+		return state, nil
+	}
+	var monitor protocol.MonitorSettings
+	for _, v := range []any{&monitor.Bell, &monitor.Activity, &monitor.Silence} {
+		if err := binary.Read(dec, binary.BigEndian, v); err != nil {
+			return nil, fmt.Errorf("persist: read monitor: %w", err)
+		}
+	}
+	if monitor.Pattern, err = readStateString(dec); err != nil {
+		return nil, fmt.Errorf("persist: read monitor pattern: %w", err)
+	}
+	state.Monitor = &monitor
+	return state, nil
+}
+
+func decodeStateLaunch(dec *bytes.Reader, state *sessionState) error {
+	var exited uint8
+	if err := binary.Read(dec, binary.BigEndian, &exited); err != nil {
+		return fmt.Errorf("persist: read exited: %w", err)
+	}
+	state.Exited = exited != 0
+	if err := binary.Read(dec, binary.BigEndian, &state.ExitCode); err != nil {
+		return fmt.Errorf("persist: read exit_code: %w", err)
+	}
+	var err error
+	if state.ExitSignal, err = readStateString(dec); err != nil {
+		return fmt.Errorf("persist: read exit_signal: %w", err)
+	}
+	var exitedAt uint64
+	if err := binary.Read(dec, binary.BigEndian, &exitedAt); err != nil {
+		return fmt.Errorf("persist: read exited_at: %w", err)
+	}
+	if exitedAt != 0 {
+		state.ExitedAt = time.Unix(int64(exitedAt), 0)
+	}
+	if state.CWD, err = readStateString(dec); err != nil {
+		return fmt.Errorf("persist: read cwd: %w", err)
+	}
+	if state.Command, err = readStateStrings(dec); err != nil {
+		return fmt.Errorf("persist: read command: %w", err)
+	}
+	if state.Env, err = readStateStrings(dec); err != nil {
+		return fmt.Errorf("persist: read env: %w", err)
+	}
+	return nil
+}
+
+func readStateString(dec *bytes.Reader) (string, error) {
+	var n uint32
+	if err := binary.Read(dec, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	if int64(n) > int64(dec.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(dec, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readStateStrings(dec *bytes.Reader) ([]string, error) {
+	var n uint32
+	if err := binary.Read(dec, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	// Each string takes at least its 4-byte length.
+	if int64(n)*4 > int64(dec.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	out := make([]string, n)
+	for i := range out {
+		s, err := readStateString(dec)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = s
+	}
+	return out, nil
 }
 
-func listDeadSessions(running map[string]bool) ([]string, error) {
-	dir := stateDir()
-	entries, err := os.ReadDir(dir)
+// listDead returns the saved sessions that are not running.
+func (p *persister) listDead(running map[string]bool) ([]string, error) {
+	entries, err := os.ReadDir(p.dir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
@@ -256,8 +478,8 @@ func listDeadSessions(running map[string]bool) ([]string, error) {
 	return dead, nil
 }
 
-func cleanState(name string) error {
-	path := filepath.Join(stateDir(), name+".state")
+func (p *persister) remove(name string) error {
+	path := filepath.Join(p.dir, name+".state")
 	err := os.Remove(path)
 	if os.IsNotExist(err) {
 		return nil
@@ -266,20 +488,21 @@ func cleanState(name string) error {
 }
 
 // cleanStaleTmp removes leftover .state.tmp files from interrupted writes.
-func cleanStaleTmp() {
-	dir := stateDir()
-	entries, err := os.ReadDir(dir)
+func (p *persister) cleanStaleTmp() {
+	entries, err := os.ReadDir(p.dir)
 	if err != nil {
 		return
 	}
 	for _, e := range entries {
 		if !e.IsDir() && strings.HasSuffix(e.Name(), ".state.tmp") {
-			os.Remove(filepath.Join(dir, e.Name()))
+			os.Remove(filepath.Join(p.dir, e.Name()))
 		}
 	}
 }
 
-func stateDir() string {
+// defaultStateDir is where sessions are saved unless the server is given
+// a state directory.
+func defaultStateDir() string {
 	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
 		return filepath.Join(dir, "hauntty", "sessions")
 	}
@@ -8,6 +8,8 @@ import (
 	"testing"
 	"time"
 
+	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
 	"gotest.tools/v3/assert"
 )
 
@@ -107,16 +109,116 @@ func TestEncodeStateFormat(t *testing.T) {
 
 	want := []byte{
 		'H', 'T', 'S', 'T', // magic
-		2,          // version
+		4,          // version
 		0x00, 0x50, // cols = 80
 		0x00, 0x18, // rows = 24
 		0, 0, 0, 0, 0x65, 0x65, 0x5E, 0x40, // saved_at
 		0, 0, 0, 2, // snapshot_length = 2
 		'A', 'B', // snapshot
+		0,          // exited
+		0, 0, 0, 0, // exit_code
+		0, 0, 0, 0, // exit_signal length
+		0, 0, 0, 0, 0, 0, 0, 0, // exited_at
+		0, 0, 0, 0, // cwd length
+		0, 0, 0, 0, // command count
+		0, 0, 0, 0, // env count
+		0, 0, 0, 0, // resize_policy length
+		0, // restart
+		0, // monitored
 	}
 	assert.DeepEqual(t, data, want)
 }
 
+func TestEncodeDecodeExitAndLaunch(t *testing.T) {
+	state := &sessionState{
+		Cols:       80,
+		Rows:       24,
+		SavedAt:    time.Unix(1700000000, 0),
+		Snapshot:   []byte("snapshot"),
+		Exited:     true,
+		ExitCode:   -1,
+		ExitSignal: "SIGKILL",
+		ExitedAt:   time.Unix(1700000050, 0),
+		CWD:        "/home/user/project",
+		Command:    []string{"npm", "run", "dev"},
+		Env:        []string{"PORT=3000"},
+
+		ResizePolicy: config.ResizePolicyFixed,
+		Restart:      protocol.RestartOnFailure,
+		Monitor:      &protocol.MonitorSettings{Bell: true, Silence: 30, Pattern: "ERROR"},
+	}
+
+	data, err := encodeState(state)
+	assert.NilError(t, err)
+
+	got, err := decodeState(data)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, state)
+	assert.DeepEqual(t, got.protocolExit(), protocol.SessionExit{
+		Exited: true,
+		Code:   -1,
+		Signal: "SIGKILL",
+		At:     1700000050,
+	})
+}
+
+func TestDecodeStateVersion2(t *testing.T) {
+	data := []byte{
+		'H', 'T', 'S', 'T',
+		2,
+		0x00, 0x50,
+		0x00, 0x18,
+		0, 0, 0, 0, 0x65, 0x65, 0x5E, 0x40,
+		0, 0, 0, 2,
+		'A', 'B',
+	}
+
+	got, err := decodeState(data)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, &sessionState{
+		Cols:     80,
+		Rows:     24,
+		SavedAt:  time.Unix(0x65655E40, 0),
+		Snapshot: []byte("AB"),
+	})
+}
+
+func TestDecodeStateTruncatedLaunch(t *testing.T) {
+	state := &sessionState{
+		Cols:     80,
+		Rows:     24,
+		SavedAt:  time.Unix(1700000000, 0),
+		Snapshot: []byte("x"),
+		CWD:      "/tmp",
+	}
+
+	data, err := encodeState(state)
+	assert.NilError(t, err)
+
+	_, err = decodeState(data[:len(data)-16])
+	assert.ErrorContains(t, err, "persist: read cwd")
+}
+
+func TestDecodeStateVersion3(t *testing.T) {
+	data, err := encodeState(&sessionState{
+		Cols:     80,
+		Rows:     24,
+		SavedAt:  time.Unix(1700000000, 0),
+		Snapshot: []byte("x"),
+		CWD:      "/tmp",
+	})
+	assert.NilError(t, err)
+	data[4] = 3
+	data = data[:len(data)-6] // no resize_policy, restart or monitored
+
+	got, err := decodeState(data)
+	assert.NilError(t, err)
+	assert.Equal(t, got.CWD, "/tmp")
+	assert.Equal(t, got.ResizePolicy, config.ResizePolicy(""))
+	assert.Equal(t, got.Restart, protocol.RestartDefault)
+	assert.Assert(t, got.Monitor == nil)
+}
+
 func TestSaveAllWithAggregatesErrors(t *testing.T) {
 	p := &persister{sessions: func() map[string]*Session {
 		return map[string]*Session{
@@ -152,16 +254,12 @@ func TestSaveAllWithNoErrors(t *testing.T) {
 }
 
 func TestCleanStaleTmp(t *testing.T) {
-	dir := t.TempDir()
-	t.Setenv("XDG_STATE_HOME", dir)
-
-	sessionDir := filepath.Join(dir, "hauntty", "sessions")
-	assert.NilError(t, os.MkdirAll(sessionDir, 0o700))
-
+	sessionDir := t.TempDir()
 	assert.NilError(t, os.WriteFile(filepath.Join(sessionDir, "foo.state.tmp"), []byte("stale"), 0o600))
 	assert.NilError(t, os.WriteFile(filepath.Join(sessionDir, "bar.state"), []byte("keep"), 0o600))
 
-	cleanStaleTmp()
+	p := &persister{dir: sessionDir}
+	p.cleanStaleTmp()
 
 	entries, err := os.ReadDir(sessionDir)
 	assert.NilError(t, err)
@@ -170,8 +268,14 @@ func TestCleanStaleTmp(t *testing.T) {
 }
 
 func TestLoadStateMissing(t *testing.T) {
+	dir := t.TempDir()
+	p := &persister{dir: dir}
+	_, err := p.load("nonexistent")
+	assert.Error(t, err, "open "+filepath.Join(dir, "nonexistent.state")+": no such file or directory")
+}
+
+func TestDefaultStateDirFollowsXDG(t *testing.T) {
 	dir := t.TempDir()
 	t.Setenv("XDG_STATE_HOME", dir)
-	_, err := loadState("nonexistent")
-	assert.Error(t, err, "open "+filepath.Join(dir, "hauntty", "sessions", "nonexistent.state")+": no such file or directory")
+	assert.Equal(t, defaultStateDir(), filepath.Join(dir, "hauntty", "sessions"))
 }
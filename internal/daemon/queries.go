@@ -0,0 +1,149 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	hauntty "code.selman.me/hauntty"
+	"code.selman.me/hauntty/libghostty"
+)
+
+// The daemon answers the terminal queries a session's programs write
+// (DA1, DA2, DSR, CPR, XTVERSION, DECRQM and the OSC 4, 10 and 11 color
+// queries) for as long as no writable client is attached to answer
+// them. outputScanner finds the queries; the replies read modes and
+// colors from the session's terminal, so they hold across attaches,
+// restores and upgrades.
+
+// csiQueryReply returns the reply to the CSI sequence ev, or nil if it
+// is not a query.
+func csiQueryReply(ev *scanEvent) func(*terminalState) []byte {
+	prefix, intermediate, final := ev.prefix, ev.intermediate, ev.final
+	params := string(ev.params)
+	switch {
+	case intermediate == 0 && final == 'c' && (params == "" || params == "0"):
+		switch prefix {
+		case 0:
+			// VT220 with ANSI color.
+			return fixedReply("\x1b[?62;22c")
+		case '>':
+			return fixedReply("\x1b[>1;10;0c")
+		}
+	case intermediate == 0 && final == 'n' && (prefix == 0 || prefix == '?'):
+		switch params {
+		case "5":
+			if prefix == 0 {
+				return fixedReply("\x1b[0n")
+			}
+		case "6":
+			private := ""
+			if prefix == '?' {
+				private = "?"
+			}
+			return func(term *terminalState) []byte {
+				row, col, err := term.cursor()
+				if err != nil {
+					return nil
+				}
+				return fmt.Appendf(nil, "\x1b[%s%d;%dR", private, row+1, col+1)
+			}
+		}
+	case intermediate == 0 && final == 'q' && prefix == '>' && (params == "" || params == "0"):
+		return fixedReply("\x1bP>|hauntty " + hauntty.Version() + "\x1b\\")
+	case intermediate == '$' && final == 'p' && (prefix == 0 || prefix == '?'):
+		mode, err := strconv.Atoi(params)
+		if err != nil {
+			return nil
+		}
+		private := ""
+		if prefix == '?' {
+			private = "?"
+		}
+		if mode < 0 || mode >= int(libghostty.ModeANSI) {
+			return fixedReply(fmt.Sprintf("\x1b[%s%d;0$y", private, mode))
+		}
+		query := libghostty.Mode(mode)
+		if prefix == 0 {
+			query |= libghostty.ModeANSI
+		}
+		return func(term *terminalState) []byte {
+			set, known, err := term.mode(query)
+			if err != nil {
+				return nil
+			}
+			// DECRPM: 0 unrecognized, 1 set, 2 reset.
+			state := 0
+			switch {
+			case known && set:
+				state = 1
+			case known:
+				state = 2
+			}
+			return fmt.Appendf(nil, "\x1b[%s%d;%d$y", private, mode, state)
+		}
+	}
+	return nil
+}
+
+// oscQueryReply returns the reply to the OSC string ev if it is a color
+// query: OSC 4 for palette entries, and OSC 10 and 11 for the
+// foreground and background, which fall back to palette entries 7 and
+// 0 until a program sets them. Replies use the query's terminator.
+func oscQueryReply(ev *scanEvent) func(*terminalState) []byte {
+	if ev.cut {
+		return nil
+	}
+	st := ev.st
+	fields := strings.Split(string(ev.payload), ";")
+	code, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil
+	}
+	var indexes []int
+	switch code {
+	case 4:
+		for i := 1; i+1 < len(fields); i += 2 {
+			index, err := strconv.Atoi(fields[i])
+			if err == nil && index >= 0 && index < 256 && fields[i+1] == "?" {
+				indexes = append(indexes, index)
+			}
+		}
+	case 10, 11:
+		// Each further field applies to the next dynamic color.
+		for i, spec := range fields[1:] {
+			if spec == "?" && code+i <= 11 {
+				indexes = append(indexes, code+i)
+			}
+		}
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+	return func(term *terminalState) []byte {
+		palette, fg, bg, err := term.colors()
+		if err != nil {
+			return nil
+		}
+		var reply []byte
+		for _, index := range indexes {
+			var prefix string
+			var color libghostty.RGB
+			switch {
+			case code == 4:
+				prefix, color = fmt.Sprintf("4;%d", index), palette[index]
+			case index == 10:
+				prefix, color = "10", fg
+			default:
+				prefix, color = "11", bg
+			}
+			reply = fmt.Appendf(reply, "\x1b]%s;rgb:%04x/%04x/%04x%s", prefix,
+				int(color.R)*0x101, int(color.G)*0x101, int(color.B)*0x101, st)
+		}
+		return reply
+	}
+}
+
+func fixedReply(reply string) func(*terminalState) []byte {
+	return func(*terminalState) []byte { return []byte(reply) }
+}
@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	hauntty "code.selman.me/hauntty"
+	"gotest.tools/v3/assert"
+)
+
+// feedQueries feeds chunks through a fresh scanner and returns the
+// query replies.
+func feedQueries(t *testing.T, answer bool, chunks ...string) []string {
+	t.Helper()
+	term, err := newTerminalState(80, 24, 100)
+	assert.NilError(t, err)
+	t.Cleanup(term.close)
+	var replies []string
+	sinks := outputSinks{}
+	if answer {
+		sinks.reply = func(reply []byte) { replies = append(replies, string(reply)) }
+	}
+	var s outputScanner
+	for _, chunk := range chunks {
+		assert.NilError(t, s.feed(term, []byte(chunk), sinks))
+	}
+	return replies
+}
+
+func TestQueriesAreAnswered(t *testing.T) {
+	replies := feedQueries(t, true,
+		"\x1b[c\x1b[>c\x1b[5n",
+		"hello\x1b[6n\r\n\x1b[?6n",
+		"\x1b[>q",
+	)
+	assert.DeepEqual(t, replies, []string{
+		"\x1b[?62;22c",
+		"\x1b[>1;10;0c",
+		"\x1b[0n",
+		"\x1b[1;6R",
+		"\x1b[?2;1R",
+		"\x1bP>|hauntty " + hauntty.Version() + "\x1b\\",
+	})
+}
+
+func TestQueriesSplitAcrossChunks(t *testing.T) {
+	replies := feedQueries(t, true, "ab\x1b", "[", "6", "n")
+	assert.DeepEqual(t, replies, []string{"\x1b[1;3R"})
+}
+
+func TestQueriesReportModes(t *testing.T) {
+	replies := feedQueries(t, true,
+		"\x1b[?2004h\x1b[?25l\x1b[4h",
+		"\x1b[?2004$p\x1b[?25$p\x1b[?1049$p\x1b[4$p\x1b[?9999$p",
+		"\x1bc\x1b[?2004$p",
+	)
+	assert.DeepEqual(t, replies, []string{
+		"\x1b[?2004;1$y",
+		"\x1b[?25;2$y",
+		"\x1b[?1049;2$y",
+		"\x1b[4;1$y",
+		"\x1b[?9999;0$y",
+		"\x1b[?2004;2$y",
+	})
+}
+
+func TestQueriesReportColors(t *testing.T) {
+	replies := feedQueries(t, true,
+		"\x1b]4;0;rgb:01/02/03;1;#102030\x07\x1b]4;1;?;0;?\x07",
+		"\x1b]10;#aabbcc\x07\x1b]10;?;?\x1b\\",
+		"\x1b]11;rgb:ff/00/80\x07\x1b]11;?\x07\x1b]12;?\x07\x1b]4;256;?\x07",
+	)
+	assert.DeepEqual(t, replies, []string{
+		"\x1b]4;1;rgb:1010/2020/3030\x07\x1b]4;0;rgb:0101/0202/0303\x07",
+		// The background falls back to palette entry 0 until set.
+		"\x1b]10;rgb:aaaa/bbbb/cccc\x1b\\\x1b]11;rgb:0101/0202/0303\x1b\\",
+		"\x1b]11;rgb:ffff/0000/8080\x07",
+	})
+}
+
+func TestQueriesReportAdoptedModes(t *testing.T) {
+	state := snapshotSessionState(t, 80, 24, time.Unix(1700000000, 0), []byte("\x1b[?1049h\x1b[?2004h"))
+	term, err := adoptTerminalState(state, termSize{cols: 80, rows: 24}, 0)
+	assert.NilError(t, err)
+	defer term.close()
+
+	var replies []string
+	var s outputScanner
+	assert.NilError(t, s.feed(term, []byte("\x1b[?1049$p\x1b[?2004$p"), outputSinks{
+		reply: func(reply []byte) { replies = append(replies, string(reply)) },
+	}))
+	assert.DeepEqual(t, replies, []string{"\x1b[?1049;1$y", "\x1b[?2004;1$y"})
+}
+
+func TestQueriesSkipStrings(t *testing.T) {
+	// Text inside OSC and DCS strings is payload, not queries.
+	replies := feedQueries(t, true, "\x1b]0;[5n\x07\x1b[5n\x1bP+q5b63\x1b\\\x1b[c")
+	assert.DeepEqual(t, replies, []string{"\x1b[0n", "\x1b[?62;22c"})
+}
+
+func TestQueriesReportModesAfterNotAnswering(t *testing.T) {
+	term, err := newTerminalState(80, 24, 100)
+	assert.NilError(t, err)
+	defer term.close()
+	var s outputScanner
+	var replies []string
+	write := func(reply []byte) { replies = append(replies, string(reply)) }
+
+	// A writable client answers while attached.
+	assert.NilError(t, s.feed(term, []byte("\x1b[?1049h\x1b[c"), outputSinks{}))
+	assert.Equal(t, len(replies), 0)
+
+	assert.NilError(t, s.feed(term, []byte("\x1b[?1049$p"), outputSinks{reply: write}))
+	assert.DeepEqual(t, replies, []string{"\x1b[?1049;1$y"})
+}
@@ -0,0 +1,246 @@
+package daemon
+
+import "time"
+
+const (
+	// maxScanPayload bounds the text line and OSC payload the scanner
+	// keeps; anything longer is cut.
+	maxScanPayload = 4096
+	// maxScanParams bounds the CSI parameter bytes the scanner keeps.
+	maxScanParams = 64
+)
+
+type scanState uint8
+
+const (
+	scanGround scanState = iota
+	scanEscape
+	scanCSI
+	scanOSC
+	scanOSCEscape
+	// scanString is inside a DCS, APC, PM or SOS string, which the
+	// scanner skips.
+	scanString
+	scanStringEscape
+)
+
+type scanKind uint8
+
+const (
+	// seenBell is a BEL outside any sequence.
+	seenBell scanKind = iota + 1
+	// seenLine is a newline; line holds the text since the last one.
+	seenLine
+	seenCSI
+	seenOSC
+)
+
+// scanEvent is something the scanner found in the output. Its slices
+// are only valid until the next byte is scanned.
+type scanEvent struct {
+	kind scanKind
+	// line is the printable text of the line, without escape sequences.
+	line []byte
+	// prefix, params, intermediate and final make up a CSI sequence.
+	prefix       byte
+	params       []byte
+	intermediate byte
+	final        byte
+	// payload is an OSC string, cut when longer than maxScanPayload, and
+	// st is the terminator that ended it.
+	payload []byte
+	cut     bool
+	st      string
+}
+
+// outputScanner tracks just enough of the escape sequence grammar to
+// pick bells, text lines, CSI sequences and OSC strings out of a
+// session's output, so each consumer need not parse it again. Only
+// feedLoop uses it.
+type outputScanner struct {
+	state        scanState
+	line         []byte
+	prefix       byte
+	params       []byte
+	intermediate byte
+	payload      []byte
+	cut          bool
+	ev           scanEvent
+}
+
+// outputSinks are the consumers of a session's scanned output. Nil
+// sinks are skipped.
+type outputSinks struct {
+	commands *commandTracker
+	monitor  *sessionMonitor
+	// reply writes the answers to terminal queries; nil leaves them to
+	// an attached client.
+	reply func([]byte)
+}
+
+// feed applies data to term and hands what the scanner finds to sinks:
+// query replies to reply, OSC 133 marks to the command tracker, and
+// bells, notifications and lines to the monitor. term is fed up to
+// each query and mark first, so they see the state they were written
+// in. It returns the first error recording a mark; data is applied
+// regardless.
+func (s *outputScanner) feed(term *terminalState, data []byte, sinks outputSinks) error {
+	sinks.monitor.observe()
+	var firstErr error
+	start := 0
+	for i, b := range data {
+		ev := s.scan(b)
+		if ev == nil {
+			continue
+		}
+		var reply func(*terminalState) []byte
+		var mark string
+		var isMark bool
+		switch ev.kind {
+		case seenBell:
+			sinks.monitor.bell()
+		case seenLine:
+			sinks.monitor.line(ev.line)
+		case seenCSI:
+			if sinks.reply != nil {
+				reply = csiQueryReply(ev)
+			}
+		case seenOSC:
+			sinks.monitor.osc(ev.payload)
+			if sinks.commands != nil {
+				mark, isMark = oscShellMark(ev.payload)
+			}
+			if sinks.reply != nil {
+				reply = oscQueryReply(ev)
+			}
+		}
+		if reply == nil && !isMark {
+			continue
+		}
+		term.feed(data[start : i+1])
+		start = i + 1
+		if isMark {
+			if err := sinks.commands.mark(term, mark, time.Now()); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if reply != nil {
+			if out := reply(term); len(out) > 0 {
+				sinks.reply(out)
+			}
+		}
+	}
+	if start < len(data) {
+		term.feed(data[start:])
+	}
+	return firstErr
+}
+
+// scan advances the scanner by one byte. It returns the event b
+// completes, if any.
+func (s *outputScanner) scan(b byte) *scanEvent {
+	// CAN and SUB abort any sequence.
+	if b == 0x18 || b == 0x1a {
+		s.state = scanGround
+		return nil
+	}
+	switch s.state {
+	case scanGround:
+		switch {
+		case b == 0x07:
+			return s.event(seenBell)
+		case b == 0x1b:
+			s.state = scanEscape
+		case b == '\n':
+			ev := s.event(seenLine)
+			ev.line = s.line
+			s.line = s.line[:0]
+			return ev
+		case b >= 0x20 && b != 0x7f:
+			if len(s.line) < maxScanPayload {
+				s.line = append(s.line, b)
+			}
+		}
+	case scanEscape:
+		switch b {
+		case '[':
+			s.state = scanCSI
+			s.prefix, s.intermediate = 0, 0
+			s.params = s.params[:0]
+		case ']':
+			s.state = scanOSC
+			s.cut = false
+			s.payload = s.payload[:0]
+		case 'P', '_', '^', 'X':
+			s.state = scanString
+		case 0x1b:
+		default:
+			s.state = scanGround
+		}
+	case scanCSI:
+		switch {
+		case b >= 0x3c && b <= 0x3f && len(s.params) == 0 && s.prefix == 0:
+			s.prefix = b
+		case b >= 0x30 && b <= 0x3b:
+			if len(s.params) < maxScanParams {
+				s.params = append(s.params, b)
+			}
+		case b >= 0x20 && b <= 0x2f:
+			s.intermediate = b
+		case b >= 0x40 && b <= 0x7e:
+			s.state = scanGround
+			ev := s.event(seenCSI)
+			ev.prefix, ev.params, ev.intermediate, ev.final = s.prefix, s.params, s.intermediate, b
+			return ev
+		case b == 0x1b:
+			s.state = scanEscape
+		}
+	case scanOSC:
+		switch b {
+		case 0x07:
+			return s.endOSC("\x07")
+		case 0x1b:
+			s.state = scanOSCEscape
+		default:
+			if len(s.payload) < maxScanPayload {
+				s.payload = append(s.payload, b)
+			} else {
+				s.cut = true
+			}
+		}
+	case scanOSCEscape:
+		if b == '\\' {
+			return s.endOSC("\x1b\\")
+		}
+		// A new escape sequence cancels the string.
+		s.state = scanEscape
+		return s.scan(b)
+	case scanString:
+		switch b {
+		case 0x07:
+			s.state = scanGround
+		case 0x1b:
+			s.state = scanStringEscape
+		}
+	case scanStringEscape:
+		if b == '\\' {
+			s.state = scanGround
+		} else {
+			s.state = scanEscape
+			return s.scan(b)
+		}
+	}
+	return nil
+}
+
+func (s *outputScanner) endOSC(st string) *scanEvent {
+	s.state = scanGround
+	ev := s.event(seenOSC)
+	ev.payload, ev.cut, ev.st = s.payload, s.cut, st
+	return ev
+}
+
+func (s *outputScanner) event(kind scanKind) *scanEvent {
+	s.ev = scanEvent{kind: kind}
+	return &s.ev
+}
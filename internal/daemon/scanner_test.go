@@ -0,0 +1,95 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	"code.selman.me/hauntty/internal/protocol"
+	"gotest.tools/v3/assert"
+)
+
+func TestOutputScanner(t *testing.T) {
+	var got []string
+	var s outputScanner
+	scan := func(data string) {
+		for _, b := range []byte(data) {
+			ev := s.scan(b)
+			switch {
+			case ev == nil:
+			case ev.kind == seenBell:
+				got = append(got, "bell")
+			case ev.kind == seenLine:
+				got = append(got, "line "+string(ev.line))
+			case ev.kind == seenCSI:
+				csi := append([]byte{ev.prefix}, ev.params...)
+				csi = append(csi, ev.intermediate, ev.final)
+				// An unset prefix or intermediate is zero.
+				got = append(got, "csi "+strings.ReplaceAll(string(csi), "\x00", ""))
+			case ev.kind == seenOSC:
+				got = append(got, "osc "+string(ev.payload))
+			}
+		}
+	}
+
+	// A BEL that ends an OSC string is not a bell.
+	scan("\x1b]0;title\x07\x1b[1mone\x1b[0m\r\n\x07")
+	scan("\x1b]9;done\x1b")
+	scan("\\two\n\x1bP+q\x07\x1b\\")
+	scan("\x1b[?2026$p\x1b]133;A\x1b[5\x18n\n")
+
+	assert.DeepEqual(t, got, []string{
+		"osc 0;title",
+		"csi 1m",
+		"csi 0m",
+		"line one",
+		"bell",
+		"osc 9;done",
+		"line two",
+		"csi ?2026$p",
+		// A new escape sequence cancels the OSC string and CAN the CSI,
+		// so its final byte is text.
+		"line n",
+	})
+}
+
+func TestOutputScannerCutsLongPayloads(t *testing.T) {
+	var s outputScanner
+	var ev *scanEvent
+	for _, b := range []byte("\x1b]4;1;?" + string(make([]byte, maxScanPayload)) + "\x07") {
+		if e := s.scan(b); e != nil {
+			ev = e
+		}
+	}
+	assert.Equal(t, ev.kind, seenOSC)
+	assert.Equal(t, len(ev.payload), maxScanPayload)
+	assert.Equal(t, ev.cut, true)
+	assert.Assert(t, oscQueryReply(ev) == nil)
+}
+
+func TestOutputScannerFeedsEverySink(t *testing.T) {
+	term, err := newTerminalState(40, 10, 100)
+	assert.NilError(t, err)
+	commands := newCommandTracker()
+	defer func() {
+		commands.close()
+		term.close()
+	}()
+	m, sub := newTestMonitor(t, protocol.MonitorSettings{Bell: true, Pattern: "done"})
+
+	var replies []string
+	var s outputScanner
+	err = s.feed(term, []byte("\x1b]133;A\x07$ \x1b]133;B\x07\x1b[6n\x07\x1b]9;hi\x07done\r\n"), outputSinks{
+		commands: commands,
+		monitor:  m,
+		reply:    func(reply []byte) { replies = append(replies, string(reply)) },
+	})
+	assert.NilError(t, err)
+
+	assert.Equal(t, commands.atPrompt(), true)
+	assert.DeepEqual(t, replies, []string{"\x1b[1;3R"})
+	assert.DeepEqual(t, monitorEvents(sub), []protocol.Event{
+		{Kind: protocol.EventBell, Session: "build"},
+		{Kind: protocol.EventNotify, Session: "build", Message: "hi"},
+		{Kind: protocol.EventMatch, Session: "build", Message: "$ done"},
+	})
+}
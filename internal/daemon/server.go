@@ -23,9 +23,12 @@ import (
 )
 
 type Server struct {
-	socketPath        string
-	pidPath           string
-	lockPath          string
+	socketPath string
+	pidPath    string
+	lockPath   string
+	// ownsSocket is set when the server created socketPath and the PID
+	// file, rather than serving a listener it was given.
+	ownsSocket        bool
 	sessions          map[string]*Session
 	mu                sync.RWMutex
 	ctx               context.Context
@@ -33,16 +36,32 @@ type Server struct {
 	listener          net.Listener
 	lockFile          *os.File
 	persister         *persister
+	stateDir          string
+	log               *slog.Logger
+	signals           []os.Signal
 	defaultScrollback uint32
 	resizePolicy      config.ResizePolicy
+	sessionLog        config.SessionLogConfig
+	restart           config.RestartConfig
+	slowClient        config.SlowClientPolicy
+	monitor           protocol.MonitorSettings
+	events            *eventHub
+	hooks             *hookRunner
 	autoExit          bool
-	shutdownOnce      sync.Once
-	startedAt         time.Time
+	// upgradeArgs builds the arguments of the daemon an upgrade execs;
+	// handover is what a previous daemon passed to this one.
+	upgradeArgs  func(fd int) []string
+	handover     *os.File
+	shutdownOnce sync.Once
+	// shutdownDone closes once shutdown has closed the sessions and
+	// removed the daemon's files.
+	shutdownDone chan struct{}
+	startedAt    time.Time
 }
 
-func New(ctx context.Context, cfg *config.DaemonConfig, resizePolicy config.ResizePolicy) (*Server, error) {
-	if cfg.StatePersistence && cfg.StatePersistenceInterval <= 0 {
-		return nil, fmt.Errorf("daemon: state_persistence_interval must be > 0 when state persistence is enabled")
+func New(ctx context.Context, cfg *config.DaemonConfig, resizePolicy config.ResizePolicy, opts ...Option) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("daemon: %w", err)
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -54,85 +73,158 @@ func New(ctx context.Context, cfg *config.DaemonConfig, resizePolicy config.Resi
 		sessions:          make(map[string]*Session),
 		ctx:               ctx,
 		cancel:            cancel,
+		log:               slog.Default(),
+		signals:           []os.Signal{syscall.SIGTERM, syscall.SIGINT},
 		defaultScrollback: cfg.DefaultScrollback,
 		resizePolicy:      resizePolicy,
+		sessionLog:        cfg.SessionLog,
+		restart:           cfg.Restart,
+		slowClient:        cfg.SlowClient,
+		monitor:           monitorSettings(cfg.Monitor),
+		events:            newEventHub(),
 		autoExit:          cfg.AutoExit,
+		shutdownDone:      make(chan struct{}),
 		startedAt:         time.Now(),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if !s.resizePolicy.Valid() {
+		cancel()
+		return nil, fmt.Errorf("daemon: invalid resize policy %q", s.resizePolicy)
+	}
+	if s.listener != nil {
+		s.socketPath = s.listener.Addr().String()
+	}
 
 	if cfg.StatePersistence {
 		interval := time.Duration(cfg.StatePersistenceInterval) * time.Second
-		s.persister = newPersister(s.liveSessions, interval)
+		dir := cmp.Or(s.stateDir, defaultStateDir())
+		s.persister = newPersister(s.liveSessions, dir, interval, s.log)
+	}
+	if s.hooks = newHookRunner(cfg.Hooks, s.events, s.log); s.hooks != nil {
+		s.hooks.start()
 	}
 
 	return s, nil
 }
 
+// Listen serves the configured socket until the server shuts down.
 func (s *Server) Listen() error {
-	cleanStaleTmp()
-
-	dir := filepath.Dir(s.socketPath)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
-		return fmt.Errorf("daemon: create socket dir: %w", err)
-	}
+	return s.Serve(context.Background())
+}
 
-	if err := s.acquireLock(); err != nil {
-		return err
-	}
-	lockHeld := true
-	defer func() {
-		if lockHeld {
-			s.releaseLock()
+// Serve accepts connections until ctx is done or the server shuts down,
+// shutting the server down in either case, and returns once the
+// shutdown has finished. Without WithListener it first takes the daemon
+// lock and listens on the configured socket; if that fails, it stops
+// the hooks New started and returns the error without running the
+// shutdown hook. A listener closed by its owner also shuts the server
+// down, and Serve returns the accept error.
+func (s *Server) Serve(ctx context.Context) error {
+	if s.listener == nil {
+		listen := s.listen
+		if s.handover != nil {
+			listen = s.adopt
+		}
+		if err := listen(); err != nil {
+			s.shutdownOnce.Do(s.abandon)
+			return err
 		}
-	}()
-
-	if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-		slog.Warn("remove stale socket", "path", s.socketPath, "err", err)
-	}
-
-	ln, err := net.Listen("unix", s.socketPath)
-	if err != nil {
-		return fmt.Errorf("daemon: listen: %w", err)
 	}
-	s.listener = ln
+	ln := s.listener
 
-	if err := s.writePID(); err != nil {
-		ln.Close()
-		return err
+	if len(s.signals) > 0 {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, s.signals...)
+		go func() {
+			defer signal.Stop(sigCh)
+			select {
+			case sig := <-sigCh:
+				s.log.Info("received shutdown signal", "signal", sig)
+				s.Shutdown()
+			case <-s.ctx.Done():
+			}
+		}()
 	}
-
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
-	go func() {
-		select {
-		case <-sigCh:
-			slog.Info("received shutdown signal")
-			s.Shutdown()
-		case <-s.ctx.Done():
-		}
-	}()
+	stop := context.AfterFunc(ctx, s.Shutdown)
+	defer stop()
 
 	if s.persister != nil {
+		s.persister.cleanStaleTmp()
 		s.persister.start()
 	}
 
-	slog.Info("daemon listening", "socket", s.socketPath)
+	s.log.Info("daemon listening", "socket", s.socketPath)
 
+	var delay time.Duration
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			select {
 			case <-s.ctx.Done():
+				<-s.shutdownDone
 				return nil
 			default:
-				slog.Error("accept error", "err", err)
-				continue
 			}
+			if errors.Is(err, net.ErrClosed) {
+				s.Shutdown()
+				return fmt.Errorf("daemon: accept: %w", err)
+			}
+			// Back off like net/http, so a failing listener, say one out
+			// of file descriptors, does not spin.
+			delay = min(max(2*delay, acceptMinDelay), acceptMaxDelay)
+			s.log.Error("accept error", "err", err, "retry_in", delay)
+			select {
+			case <-time.After(delay):
+			case <-s.ctx.Done():
+			}
+			continue
 		}
+		delay = 0
 		go s.handleConn(conn)
 	}
 }
 
+// acceptMinDelay and acceptMaxDelay bound the wait before accepting
+// again after an accept error.
+const (
+	acceptMinDelay = 5 * time.Millisecond
+	acceptMaxDelay = time.Second
+)
+
+// listen takes the daemon lock and listens on socketPath, replacing a
+// stale socket left by a daemon that died.
+func (s *Server) listen() error {
+	dir := filepath.Dir(s.socketPath)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("daemon: create socket dir: %w", err)
+	}
+
+	if err := s.acquireLock(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.log.Warn("remove stale socket", "path", s.socketPath, "err", err)
+	}
+
+	ln, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		s.releaseLock()
+		return fmt.Errorf("daemon: listen: %w", err)
+	}
+
+	if err := s.writePID(); err != nil {
+		ln.Close()
+		s.releaseLock()
+		return err
+	}
+	s.listener = ln
+	s.ownsSocket = true
+	return nil
+}
+
 func (s *Server) acquireLock() error {
 	f, err := os.OpenFile(s.lockPath, os.O_CREATE|os.O_RDWR, 0o600)
 	if err != nil {
@@ -162,51 +254,50 @@ func (s *Server) releaseLock() {
 
 func (s *Server) handleConn(netConn net.Conn) {
 	defer netConn.Close()
-
-	unixConn, ok := netConn.(*net.UnixConn)
-	if !ok {
-		return
-	}
-	raw, err := unixConn.SyscallConn()
-	if err != nil {
-		return
-	}
-	var peerUID int
-	var credErr error
-	_ = raw.Control(func(fd uintptr) {
-		cred, err := unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	// Shutting down hangs up on every client, so an embedded server
+	// leaves no connections behind.
+	stop := context.AfterFunc(s.ctx, func() { netConn.Close() })
+	defer stop()
+
+	// Only Unix sockets carry peer credentials. Other connections come
+	// from a listener the embedding program supplied and vets itself.
+	var peer peerCred
+	if unixConn, ok := netConn.(*net.UnixConn); ok {
+		var err error
+		peer, err = unixPeerCred(unixConn)
 		if err != nil {
-			credErr = err
+			s.log.Warn("read peer credentials failed", "err", err)
+			return
+		}
+		if peer.uid != os.Getuid() {
+			s.log.Warn("rejected connection from different UID", "peer", peer.uid, "pid", peer.pid)
 			return
 		}
-		peerUID = int(cred.Uid)
-	})
-	if credErr != nil {
-		slog.Warn("getpeereid failed", "err", credErr)
-		return
-	}
-	if peerUID != os.Getuid() {
-		slog.Warn("rejected connection from different UID", "peer", peerUID)
-		return
 	}
 
 	conn := protocol.NewConn(netConn)
 
-	clientVer, clientRev, err := conn.AcceptHandshake()
+	hello, err := conn.AcceptHandshake()
 	if err != nil {
 		return
 	}
-	if clientVer != protocol.ProtocolVersion {
-		if err := conn.WriteVersionReply(0, ""); err != nil {
-			slog.Debug("reject handshake", "err", err)
+	clientRev := hello.Revision
+	version, caps := protocol.Negotiate(hello)
+	if version == 0 {
+		s.log.Warn("rejected client protocol", "min", hello.MinVersion, "max", hello.MaxVersion, "revision", clientRev)
+		if err := conn.WriteHandshakeReply(0, "", nil); err != nil {
+			s.log.Debug("reject handshake", "err", err)
 		}
 		return
 	}
 	serverRev := hauntty.Version()
 	if clientRev != serverRev {
-		slog.Warn("client/server revision differ", "client", clientRev, "server", serverRev)
+		s.log.Warn("client/server revision differ", "client", clientRev, "server", serverRev)
 	}
-	if err := conn.WriteVersionReply(protocol.ProtocolVersion, serverRev); err != nil {
+	if version < protocol.ProtocolVersion {
+		s.log.Info("serving client in compatibility mode", "protocol", version, "revision", clientRev)
+	}
+	if err := conn.WriteHandshakeReply(version, serverRev, caps); err != nil {
 		return
 	}
 
@@ -225,7 +316,7 @@ func (s *Server) handleConn(netConn net.Conn) {
 			case *protocol.Input:
 				if !readOnly {
 					if err := attached.sendInput(m.Data); err != nil {
-						slog.Debug("pty write", "session", attached.Name, "err", err)
+						s.log.Debug("pty write", "session", attached.Name, "err", err)
 					}
 				}
 			case *protocol.Resize:
@@ -236,20 +327,25 @@ func (s *Server) handleConn(netConn net.Conn) {
 				attached.detachClient(ac)
 				return
 			default:
-				slog.Debug("control message in streaming mode, closing", "type", fmt.Sprintf("0x%02x", msg.Type()))
+				s.log.Debug("control message in streaming mode, closing", "type", fmt.Sprintf("0x%02x", msg.Type()))
 				attached.detachClient(ac)
 				return
 			}
 			continue
 		}
 
+		if c, ok := protocol.RequiredCapability(msg.Type()); ok && !conn.Has(c) {
+			s.writeError(conn, fmt.Sprintf("capability %q not negotiated", c))
+			continue
+		}
+
 		switch m := msg.(type) {
 		case *protocol.Create:
 			s.handleCreate(conn, m)
 		case *protocol.Attach:
-			sess, client, ro, err := s.handleAttach(conn, netConn.Close, m, clientRev)
+			sess, client, ro, err := s.handleAttach(conn, netConn.Close, m, clientRev, uint32(peer.pid))
 			if err != nil {
-				slog.Debug("attach error", "err", err)
+				s.log.Debug("attach error", "err", err)
 				continue
 			}
 			attached = sess
@@ -271,8 +367,28 @@ func (s *Server) handleConn(netConn net.Conn) {
 			s.handleStatus(conn, m)
 		case *protocol.Kick:
 			s.handleKick(conn, m)
+		case *protocol.Watch:
+			s.handleWatch(conn, netConn, m)
+		case *protocol.Log:
+			s.handleLog(conn, m)
+		case *protocol.Record:
+			s.handleRecord(conn, m)
+		case *protocol.Search:
+			s.handleSearch(conn, m)
+		case *protocol.Monitor:
+			s.handleMonitor(conn, m)
+		case *protocol.Upgrade:
+			s.handleUpgrade(conn, m)
+		case *protocol.History:
+			s.handleHistory(conn, m)
+		case *protocol.Exec:
+			s.handleExec(conn, netConn, m)
+		case *protocol.Subscribe:
+			// The connection belongs to the event stream from here on.
+			s.handleSubscribe(conn, m)
+			return
 		default:
-			slog.Debug("unknown message in control mode", "type", fmt.Sprintf("0x%02x", msg.Type()))
+			s.log.Debug("unknown message in control mode", "type", fmt.Sprintf("0x%02x", msg.Type()))
 			return
 		}
 	}
@@ -286,11 +402,23 @@ func (s *Server) Shutdown() {
 	s.shutdownOnce.Do(s.shutdown)
 }
 
+// abandon releases what New started when Serve fails before it serves.
+// Unlike shutdown it runs no shutdown hook and leaves the socket alone,
+// since another daemon may own it.
+func (s *Server) abandon() {
+	if s.persister != nil {
+		s.persister.stop()
+	}
+	s.cancel()
+	s.hooks.discard()
+	close(s.shutdownDone)
+}
+
 func (s *Server) shutdown() {
 	if s.persister != nil {
 		s.persister.stop()
 		if err := s.persister.saveAll(); err != nil {
-			slog.Warn("persist: shutdown save failed", "err", err)
+			s.log.Warn("persist: shutdown save failed", "err", err)
 		}
 	}
 
@@ -308,14 +436,17 @@ func (s *Server) shutdown() {
 	s.sessions = make(map[string]*Session)
 	s.mu.Unlock()
 
-	if s.listener != nil {
+	s.hooks.shutdown()
+
+	if s.ownsSocket {
 		if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-			slog.Warn("remove socket", "path", s.socketPath, "err", err)
+			s.log.Warn("remove socket", "path", s.socketPath, "err", err)
 		}
 		if err := os.Remove(s.pidPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-			slog.Warn("remove pid file", "path", s.pidPath, "err", err)
+			s.log.Warn("remove pid file", "path", s.pidPath, "err", err)
 		}
 	}
+	close(s.shutdownDone)
 }
 
 func (s *Server) writePID() error {
@@ -337,14 +468,14 @@ func (s *Server) liveSession(name string) (*Session, bool) {
 	return sess, ok
 }
 
-func writeOK(conn *protocol.Conn) {
+func (s *Server) writeOK(conn *protocol.Conn) {
 	if err := conn.WriteMessage(&protocol.OK{}); err != nil {
-		slog.Debug("write ok response", "err", err)
+		s.log.Debug("write ok response", "err", err)
 	}
 }
 
-func writeError(conn *protocol.Conn, message string) {
+func (s *Server) writeError(conn *protocol.Conn, message string) {
 	if err := conn.WriteMessage(&protocol.Error{Message: message}); err != nil {
-		slog.Debug("write error response", "err", err)
+		s.log.Debug("write error response", "err", err)
 	}
 }
@@ -1,65 +1,89 @@
 package daemon
 
 import (
+	"cmp"
 	"fmt"
-	"log/slog"
+	"os"
 
+	"code.selman.me/hauntty/internal/config"
 	"code.selman.me/hauntty/internal/protocol"
 )
 
 func (s *Server) handleCreate(conn *protocol.Conn, msg *protocol.Create) {
+	resizePolicy := s.resizePolicy
+	if msg.ResizePolicy != "" {
+		resizePolicy = config.ResizePolicy(msg.ResizePolicy)
+		if !resizePolicy.Valid() {
+			s.writeError(conn, fmt.Sprintf("invalid resize policy %q", msg.ResizePolicy))
+			return
+		}
+	}
+	// Without clients to size it, a session runs at the requested size
+	// or 80x24.
+	size := termSize{cols: 80, rows: 24}
+	if msg.Cols != 0 && msg.Rows != 0 {
+		size = termSize{cols: msg.Cols, rows: msg.Rows, xpixel: msg.Xpixel, ypixel: msg.Ypixel}
+	}
+
 	name, err := s.reserveSessionName(msg.Name)
 	if err != nil {
-		writeError(conn, fmt.Errorf("reserve session name: %w", err).Error())
+		s.writeError(conn, fmt.Errorf("reserve session name: %w", err).Error())
 		return
 	}
 
 	s.mu.Lock()
 	if _, exists := s.sessions[name]; exists {
 		s.mu.Unlock()
-		writeError(conn, "session already exists")
+		s.writeError(conn, "session already exists")
 		return
 	}
 	s.mu.Unlock()
 
 	if err := s.prepareCreateDeadSession(name, msg.Force); err != nil {
-		writeError(conn, err.Error())
+		s.writeError(conn, err.Error())
 		return
 	}
 
-	sess, err := newSession(s.ctx, s.resizePolicy, sessionStartSpec{
+	sess, err := newSession(s.ctx, resizePolicy, sessionStartSpec{
 		name:       name,
 		command:    msg.Command,
 		env:        msg.Env,
 		cwd:        msg.CWD,
-		size:       termSize{cols: 80, rows: 24},
+		size:       size,
 		scrollback: s.scrollback(msg.Scrollback),
+		log:        newSessionLogSpec(s.sessionLog, name, msg.LogPath, msg.LogFormat),
+		restart:    newRestartPolicy(s.restart, msg.Restart),
+		events:     s.events,
+		daemonLog:  s.log,
+		slowClient: s.slowClient,
+		monitor:    s.monitor,
 	})
 	if err != nil {
-		writeError(conn, err.Error())
+		s.writeError(conn, err.Error())
 		return
 	}
 
 	if !s.addSession(name, sess) {
-		sess.close(s.ctx)
-		writeError(conn, "session already exists")
+		sess.discard(s.ctx)
+		s.writeError(conn, "session already exists")
 		return
 	}
+	s.events.publish(protocol.Event{Kind: protocol.EventCreated, Session: name, PID: sess.pid()})
 
-	if err := conn.WriteMessage(&protocol.Created{Name: name, PID: sess.PID}); err != nil {
-		slog.Debug("write created response", "err", err)
+	if err := conn.WriteMessage(&protocol.Created{Name: name, PID: sess.pid()}); err != nil {
+		s.log.Debug("write created response", "err", err)
 	}
 }
 
-func (s *Server) handleAttach(conn *protocol.Conn, closeConn func() error, msg *protocol.Attach, clientRev string) (*Session, *sessionClient, bool, error) {
+func (s *Server) handleAttach(conn *protocol.Conn, closeConn func() error, msg *protocol.Attach, clientRev string, clientPID uint32) (*Session, *sessionClient, bool, error) {
 	if msg.Restore {
-		return s.handleAttachRestore(conn, closeConn, msg, clientRev)
+		return s.handleAttachRestore(conn, closeConn, msg, clientRev, clientPID)
 	}
 
 	name, err := s.reserveSessionName(msg.Name)
 	if err != nil {
 		err = fmt.Errorf("reserve session name: %w", err)
-		writeError(conn, err.Error())
+		s.writeError(conn, err.Error())
 		return nil, nil, false, err
 	}
 
@@ -75,11 +99,11 @@ func (s *Server) handleAttach(conn *protocol.Conn, closeConn func() error, msg *
 		switch {
 		case err != nil:
 			err = fmt.Errorf("load dead session state: %w", err)
-			writeError(conn, err.Error())
+			s.writeError(conn, err.Error())
 			return nil, nil, false, err
 		case exists:
 			err = fmt.Errorf("%s", deadSessionStateExistsMessage(name))
-			writeError(conn, err.Error())
+			s.writeError(conn, err.Error())
 			return nil, nil, false, err
 		}
 
@@ -90,18 +114,25 @@ func (s *Server) handleAttach(conn *protocol.Conn, closeConn func() error, msg *
 			cwd:        msg.CWD,
 			size:       size,
 			scrollback: s.scrollback(msg.Scrollback),
+			log:        newSessionLogSpec(s.sessionLog, name, msg.LogPath, msg.LogFormat),
+			restart:    newRestartPolicy(s.restart, protocol.RestartDefault),
+			events:     s.events,
+			daemonLog:  s.log,
+			slowClient: s.slowClient,
+			monitor:    s.monitor,
 		})
 		if err != nil {
-			writeError(conn, err.Error())
+			s.writeError(conn, err.Error())
 			return nil, nil, false, err
 		}
 
 		existing, inserted := s.insertSessionOrExisting(name, sess)
 		if !inserted {
-			sess.close(s.ctx)
+			sess.discard(s.ctx)
 			sess = existing
 		} else {
 			created = true
+			s.events.publish(protocol.Event{Kind: protocol.EventCreated, Session: name, PID: sess.pid()})
 		}
 	}
 
@@ -110,6 +141,7 @@ func (s *Server) handleAttach(conn *protocol.Conn, closeConn func() error, msg *
 		closeConn: closeConn,
 		size:      size,
 		version:   clientRev,
+		pid:       clientPID,
 		readOnly:  msg.ReadOnly,
 		created:   created,
 	})
@@ -118,7 +150,7 @@ func (s *Server) handleAttach(conn *protocol.Conn, closeConn func() error, msg *
 			s.removeSession(name)
 			sess.close(s.ctx)
 		}
-		writeError(conn, err.Error())
+		s.writeError(conn, err.Error())
 		return nil, nil, false, err
 	}
 
@@ -128,54 +160,73 @@ func (s *Server) handleAttach(conn *protocol.Conn, closeConn func() error, msg *
 	return sess, ac, msg.ReadOnly, nil
 }
 
-func (s *Server) handleAttachRestore(conn *protocol.Conn, closeConn func() error, msg *protocol.Attach, clientRev string) (*Session, *sessionClient, bool, error) {
+func (s *Server) handleAttachRestore(conn *protocol.Conn, closeConn func() error, msg *protocol.Attach, clientRev string, clientPID uint32) (*Session, *sessionClient, bool, error) {
 	name := msg.Name
 	state, err := s.prepareRestoreDeadSession(name)
 	if err != nil {
-		writeError(conn, err.Error())
+		s.writeError(conn, err.Error())
 		return nil, nil, false, err
 	}
 
 	size := termSize{cols: msg.Cols, rows: msg.Rows, xpixel: msg.Xpixel, ypixel: msg.Ypixel}
+	// A fixed session comes back at the size it was saved with, not the
+	// restoring client's.
+	resizePolicy := cmp.Or(state.ResizePolicy, s.resizePolicy)
+	if resizePolicy == config.ResizePolicyFixed && state.Cols != 0 && state.Rows != 0 {
+		size = termSize{cols: state.Cols, rows: state.Rows}
+	}
 
-	sess, err := restoreSession(s.ctx, state, s.resizePolicy, sessionStartSpec{
+	monitor := s.monitor
+	if state.Monitor != nil {
+		monitor = *state.Monitor
+	}
+	command, cwd, env := restoreLaunch(state, msg)
+	sess, err := restoreSession(s.ctx, state, resizePolicy, sessionStartSpec{
 		name:       name,
-		command:    msg.Command,
-		env:        msg.Env,
-		cwd:        msg.CWD,
+		command:    command,
+		env:        env,
+		cwd:        cwd,
 		size:       size,
 		scrollback: s.scrollback(msg.Scrollback),
+		log:        newSessionLogSpec(s.sessionLog, name, msg.LogPath, msg.LogFormat),
+		restart:    newRestartPolicy(s.restart, state.Restart),
+		events:     s.events,
+		daemonLog:  s.log,
+		slowClient: s.slowClient,
+		monitor:    monitor,
 	})
 	if err != nil {
-		writeError(conn, err.Error())
+		s.writeError(conn, err.Error())
 		return nil, nil, false, err
 	}
 
 	if !s.insertSession(name, sess) {
-		sess.close(s.ctx)
-		writeError(conn, "session already exists")
+		sess.discard(s.ctx)
+		s.writeError(conn, "session already exists")
 		return nil, nil, false, fmt.Errorf("session %q created by another client during restore", name)
 	}
 
 	if err := s.commitRestoreDeadSession(name); err != nil {
 		s.removeSession(name)
-		sess.close(s.ctx)
-		writeError(conn, err.Error())
+		sess.discard(s.ctx)
+		s.writeError(conn, err.Error())
 		return nil, nil, false, err
 	}
+	s.events.publish(protocol.Event{Kind: protocol.EventRestored, Session: name, PID: sess.pid()})
 
 	ac, err := sess.attach(s.ctx, sessionAttachSpec{
 		conn:      conn,
 		closeConn: closeConn,
 		size:      size,
 		version:   clientRev,
+		pid:       clientPID,
 		readOnly:  msg.ReadOnly,
 	})
 	if err != nil {
 		s.removeSession(name)
 		sess.close(s.ctx)
 		err = s.rollbackRestoreDeadSession(name, state, err)
-		writeError(conn, err.Error())
+		s.writeError(conn, err.Error())
 		return nil, nil, false, err
 	}
 
@@ -183,6 +234,25 @@ func (s *Server) handleAttachRestore(conn *protocol.Conn, closeConn func() error
 	return sess, ac, msg.ReadOnly, nil
 }
 
+// restoreLaunch reruns a dead session's original command in its original
+// directory. A command in msg replaces the saved one, and the restoring
+// client's environment overrides the saved environment. Sessions saved
+// without launch parameters, or whose directory is gone, fall back to
+// the client's directory.
+func restoreLaunch(state *sessionState, msg *protocol.Attach) ([]string, string, []string) {
+	command := state.Command
+	if len(msg.Command) > 0 {
+		command = msg.Command
+	}
+	cwd := msg.CWD
+	if state.CWD != "" {
+		if info, err := os.Stat(state.CWD); err == nil && info.IsDir() {
+			cwd = state.CWD
+		}
+	}
+	return command, cwd, mergeEnv(state.Env, msg.Env)
+}
+
 func (s *Server) scrollback(requested uint32) uint32 {
 	if requested == 0 {
 		return s.defaultScrollback
@@ -227,7 +297,7 @@ func (s *Server) watchSession(sess *Session) {
 		<-sess.done
 		if s.persister != nil && s.ctx.Err() == nil {
 			if err := s.persister.saveSession(sess.Name, sess); err != nil {
-				slog.Warn("persist: save failed on exit", "session", sess.Name, "err", err)
+				s.log.Warn("persist: save failed on exit", "session", sess.Name, "err", err)
 			}
 		}
 		s.mu.Lock()
@@ -236,7 +306,7 @@ func (s *Server) watchSession(sess *Session) {
 		s.mu.Unlock()
 		if s.autoExit && empty {
 			sess.waitClients()
-			slog.Info("auto-exit: last session ended, shutting down")
+			s.log.Info("auto-exit: last session ended, shutting down")
 			s.Shutdown()
 		}
 	}()
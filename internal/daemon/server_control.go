@@ -1,71 +1,255 @@
 package daemon
 
-import "code.selman.me/hauntty/internal/protocol"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"code.selman.me/hauntty/internal/protocol"
+	"golang.org/x/sys/unix"
+)
 
 func (s *Server) handleKick(conn *protocol.Conn, msg *protocol.Kick) {
 	if msg.Name == "" || msg.ClientID == "" {
-		writeError(conn, "name and client ID required")
+		s.writeError(conn, "name and client ID required")
 		return
 	}
 
 	sess, ok := s.liveSession(msg.Name)
 	if !ok {
-		writeError(conn, "session not found")
+		s.writeError(conn, "session not found")
 		return
 	}
 
 	if !sess.kickClient(msg.ClientID) {
-		writeError(conn, "client not found")
+		s.writeError(conn, "client not found")
 		return
 	}
 
-	writeOK(conn)
+	s.writeOK(conn)
+}
+
+func (s *Server) handleMonitor(conn *protocol.Conn, msg *protocol.Monitor) {
+	sess, ok := s.liveSession(msg.Name)
+	if !ok {
+		s.writeError(conn, "session not found")
+		return
+	}
+
+	if err := sess.monitor.set(msg.Settings); err != nil {
+		s.writeError(conn, fmt.Sprintf("invalid pattern: %v", err))
+		return
+	}
+	s.writeOK(conn)
 }
 
 func (s *Server) handleKill(conn *protocol.Conn, msg *protocol.Kill) {
 	sess, ok := s.liveSession(msg.Name)
 	if !ok {
-		writeError(conn, "session not found")
+		s.writeError(conn, "session not found")
 		return
 	}
 
 	sess.kill()
-	writeOK(conn)
+	s.writeOK(conn)
 }
 
 func (s *Server) handleSend(conn *protocol.Conn, msg *protocol.Send) {
 	sess, ok := s.liveSession(msg.Name)
 	if !ok {
-		writeError(conn, "session not found")
+		s.writeError(conn, "session not found")
 		return
 	}
 
 	if err := sess.sendInput(msg.Data); err != nil {
-		writeError(conn, err.Error())
+		s.writeError(conn, err.Error())
 		return
 	}
-	writeOK(conn)
+	s.writeOK(conn)
 }
 
 func (s *Server) handleSendKey(conn *protocol.Conn, msg *protocol.SendKey) {
 	sess, ok := s.liveSession(msg.Name)
 	if !ok {
-		writeError(conn, "session not found")
+		s.writeError(conn, "session not found")
 		return
 	}
 
 	data, err := sess.term.encodeClientKey(msg.Key, msg.Mods)
 	if err != nil {
-		writeError(conn, err.Error())
+		s.writeError(conn, err.Error())
 		return
 	}
 
 	if len(data) > 0 {
 		if err := sess.sendInput(data); err != nil {
-			writeError(conn, err.Error())
+			s.writeError(conn, err.Error())
+			return
+		}
+	}
+
+	s.writeOK(conn)
+}
+
+func (s *Server) handleLog(conn *protocol.Conn, msg *protocol.Log) {
+	sess, ok := s.liveSession(msg.Name)
+	if !ok {
+		s.writeError(conn, "session not found")
+		return
+	}
+
+	if !msg.Enable {
+		if err := sess.stopLog(); err != nil {
+			s.writeError(conn, err.Error())
+			return
+		}
+		s.writeOK(conn)
+		return
+	}
+
+	spec := newSessionLogSpec(s.sessionLog, sess.Name, msg.Path, msg.Format)
+	if spec.path == "" {
+		s.writeError(conn, "log path required (or set session_log.dir)")
+		return
+	}
+	if err := sess.startLog(spec); err != nil {
+		s.writeError(conn, err.Error())
+		return
+	}
+	s.writeOK(conn)
+}
+
+func (s *Server) handleRecord(conn *protocol.Conn, msg *protocol.Record) {
+	sess, ok := s.liveSession(msg.Name)
+	if !ok {
+		s.writeError(conn, "session not found")
+		return
+	}
+
+	var err error
+	if msg.Enable {
+		err = sess.startRecording(msg.Path)
+	} else {
+		err = sess.stopRecording()
+	}
+	if err != nil {
+		s.writeError(conn, err.Error())
+		return
+	}
+	s.writeOK(conn)
+}
+
+// handleExec types msg.Command at the shell's prompt and waits for the
+// shell to report it finished, then answers with its exit status and
+// output. The wait ends early if the client hangs up.
+func (s *Server) handleExec(conn *protocol.Conn, netConn net.Conn, msg *protocol.Exec) {
+	if msg.Command == "" || strings.ContainsAny(msg.Command, "\r\n") {
+		s.writeError(conn, "command must be one non-empty line")
+		return
+	}
+	sess, ok := s.liveSession(msg.Name)
+	if !ok {
+		s.writeError(conn, "session not found")
+		return
+	}
+
+	from, err := sess.startExec(msg.Command)
+	if err != nil {
+		s.writeError(conn, fmt.Sprintf("session %q: %v", msg.Name, err))
+		return
+	}
+	defer sess.commands.endExec()
+
+	ctx, stop := watchHangup(s.ctx, netConn)
+	defer stop()
+	if msg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(msg.Timeout)*time.Millisecond)
+		defer cancel()
+	}
+	var res *execResult
+	for {
+		var changed <-chan struct{}
+		res, changed, err = sess.commands.execResult(sess.term, from)
+		if err != nil {
+			s.writeError(conn, fmt.Sprintf("session %q: %v", msg.Name, err))
 			return
 		}
+		if res != nil {
+			break
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// The client hung up or the daemon is stopping.
+				return
+			}
+			if err := conn.WriteMessage(&protocol.ExecResponse{TimedOut: true}); err != nil {
+				s.log.Debug("write exec response", "err", err)
+			}
+			return
+		}
+	}
+
+	var output []byte
+	if res.rows != nil {
+		format := terminalDumpFormat(protocol.DumpPlain | protocol.DumpFlagUnwrap)
+		format.rows = res.rows
+		dump, err := sess.dumpScreen(s.ctx, format)
+		if err != nil {
+			s.writeError(conn, err.Error())
+			return
+		}
+		output = dump.Data
+	}
+	if err := conn.WriteMessage(&protocol.ExecResponse{HasExit: res.hasExit, ExitCode: res.exitCode}); err != nil {
+		s.log.Debug("write exec response", "err", err)
+		return
+	}
+	if err := conn.WriteDump(output); err != nil {
+		s.log.Debug("write exec output", "err", err)
 	}
+}
 
-	writeOK(conn)
+// watchHangup returns a context cancelled when the peer of netConn hangs
+// up, for requests that wait without reading. It peeks, so a request
+// the client sends meanwhile stays unread. stop ends the watch; netConn
+// can be read again once it returns. Connections that are not sockets
+// are not watched.
+func watchHangup(parent context.Context, netConn net.Conn) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(parent)
+	sc, ok := netConn.(syscall.Conn)
+	if !ok {
+		return ctx, cancel
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return ctx, cancel
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var buf [1]byte
+		var n int
+		var peekErr error
+		err := raw.Read(func(fd uintptr) bool {
+			n, _, peekErr = unix.Recvfrom(int(fd), buf[:], unix.MSG_PEEK)
+			return peekErr != unix.EAGAIN
+		})
+		// A read of nothing is end of file.
+		if err == nil && (peekErr != nil || n == 0) {
+			cancel()
+		}
+	}()
+	return ctx, func() {
+		_ = netConn.SetReadDeadline(time.Now())
+		<-done
+		_ = netConn.SetReadDeadline(time.Time{})
+		cancel()
+	}
 }
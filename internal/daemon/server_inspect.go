@@ -1,11 +1,15 @@
 package daemon
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"log/slog"
+	"io"
+	"maps"
 	"math"
+	"net"
 	"os"
+	"slices"
 	"time"
 
 	hauntty "code.selman.me/hauntty"
@@ -36,10 +40,13 @@ func (s *Server) handleList(conn *protocol.Conn, msg *protocol.List) {
 				State:     state,
 				Cols:      cols,
 				Rows:      rows,
-				PID:       sess.PID,
+				PID:       sess.pid(),
 				CreatedAt: uint32(sess.CreatedAt.Unix()),
+				Exit:      sess.protocolExit(),
+				Command:   sess.command,
 			},
 		})
+		_, snapshots[len(snapshots)-1].row.Alerts = sess.monitor.state()
 	}
 	s.mu.RUnlock()
 
@@ -47,7 +54,7 @@ func (s *Server) handleList(conn *protocol.Conn, msg *protocol.List) {
 	for _, snapshot := range snapshots {
 		cwd, err := sessionCWD(ctx, snapshot.sess)
 		if err != nil {
-			slog.Debug("list session cwd", "err", err)
+			s.log.Debug("list session cwd", "err", err)
 		}
 		snapshot.row.CWD = cwd
 		if msg.IncludeClients {
@@ -58,54 +65,237 @@ func (s *Server) handleList(conn *protocol.Conn, msg *protocol.List) {
 
 	dead, err := s.deadSessionRows()
 	if err != nil {
-		writeError(conn, fmt.Errorf("list dead sessions: %w", err).Error())
+		s.writeError(conn, fmt.Errorf("list dead sessions: %w", err).Error())
 		return
 	}
 	sessions = append(sessions, dead...)
 
 	if err := conn.WriteMessage(&protocol.Sessions{Sessions: sessions}); err != nil {
-		slog.Debug("write sessions response", "err", err)
+		s.log.Debug("write sessions response", "err", err)
 	}
 }
 
 func (s *Server) handleDump(conn *protocol.Conn, msg *protocol.Dump) {
 	sess, ok := s.liveSession(msg.Name)
+	if ok && msg.Format&protocol.DumpFlagLastCommand != 0 {
+		s.dumpLastCommand(conn, sess, msg.Format)
+		return
+	}
 	if ok {
-		dump, err := sess.dumpScreen(s.ctx, terminalDumpFormat(msg.Format))
+		s.writeDump(conn, func(w io.Writer) error {
+			return sess.term.writeDump(w, terminalDumpFormat(msg.Format))
+		})
+		return
+	}
+
+	state, exists, err := s.readDeadSession(msg.Name)
+	if err != nil {
+		s.writeError(conn, fmt.Errorf("load dead session state: %w", err).Error())
+		return
+	}
+	if !exists {
+		s.writeError(conn, "session not found")
+		return
+	}
+	if msg.Format&protocol.DumpFlagLastCommand != 0 {
+		s.writeError(conn, fmt.Sprintf("session %q is not running; command history is kept only while it runs", msg.Name))
+		return
+	}
+	s.writeDump(conn, func(w io.Writer) error {
+		return dumpDeadTerminalState(w, state, s.defaultScrollback, msg.Format)
+	})
+}
+
+// writeDump answers a Dump request with what dump writes, sending it as
+// it is written. A dump that fails part way ends with an error.
+func (s *Server) writeDump(conn *protocol.Conn, dump func(io.Writer) error) {
+	w := conn.DumpWriter()
+	if err := dump(w); err != nil {
+		s.writeError(conn, err.Error())
+		return
+	}
+	if err := w.Close(); err != nil {
+		s.log.Debug("write dump response", "err", err)
+	}
+}
+
+// dumpLastCommand dumps the output of the latest command the session's
+// shell reported.
+func (s *Server) dumpLastCommand(conn *protocol.Conn, sess *Session, format protocol.DumpFormat) {
+	rows, err := sess.commands.lastOutput(sess.term)
+	if err != nil {
+		s.writeError(conn, err.Error())
+		return
+	}
+	var data []byte
+	if rows != nil {
+		tf := terminalDumpFormat(format)
+		tf.rows = rows
+		dump, err := sess.dumpScreen(s.ctx, tf)
 		if err != nil {
-			writeError(conn, err.Error())
+			s.writeError(conn, err.Error())
 			return
 		}
-		if err := conn.WriteMessage(&protocol.DumpResponse{Data: dump.Data}); err != nil {
-			slog.Debug("write dump response", "err", err)
+		data = dump.Data
+	}
+	if err := conn.WriteDump(data); err != nil {
+		s.log.Debug("write dump response", "err", err)
+	}
+}
+
+func (s *Server) handleHistory(conn *protocol.Conn, msg *protocol.History) {
+	sess, ok := s.liveSession(msg.Name)
+	if !ok {
+		if _, exists, _ := s.readDeadSession(msg.Name); exists {
+			s.writeError(conn, fmt.Sprintf("session %q is not running; command history is kept only while it runs", msg.Name))
+			return
 		}
+		s.writeError(conn, "session not found")
+		return
+	}
+	commands, atPrompt, err := sess.commands.history(sess.term)
+	if err != nil {
+		s.writeError(conn, err.Error())
 		return
 	}
+	if err := conn.WriteMessage(&protocol.HistoryResponse{Commands: commands, AtPrompt: atPrompt}); err != nil {
+		s.log.Debug("write history response", "err", err)
+	}
+}
 
-	data, exists, err := s.dumpDeadSession(msg.Name, msg.Format)
+func (s *Server) handleWatch(conn *protocol.Conn, netConn net.Conn, msg *protocol.Watch) {
+	w, err := newScreenWatch(msg)
 	if err != nil {
-		writeError(conn, fmt.Errorf("load dead session state: %w", err).Error())
+		s.writeError(conn, err.Error())
 		return
 	}
-	if exists {
-		if err := conn.WriteMessage(&protocol.DumpResponse{Data: data}); err != nil {
-			slog.Debug("write dump response", "err", err)
+
+	var matched bool
+	if sess, ok := s.liveSession(msg.Name); ok {
+		ctx, stop := watchHangup(s.ctx, netConn)
+		defer stop()
+		if msg.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(msg.Timeout)*time.Millisecond)
+			defer cancel()
+		}
+		matched, err = sess.watch(ctx, w)
+		if err != nil {
+			s.writeError(conn, err.Error())
+			return
+		}
+	} else {
+		// Dead sessions never change, so one evaluation is final and
+		// their screen already counts as stable.
+		var data bytes.Buffer
+		exists, err := s.dumpDeadSession(&data, msg.Name, protocol.DumpPlain)
+		if err != nil {
+			s.writeError(conn, fmt.Errorf("load dead session state: %w", err).Error())
+			return
+		}
+		if !exists {
+			s.writeError(conn, "session not found")
+			return
 		}
+		w.stable = 0
+		matched = w.observe(data.String(), false, time.Now())
+	}
+
+	if err := conn.WriteMessage(&protocol.WatchResponse{Matched: matched}); err != nil {
+		s.log.Debug("write watch response", "err", err)
+	}
+}
+
+// maxSearchResponseSize bounds the encoded matches of one search
+// response, well under the protocol's frame size limit.
+const maxSearchResponseSize = 8 << 20
+
+func (s *Server) handleSearch(conn *protocol.Conn, msg *protocol.Search) {
+	match, err := compileScreenMatcher(msg.Pattern, msg.Regex)
+	if err != nil {
+		s.writeError(conn, err.Error())
 		return
 	}
 
-	writeError(conn, "session not found")
+	names := msg.Names
+	if len(names) == 0 {
+		names = slices.Sorted(maps.Keys(s.liveSessionNames()))
+		if msg.Dead {
+			dead, err := s.deadSessionNames()
+			if err != nil {
+				s.writeError(conn, fmt.Errorf("list dead sessions: %w", err).Error())
+				return
+			}
+			slices.Sort(dead)
+			names = append(names, dead...)
+		}
+	}
+
+	resp := &protocol.SearchResponse{}
+	size := 0
+	for _, name := range names {
+		hits, err := s.searchSession(name, match)
+		if err != nil {
+			s.writeError(conn, err.Error())
+			return
+		}
+		if !addSearchHits(resp, &size, name, hits, msg.Limit) {
+			break
+		}
+	}
+
+	if err := conn.WriteMessage(resp); err != nil {
+		s.log.Debug("write search response", "err", err)
+	}
+}
+
+// addSearchHits appends a session's hits to resp while it stays within
+// limit and maxSearchResponseSize, size counting the bytes added so
+// far. Once one does not fit it marks resp truncated and reports false.
+func addSearchHits(resp *protocol.SearchResponse, size *int, name string, hits []searchHit, limit uint32) bool {
+	for _, hit := range hits {
+		// Session and text are each length-prefixed, next to the line
+		// number.
+		*size += 2 + len(name) + 4 + 2 + len(hit.text)
+		if *size > maxSearchResponseSize || (limit > 0 && len(resp.Matches) == int(limit)) {
+			resp.Truncated = true
+			return false
+		}
+		resp.Matches = append(resp.Matches, protocol.SearchMatch{Session: name, Line: hit.line, Text: hit.text})
+	}
+	return true
+}
+
+// searchSession searches a live session, falling back to the saved state
+// of a dead one.
+func (s *Server) searchSession(name string, match func(string) bool) ([]searchHit, error) {
+	if sess, ok := s.liveSession(name); ok {
+		hits, err := sess.term.search(match)
+		if err != nil {
+			return nil, fmt.Errorf("search session %q: %w", name, err)
+		}
+		return hits, nil
+	}
+
+	state, exists, err := s.readDeadSession(name)
+	if err != nil {
+		return nil, fmt.Errorf("load dead session state %q: %w", name, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("session not found: %s", name)
+	}
+	return searchDeadTerminalState(state, s.defaultScrollback, match)
 }
 
 func (s *Server) handlePrune(conn *protocol.Conn) {
 	count, err := s.pruneDeadSessions()
 	if err != nil {
-		writeError(conn, err.Error())
+		s.writeError(conn, err.Error())
 		return
 	}
 
 	if err := conn.WriteMessage(&protocol.PruneResponse{Count: count}); err != nil {
-		slog.Debug("write prune response", "err", err)
+		s.log.Debug("write prune response", "err", err)
 	}
 }
 
@@ -114,7 +304,7 @@ func (s *Server) handleStatus(conn *protocol.Conn, msg *protocol.Status) {
 
 	dead, err := s.deadSessionNames()
 	if err != nil {
-		writeError(conn, fmt.Errorf("list dead sessions: %w", err).Error())
+		s.writeError(conn, fmt.Errorf("list dead sessions: %w", err).Error())
 		return
 	}
 	deadCount += uint32(len(dead))
@@ -134,7 +324,7 @@ func (s *Server) handleStatus(conn *protocol.Conn, msg *protocol.Status) {
 		Session: ss,
 	}
 	if err := conn.WriteMessage(resp); err != nil {
-		slog.Debug("write status response", "err", err)
+		s.log.Debug("write status response", "err", err)
 	}
 }
 
@@ -160,7 +350,10 @@ func (s *Server) statusSnapshot(sessionName string) (uint32, uint32, *protocol.S
 	s.mu.RUnlock()
 
 	if sess == nil {
-		return runningCount, deadCount, nil
+		if sessionName == "" {
+			return runningCount, deadCount, nil
+		}
+		return runningCount, deadCount, s.deadSessionStatus(sessionName)
 	}
 
 	state := protocol.SessionStateRunning
@@ -171,20 +364,51 @@ func (s *Server) statusSnapshot(sessionName string) (uint32, uint32, *protocol.S
 	cols, rows := sess.size()
 	cwd, err := sessionCWD(ctx, sess)
 	if err != nil {
-		slog.Debug("status session cwd", "err", err)
+		s.log.Debug("status session cwd", "err", err)
 	}
+	monitor, alerts := sess.monitor.state()
 	ss := &protocol.SessionStatus{
-		Name:    sess.Name,
-		State:   state,
-		Cols:    cols,
-		Rows:    rows,
-		PID:     sess.PID,
-		CWD:     cwd,
-		Clients: sess.clientInfo(),
+		Name:      sess.Name,
+		State:     state,
+		Cols:      cols,
+		Rows:      rows,
+		PID:       sess.pid(),
+		CWD:       cwd,
+		Clients:   sess.clientInfo(),
+		Exit:      sess.protocolExit(),
+		Command:   sess.command,
+		Restart:   sess.restart.mode,
+		Restarts:  sess.restarts.Load(),
+		SlowKicks: sess.slowKicks.Load(),
+		Monitor:   monitor,
+		Alerts:    alerts,
 	}
 	return runningCount, deadCount, ss
 }
 
+// deadSessionStatus describes a dead session from its saved state, or
+// returns nil when there is none.
+func (s *Server) deadSessionStatus(name string) *protocol.SessionStatus {
+	state, exists, err := s.readDeadSession(name)
+	if err != nil {
+		s.log.Debug("status dead session", "session", name, "err", err)
+		return nil
+	}
+	if !exists {
+		return nil
+	}
+	return &protocol.SessionStatus{
+		Name:    name,
+		State:   protocol.SessionStateDead,
+		Cols:    state.Cols,
+		Rows:    state.Rows,
+		CWD:     state.CWD,
+		Clients: []protocol.SessionClient{},
+		Exit:    state.protocolExit(),
+		Command: state.Command,
+	}
+}
+
 func sessionCWD(ctx context.Context, sess *Session) (string, error) {
 	cwd, ok, err := sess.term.cwd()
 	if err != nil {
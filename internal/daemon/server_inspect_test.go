@@ -2,9 +2,11 @@ package daemon
 
 import (
 	"bytes"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -31,9 +33,10 @@ func TestHandleListIncludesLiveAndDeadSessions(t *testing.T) {
 	assert.NilError(t, err)
 
 	srv := &Server{
+		log:       slog.Default(),
 		ctx:       t.Context(),
 		sessions:  map[string]*Session{"live": live},
-		persister: &persister{},
+		persister: &persister{dir: defaultStateDir()},
 	}
 
 	var out bytes.Buffer
@@ -60,17 +63,19 @@ func TestHandleListIncludesLiveAndDeadSessions(t *testing.T) {
 			SavedAt:   1700000100,
 			CWD:       "",
 			Clients:   []protocol.SessionClient{},
+			Command:   []string{},
 		},
 		{
 			Name:      "live",
 			State:     protocol.SessionStateRunning,
 			Cols:      80,
 			Rows:      24,
-			PID:       live.PID,
+			PID:       live.pid(),
 			CreatedAt: uint32(live.CreatedAt.Unix()),
 			SavedAt:   0,
 			CWD:       "",
-			Clients:   []protocol.SessionClient{{ClientID: "1", ReadOnly: true, Version: "client-v1"}},
+			Clients:   []protocol.SessionClient{{ClientID: "1", ReadOnly: true, Version: "client-v1", Features: []protocol.Capability{}}},
+			Command:   []string{},
 		},
 	}})
 }
@@ -86,7 +91,8 @@ func TestHandleDumpReturnsDeadSession(t *testing.T) {
 	assert.NilError(t, err)
 	defer srv.Shutdown()
 
-	want, exists, err := srv.dumpDeadSession("dead", protocol.DumpPlain)
+	var want bytes.Buffer
+	exists, err := srv.dumpDeadSession(&want, "dead", protocol.DumpPlain)
 	assert.NilError(t, err)
 	assert.Equal(t, exists, true)
 
@@ -94,7 +100,7 @@ func TestHandleDumpReturnsDeadSession(t *testing.T) {
 	srv.handleDump(protocol.NewConn(&out), &protocol.Dump{Name: "dead", Format: protocol.DumpPlain})
 
 	got := readServerMessage(t, &out).(*protocol.DumpResponse)
-	assert.DeepEqual(t, got, &protocol.DumpResponse{Data: want})
+	assert.DeepEqual(t, got, &protocol.DumpResponse{Data: want.Bytes()})
 }
 
 func TestHandleStatusCountsDeadSessionsAndReturnsSession(t *testing.T) {
@@ -105,9 +111,10 @@ func TestHandleStatusCountsDeadSessionsAndReturnsSession(t *testing.T) {
 	live.Name = "live"
 
 	srv := &Server{
+		log:        slog.Default(),
 		ctx:        t.Context(),
 		sessions:   map[string]*Session{"live": live},
-		persister:  &persister{},
+		persister:  &persister{dir: defaultStateDir()},
 		socketPath: "/tmp/hauntty.sock",
 		startedAt:  time.Now(),
 	}
@@ -130,13 +137,49 @@ func TestHandleStatusCountsDeadSessionsAndReturnsSession(t *testing.T) {
 			State:   protocol.SessionStateRunning,
 			Cols:    80,
 			Rows:    24,
-			PID:     live.PID,
+			PID:     live.pid(),
 			CWD:     "",
 			Clients: []protocol.SessionClient{},
+			Command: []string{},
 		},
 	})
 }
 
+func TestHandleStatusReturnsDeadSessionExit(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	state := snapshotSessionState(t, 90, 30, time.Unix(1700000300, 0), []byte("saved"))
+	state.Exited = true
+	state.ExitCode = 3
+	state.ExitedAt = time.Unix(1700000250, 0)
+	state.CWD = "/srv/app"
+	state.Command = []string{"make", "test"}
+	writeDeadSessionState(t, "dead", state)
+
+	srv := &Server{
+		log:        slog.Default(),
+		ctx:        t.Context(),
+		sessions:   map[string]*Session{},
+		persister:  &persister{dir: defaultStateDir()},
+		socketPath: "/tmp/hauntty.sock",
+		startedAt:  time.Now(),
+	}
+
+	var out bytes.Buffer
+	srv.handleStatus(protocol.NewConn(&out), &protocol.Status{Name: "dead"})
+
+	got := readServerMessage(t, &out).(*protocol.StatusResponse)
+	assert.DeepEqual(t, got.Session, &protocol.SessionStatus{
+		Name:    "dead",
+		State:   protocol.SessionStateDead,
+		Cols:    90,
+		Rows:    30,
+		CWD:     "/srv/app",
+		Clients: []protocol.SessionClient{},
+		Exit:    protocol.SessionExit{Exited: true, Code: 3, At: 1700000250},
+		Command: []string{"make", "test"},
+	})
+}
+
 func TestDumpFormatMapping(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -154,3 +197,65 @@ func TestDumpFormatMapping(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleSearchLiveAndDeadSessions(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	writeDeadSessionState(t, "dead", snapshotSessionState(t, 80, 24, time.Unix(1700000300, 0), []byte("ok\r\nerror: saved\r\n")))
+
+	live := newSessionLoopHarness(t)
+	live.Name = "live"
+	live.ptyOut <- []byte("build\r\nerror: live\r\ndone\r\n")
+	waitSessionOutput(t, live, "done")
+
+	srv := &Server{
+		log:       slog.Default(),
+		ctx:       t.Context(),
+		sessions:  map[string]*Session{"live": live},
+		persister: &persister{dir: defaultStateDir()},
+	}
+
+	var out bytes.Buffer
+	srv.handleSearch(protocol.NewConn(&out), &protocol.Search{Pattern: "error"})
+	got := readServerMessage(t, &out).(*protocol.SearchResponse)
+	assert.DeepEqual(t, got, &protocol.SearchResponse{Matches: []protocol.SearchMatch{
+		{Session: "live", Line: 2, Text: "error: live"},
+	}})
+
+	out.Reset()
+	srv.handleSearch(protocol.NewConn(&out), &protocol.Search{Pattern: "^error", Regex: true, Dead: true})
+	got = readServerMessage(t, &out).(*protocol.SearchResponse)
+	assert.DeepEqual(t, got, &protocol.SearchResponse{Matches: []protocol.SearchMatch{
+		{Session: "live", Line: 2, Text: "error: live"},
+		{Session: "dead", Line: 2, Text: "error: saved"},
+	}})
+
+	out.Reset()
+	srv.handleSearch(protocol.NewConn(&out), &protocol.Search{Pattern: "error", Dead: true, Limit: 1})
+	got = readServerMessage(t, &out).(*protocol.SearchResponse)
+	assert.DeepEqual(t, got, &protocol.SearchResponse{Matches: []protocol.SearchMatch{
+		{Session: "live", Line: 2, Text: "error: live"},
+	}, Truncated: true})
+
+	out.Reset()
+	srv.handleSearch(protocol.NewConn(&out), &protocol.Search{Pattern: "error", Names: []string{"missing"}})
+	errMsg := readServerMessage(t, &out).(*protocol.Error)
+	assert.Equal(t, errMsg.Message, "session not found: missing")
+}
+
+func TestAddSearchHitsStaysUnderTheFrameLimit(t *testing.T) {
+	// 300 lines of 60KB match more than the protocol's 16MB frame.
+	hits := make([]searchHit, 300)
+	for i := range hits {
+		hits[i] = searchHit{line: uint32(i + 1), text: strings.Repeat("x", 60<<10)}
+	}
+	resp := &protocol.SearchResponse{}
+	size := 0
+	assert.Equal(t, addSearchHits(resp, &size, "big", hits, 0), false)
+	assert.Equal(t, resp.Truncated, true)
+	assert.Assert(t, len(resp.Matches) > 0 && len(resp.Matches) < len(hits))
+
+	var out bytes.Buffer
+	assert.NilError(t, protocol.NewConn(&out).WriteMessage(resp))
+	got := readServerMessage(t, &out).(*protocol.SearchResponse)
+	assert.Equal(t, len(got.Matches), len(resp.Matches))
+}
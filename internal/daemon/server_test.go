@@ -1,12 +1,18 @@
 package daemon
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
 	"gotest.tools/v3/assert"
 )
 
@@ -26,7 +32,7 @@ func TestReserveSessionNameIncludesDeadSessions(t *testing.T) {
 	assert.NilError(t, os.MkdirAll(sessionDir, 0o700))
 	assert.NilError(t, os.WriteFile(filepath.Join(sessionDir, "alpha-beta.state"), []byte("saved"), 0o600))
 
-	srv := &Server{sessions: make(map[string]*Session), persister: &persister{}}
+	srv := &Server{log: slog.Default(), sessions: make(map[string]*Session), persister: &persister{dir: defaultStateDir()}}
 	name, err := srv.reserveSessionName("")
 	assert.NilError(t, err)
 	assert.Equal(t, name, "alpha-gamma")
@@ -54,6 +60,71 @@ func TestShutdownBeforeListenPreservesExistingSocket(t *testing.T) {
 	assert.NilError(t, conn.Close())
 }
 
+func TestServeFailingToListenStopsHooks(t *testing.T) {
+	dir, err := os.MkdirTemp("/tmp", "ht-serve-")
+	assert.NilError(t, err)
+	t.Cleanup(func() { assert.NilError(t, os.RemoveAll(dir)) })
+
+	running := &Server{lockPath: filepath.Join(dir, "hauntty.lock")}
+	assert.NilError(t, running.acquireLock())
+	t.Cleanup(running.releaseLock)
+
+	out := filepath.Join(dir, "shutdown")
+	cfg := config.Default()
+	cfg.Daemon.SocketPath = filepath.Join(dir, "hauntty.sock")
+	cfg.Daemon.Hooks.OnShutdown = "echo ran > " + out
+	srv, err := New(t.Context(), &cfg.Daemon, cfg.Session.ResizePolicy, WithSignals())
+	assert.NilError(t, err)
+
+	err = srv.Serve(t.Context())
+	assert.Error(t, err, "daemon already running")
+	assert.Assert(t, srv.ctx.Err() != nil)
+	<-srv.hooks.done
+	assert.Equal(t, len(srv.events.subs), 0)
+
+	srv.Shutdown()
+	_, err = os.Stat(out)
+	assert.Assert(t, os.IsNotExist(err), "shutdown hook ran for a daemon that never served")
+}
+
+func TestServeShutsDownWhenListenerCloses(t *testing.T) {
+	ln := &failingListener{errs: []error{errors.New("too many open files"), errors.New("too many open files")}}
+	cfg := config.Default()
+	cfg.Daemon.StatePersistence = false
+	srv, err := New(t.Context(), &cfg.Daemon, cfg.Session.ResizePolicy,
+		WithListener(ln), WithLogger(slog.New(slog.DiscardHandler)), WithSignals())
+	assert.NilError(t, err)
+
+	start := time.Now()
+	err = srv.Serve(t.Context())
+	assert.Assert(t, errors.Is(err, net.ErrClosed), err)
+	assert.Assert(t, srv.ctx.Err() != nil)
+	// Two failed accepts back off before the listener reports closed.
+	assert.Assert(t, time.Since(start) >= 3*acceptMinDelay)
+	assert.Equal(t, ln.accepts, 3)
+}
+
+// failingListener fails Accept with errs, then as a closed listener.
+type failingListener struct {
+	net.Listener
+	errs    []error
+	accepts int
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts++
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		return nil, err
+	}
+	return nil, net.ErrClosed
+}
+
+func (l *failingListener) Close() error { return nil }
+
+func (l *failingListener) Addr() net.Addr { return &net.UnixAddr{Name: "failing", Net: "unix"} }
+
 func TestAcquireLockPreventsSecondDaemon(t *testing.T) {
 	dir := t.TempDir()
 	lockPath := filepath.Join(dir, "hauntty.lock")
@@ -79,3 +150,114 @@ func TestReleaseLockAllowsNextDaemon(t *testing.T) {
 	assert.NilError(t, s2.acquireLock())
 	t.Cleanup(s2.releaseLock)
 }
+
+func serveTestConn(t *testing.T, srv *Server) net.Conn {
+	t.Helper()
+	conn, serverConn := unixConnPair(t)
+	go srv.handleConn(serverConn)
+	return conn
+}
+
+func TestHandleConnServesLegacyClientInCompatMode(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	srv := &Server{log: slog.Default(), sessions: make(map[string]*Session), ctx: t.Context()}
+	conn := protocol.NewConn(serveTestConn(t, srv))
+
+	accepted, _, err := conn.Handshake(protocol.Hello{MinVersion: 8, MaxVersion: 8, Revision: "old"})
+	assert.NilError(t, err)
+	assert.Equal(t, accepted, uint8(8))
+	assert.Assert(t, !conn.Has(protocol.CapWatch))
+
+	assert.NilError(t, conn.WriteMessage(&protocol.List{}))
+	msg, err := conn.ReadMessage()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, msg, &protocol.Sessions{Sessions: []protocol.Session{}})
+}
+
+func TestHandleConnRejectsUnsupportedVersion(t *testing.T) {
+	srv := &Server{log: slog.Default(), sessions: make(map[string]*Session), ctx: t.Context()}
+	conn := protocol.NewConn(serveTestConn(t, srv))
+
+	accepted, _, err := conn.Handshake(protocol.Hello{MinVersion: 7, MaxVersion: 7})
+	assert.NilError(t, err)
+	assert.Equal(t, accepted, uint8(0))
+}
+
+func TestHandleConnGatesMessagesOnCapabilities(t *testing.T) {
+	srv := &Server{log: slog.Default(), sessions: make(map[string]*Session), ctx: t.Context()}
+	nc := serveTestConn(t, srv)
+
+	hello := protocol.NewHello("new")
+	hello.Capabilities = []protocol.Capability{protocol.CapLog}
+	accepted, _, err := protocol.NewConn(nc).Handshake(hello)
+	assert.NilError(t, err)
+	assert.Equal(t, accepted, protocol.ProtocolVersion)
+
+	// A connection that skipped the handshake bookkeeping can still put
+	// a gated message on the wire; the daemon must refuse it.
+	raw := protocol.NewConn(nc)
+	assert.NilError(t, raw.WriteMessage(&protocol.Search{Pattern: "x"}))
+	msg, err := raw.ReadMessage()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, msg, &protocol.Error{Message: `capability "search" not negotiated`})
+}
+
+func unixConnPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	dir, err := os.MkdirTemp("/tmp", "ht-conn-")
+	assert.NilError(t, err)
+	t.Cleanup(func() { assert.NilError(t, os.RemoveAll(dir)) })
+
+	ln, err := net.Listen("unix", filepath.Join(dir, "hauntty.sock"))
+	assert.NilError(t, err)
+	defer ln.Close()
+
+	client, err = net.Dial("unix", ln.Addr().String())
+	assert.NilError(t, err)
+	t.Cleanup(func() { client.Close() })
+	server, err = ln.Accept()
+	assert.NilError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+func TestWatchHangupCancelsOnClose(t *testing.T) {
+	client, server := unixConnPair(t)
+	ctx, stop := watchHangup(t.Context(), server)
+	defer stop()
+
+	assert.NilError(t, client.Close())
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("hangup not noticed")
+	}
+}
+
+func TestWatchHangupLeavesRequestsUnread(t *testing.T) {
+	client, server := unixConnPair(t)
+	ctx, stop := watchHangup(t.Context(), server)
+
+	_, err := client.Write([]byte("next"))
+	assert.NilError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.NilError(t, ctx.Err())
+	stop()
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(server, buf)
+	assert.NilError(t, err)
+	assert.Equal(t, string(buf), "next")
+}
+
+func TestWatchHangupStopsWhileIdle(t *testing.T) {
+	_, server := unixConnPair(t)
+	ctx, stop := watchHangup(t.Context(), server)
+	stop()
+	assert.Equal(t, ctx.Err(), context.Canceled)
+
+	// The connection reads normally again.
+	assert.NilError(t, server.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err := server.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
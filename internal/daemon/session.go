@@ -2,8 +2,8 @@ package daemon
 
 import (
 	"context"
+	"log/slog"
 	"os"
-	"os/exec"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,6 +23,9 @@ var feedPool = sync.Pool{
 type feedItem struct {
 	data    *[]byte
 	applied chan struct{}
+	// answer has the daemon reply to terminal queries in data: no
+	// writable client received it.
+	answer bool
 }
 
 type termSize struct {
@@ -43,11 +46,18 @@ type sessionClient struct {
 	closeConn func() error
 	size      termSize
 	version   string
+	pid       uint32
 	readOnly  bool
 	outCh     chan protocol.Message
 	ready     chan<- struct{}
 	writeDone chan struct{}
 	final     protocol.Message
+
+	// behind is set once outCh overflowed and cleared when the client
+	// has been sent a fresh screen; output in between is dropped.
+	behind  bool
+	resyncs uint32
+	dropped uint64
 }
 
 type sessionAction interface {
@@ -59,6 +69,7 @@ type sessionAttachSpec struct {
 	closeConn func() error
 	size      termSize
 	version   string
+	pid       uint32
 	readOnly  bool
 	created   bool
 }
@@ -107,29 +118,71 @@ func (stopReq) isSessionAction() {}
 
 type Session struct {
 	Name      string
-	PID       uint32
 	CreatedAt time.Time
 
-	ptmx     *os.File
-	cmd      *exec.Cmd
+	// proc is the running command; the run loop replaces it when the
+	// session restarts.
+	proc     atomic.Pointer[sessionProcess]
 	term     *terminalState
 	feedCh   chan feedItem
 	feedDone chan struct{}
-	ptyDone  chan struct{}
-	tempDir  string
+	fed      chan struct{}
 
-	actions     chan sessionAction
+	actions chan sessionAction
+	// ptyOut carries the first process's output. Restarts hand the run
+	// loop a fresh channel.
 	ptyOut      chan []byte
 	clientReady chan struct{}
 	done        chan struct{}
-	exitCode    int32
+
+	// command, launchCWD and env are the launch parameters as
+	// requested, kept so a dead session can be rerun.
+	command   []string
+	launchCWD string
+	env       []string
+
+	restart    restartPolicy
+	restarts   atomic.Uint32
+	scrollback uint32
+	// killed stops the restart policy once the session is killed.
+	killed atomic.Bool
+
+	events *eventHub
+	// daemonLog receives the session's diagnostics; logger records its
+	// output.
+	daemonLog *slog.Logger
 
 	// sizeVal packs cols|rows as (cols<<16)|rows for lock-free reads.
 	sizeVal atomic.Uint32
 
+	// logger and recorder are owned by the run loop; they are read
+	// elsewhere only after done closes.
+	logger   *sessionLogger
+	recorder *sessionRecorder
+
 	resizePolicy  config.ResizePolicy
+	slowClient    config.SlowClientPolicy
+	slowKicks     atomic.Uint32
+	monitor       *sessionMonitor
+	commands      *commandTracker
 	clientWriters sync.WaitGroup
 	ctx           context.Context
+
+	// output is owned by feedLoop.
+	output outputScanner
+	// replies queues query replies for replyLoop, so feedLoop never
+	// blocks on a process that stops reading its input. A full queue
+	// drops replies.
+	replies        chan []byte
+	repliesDropped atomic.Uint64
+}
+
+func (s *Session) process() *sessionProcess {
+	return s.proc.Load()
+}
+
+func (s *Session) pid() uint32 {
+	return s.process().pid
 }
 
 func (s *Session) size() (uint16, uint16) {
@@ -141,6 +194,33 @@ func (s *Session) setSize(cols, rows uint16) {
 	s.sizeVal.Store(uint32(cols)<<16 | uint32(rows))
 }
 
+type sessionExit struct {
+	code int32
+	// signal names the signal that killed the process, if any.
+	signal string
+	at     time.Time
+}
+
+// exitStatus reports how the current process exited once it has been
+// reaped.
+func (s *Session) exitStatus() (sessionExit, bool) {
+	p := s.process()
+	select {
+	case <-p.done:
+		return p.exit, true
+	default:
+		return sessionExit{}, false
+	}
+}
+
+func (s *Session) protocolExit() protocol.SessionExit {
+	exit, ok := s.exitStatus()
+	if !ok {
+		return protocol.SessionExit{}
+	}
+	return protocol.SessionExit{Exited: true, Code: exit.code, Signal: exit.signal, At: uint32(exit.at.Unix())}
+}
+
 func (s *Session) isRunning() bool {
 	select {
 	case <-s.done:
@@ -172,10 +252,26 @@ type sessionStartSpec struct {
 	cwd        string
 	size       termSize
 	scrollback uint32
+	log        sessionLogSpec
+	record     sessionRecordSpec
+	restart    restartPolicy
+	events     *eventHub
+	daemonLog  *slog.Logger
+	slowClient config.SlowClientPolicy
+	monitor    protocol.MonitorSettings
 }
 
-type sessionLaunch struct {
-	ptmx    *os.File
-	cmd     *exec.Cmd
-	tempDir string
+// sessionProcess is one run of a session's command.
+type sessionProcess struct {
+	pid       uint32
+	ptmx      *os.File
+	process   *os.Process
+	tempDir   string
+	startedAt time.Time
+	// done closes once the process has been reaped; exit is written
+	// before and read only after.
+	done chan struct{}
+	exit sessionExit
+	// paused is set while readPTY is stopped for a daemon upgrade.
+	paused atomic.Bool
 }
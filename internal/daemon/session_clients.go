@@ -3,13 +3,11 @@ package daemon
 import (
 	"context"
 	"fmt"
-	"log/slog"
 	"math"
 	"syscall"
 
 	"code.selman.me/hauntty/internal/config"
 	"code.selman.me/hauntty/internal/protocol"
-	"github.com/creack/pty"
 )
 
 const sessionClientOutBufferSize = 256
@@ -28,7 +26,7 @@ func (c *sessionClient) writeLoop() {
 		case c.ready <- struct{}{}:
 		default:
 		}
-		if err := c.conn.WriteMessage(msg); err != nil {
+		if err := c.write(msg); err != nil {
 			_ = c.closeConn()
 			return
 		}
@@ -40,6 +38,13 @@ func (c *sessionClient) writeLoop() {
 	}
 }
 
+func (c *sessionClient) write(msg protocol.Message) error {
+	if m, ok := msg.(*protocol.Attached); ok {
+		return c.conn.WriteAttached(m)
+	}
+	return c.conn.WriteMessage(msg)
+}
+
 func (s *Session) attach(ctx context.Context, spec sessionAttachSpec) (*sessionClient, error) {
 	ch := make(chan attachResp, 1)
 	req := attachReq{spec: spec, result: ch}
@@ -107,13 +112,32 @@ func (s *Session) clientInfo() []protocol.SessionClient {
 func (s *Session) resize(size termSize) {
 	s.setSize(size.cols, size.rows)
 
-	if err := pty.Setsize(s.ptmx, size.winsize()); err != nil {
-		slog.Warn("pty setsize", "session", s.Name, "err", err)
+	p := s.process()
+	if err := setWinsize(p.ptmx, size); err != nil {
+		s.daemonLog.Warn("pty setsize", "session", s.Name, "err", err)
 	}
-	_ = syscall.Kill(-int(s.PID), syscall.SIGWINCH)
+	_ = syscall.Kill(-int(p.pid), syscall.SIGWINCH)
 	if err := s.term.resize(uint32(size.cols), uint32(size.rows)); err != nil {
-		slog.Warn("wasm resize", "session", s.Name, "err", err)
+		s.daemonLog.Warn("wasm resize", "session", s.Name, "err", err)
+	}
+	if s.logger != nil {
+		s.logger.resize(size)
+	}
+	if s.recorder != nil {
+		s.recorder.resize(size)
+	}
+}
+
+// answeringClient reports whether a client's terminal will answer the
+// queries in the output just queued: it must be writable and must not
+// have skipped the output while behind.
+func answeringClient(clients []*sessionClient) bool {
+	for _, c := range clients {
+		if !c.readOnly && !c.behind {
+			return true
+		}
 	}
+	return false
 }
 
 func collectClientSizes(clients []*sessionClient) []termSize {
@@ -128,6 +152,9 @@ func collectClientSizes(clients []*sessionClient) []termSize {
 }
 
 func (s *Session) arbitrateResize(clients []*sessionClient) {
+	if s.resizePolicy == config.ResizePolicyFixed {
+		return
+	}
 	sizes := collectClientSizes(clients)
 	if len(sizes) == 0 {
 		return
@@ -140,6 +167,9 @@ func (s *Session) arbitrateResize(clients []*sessionClient) {
 }
 
 func (s *Session) resizeForPending(clients []*sessionClient, size termSize) {
+	if s.resizePolicy == config.ResizePolicyFixed {
+		return
+	}
 	sizes := append(collectClientSizes(clients), size)
 	target := applyResizePolicy(s.resizePolicy, sizes)
 	curCols, curRows := s.size()
@@ -183,37 +213,163 @@ func removeClient(clients []*sessionClient, target *sessionClient) []*sessionCli
 	return clients
 }
 
+// queueOutput hands msg to every client with room for it and returns
+// the clients whose queue was full. Clients already behind skip msg;
+// they get a fresh screen instead.
 func queueOutput(clients []*sessionClient, msg *protocol.Output) []*sessionClient {
-	var pending []*sessionClient
+	var slow []*sessionClient
 	for _, c := range clients {
 		select {
 		case <-c.writeDone:
 			continue
 		default:
 		}
+		if c.behind {
+			c.dropped += uint64(len(msg.Data))
+			continue
+		}
 		select {
 		case c.outCh <- msg:
 		default:
-			pending = append(pending, c)
+			c.dropped += uint64(len(msg.Data))
+			slow = append(slow, c)
+		}
+	}
+	return slow
+}
+
+// handleSlowClients applies the slow client policy to clients whose
+// queue overflowed and returns the clients still attached.
+func (s *Session) handleSlowClients(clients, slow []*sessionClient) []*sessionClient {
+	if s.slowClient != config.SlowClientKick {
+		for _, c := range slow {
+			c.behind = true
+			dropQueuedOutput(c)
+		}
+		return clients
+	}
+	for _, c := range slow {
+		clients = removeClient(clients, c)
+		close(c.outCh)
+		_ = c.closeConn()
+		s.slowKicks.Add(1)
+		s.daemonLog.Info("kicked slow client", "session", s.Name, "client", c.id)
+		s.events.publish(protocol.Event{Kind: protocol.EventKicked, Session: s.Name, ClientID: c.id})
+	}
+	s.arbitrateResize(clients)
+	notifyClientsChanged(clients, s.size)
+	return clients
+}
+
+// dropQueuedOutput discards the Output messages waiting in c's queue;
+// the resync screen supersedes them. Other messages stay queued in
+// order.
+func dropQueuedOutput(c *sessionClient) {
+	var kept []protocol.Message
+	for range len(c.outCh) {
+		var msg protocol.Message
+		select {
+		case msg = <-c.outCh:
+		default:
 		}
+		if msg == nil {
+			break
+		}
+		if out, ok := msg.(*protocol.Output); ok {
+			c.dropped += uint64(len(out.Data))
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	for _, msg := range kept {
+		c.outCh <- msg
+	}
+}
+
+func anyBehind(clients []*sessionClient) bool {
+	for _, c := range clients {
+		if c.behind {
+			return true
+		}
+	}
+	return false
+}
+
+// resyncDue reports whether a client that fell behind has room for its
+// fresh screen.
+func resyncDue(clients []*sessionClient) bool {
+	for _, c := range clients {
+		if c.behind && len(c.outCh) < cap(c.outCh) {
+			return true
+		}
+	}
+	return false
+}
+
+// resyncPrefix returns the client's terminal to the main screen and
+// clears it so the screen dump that follows draws on a blank screen.
+const resyncPrefix = "\x1b[?1049l\x1b[0m\x1b[H\x1b[2J"
+
+// resyncClients sends every client that fell behind and has room the
+// session's visible screen. The scrollback is left out: the client's
+// terminal still has what it was sent before falling behind. The
+// caller must have fed all accepted PTY output to the terminal.
+func (s *Session) resyncClients(clients []*sessionClient) {
+	var msgs []*protocol.Output
+	for _, c := range clients {
+		if !c.behind || len(c.outCh) == cap(c.outCh) {
+			continue
+		}
+		if msgs == nil {
+			dump, err := s.term.dumpScreen(terminalFormatVTScreen)
+			if err != nil {
+				s.daemonLog.Warn("slow client resync dump", "session", s.Name, "err", err)
+				return
+			}
+			msgs = resyncOutput(dump.Data)
+		}
+		if cap(c.outCh)-len(c.outCh) < len(msgs) {
+			continue
+		}
+		// Only the run loop sends on outCh, so the room checked above
+		// is still there.
+		for _, msg := range msgs {
+			c.outCh <- msg
+		}
+		c.behind = false
+		c.resyncs++
+	}
+}
+
+// resyncOutput splits a resync screen into Output messages of at most
+// protocol.DumpChunkSize bytes, so none goes over the frame size limit.
+func resyncOutput(dump []byte) []*protocol.Output {
+	data := append([]byte(resyncPrefix), dump...)
+	var msgs []*protocol.Output
+	for len(data) > 0 {
+		n := min(len(data), protocol.DumpChunkSize)
+		msgs = append(msgs, &protocol.Output{Data: data[:n]})
+		data = data[n:]
 	}
-	return pending
+	return msgs
 }
 
-func pruneFinishedClients(clients, pending []*sessionClient) ([]*sessionClient, []*sessionClient, bool) {
+// pruneFinishedClients drops clients whose connection went away; they
+// count as detached.
+func (s *Session) pruneFinishedClients(clients []*sessionClient) ([]*sessionClient, bool) {
 	kept := clients[:0]
 	changed := false
 	for _, c := range clients {
 		select {
 		case <-c.writeDone:
-			pending = removeClient(pending, c)
 			close(c.outCh)
 			changed = true
+			s.events.publish(protocol.Event{Kind: protocol.EventDetached, Session: s.Name, ClientID: c.id})
 		default:
 			kept = append(kept, c)
 		}
 	}
-	return kept, pending, changed
+	return kept, changed
 }
 
 func notifyClientsChanged(clients []*sessionClient, sizeFn func() (uint16, uint16)) {
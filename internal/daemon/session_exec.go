@@ -0,0 +1,44 @@
+package daemon
+
+import "fmt"
+
+// execReq has the run loop record where exec's command starts.
+type execReq struct {
+	result chan<- execStart
+}
+
+func (execReq) isSessionAction() {}
+
+type execStart struct {
+	from execMark
+	err  error
+}
+
+// startExec types command at the shell's prompt. The run loop checks
+// the prompt against every PTY chunk accepted so far and records where
+// the command starts; the command is then written from the caller's
+// goroutine, so a process that leaves its input unread stalls only this
+// exec, not the session. Only one exec runs at a time; on success the
+// caller must call s.commands.endExec once it stops waiting.
+func (s *Session) startExec(command string) (execMark, error) {
+	ch := make(chan execStart, 1)
+	select {
+	case s.actions <- execReq{result: ch}:
+	case <-s.done:
+		return execMark{}, fmt.Errorf("session closed")
+	}
+	var res execStart
+	select {
+	case res = <-ch:
+	case <-s.done:
+		return execMark{}, fmt.Errorf("session closed")
+	}
+	if res.err != nil {
+		return execMark{}, res.err
+	}
+	if err := s.sendInput([]byte(command + "\r")); err != nil {
+		s.commands.endExec()
+		return execMark{}, err
+	}
+	return res.from, nil
+}
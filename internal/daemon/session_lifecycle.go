@@ -1,7 +1,9 @@
 package daemon
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -12,15 +14,16 @@ import (
 	"code.selman.me/hauntty/internal/config"
 	"code.selman.me/hauntty/internal/protocol"
 	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
 )
 
-func launchSessionProcess(spec sessionStartSpec) (*sessionLaunch, error) {
+func launchSessionProcess(spec sessionStartSpec) (*sessionProcess, error) {
 	env := mergeEnv(os.Environ(), spec.env)
 	command := resolveShellCommand(spec.command, env)
 
 	shellArgs, shellEnv, tempDir, err := prepareShellLaunch(command, env, spec.name)
 	if err != nil {
-		slog.Warn("shell integration setup failed, continuing without it", "err", err)
+		spec.daemonLog.Warn("shell integration setup failed, continuing without it", "err", err)
 		shellArgs = command
 		shellEnv = env
 	}
@@ -38,53 +41,95 @@ func launchSessionProcess(spec sessionStartSpec) (*sessionLaunch, error) {
 		}
 		return nil, err
 	}
+	if err := setNonblock(ptmx); err != nil {
+		spec.daemonLog.Warn("pty nonblocking mode", "session", spec.name, "err", err)
+	}
 
-	return &sessionLaunch{ptmx: ptmx, cmd: cmd, tempDir: tempDir}, nil
+	return &sessionProcess{
+		pid:       uint32(cmd.Process.Pid),
+		ptmx:      ptmx,
+		process:   cmd.Process,
+		tempDir:   tempDir,
+		startedAt: time.Now(),
+		done:      make(chan struct{}),
+	}, nil
 }
 
-func startSession(ctx context.Context, launch *sessionLaunch, term *terminalState, resizePolicy config.ResizePolicy, spec sessionStartSpec) *Session {
+func startSession(ctx context.Context, proc *sessionProcess, term *terminalState, logger *sessionLogger, recorder *sessionRecorder, resizePolicy config.ResizePolicy, spec sessionStartSpec) *Session {
 	s := &Session{
 		Name:         spec.name,
-		PID:          uint32(launch.cmd.Process.Pid),
 		CreatedAt:    time.Now(),
-		ptmx:         launch.ptmx,
-		cmd:          launch.cmd,
 		term:         term,
 		feedCh:       make(chan feedItem, 64),
 		feedDone:     make(chan struct{}),
-		ptyDone:      make(chan struct{}),
-		tempDir:      launch.tempDir,
+		fed:          make(chan struct{}, 1),
 		actions:      make(chan sessionAction, 16),
 		ptyOut:       make(chan []byte, 64),
 		clientReady:  make(chan struct{}, 1),
 		done:         make(chan struct{}),
+		logger:       logger,
+		recorder:     recorder,
+		command:      spec.command,
+		launchCWD:    spec.cwd,
+		env:          spec.env,
+		restart:      spec.restart,
+		scrollback:   spec.scrollback,
+		events:       spec.events,
+		daemonLog:    spec.daemonLog,
 		resizePolicy: resizePolicy,
+		slowClient:   spec.slowClient,
+		monitor:      newSessionMonitor(spec.name, spec.events),
+		commands:     newCommandTracker(),
 		ctx:          ctx,
+		replies:      make(chan []byte, queryReplyBufferSize),
 	}
+	s.proc.Store(proc)
 	s.setSize(spec.size.cols, spec.size.rows)
+	if err := s.monitor.set(spec.monitor); err != nil {
+		// The config pattern was checked at load; start unmonitored.
+		s.daemonLog.Warn("session monitor", "session", s.Name, "err", err)
+	}
+	if logger != nil {
+		// Restored sessions start from a non-empty screen.
+		if dump, err := term.dumpScreen(terminalFormatVTFull); err == nil {
+			logger.seed(dump.Data)
+		}
+	}
 
 	go s.feedLoop(ctx)
-	go s.ptyRead()
+	go s.replyLoop()
+	go s.ptyRead(proc, s.ptyOut)
 	go s.run()
 	return s
 }
 
 func newSession(ctx context.Context, resizePolicy config.ResizePolicy, spec sessionStartSpec) (*Session, error) {
+	spec.daemonLog = cmp.Or(spec.daemonLog, slog.Default())
 	term, err := newTerminalState(uint32(spec.size.cols), uint32(spec.size.rows), spec.scrollback)
 	if err != nil {
 		return nil, err
 	}
 
-	launch, err := launchSessionProcess(spec)
+	logger, err := openSessionLog(spec.log, spec.size, spec.daemonLog)
+	if err != nil {
+		term.close()
+		return nil, err
+	}
+
+	proc, err := launchSessionProcess(spec)
 	if err != nil {
+		if logger != nil {
+			logger.finish()
+		}
 		term.close()
 		return nil, err
 	}
 
-	return startSession(ctx, launch, term, resizePolicy, spec), nil
+	return startSession(ctx, proc, term, logger, nil, resizePolicy, spec), nil
 }
 
 func restoreSession(ctx context.Context, state *sessionState, resizePolicy config.ResizePolicy, spec sessionStartSpec) (*Session, error) {
+	spec.daemonLog = cmp.Or(spec.daemonLog, slog.Default())
 	term, err := restoreTerminalState(state, spec.size, spec.scrollback)
 	if err != nil {
 		return nil, err
@@ -97,22 +142,62 @@ func restoreSession(ctx context.Context, state *sessionState, resizePolicy confi
 		}
 	}()
 
-	launch, err := launchSessionProcess(spec)
+	logger, err := openSessionLog(spec.log, spec.size, spec.daemonLog)
 	if err != nil {
 		return nil, err
 	}
 
+	proc, err := launchSessionProcess(spec)
+	if err != nil {
+		if logger != nil {
+			logger.finish()
+		}
+		return nil, err
+	}
+
 	cleanup = false
-	return startSession(ctx, launch, term, resizePolicy, spec), nil
+	return startSession(ctx, proc, term, logger, nil, resizePolicy, spec), nil
+}
+
+// adoptSession resumes a session a daemon handed over on upgrade: proc
+// keeps running and the terminal picks up from the handed-over screen.
+func adoptSession(ctx context.Context, state *sessionState, proc *sessionProcess, resizePolicy config.ResizePolicy, spec sessionStartSpec) (*Session, error) {
+	spec.daemonLog = cmp.Or(spec.daemonLog, slog.Default())
+	term, err := adoptTerminalState(state, spec.size, spec.scrollback)
+	if err != nil {
+		return nil, err
+	}
+	logger, err := openSessionLog(spec.log, spec.size, spec.daemonLog)
+	if err != nil {
+		spec.daemonLog.Warn("reopen session log", "session", spec.name, "err", err)
+	}
+	var recorder *sessionRecorder
+	if spec.record.path != "" {
+		if recorder, err = resumeSessionRecorder(spec.record.path, spec.record.start, spec.daemonLog); err != nil {
+			spec.daemonLog.Warn("reopen session recording", "session", spec.name, "err", err)
+		}
+	}
+	return startSession(ctx, proc, term, logger, recorder, resizePolicy, spec), nil
 }
 
 func (s *Session) feedLoop(ctx context.Context) {
 	defer close(s.feedDone)
+	defer close(s.replies)
 	for item := range s.feedCh {
-		s.term.feed(*item.data)
+		sinks := outputSinks{commands: s.commands, monitor: s.monitor}
+		if item.answer {
+			sinks.reply = s.writeQueryReply
+		}
+		if err := s.output.feed(s.term, *item.data, sinks); err != nil {
+			s.daemonLog.Debug("track shell marks", "session", s.Name, "err", err)
+		}
 		if item.applied != nil {
 			close(item.applied)
 		}
+		select {
+		case s.fed <- struct{}{}:
+		default:
+		}
 		*item.data = (*item.data)[:cap(*item.data)]
 		feedPool.Put(item.data)
 	}
@@ -132,29 +217,41 @@ const (
 	ptyBatchWindow    = 3 * time.Millisecond
 )
 
-// ptyRead owns the gather stage. readPTY exits at process EOF or after
-// Session.close closes the PTY, and ptyDone joins process reaping.
-func (s *Session) ptyRead() {
+// ptyRead owns the gather stage for one process. readPTY exits at
+// process EOF or after Session.close closes the PTY, and the process's
+// done channel joins reaping.
+func (s *Session) ptyRead(p *sessionProcess, out chan<- []byte) {
 	reads := make(chan []byte)
-	go s.readPTY(reads)
-	gatherPTYReads(reads, s.ptyOut, s.done)
+	go s.readPTY(p, reads)
+	gatherPTYReads(reads, out, s.done)
 }
 
-func (s *Session) readPTY(reads chan<- []byte) {
+func (s *Session) readPTY(p *sessionProcess, reads chan<- []byte) {
+	paused := false
 	defer func() {
-		_ = s.cmd.Wait()
-		if s.cmd.ProcessState != nil {
-			if ws, ok := s.cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
-				s.exitCode = exitCodeFromWaitStatus(ws)
+		if paused {
+			close(reads)
+			return
+		}
+		state, _ := p.process.Wait()
+		p.exit.at = time.Now()
+		if state != nil {
+			if ws, ok := state.Sys().(syscall.WaitStatus); ok {
+				p.exit.code = exitCodeFromWaitStatus(ws)
+				if ws.Signaled() {
+					p.exit.signal = unix.SignalName(ws.Signal())
+				}
 			}
 		}
+		// Close done first so the exit status is visible by the time
+		// the run loop sees EOF.
+		close(p.done)
 		close(reads)
-		close(s.ptyDone)
 	}()
 
 	buf := make([]byte, ptyReadSize)
 	for {
-		n, err := s.ptmx.Read(buf)
+		n, err := p.ptmx.Read(buf)
 		if n > 0 {
 			data := make([]byte, n)
 			copy(data, buf[:n])
@@ -165,11 +262,70 @@ func (s *Session) readPTY(reads chan<- []byte) {
 			}
 		}
 		if err != nil {
+			// pauseReads stops reading with a deadline; the process
+			// runs on.
+			paused = p.paused.Load() && errors.Is(err, os.ErrDeadlineExceeded)
 			break
 		}
 	}
 }
 
+// pauseReads stops readPTY, which closes the process's output channel
+// once everything it read has gone out. Data still unread stays in the
+// PTY.
+func (p *sessionProcess) pauseReads() error {
+	p.paused.Store(true)
+	if err := p.ptmx.SetReadDeadline(time.Now()); err != nil {
+		p.paused.Store(false)
+		return err
+	}
+	return nil
+}
+
+// resumeReads restarts reading a PTY paused by pauseReads and returns
+// the channel the run loop reads its output from.
+func (s *Session) resumeReads(p *sessionProcess) chan []byte {
+	_ = p.ptmx.SetReadDeadline(time.Time{})
+	p.paused.Store(false)
+	out := make(chan []byte, cap(s.ptyOut))
+	go s.ptyRead(p, out)
+	return out
+}
+
+// setNonblock puts f in non-blocking mode so reads on it honor
+// deadlines. It avoids f.Fd, which would switch f back to blocking.
+func setNonblock(f *os.File) error {
+	// A file outside the runtime poller would fail reads with EAGAIN
+	// instead of waiting.
+	if err := f.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+	return controlFD(f, func(fd int) error {
+		return unix.SetNonblock(fd, true)
+	})
+}
+
+// setWinsize is pty.Setsize without f.Fd.
+func setWinsize(f *os.File, size termSize) error {
+	return controlFD(f, func(fd int) error {
+		return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{
+			Row: size.rows, Col: size.cols, Xpixel: size.xpixel, Ypixel: size.ypixel,
+		})
+	})
+}
+
+func controlFD(f *os.File, fn func(fd int) error) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := rc.Control(func(fd uintptr) { fnErr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return fnErr
+}
+
 func gatherPTYReads(reads <-chan []byte, batches chan<- []byte, done <-chan struct{}) {
 	defer close(batches)
 
@@ -257,86 +413,169 @@ func (s *Session) run() {
 
 	// pendingFeed holds data waiting to be sent to feedCh. While
 	// non-nil, we stop reading ptyOut (backpressure) but keep
-	// processing actions so detach/kick/list don't stall.
+	// processing actions so detach/kick/list don't stall. Clients never
+	// hold up ptyOut: one whose queue fills up is resynced or kicked.
 	var pendingFeed *feedItem
 	var lastFeedApplied <-chan struct{}
-	var pendingOutput *protocol.Output
-	var pendingClients []*sessionClient
+
+	// watches are evaluated after feedLoop applies PTY output, and again
+	// when a stable watch's quiet period elapses.
+	var watches []*screenWatch
+	var stableTimer *time.Timer
+	var stableCh <-chan time.Time
+	defer func() {
+		if stableTimer != nil {
+			stableTimer.Stop()
+		}
+	}()
+
+	// ptyOut is nil while a restart is pending.
+	ptyOut := s.ptyOut
+	var restartAttempt int
+	var restartTimer *time.Timer
+	var restartCh <-chan time.Time
+	defer func() {
+		if restartTimer != nil {
+			restartTimer.Stop()
+		}
+	}()
+
+	exited := func() {
+		close(s.feedCh)
+		<-s.feedDone
+		s.monitor.close()
+		watches = s.evaluateWatches(watches)
+		finishWatches(watches, watchResult{err: fmt.Errorf("session exited")})
+		if s.logger != nil {
+			s.logger.finish()
+		}
+		if s.recorder != nil {
+			s.recorder.finish()
+		}
+		exitMsg := &protocol.Exited{ExitCode: s.process().exit.code}
+		for _, c := range clients {
+			c.final = exitMsg
+			close(c.outCh)
+			s.events.publish(protocol.Event{Kind: protocol.EventDetached, Session: s.Name, ClientID: c.id})
+		}
+		s.events.publish(protocol.Event{Kind: protocol.EventExited, Session: s.Name, PID: s.pid(), Exit: s.protocolExit()})
+	}
+
+	// accept hands a chunk of PTY output to the log, the recording and
+	// the clients, and queues it for the terminal.
+	accept := func(data []byte) {
+		if s.logger != nil {
+			s.logger.record(data)
+		}
+		if s.recorder != nil {
+			s.recorder.record(data)
+		}
+
+		msg := &protocol.Output{Data: data}
+		if slow := queueOutput(clients, msg); len(slow) > 0 {
+			clients = s.handleSlowClients(clients, slow)
+		}
+
+		bp := feedPool.Get().(*[]byte)
+		d := (*bp)[:len(data)]
+		copy(d, data)
+		*bp = d
+		applied := make(chan struct{})
+		pendingFeed = &feedItem{data: bp, applied: applied, answer: !answeringClient(clients)}
+		lastFeedApplied = applied
+	}
 
 	for {
 		var clientsChanged bool
-		clients, pendingClients, clientsChanged = pruneFinishedClients(clients, pendingClients)
+		clients, clientsChanged = s.pruneFinishedClients(clients)
 		if clientsChanged {
 			notifyClientsChanged(clients, s.size)
 		}
-		if len(pendingClients) == 0 {
-			pendingOutput = nil
-		}
-		if pendingOutput != nil {
-			pendingClients = queueOutput(pendingClients, pendingOutput)
-			if len(pendingClients) == 0 {
-				pendingOutput = nil
+		s.monitor.setAttached(len(clients) > 0)
+		if resyncDue(clients) {
+			if pendingFeed != nil {
+				s.feedCh <- *pendingFeed
+				pendingFeed = nil
 			}
+			// The fresh screen must include every PTY chunk the client
+			// missed.
+			waitFeedApplied(lastFeedApplied)
+			s.resyncClients(clients)
 		}
 
-		// Nil channels stop PTY intake while terminal feed or client
-		// delivery is backpressured. Session actions remain responsive.
+		// Nil channels stop PTY intake while terminal feed or the
+		// recording is backpressured. Session actions remain responsive.
 		var ptyCh <-chan []byte
 		var feedSend chan<- feedItem
 		var feedItemToSend feedItem
 		var clientReady <-chan struct{}
+		var fedCh <-chan struct{}
+		if len(watches) > 0 {
+			fedCh = s.fed
+		}
 		if pendingFeed != nil {
 			feedSend = s.feedCh
 			feedItemToSend = *pendingFeed
 		}
-		if pendingOutput != nil {
+		if anyBehind(clients) {
 			clientReady = s.clientReady
 		}
-		if pendingFeed == nil && pendingOutput == nil {
-			ptyCh = s.ptyOut
+		recordSend, recordEntryToSend := s.recorder.next()
+		if pendingFeed == nil && recordSend == nil {
+			ptyCh = ptyOut
 		}
 
 		select {
 		case data, ok := <-ptyCh:
 			if !ok {
 				waitFeedApplied(lastFeedApplied)
-				close(s.feedCh)
-				<-s.feedDone
-				exitMsg := &protocol.Exited{ExitCode: s.exitCode}
-				for _, c := range clients {
-					c.final = exitMsg
-					close(c.outCh)
+				var delay time.Duration
+				var restart bool
+				delay, restartAttempt, restart = s.nextRestart(restartAttempt)
+				if restart {
+					ptyOut = nil
+					restartTimer = time.NewTimer(delay)
+					restartCh = restartTimer.C
+					continue
 				}
+				exited()
 				return
 			}
 
-			msg := &protocol.Output{Data: data}
-			pendingClients = queueOutput(clients, msg)
-			if len(pendingClients) > 0 {
-				pendingOutput = msg
-			}
-
-			bp := feedPool.Get().(*[]byte)
-			d := (*bp)[:len(data)]
-			copy(d, data)
-			*bp = d
-			applied := make(chan struct{})
-			pendingFeed = &feedItem{data: bp, applied: applied}
-			lastFeedApplied = applied
+			accept(data)
 
 		case feedSend <- feedItemToSend:
 			pendingFeed = nil
 
+		case recordSend <- recordEntryToSend:
+			s.recorder.sent()
+
 		case <-clientReady:
 
+		case <-fedCh:
+			watches = s.evaluateWatches(watches)
+			stableTimer, stableCh = armStableTimer(stableTimer, watches)
+
+		case <-stableCh:
+			watches = s.evaluateWatches(watches)
+			stableTimer, stableCh = armStableTimer(stableTimer, watches)
+
+		case <-restartCh:
+			restartTimer, restartCh = nil, nil
+			out, err := s.restartProcess(restartAttempt)
+			if err != nil {
+				s.daemonLog.Warn("session restart", "session", s.Name, "err", err)
+				exited()
+				return
+			}
+			ptyOut = out
+			s.events.publish(protocol.Event{Kind: protocol.EventRestarted, Session: s.Name, PID: s.pid()})
+
 		case action := <-s.actions:
-			clients, pendingClients, clientsChanged = pruneFinishedClients(clients, pendingClients)
+			clients, clientsChanged = s.pruneFinishedClients(clients)
 			if clientsChanged {
 				notifyClientsChanged(clients, s.size)
 			}
-			if len(pendingClients) == 0 {
-				pendingOutput = nil
-			}
 			switch a := action.(type) {
 			case attachReq:
 				if !a.spec.readOnly {
@@ -365,6 +604,7 @@ func (s *Session) run() {
 					closeConn: a.spec.closeConn,
 					size:      a.spec.size,
 					version:   a.spec.version,
+					pid:       a.spec.pid,
 					readOnly:  a.spec.readOnly,
 					outCh:     make(chan protocol.Message, sessionClientOutBufferSize),
 					ready:     s.clientReady,
@@ -379,7 +619,7 @@ func (s *Session) run() {
 				// clients list yet.
 				sc.outCh <- &protocol.Attached{
 					Name:       s.Name,
-					PID:        s.PID,
+					PID:        s.pid(),
 					ClientID:   clientID,
 					Cols:       cols,
 					Rows:       rows,
@@ -395,6 +635,7 @@ func (s *Session) run() {
 					s.arbitrateResize(clients)
 				}
 				notifyClientsChanged(clients, s.size)
+				s.events.publish(protocol.Event{Kind: protocol.EventAttached, Session: s.Name, ClientID: clientID})
 
 				a.result <- attachResp{client: sc}
 
@@ -404,13 +645,10 @@ func (s *Session) run() {
 				if len(clients) == before {
 					continue // already removed (e.g., kicked)
 				}
-				pendingClients = removeClient(pendingClients, a.client)
-				if len(pendingClients) == 0 {
-					pendingOutput = nil
-				}
 				close(a.client.outCh)
 				s.arbitrateResize(clients)
 				notifyClientsChanged(clients, s.size)
+				s.events.publish(protocol.Event{Kind: protocol.EventDetached, Session: s.Name, ClientID: a.client.id})
 
 			case kickReq:
 				var target *sessionClient
@@ -425,20 +663,88 @@ func (s *Session) run() {
 					continue
 				}
 				clients = removeClient(clients, target)
-				pendingClients = removeClient(pendingClients, target)
-				if len(pendingClients) == 0 {
-					pendingOutput = nil
-				}
 				close(target.outCh)
 				_ = target.closeConn()
 				s.arbitrateResize(clients)
 				notifyClientsChanged(clients, s.size)
+				s.events.publish(protocol.Event{Kind: protocol.EventKicked, Session: s.Name, ClientID: target.id})
 				a.result <- true
 
 			case resizeReq:
 				a.client.size = a.size
 				s.arbitrateResize(clients)
 
+			case watchReq:
+				if pendingFeed != nil {
+					s.feedCh <- *pendingFeed
+					pendingFeed = nil
+				}
+				// Like attach dumps, the first evaluation must see every
+				// PTY chunk already accepted.
+				waitFeedApplied(lastFeedApplied)
+				watches = append(watches, s.evaluateWatches([]*screenWatch{a.watch})...)
+				stableTimer, stableCh = armStableTimer(stableTimer, watches)
+
+			case unwatchReq:
+				watches = removeWatch(watches, a.watch)
+				stableTimer, stableCh = armStableTimer(stableTimer, watches)
+				close(a.done)
+
+			case logReq:
+				if a.logger != nil && a.logger.format == protocol.LogPlain {
+					if pendingFeed != nil {
+						s.feedCh <- *pendingFeed
+						pendingFeed = nil
+					}
+					// A plain logger renders rows itself, so it starts from
+					// the screen as of the last accepted PTY chunk.
+					waitFeedApplied(lastFeedApplied)
+					if dump, err := s.term.dumpScreen(terminalFormatVTFull); err == nil {
+						a.logger.seed(dump.Data)
+					} else {
+						s.daemonLog.Warn("session log seed dump", "session", s.Name, "err", err)
+					}
+				}
+				prev := s.logger
+				s.logger = a.logger
+				if prev != nil {
+					prev.finish()
+				}
+				a.result <- prev
+
+			case recordReq:
+				if a.recorder != nil {
+					if pendingFeed != nil {
+						s.feedCh <- *pendingFeed
+						pendingFeed = nil
+					}
+					// The recording opens on the screen as of the last
+					// accepted PTY chunk, so playback starts where the
+					// session is rather than from a blank terminal.
+					waitFeedApplied(lastFeedApplied)
+					if dump, err := s.term.dumpScreen(terminalFormatVTFull); err == nil {
+						a.recorder.seed(dump.Data)
+					} else {
+						s.daemonLog.Warn("session recording seed dump", "session", s.Name, "err", err)
+					}
+				}
+				prev := s.recorder
+				s.recorder = a.recorder
+				if prev != nil {
+					prev.finish()
+				}
+				a.result <- prev
+
+			case execReq:
+				if pendingFeed != nil {
+					s.feedCh <- *pendingFeed
+					pendingFeed = nil
+				}
+				// The prompt check must see every accepted PTY chunk.
+				waitFeedApplied(lastFeedApplied)
+				from, err := s.commands.beginExec()
+				a.result <- execStart{from: from, err: err}
+
 			case clientInfoReq:
 				info := make([]protocol.SessionClient, len(clients))
 				for i, c := range clients {
@@ -446,14 +752,70 @@ func (s *Session) run() {
 						ClientID: c.id,
 						ReadOnly: c.readOnly,
 						Version:  c.version,
+						PID:      c.pid,
+						Protocol: c.conn.Version(),
+						Features: c.conn.Capabilities(),
+						Resyncs:  c.resyncs,
+						Dropped:  c.dropped,
 					}
 				}
 				a.result <- info
 
+			case freezeReq:
+				if ptyOut == nil {
+					a.result <- freezeResp{err: fmt.Errorf("session %q is restarting", s.Name)}
+					continue
+				}
+				p := s.process()
+				if err := p.pauseReads(); err != nil {
+					a.result <- freezeResp{err: err}
+					continue
+				}
+				for data := range ptyOut {
+					if pendingFeed != nil {
+						s.feedCh <- *pendingFeed
+						pendingFeed = nil
+					}
+					accept(data)
+				}
+				if pendingFeed != nil {
+					s.feedCh <- *pendingFeed
+					pendingFeed = nil
+				}
+				waitFeedApplied(lastFeedApplied)
+				if _, ok := s.exitStatus(); ok {
+					// ptyOut is closed, so the next pass handles the exit.
+					a.result <- freezeResp{exited: true}
+					continue
+				}
+				snapshot, err := s.term.snapshot()
+				if err != nil {
+					ptyOut = s.resumeReads(p)
+					a.result <- freezeResp{err: err}
+					continue
+				}
+				resp := freezeResp{snapshot: snapshot}
+				if s.logger != nil {
+					resp.logPath, resp.logFormat = s.logger.path, s.logger.format
+				}
+				if s.recorder != nil {
+					resp.recordPath, resp.recordStart = s.recorder.path, s.recorder.start
+				}
+				a.result <- resp
+				if <-a.proceed {
+					s.finishOutputs()
+					a.result <- freezeResp{}
+					// The exec replaces the daemon before proceed closes,
+					// unless it fails.
+					<-a.proceed
+				}
+				ptyOut = s.resumeReads(p)
+
 			case stopReq:
 				// Force-close: disconnect all clients, close feedCh, return.
 				// Clients see connection close (EOF), not Exited — this is
-				// the kill/shutdown path.
+				// the shutdown path. close publishes the exit once the
+				// process is reaped.
 				if pendingFeed != nil {
 					if pendingFeed.applied != nil {
 						close(pendingFeed.applied)
@@ -463,9 +825,18 @@ func (s *Session) run() {
 				}
 				close(s.feedCh)
 				<-s.feedDone
+				s.monitor.close()
+				finishWatches(watches, watchResult{err: fmt.Errorf("session closed")})
+				if s.logger != nil {
+					s.logger.finish()
+				}
+				if s.recorder != nil {
+					s.recorder.finish()
+				}
 				for _, c := range clients {
 					close(c.outCh)
 					_ = c.closeConn()
+					s.events.publish(protocol.Event{Kind: protocol.EventDetached, Session: s.Name, ClientID: c.id})
 				}
 				return
 			}
@@ -473,42 +844,104 @@ func (s *Session) run() {
 	}
 }
 
+// close ends the session, hanging up its process, and publishes its
+// exit unless the process had already exited on its own.
 func (s *Session) close(ctx context.Context) {
+	if s.stop(ctx) {
+		s.events.publish(protocol.Event{Kind: protocol.EventExited, Session: s.Name, PID: s.pid(), Exit: s.protocolExit()})
+	}
+}
+
+// discard ends a session that was never announced, publishing nothing.
+func (s *Session) discard(ctx context.Context) {
+	s.stop(ctx)
+}
+
+// stop ends the session and waits for its process. It reports whether
+// it stopped the run loop, which then published no exit.
+func (s *Session) stop(ctx context.Context) bool {
+	s.killed.Store(true)
+	var stopped bool
 	select {
 	case s.actions <- stopReq{}:
+		stopped = true
 	case <-s.done:
 	}
 
-	s.kill()
-	s.ptmx.Close() // unblock ptyRead if blocked on Read
+	// The run loop only replaces the process before done closes.
 	<-s.done
+	p := s.process()
+	p.hangup()
+	p.ptmx.Close() // unblock ptyRead if blocked on Read
 	select {
-	case <-s.ptyDone:
+	case <-p.done:
 	case <-time.After(5 * time.Second):
-		slog.Warn("child ignored SIGHUP, sending SIGKILL", "session", s.Name)
-		_ = syscall.Kill(-int(s.PID), syscall.SIGKILL)
-		<-s.ptyDone
+		s.daemonLog.Warn("child ignored SIGHUP, sending SIGKILL", "session", s.Name)
+		_ = syscall.Kill(-int(p.pid), syscall.SIGKILL)
+		<-p.done
 	}
 	s.clientWriters.Wait()
+	if s.logger != nil {
+		<-s.logger.done
+	}
+	if s.recorder != nil {
+		<-s.recorder.done
+	}
+	s.commands.close()
 	s.term.close()
-	if s.tempDir != "" {
-		os.RemoveAll(s.tempDir)
+	if p.tempDir != "" {
+		os.RemoveAll(p.tempDir)
 	}
+	return stopped
 }
 
 func (s *Session) waitClients() {
 	s.clientWriters.Wait()
 }
 
+// kill hangs up the session's process group and stops its restart
+// policy, so the session ends once the process exits.
 func (s *Session) kill() {
-	_ = syscall.Kill(-int(s.PID), syscall.SIGHUP)
+	s.killed.Store(true)
+	s.process().hangup()
+}
+
+func (p *sessionProcess) hangup() {
+	_ = syscall.Kill(-int(p.pid), syscall.SIGHUP)
 }
 
 func (s *Session) sendInput(data []byte) error {
-	_, err := s.ptmx.Write(data)
+	_, err := s.process().ptmx.Write(data)
 	return err
 }
 
+// queryReplyBufferSize bounds the query replies queued per session.
+const queryReplyBufferSize = 64
+
+// writeQueryReply queues reply for replyLoop. It is called by feedLoop
+// only and never blocks.
+func (s *Session) writeQueryReply(reply []byte) {
+	select {
+	case s.replies <- reply:
+	default:
+		s.repliesDropped.Add(1)
+	}
+}
+
+// replyLoop writes query replies to the PTY until feedLoop exits. A
+// write blocks while the process leaves its input unread; replies that
+// arrive once the queue is full are dropped.
+func (s *Session) replyLoop() {
+	for reply := range s.replies {
+		if n := s.repliesDropped.Swap(0); n > 0 {
+			s.daemonLog.Debug("session input is full, dropped query replies", "session", s.Name, "replies", n)
+		}
+		if err := s.sendInput(reply); err != nil {
+			s.daemonLog.Debug("write query reply", "session", s.Name, "err", err)
+		}
+	}
+}
+
 func (s *Session) dumpScreen(ctx context.Context, format terminalFormat) (*screenDump, error) {
 	return s.term.dumpScreen(format)
 }
@@ -0,0 +1,331 @@
+package daemon
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
+	"code.selman.me/hauntty/libghostty"
+)
+
+// sessionLogBufferSize bounds queued PTY chunks per logger. A full queue
+// drops output instead of stalling the session's run loop.
+const sessionLogBufferSize = 256
+
+type sessionLogEntry struct {
+	data []byte
+	// size is set for resize entries, which carry no data.
+	size termSize
+	// seed marks the screen dump a plain logger starts from.
+	seed bool
+}
+
+// sessionLogger writes a session's PTY output to a rotating file from
+// its own goroutine. record and resize are called by the run loop only.
+type sessionLogger struct {
+	path    string
+	format  protocol.LogFormat
+	file    *rotatingFile
+	term    *terminalState
+	entries chan sessionLogEntry
+	dropped atomic.Uint64
+	done    chan struct{}
+	// daemonLog receives write failures and drops.
+	daemonLog *slog.Logger
+}
+
+type sessionLogSpec struct {
+	path     string
+	format   protocol.LogFormat
+	maxSize  int64
+	maxFiles int
+}
+
+type logReq struct {
+	logger *sessionLogger
+	result chan<- *sessionLogger
+}
+
+func (logReq) isSessionAction() {}
+
+func newSessionLogger(spec sessionLogSpec, size termSize, daemonLog *slog.Logger) (*sessionLogger, error) {
+	if spec.path == "" {
+		return nil, fmt.Errorf("log path required")
+	}
+	switch spec.format {
+	case protocol.LogRaw, protocol.LogPlain:
+	default:
+		return nil, fmt.Errorf("unsupported log format %d", spec.format)
+	}
+
+	file, err := openRotatingFile(spec.path, spec.maxSize, spec.maxFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &sessionLogger{
+		path:      spec.path,
+		format:    spec.format,
+		file:      file,
+		entries:   make(chan sessionLogEntry, sessionLogBufferSize),
+		done:      make(chan struct{}),
+		daemonLog: daemonLog,
+	}
+	if spec.format == protocol.LogPlain {
+		// One PTY batch can scroll at most ptyBatchSize rows, so history
+		// never loses rows between takeHistory calls.
+		l.term, err = newTerminalState(uint32(size.cols), uint32(size.rows), ptyBatchSize)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	go l.writeLoop()
+	return l, nil
+}
+
+// newSessionLogSpec applies cfg to a client's log request. An empty path
+// falls back to the configured log directory, and stays empty when none
+// is configured; LogDefault falls back to the configured format.
+func newSessionLogSpec(cfg config.SessionLogConfig, name, path string, format protocol.LogFormat) sessionLogSpec {
+	if path == "" && cfg.Dir != "" {
+		path = filepath.Join(cfg.Dir, name+".log")
+	}
+	if format == protocol.LogDefault {
+		format = protocol.LogRaw
+		if cfg.Format == config.LogFormatPlain {
+			format = protocol.LogPlain
+		}
+	}
+	return sessionLogSpec{
+		path:     path,
+		format:   format,
+		maxSize:  int64(cfg.MaxSizeMB) << 20,
+		maxFiles: cfg.MaxFiles,
+	}
+}
+
+// openSessionLog returns a nil logger when spec has no path.
+func openSessionLog(spec sessionLogSpec, size termSize, daemonLog *slog.Logger) (*sessionLogger, error) {
+	if spec.path == "" {
+		return nil, nil
+	}
+	return newSessionLogger(spec, size, daemonLog)
+}
+
+func (l *sessionLogger) record(data []byte) {
+	l.enqueue(sessionLogEntry{data: data})
+}
+
+func (l *sessionLogger) resize(size termSize) {
+	if l.format == protocol.LogPlain {
+		l.enqueue(sessionLogEntry{size: size})
+	}
+}
+
+func (l *sessionLogger) seed(dump []byte) {
+	if l.format == protocol.LogPlain {
+		l.enqueue(sessionLogEntry{data: dump, seed: true})
+	}
+}
+
+func (l *sessionLogger) enqueue(entry sessionLogEntry) {
+	select {
+	case l.entries <- entry:
+	default:
+		l.dropped.Add(uint64(len(entry.data)))
+	}
+}
+
+// finish stops intake. writeLoop drains what is queued and closes the file.
+func (l *sessionLogger) finish() {
+	close(l.entries)
+}
+
+func (l *sessionLogger) writeLoop() {
+	defer close(l.done)
+	defer func() {
+		if l.term != nil {
+			// Rows still on screen have not scrolled into history yet.
+			dump, err := l.term.dumpScreen(terminalFormat{emit: libghostty.FormatterFormatPlain, unwrap: true})
+			if err == nil && len(dump.Data) > 0 {
+				l.write(append(dump.Data, '\n'))
+			}
+			l.term.close()
+		}
+		if err := l.file.Close(); err != nil {
+			l.daemonLog.Warn("session log close", "path", l.path, "err", err)
+		}
+	}()
+
+	for entry := range l.entries {
+		if n := l.dropped.Swap(0); n > 0 {
+			l.daemonLog.Warn("session log fell behind, dropped output", "path", l.path, "bytes", n)
+		}
+		if l.term == nil {
+			l.write(entry.data)
+			continue
+		}
+
+		switch {
+		case entry.data == nil:
+			if err := l.term.resize(uint32(entry.size.cols), uint32(entry.size.rows)); err != nil {
+				l.daemonLog.Warn("session log resize", "path", l.path, "err", err)
+			}
+			continue
+		case entry.seed:
+			// The seed only recreates the screen; its history was
+			// produced before logging started.
+			l.term.feed(entry.data)
+			if _, err := l.term.takeHistory(); err != nil {
+				l.daemonLog.Warn("session log seed", "path", l.path, "err", err)
+			}
+			continue
+		}
+		l.term.feed(entry.data)
+		text, err := l.term.takeHistory()
+		if err != nil {
+			l.daemonLog.Warn("session log render", "path", l.path, "err", err)
+			continue
+		}
+		l.write(text)
+	}
+}
+
+func (l *sessionLogger) write(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if _, err := l.file.Write(data); err != nil {
+		l.daemonLog.Warn("session log write", "path", l.path, "err", err)
+	}
+}
+
+// rotatingFile appends to path and, once maxSize would be exceeded,
+// shifts path to path.1, path.1 to path.2 and so on, keeping at most
+// maxFiles rotated files. maxSize 0 disables rotation.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create log dir: %w", err)
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("close log file: %w", err)
+	}
+	if r.maxFiles == 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove log file: %w", err)
+		}
+		return r.open()
+	}
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotatedLogPath(r.path, i), rotatedLogPath(r.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotate log file: %w", err)
+		}
+	}
+	if err := os.Rename(r.path, rotatedLogPath(r.path, 1)); err != nil {
+		return fmt.Errorf("rotate log file: %w", err)
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	return r.file.Close()
+}
+
+func rotatedLogPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// startLog opens a logger for spec and hands it to the run loop,
+// replacing and flushing any logger already running.
+func (s *Session) startLog(spec sessionLogSpec) error {
+	cols, rows := s.size()
+	l, err := newSessionLogger(spec, termSize{cols: cols, rows: rows}, s.daemonLog)
+	if err != nil {
+		return err
+	}
+	prev, ok := s.swapLogger(l)
+	if !ok {
+		l.finish()
+		<-l.done
+		return fmt.Errorf("session closed")
+	}
+	if prev != nil {
+		<-prev.done
+	}
+	return nil
+}
+
+// stopLog stops the running logger and waits for it to flush.
+func (s *Session) stopLog() error {
+	prev, ok := s.swapLogger(nil)
+	if !ok {
+		return fmt.Errorf("session closed")
+	}
+	if prev == nil {
+		return fmt.Errorf("session is not logging")
+	}
+	<-prev.done
+	return nil
+}
+
+func (s *Session) swapLogger(l *sessionLogger) (*sessionLogger, bool) {
+	ch := make(chan *sessionLogger, 1)
+	select {
+	case s.actions <- logReq{logger: l, result: ch}:
+	case <-s.done:
+		return nil, false
+	}
+	select {
+	case prev := <-ch:
+		return prev, true
+	case <-s.done:
+		return nil, false
+	}
+}
@@ -0,0 +1,140 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
+	"gotest.tools/v3/assert"
+)
+
+func TestNewSessionLogSpec(t *testing.T) {
+	cfg := config.SessionLogConfig{Dir: "/var/log/ht", Format: config.LogFormatPlain, MaxSizeMB: 2, MaxFiles: 4}
+
+	spec := newSessionLogSpec(cfg, "demo", "", protocol.LogDefault)
+	assert.Equal(t, spec, sessionLogSpec{path: "/var/log/ht/demo.log", format: protocol.LogPlain, maxSize: 2 << 20, maxFiles: 4})
+
+	spec = newSessionLogSpec(cfg, "demo", "/tmp/explicit.log", protocol.LogRaw)
+	assert.Equal(t, spec.path, "/tmp/explicit.log")
+	assert.Equal(t, spec.format, protocol.LogRaw)
+
+	spec = newSessionLogSpec(config.SessionLogConfig{Format: config.LogFormatRaw}, "demo", "", protocol.LogDefault)
+	assert.Equal(t, spec.path, "")
+	assert.Equal(t, spec.format, protocol.LogRaw)
+}
+
+func TestRotatingFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "demo.log")
+	f, err := openRotatingFile(path, 8, 2)
+	assert.NilError(t, err)
+
+	for _, chunk := range []string{"aaaaaa", "bbbbbb", "cccccc", "dddddd"} {
+		_, err := f.Write([]byte(chunk))
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, f.Close())
+
+	assertFileContent(t, path, "dddddd")
+	assertFileContent(t, path+".1", "cccccc")
+	assertFileContent(t, path+".2", "bbbbbb")
+	_, err = os.Stat(path + ".3")
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestRotatingFileWithoutBackupsTruncates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "demo.log")
+	f, err := openRotatingFile(path, 8, 0)
+	assert.NilError(t, err)
+
+	_, err = f.Write([]byte("aaaaaa"))
+	assert.NilError(t, err)
+	_, err = f.Write([]byte("bbbbbb"))
+	assert.NilError(t, err)
+	assert.NilError(t, f.Close())
+
+	assertFileContent(t, path, "bbbbbb")
+	_, err = os.Stat(path + ".1")
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestSessionLoggerDropsWhenBehind(t *testing.T) {
+	l := &sessionLogger{entries: make(chan sessionLogEntry, 1)}
+
+	l.record([]byte("kept"))
+	l.record([]byte("dropped"))
+
+	assert.Equal(t, l.dropped.Load(), uint64(len("dropped")))
+	assert.Equal(t, string((<-l.entries).data), "kept")
+}
+
+func TestTerminalStateTakeHistory(t *testing.T) {
+	term, err := newTerminalState(20, 3, 100)
+	assert.NilError(t, err)
+	defer term.close()
+
+	term.feed([]byte("one\r\ntwo\r\n"))
+	history, err := term.takeHistory()
+	assert.NilError(t, err)
+	assert.Equal(t, string(history), "")
+
+	term.feed([]byte("three\r\nfour\r\nfive"))
+	history, err = term.takeHistory()
+	assert.NilError(t, err)
+	assert.Equal(t, string(history), "one\ntwo\n")
+
+	history, err = term.takeHistory()
+	assert.NilError(t, err)
+	assert.Equal(t, string(history), "")
+}
+
+func TestSessionLogRecordsRawOutput(t *testing.T) {
+	s := newSessionLoopHarness(t)
+	path := filepath.Join(t.TempDir(), "raw.log")
+
+	assert.NilError(t, s.startLog(sessionLogSpec{path: path, format: protocol.LogRaw}))
+	s.ptyOut <- []byte("\x1b[1mhello\x1b[0m\r\n")
+	waitSessionOutput(t, s, "hello")
+	assert.NilError(t, s.stopLog())
+
+	assertFileContent(t, path, "\x1b[1mhello\x1b[0m\r\n")
+	assert.Error(t, s.stopLog(), "session is not logging")
+}
+
+func TestSessionLogRecordsPlainOutput(t *testing.T) {
+	s := newSessionLoopHarness(t)
+	path := filepath.Join(t.TempDir(), "plain.log")
+
+	s.ptyOut <- []byte("before\r\n")
+	waitSessionOutput(t, s, "before")
+
+	assert.NilError(t, s.startLog(sessionLogSpec{path: path, format: protocol.LogPlain}))
+	s.ptyOut <- []byte("\x1b[31mred\x1b[0m\r\nlast")
+	waitSessionOutput(t, s, "last")
+	assert.NilError(t, s.stopLog())
+
+	assertFileContent(t, path, "before\nred\nlast\n")
+}
+
+func waitSessionOutput(t *testing.T, s *Session, pattern string) {
+	t.Helper()
+
+	w, err := newScreenWatch(&protocol.Watch{Pattern: pattern, Row: -1})
+	assert.NilError(t, err)
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	matched, err := s.watch(ctx, w)
+	assert.NilError(t, err)
+	assert.Equal(t, matched, true)
+}
+
+func assertFileContent(t *testing.T, path, want string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), want)
+}
@@ -3,6 +3,7 @@ package daemon
 import (
 	"bytes"
 	"io"
+	"log/slog"
 	"net"
 	"sync/atomic"
 	"testing"
@@ -11,6 +12,7 @@ import (
 	"code.selman.me/hauntty/internal/config"
 	"code.selman.me/hauntty/internal/protocol"
 	"github.com/creack/pty"
+	"golang.org/x/term"
 	"gotest.tools/v3/assert"
 )
 
@@ -34,7 +36,7 @@ func (discardRW) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
-func newSessionLoopHarness(t *testing.T) *Session {
+func newSessionLoopHarness(t *testing.T, opts ...func(*Session)) *Session {
 	ctx := t.Context()
 	term, err := newTerminalState(80, 24, 0)
 	assert.NilError(t, err)
@@ -44,22 +46,28 @@ func newSessionLoopHarness(t *testing.T) *Session {
 
 	s := &Session{
 		Name:         "demo",
-		PID:          999999999,
 		CreatedAt:    time.Unix(1700000000, 0),
-		ptmx:         ptmx,
 		term:         term,
 		feedCh:       make(chan feedItem, 64),
 		feedDone:     make(chan struct{}),
+		fed:          make(chan struct{}, 1),
 		actions:      make(chan sessionAction, 16),
 		ptyOut:       make(chan []byte, 64),
 		clientReady:  make(chan struct{}, 1),
 		done:         make(chan struct{}),
+		daemonLog:    slog.Default(),
 		resizePolicy: config.ResizePolicySmallest,
 		ctx:          ctx,
+		replies:      make(chan []byte, queryReplyBufferSize),
 	}
+	s.proc.Store(&sessionProcess{pid: 999999999, ptmx: ptmx})
 	s.setSize(80, 24)
+	for _, opt := range opts {
+		opt(s)
+	}
 
 	go s.feedLoop(ctx)
+	go s.replyLoop()
 	go s.run()
 
 	t.Cleanup(func() {
@@ -84,13 +92,14 @@ func TestSessionClientInfoTracksAttachAndDetach(t *testing.T) {
 		closeConn: func() error { return nil },
 		size:      termSize{cols: 80, rows: 24},
 		version:   "client-v1",
+		pid:       4242,
 		readOnly:  true,
 	})
 	assert.NilError(t, err)
 	assert.Equal(t, client.id, "1")
 
 	info := s.clientInfo()
-	assert.DeepEqual(t, info, []protocol.SessionClient{{ClientID: "1", ReadOnly: true, Version: "client-v1"}})
+	assert.DeepEqual(t, info, []protocol.SessionClient{{ClientID: "1", ReadOnly: true, Version: "client-v1", PID: 4242}})
 
 	s.detachClient(client)
 
@@ -307,30 +316,44 @@ func TestSessionStopClosesClients(t *testing.T) {
 	assert.Equal(t, closeCount.Load(), int32(2))
 }
 
-func TestQueueOutputBackpressuresSlowClients(t *testing.T) {
+func TestQueueOutputSkipsSlowClients(t *testing.T) {
 	msg := &protocol.Output{Data: []byte("hello")}
 	fast := &sessionClient{id: "fast", outCh: make(chan protocol.Message, 1)}
 	slow := &sessionClient{id: "slow", outCh: make(chan protocol.Message, 1)}
 	slow.outCh <- &protocol.Output{Data: []byte("busy")}
+	behind := &sessionClient{id: "behind", outCh: make(chan protocol.Message, 1), behind: true}
 
-	pending := queueOutput([]*sessionClient{fast, slow}, msg)
-	assert.DeepEqual(t, []string{pending[0].id}, []string{"slow"})
+	got := queueOutput([]*sessionClient{fast, slow, behind}, msg)
+	assert.DeepEqual(t, []string{got[0].id}, []string{"slow"})
+	assert.Equal(t, len(got), 1)
 	assert.DeepEqual(t, <-fast.outCh, msg)
-	assert.DeepEqual(t, <-slow.outCh, &protocol.Output{Data: []byte("busy")})
+	assert.Equal(t, fast.dropped, uint64(0))
+	assert.Equal(t, slow.dropped, uint64(5))
+	assert.Equal(t, len(behind.outCh), 0)
+	assert.Equal(t, behind.dropped, uint64(5))
+}
 
-	pending = queueOutput(pending, msg)
-	assert.DeepEqual(t, pending, []*sessionClient(nil))
-	assert.DeepEqual(t, <-slow.outCh, msg)
+func TestDropQueuedOutputKeepsOtherMessages(t *testing.T) {
+	c := &sessionClient{outCh: make(chan protocol.Message, 4)}
+	c.outCh <- &protocol.Output{Data: []byte("one")}
+	c.outCh <- &protocol.ClientsChanged{Count: 2}
+	c.outCh <- &protocol.Output{Data: []byte("three")}
+	c.outCh <- &protocol.ClientsChanged{Count: 1}
+
+	dropQueuedOutput(c)
+	assert.Equal(t, c.dropped, uint64(8))
+	assert.Equal(t, len(c.outCh), 2)
+	assert.DeepEqual(t, <-c.outCh, &protocol.ClientsChanged{Count: 2})
+	assert.DeepEqual(t, <-c.outCh, &protocol.ClientsChanged{Count: 1})
 }
 
-func TestSessionBackpressurePreservesClientAndOutput(t *testing.T) {
-	s := newSessionLoopHarness(t)
+// attachStalledClient attaches a client over a pipe whose far end reads
+// the Attached message and then stops reading.
+func attachStalledClient(t *testing.T, s *Session, closeCount *atomic.Int32) (*protocol.Conn, net.Conn) {
+	t.Helper()
 	serverConn, clientConn := net.Pipe()
-	defer clientConn.Close()
-	client := protocol.NewConn(clientConn)
-
-	var closeCount atomic.Int32
-	sessionClient, err := s.attach(t.Context(), sessionAttachSpec{
+	t.Cleanup(func() { clientConn.Close() })
+	_, err := s.attach(t.Context(), sessionAttachSpec{
 		conn: protocol.NewConn(serverConn),
 		closeConn: func() error {
 			closeCount.Add(1)
@@ -341,62 +364,133 @@ func TestSessionBackpressurePreservesClientAndOutput(t *testing.T) {
 		readOnly: true,
 	})
 	assert.NilError(t, err)
-
+	client := protocol.NewConn(clientConn)
 	msg, err := client.ReadMessage()
 	assert.NilError(t, err)
-	assert.DeepEqual(t, msg, &protocol.Attached{
-		Name:       "demo",
-		PID:        999999999,
-		ClientID:   "1",
-		Cols:       80,
-		Rows:       24,
-		ScreenDump: []byte("\x1b[1;1H\x1b[0m"),
-		Created:    false,
-	})
+	_, ok := msg.(*protocol.Attached)
+	assert.Assert(t, ok, "got %T", msg)
+	return client, clientConn
+}
 
-	outputCount := sessionClientOutBufferSize + 2*cap(s.ptyOut)
+// flood sends more PTY output than a stalled client can queue and fails
+// the test if the session stops taking it.
+func flood(t *testing.T, s *Session, last []byte) {
+	t.Helper()
 	sent := make(chan struct{})
 	go func() {
 		defer close(sent)
-		for range outputCount {
+		for range sessionClientOutBufferSize + 2*cap(s.ptyOut) {
 			s.ptyOut <- []byte("x")
 		}
+		s.ptyOut <- last
 	}()
-
 	select {
 	case <-sent:
-		t.Fatal("PTY producer did not encounter backpressure")
-	case <-time.After(150 * time.Millisecond):
-	}
-
-	infoCh := make(chan []protocol.SessionClient, 1)
-	go func() { infoCh <- s.clientInfo() }()
-	select {
-	case info := <-infoCh:
-		assert.DeepEqual(t, info, []protocol.SessionClient{{ClientID: "1", ReadOnly: true, Version: "slow-client"}})
 	case <-time.After(5 * time.Second):
-		t.Fatal("session actions blocked behind client output")
+		t.Fatal("PTY intake blocked behind a slow client")
 	}
+}
+
+func TestSessionResyncsSlowClient(t *testing.T) {
+	s := newSessionLoopHarness(t)
+	var closeCount atomic.Int32
+	client, clientConn := attachStalledClient(t, s, &closeCount)
+	_, err := s.attach(t.Context(), sessionAttachSpec{
+		conn:      protocol.NewConn(discardRW{}),
+		closeConn: func() error { return nil },
+		size:      termSize{cols: 80, rows: 24},
+		version:   "fast-client",
+		readOnly:  true,
+	})
+	assert.NilError(t, err)
+
+	flood(t, s, []byte("\r\nflood-done"))
+
+	info := s.clientInfo()
+	assert.Equal(t, len(info), 2)
+	assert.Assert(t, info[0].Resyncs >= 1, "slow client was not resynced: %+v", info[0])
+	assert.Assert(t, info[0].Dropped > 0)
+	assert.Equal(t, info[1].Resyncs, uint32(0))
+	assert.Equal(t, info[1].Dropped, uint64(0))
 	assert.Equal(t, closeCount.Load(), int32(0))
 
+	// The stalled client catches up through a fresh screen that ends
+	// up showing the last output.
 	assert.NilError(t, clientConn.SetReadDeadline(time.Now().Add(5*time.Second)))
-	var output []byte
-	for len(output) < outputCount {
+	var resynced bool
+	var screen []byte
+	for !resynced || !bytes.Contains(screen, []byte("flood-done")) {
 		msg, err := client.ReadMessage()
 		assert.NilError(t, err)
-		if m, ok := msg.(*protocol.Output); ok {
-			output = append(output, m.Data...)
+		out, ok := msg.(*protocol.Output)
+		if !ok {
+			continue
 		}
+		if bytes.HasPrefix(out.Data, []byte(resyncPrefix)) {
+			resynced = true
+			screen = nil
+		}
+		screen = append(screen, out.Data...)
 	}
-	assert.DeepEqual(t, output, bytes.Repeat([]byte("x"), outputCount))
-	<-sent
-	close(s.ptyOut)
+}
 
-	msg, err = client.ReadMessage()
+func TestSessionDropsQueryRepliesTheProcessDoesNotRead(t *testing.T) {
+	s := newSessionLoopHarness(t)
+	// Nothing reads the harness's tty. In raw mode its input queue
+	// fills up and blocks the replies, which must not stall the feed.
+	rc, err := s.process().ptmx.SyscallConn()
 	assert.NilError(t, err)
-	assert.DeepEqual(t, msg, &protocol.Exited{ExitCode: 0})
-	<-sessionClient.writeDone
-	assert.Equal(t, closeCount.Load(), int32(0))
+	assert.NilError(t, rc.Control(func(fd uintptr) {
+		_, err = term.MakeRaw(int(fd))
+	}))
+	assert.NilError(t, err)
+
+	query := bytes.Repeat([]byte("\x1b[c"), 64)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for range 2 * (cap(s.ptyOut) + cap(s.feedCh)) {
+			s.ptyOut <- query
+		}
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("PTY intake blocked behind unread query replies")
+	}
+}
+
+func TestResyncOutputSplitsScreensOverTheFrameLimit(t *testing.T) {
+	// One byte over the protocol's 16MB frame limit.
+	dump := bytes.Repeat([]byte("x"), 16<<20+1)
+	var buf bytes.Buffer
+	conn := protocol.NewConn(&buf)
+	for _, msg := range resyncOutput(dump) {
+		assert.Assert(t, len(msg.Data) <= protocol.DumpChunkSize)
+		assert.NilError(t, conn.WriteMessage(msg))
+	}
+
+	var got []byte
+	for buf.Len() > 0 {
+		msg, err := conn.ReadMessage()
+		assert.NilError(t, err)
+		got = append(got, msg.(*protocol.Output).Data...)
+	}
+	assert.Assert(t, bytes.Equal(got, append([]byte(resyncPrefix), dump...)))
+}
+
+func TestSessionKicksSlowClient(t *testing.T) {
+	s := newSessionLoopHarness(t, func(s *Session) {
+		s.slowClient = config.SlowClientKick
+	})
+	var closeCount atomic.Int32
+	attachStalledClient(t, s, &closeCount)
+
+	flood(t, s, []byte("flood-done"))
+
+	assert.DeepEqual(t, s.clientInfo(), []protocol.SessionClient{})
+	assert.Assert(t, closeCount.Load() >= 1, "slow client connection was not closed")
+	assert.Equal(t, s.slowKicks.Load(), uint32(1))
 }
 
 func TestNotifyClientsChangedSkipsBlockedClients(t *testing.T) {
@@ -0,0 +1,232 @@
+package daemon
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.selman.me/hauntty/internal/asciicast"
+)
+
+// sessionRecorderBufferSize bounds queued events per recorder. Events
+// that find the queue full wait in the recorder's backlog.
+const sessionRecorderBufferSize = 256
+
+type recordEntry struct {
+	at   time.Duration
+	data []byte
+	// size is set for resize entries, which carry no data.
+	size termSize
+}
+
+// sessionRecorder writes an asciicast v2 recording of a session from its
+// own goroutine. record and resize are called by the run loop only.
+type sessionRecorder struct {
+	path    string
+	start   time.Time
+	file    *os.File
+	entries chan recordEntry
+	// backlog holds events that found entries full, oldest first. While
+	// it is non-empty the run loop stops reading the PTY and drains it
+	// into entries, so a slow disk slows the session down instead of
+	// losing output.
+	backlog []recordEntry
+	done    chan struct{}
+	// daemonLog receives write failures.
+	daemonLog *slog.Logger
+}
+
+// sessionRecordSpec names a recording a session resumes after an
+// upgrade.
+type sessionRecordSpec struct {
+	path  string
+	start time.Time
+}
+
+type recordReq struct {
+	recorder *sessionRecorder
+	result   chan<- *sessionRecorder
+}
+
+func (recordReq) isSessionAction() {}
+
+func newSessionRecorder(path, title string, size termSize, daemonLog *slog.Logger) (*sessionRecorder, error) {
+	if path == "" {
+		return nil, fmt.Errorf("recording path required")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create recording dir: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+
+	start := time.Now()
+	w := bufio.NewWriter(file)
+	enc, err := asciicast.NewEncoder(w, asciicast.Header{
+		Width:     int(size.cols),
+		Height:    int(size.rows),
+		Timestamp: start.Unix(),
+		Title:     title,
+	})
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("write recording header: %w", err)
+	}
+	return startSessionRecorder(path, start, file, w, enc, daemonLog), nil
+}
+
+// resumeSessionRecorder appends to the recording at path that a daemon
+// handed over on upgrade. Event times stay relative to start.
+func resumeSessionRecorder(path string, start time.Time, daemonLog *slog.Logger) (*sessionRecorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	return startSessionRecorder(path, start, file, w, asciicast.ResumeEncoder(w), daemonLog), nil
+}
+
+func startSessionRecorder(path string, start time.Time, file *os.File, w *bufio.Writer, enc *asciicast.Encoder, daemonLog *slog.Logger) *sessionRecorder {
+	r := &sessionRecorder{
+		path:      path,
+		start:     start,
+		file:      file,
+		entries:   make(chan recordEntry, sessionRecorderBufferSize),
+		done:      make(chan struct{}),
+		daemonLog: daemonLog,
+	}
+	go r.writeLoop(w, enc)
+	return r
+}
+
+func (r *sessionRecorder) record(data []byte) {
+	r.enqueue(recordEntry{at: time.Since(r.start), data: data})
+}
+
+// seed records the screen the recording opens on at time zero.
+func (r *sessionRecorder) seed(dump []byte) {
+	r.enqueue(recordEntry{data: dump})
+}
+
+func (r *sessionRecorder) resize(size termSize) {
+	r.enqueue(recordEntry{at: time.Since(r.start), size: size})
+}
+
+func (r *sessionRecorder) enqueue(entry recordEntry) {
+	if len(r.backlog) == 0 {
+		select {
+		case r.entries <- entry:
+			return
+		default:
+		}
+	}
+	r.backlog = append(r.backlog, entry)
+}
+
+// next returns the channel and the event the run loop should send next
+// to drain the backlog, or a nil channel when it is empty.
+func (r *sessionRecorder) next() (chan<- recordEntry, recordEntry) {
+	if r == nil || len(r.backlog) == 0 {
+		return nil, recordEntry{}
+	}
+	return r.entries, r.backlog[0]
+}
+
+// sent drops the event next returned from the backlog.
+func (r *sessionRecorder) sent() {
+	r.backlog[0] = recordEntry{}
+	r.backlog = r.backlog[1:]
+}
+
+// finish stops intake once the backlog is queued. writeLoop drains what
+// is queued and closes the file.
+func (r *sessionRecorder) finish() {
+	for _, entry := range r.backlog {
+		r.entries <- entry
+	}
+	r.backlog = nil
+	close(r.entries)
+}
+
+func (r *sessionRecorder) writeLoop(w *bufio.Writer, enc *asciicast.Encoder) {
+	defer close(r.done)
+	var last time.Duration
+	for entry := range r.entries {
+		last = entry.at
+		var err error
+		if entry.data == nil {
+			err = enc.Resize(entry.at, entry.size.cols, entry.size.rows)
+		} else {
+			err = enc.Output(entry.at, entry.data)
+		}
+		if err == nil && len(r.entries) == 0 {
+			// Keep the file playable while the session runs.
+			err = w.Flush()
+		}
+		if err != nil {
+			r.daemonLog.Warn("session recording write", "path", r.path, "err", err)
+		}
+	}
+	if err := enc.Flush(last); err != nil {
+		r.daemonLog.Warn("session recording write", "path", r.path, "err", err)
+	}
+	if err := w.Flush(); err != nil {
+		r.daemonLog.Warn("session recording write", "path", r.path, "err", err)
+	}
+	if err := r.file.Close(); err != nil {
+		r.daemonLog.Warn("session recording close", "path", r.path, "err", err)
+	}
+}
+
+// startRecording opens a recording at path and hands it to the run loop,
+// replacing and flushing any recording already running.
+func (s *Session) startRecording(path string) error {
+	cols, rows := s.size()
+	r, err := newSessionRecorder(path, s.Name, termSize{cols: cols, rows: rows}, s.daemonLog)
+	if err != nil {
+		return err
+	}
+	prev, ok := s.swapRecorder(r)
+	if !ok {
+		r.finish()
+		<-r.done
+		return fmt.Errorf("session closed")
+	}
+	if prev != nil {
+		<-prev.done
+	}
+	return nil
+}
+
+// stopRecording stops the running recording and waits for it to flush.
+func (s *Session) stopRecording() error {
+	prev, ok := s.swapRecorder(nil)
+	if !ok {
+		return fmt.Errorf("session closed")
+	}
+	if prev == nil {
+		return fmt.Errorf("session is not recording")
+	}
+	<-prev.done
+	return nil
+}
+
+func (s *Session) swapRecorder(r *sessionRecorder) (*sessionRecorder, bool) {
+	ch := make(chan *sessionRecorder, 1)
+	select {
+	case s.actions <- recordReq{recorder: r, result: ch}:
+	case <-s.done:
+		return nil, false
+	}
+	select {
+	case prev := <-ch:
+		return prev, true
+	case <-s.done:
+		return nil, false
+	}
+}
@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/internal/asciicast"
+	"code.selman.me/hauntty/internal/protocol"
+	"gotest.tools/v3/assert"
+)
+
+func TestSessionRecordingCapturesOutputAndResize(t *testing.T) {
+	s := newSessionLoopHarness(t)
+	path := filepath.Join(t.TempDir(), "casts", "demo.cast")
+
+	s.ptyOut <- []byte("before\r\n")
+	waitSessionOutput(t, s, "before")
+
+	assert.NilError(t, s.startRecording(path))
+	s.ptyOut <- []byte("after\r\n")
+	waitSessionOutput(t, s, "after")
+	// A writable client attaching resizes the session to its size.
+	_, err := s.attach(t.Context(), sessionAttachSpec{
+		conn:      protocol.NewConn(discardRW{}),
+		closeConn: func() error { return nil },
+		size:      termSize{cols: 100, rows: 30},
+		version:   "writer",
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, s.stopRecording())
+
+	f, err := os.Open(path)
+	assert.NilError(t, err)
+	defer f.Close()
+	dec, err := asciicast.NewDecoder(f)
+	assert.NilError(t, err)
+	assert.Equal(t, dec.Header.Version, asciicast.Version)
+	assert.Equal(t, dec.Header.Title, s.Name)
+
+	var events []asciicast.Event
+	for {
+		ev, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NilError(t, err)
+		events = append(events, ev)
+	}
+	assert.Equal(t, len(events), 3)
+	assert.Equal(t, events[0].Type, asciicast.EventOutput)
+	assert.Equal(t, events[0].Time, time.Duration(0))
+	assert.Assert(t, strings.Contains(events[0].Data, "before"))
+	assert.Equal(t, events[1].Data, "after\r\n")
+	cols, rows, err := events[2].Resize()
+	assert.NilError(t, err)
+	assert.Equal(t, cols, uint16(100))
+	assert.Equal(t, rows, uint16(30))
+
+	assert.Error(t, s.stopRecording(), "session is not recording")
+}
+
+func TestSessionRecordingHoldsPTYOutputWhileBehind(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow.cast")
+	file, err := os.Create(path)
+	assert.NilError(t, err)
+	r := &sessionRecorder{
+		path:      path,
+		start:     time.Now(),
+		file:      file,
+		entries:   make(chan recordEntry, 1),
+		done:      make(chan struct{}),
+		daemonLog: slog.Default(),
+	}
+	s := newSessionLoopHarness(t, func(s *Session) { s.recorder = r })
+
+	for i := range 5 {
+		s.ptyOut <- fmt.Appendf(nil, "chunk-%d\r\n", i)
+	}
+	// Nothing writes the recording yet: one chunk fills the queue, the
+	// next waits in the backlog and the rest stay unread.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.ptyOut) > 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, len(s.ptyOut), 3)
+
+	w := bufio.NewWriter(file)
+	enc, err := asciicast.NewEncoder(w, asciicast.Header{Width: 80, Height: 24})
+	assert.NilError(t, err)
+	go r.writeLoop(w, enc)
+	waitSessionOutput(t, s, "chunk-4")
+	assert.NilError(t, s.stopRecording())
+
+	f, err := os.Open(path)
+	assert.NilError(t, err)
+	defer f.Close()
+	dec, err := asciicast.NewDecoder(f)
+	assert.NilError(t, err)
+	var got []string
+	for {
+		ev, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NilError(t, err)
+		got = append(got, ev.Data)
+	}
+	assert.DeepEqual(t, got, []string{"chunk-0\r\n", "chunk-1\r\n", "chunk-2\r\n", "chunk-3\r\n", "chunk-4\r\n"})
+}
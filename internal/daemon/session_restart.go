@@ -0,0 +1,139 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
+)
+
+// minStableRun is the shortest run that resets the restart count.
+const minStableRun = 10 * time.Second
+
+// restartPolicy decides whether a session reruns its command when the
+// command exits. Consecutive restarts back off exponentially from backoff
+// up to maxBackoff; a run that outlasts stableRun resets the count.
+type restartPolicy struct {
+	mode       protocol.RestartPolicy
+	maxRetries int // 0 means no limit
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// newRestartPolicy applies cfg to a client's restart request;
+// RestartDefault falls back to the configured policy.
+func newRestartPolicy(cfg config.RestartConfig, mode protocol.RestartPolicy) restartPolicy {
+	if mode == protocol.RestartDefault {
+		switch cfg.Policy {
+		case config.RestartOnFailure:
+			mode = protocol.RestartOnFailure
+		case config.RestartAlways:
+			mode = protocol.RestartAlways
+		default:
+			mode = protocol.RestartNever
+		}
+	}
+	return restartPolicy{
+		mode:       mode,
+		maxRetries: cfg.MaxRetries,
+		backoff:    time.Duration(cfg.BackoffMS) * time.Millisecond,
+		maxBackoff: time.Duration(cfg.MaxBackoffMS) * time.Millisecond,
+	}
+}
+
+func (p restartPolicy) wants(exit sessionExit) bool {
+	switch p.mode {
+	case protocol.RestartAlways:
+		return true
+	case protocol.RestartOnFailure:
+		return exit.code != 0 || exit.signal != ""
+	default:
+		return false
+	}
+}
+
+// delay returns the backoff before restart number attempt, counting
+// from 0.
+func (p restartPolicy) delay(attempt int) time.Duration {
+	d := p.backoff
+	for range attempt {
+		if d >= p.maxBackoff {
+			break
+		}
+		d *= 2
+	}
+	return min(d, p.maxBackoff)
+}
+
+// stableRun is how long a process must run before its exit no longer
+// counts toward maxRetries.
+func (p restartPolicy) stableRun() time.Duration {
+	return max(p.maxBackoff, minStableRun)
+}
+
+// nextRestart reports whether the run loop should restart the exited
+// process and after how long. attempt counts the consecutive restarts so
+// far and is returned updated.
+func (s *Session) nextRestart(attempt int) (time.Duration, int, bool) {
+	if s.killed.Load() || s.ctx.Err() != nil {
+		return 0, attempt, false
+	}
+	p := s.process()
+	exit, ok := s.exitStatus()
+	if !ok || !s.restart.wants(exit) {
+		return 0, attempt, false
+	}
+	if exit.at.Sub(p.startedAt) >= s.restart.stableRun() {
+		attempt = 0
+	}
+	if s.restart.maxRetries > 0 && attempt >= s.restart.maxRetries {
+		return 0, attempt, false
+	}
+	return s.restart.delay(attempt), attempt + 1, true
+}
+
+// restartProcess launches the session's command again and returns the
+// channel the run loop reads its output from. The output opens with a
+// separator so clients and the terminal state show where the restart
+// happened.
+func (s *Session) restartProcess(attempt int) (chan []byte, error) {
+	prev := s.process()
+	cols, rows := s.size()
+	proc, err := launchSessionProcess(sessionStartSpec{
+		name:      s.Name,
+		command:   s.command,
+		env:       s.env,
+		cwd:       s.launchCWD,
+		size:      termSize{cols: cols, rows: rows},
+		daemonLog: s.daemonLog,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prev.ptmx.Close()
+	if prev.tempDir != "" {
+		os.RemoveAll(prev.tempDir)
+	}
+	s.proc.Store(proc)
+	s.restarts.Add(1)
+
+	out := make(chan []byte, cap(s.ptyOut))
+	out <- restartSeparator(prev.exit, attempt, s.restart.maxRetries)
+	go s.ptyRead(proc, out)
+	return out, nil
+}
+
+func restartSeparator(exit sessionExit, attempt, maxRetries int) []byte {
+	status := fmt.Sprintf("exited with code %d", exit.code)
+	if exit.signal != "" {
+		status = "killed by " + exit.signal
+	}
+	count := fmt.Sprint(attempt)
+	if maxRetries > 0 {
+		count = fmt.Sprintf("%d/%d", attempt, maxRetries)
+	}
+	return fmt.Appendf(nil, "\x1b[0m\r\n\x1b[7m[hauntty] %s, restarting (%s)\x1b[0m\r\n", status, count)
+}
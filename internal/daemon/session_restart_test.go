@@ -0,0 +1,140 @@
+package daemon
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
+	"code.selman.me/hauntty/libghostty"
+	"gotest.tools/v3/assert"
+)
+
+func TestNewRestartPolicyAppliesConfig(t *testing.T) {
+	cfg := config.RestartConfig{
+		Policy:       config.RestartOnFailure,
+		MaxRetries:   3,
+		BackoffMS:    250,
+		MaxBackoffMS: 4000,
+	}
+
+	assert.Equal(t, newRestartPolicy(cfg, protocol.RestartDefault), restartPolicy{
+		mode:       protocol.RestartOnFailure,
+		maxRetries: 3,
+		backoff:    250 * time.Millisecond,
+		maxBackoff: 4 * time.Second,
+	})
+	assert.Equal(t, newRestartPolicy(cfg, protocol.RestartAlways).mode, protocol.RestartAlways)
+	assert.Equal(t, newRestartPolicy(config.RestartConfig{}, protocol.RestartDefault).mode, protocol.RestartNever)
+}
+
+func TestRestartPolicyWants(t *testing.T) {
+	tests := []struct {
+		mode protocol.RestartPolicy
+		exit sessionExit
+		want bool
+	}{
+		{protocol.RestartNever, sessionExit{code: 1}, false},
+		{protocol.RestartOnFailure, sessionExit{code: 0}, false},
+		{protocol.RestartOnFailure, sessionExit{code: 2}, true},
+		{protocol.RestartOnFailure, sessionExit{code: 129, signal: "SIGHUP"}, true},
+		{protocol.RestartAlways, sessionExit{code: 0}, true},
+	}
+
+	for _, tt := range tests {
+		p := restartPolicy{mode: tt.mode}
+		assert.Equal(t, p.wants(tt.exit), tt.want, "%s %+v", tt.mode, tt.exit)
+	}
+}
+
+func TestRestartPolicyDelayBacksOff(t *testing.T) {
+	p := restartPolicy{backoff: 100 * time.Millisecond, maxBackoff: time.Second}
+
+	var got []time.Duration
+	for attempt := range 6 {
+		got = append(got, p.delay(attempt))
+	}
+	assert.DeepEqual(t, got, []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	})
+}
+
+func TestSessionRestartsOnFailureKeepsClients(t *testing.T) {
+	s, err := newSession(t.Context(), config.ResizePolicySmallest, sessionStartSpec{
+		name:    "flaky",
+		command: []string{"/bin/sh", "-c", "sleep 0.2; echo run; exit 3"},
+		size:    termSize{cols: 80, rows: 24},
+		restart: restartPolicy{
+			mode:       protocol.RestartOnFailure,
+			maxRetries: 2,
+			backoff:    10 * time.Millisecond,
+			maxBackoff: 10 * time.Millisecond,
+		},
+	})
+	assert.NilError(t, err)
+	defer s.close(t.Context())
+	firstPID := s.pid()
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	_, err = s.attach(t.Context(), sessionAttachSpec{
+		conn:      protocol.NewConn(serverConn),
+		closeConn: serverConn.Close,
+		size:      termSize{cols: 80, rows: 24},
+		readOnly:  true,
+	})
+	assert.NilError(t, err)
+
+	client := protocol.NewConn(clientConn)
+	assert.NilError(t, clientConn.SetReadDeadline(time.Now().Add(10*time.Second)))
+	var output strings.Builder
+	var exited *protocol.Exited
+	for exited == nil {
+		msg, err := client.ReadMessage()
+		assert.NilError(t, err)
+		switch m := msg.(type) {
+		case *protocol.Output:
+			output.Write(m.Data)
+		case *protocol.Exited:
+			exited = m
+		}
+	}
+
+	assert.DeepEqual(t, exited, &protocol.Exited{ExitCode: 3})
+	assert.Equal(t, s.restarts.Load(), uint32(2))
+	assert.Assert(t, s.pid() != firstPID)
+	got := output.String()
+	assert.Equal(t, strings.Count(got, "run\r\n"), 3, got)
+	assert.Assert(t, strings.Contains(got, "[hauntty] exited with code 3, restarting (1/2)"), got)
+	assert.Assert(t, strings.Contains(got, "[hauntty] exited with code 3, restarting (2/2)"), got)
+
+	dump, err := s.term.dumpScreen(terminalFormat{emit: libghostty.FormatterFormatPlain})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(dump.Data), "restarting (2/2)"), string(dump.Data))
+}
+
+func TestSessionKillStopsRestart(t *testing.T) {
+	s, err := newSession(t.Context(), config.ResizePolicySmallest, sessionStartSpec{
+		name:    "server",
+		command: []string{"/bin/sh", "-c", "sleep 30"},
+		size:    termSize{cols: 80, rows: 24},
+		restart: restartPolicy{mode: protocol.RestartAlways},
+	})
+	assert.NilError(t, err)
+	defer s.close(t.Context())
+
+	s.kill()
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("killed session restarted")
+	}
+	assert.Equal(t, s.restarts.Load(), uint32(0))
+}
@@ -2,11 +2,15 @@ package daemon
 
 import (
 	"math"
+	"net"
 	"os/exec"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
 	"gotest.tools/v3/assert"
 )
 
@@ -104,6 +108,68 @@ func TestApplyResizePolicySmallestStartsAtMaxUint16(t *testing.T) {
 	assert.Equal(t, size.ypixel, uint16(math.MaxUint16))
 }
 
+func TestFixedResizePolicyKeepsSessionSize(t *testing.T) {
+	s, err := newSession(t.Context(), config.ResizePolicyFixed, sessionStartSpec{
+		name:    "fixed",
+		command: []string{"/bin/sh", "-c", "sleep 30"},
+		size:    termSize{cols: 120, rows: 40},
+	})
+	assert.NilError(t, err)
+	defer s.close(t.Context())
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	sc, err := s.attach(t.Context(), sessionAttachSpec{
+		conn:      protocol.NewConn(serverConn),
+		closeConn: serverConn.Close,
+		size:      termSize{cols: 80, rows: 24},
+	})
+	assert.NilError(t, err)
+	s.resizeClient(sc, termSize{cols: 100, rows: 30})
+	s.clientInfo() // the run loop has handled the resize
+
+	cols, rows := s.size()
+	assert.Equal(t, cols, uint16(120))
+	assert.Equal(t, rows, uint16(40))
+}
+
+func TestExecWriteDoesNotStallRunLoop(t *testing.T) {
+	s, err := newSession(t.Context(), config.ResizePolicySmallest, sessionStartSpec{
+		name:    "stuck",
+		command: []string{"/bin/sh", "-c", `stty raw -echo; printf '\033]133;A\007'; sleep 30`},
+		size:    termSize{cols: 80, rows: 24},
+	})
+	assert.NilError(t, err)
+	defer s.close(t.Context())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.commands.mu.Lock()
+		prompt := s.commands.prompt
+		s.commands.mu.Unlock()
+		if prompt {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session never reported a prompt")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// sleep never reads its input, so this write fills the PTY and
+	// blocks.
+	go func() { _, _ = s.startExec(strings.Repeat("x", 1<<20)) }()
+	time.Sleep(100 * time.Millisecond)
+
+	info := make(chan []protocol.SessionClient, 1)
+	go func() { info <- s.clientInfo() }()
+	select {
+	case <-info:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run loop stalled behind the exec write")
+	}
+}
+
 func TestExitCodeFromWaitStatusExited(t *testing.T) {
 	cmd := exec.Command("/bin/sh", "-c", "exit 17")
 	err := cmd.Run()
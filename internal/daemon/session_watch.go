@@ -0,0 +1,226 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"code.selman.me/hauntty/internal/protocol"
+)
+
+// screenWatch is a pending Watch request. Apart from result, fields are
+// owned by the session's run loop once the watch is registered.
+type screenWatch struct {
+	match func(string) bool
+	row   int
+	// prompt also requires the shell to be at its prompt.
+	prompt    bool
+	stable    time.Duration
+	content   string
+	atPrompt  bool
+	changedAt time.Time
+	seen      bool
+	result    chan watchResult
+}
+
+type watchResult struct {
+	matched bool
+	err     error
+}
+
+type watchReq struct {
+	watch *screenWatch
+}
+
+func (watchReq) isSessionAction() {}
+
+type unwatchReq struct {
+	watch *screenWatch
+	done  chan<- struct{}
+}
+
+func (unwatchReq) isSessionAction() {}
+
+func newScreenWatch(msg *protocol.Watch) (*screenWatch, error) {
+	match, err := compileScreenMatcher(msg.Pattern, msg.Regex)
+	if err != nil {
+		return nil, err
+	}
+	return &screenWatch{
+		match:  match,
+		row:    int(msg.Row),
+		prompt: msg.Prompt,
+		stable: time.Duration(msg.Stable) * time.Millisecond,
+		result: make(chan watchResult, 1),
+	}, nil
+}
+
+func compileScreenMatcher(pattern string, regex bool) (func(string) bool, error) {
+	if regex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		return re.MatchString, nil
+	}
+	return func(s string) bool { return strings.Contains(s, pattern) }, nil
+}
+
+func screenRowContent(content string, row int) string {
+	if row < 0 {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+	if row >= len(lines) {
+		return ""
+	}
+	return lines[row]
+}
+
+// observe records the current screen and whether the shell is at its
+// prompt, and reports whether the watch is satisfied: the pattern
+// matches, the shell is at its prompt if the watch asks for that and,
+// for stable watches, the watched content has not changed for w.stable.
+func (w *screenWatch) observe(screen string, atPrompt bool, now time.Time) bool {
+	content := screenRowContent(screen, w.row)
+	if !w.seen || content != w.content {
+		w.content = content
+		w.changedAt = now
+		w.seen = true
+	}
+	w.atPrompt = atPrompt
+	if !w.matched() {
+		return false
+	}
+	return now.Sub(w.changedAt) >= w.stable
+}
+
+func (w *screenWatch) matched() bool {
+	return w.match(w.content) && (!w.prompt || w.atPrompt)
+}
+
+// stableDeadline reports when a matching stable watch becomes satisfied
+// if the screen does not change again.
+func (w *screenWatch) stableDeadline() (time.Time, bool) {
+	if w.stable == 0 || !w.seen || !w.matched() {
+		return time.Time{}, false
+	}
+	return w.changedAt.Add(w.stable), true
+}
+
+// watch blocks until the run loop reports a match, the session ends, or
+// ctx expires. An expired ctx is a timeout, not an error.
+func (s *Session) watch(ctx context.Context, w *screenWatch) (bool, error) {
+	select {
+	case s.actions <- watchReq{watch: w}:
+	case <-s.done:
+		return false, fmt.Errorf("session closed")
+	}
+
+	select {
+	case res := <-w.result:
+		return res.matched, res.err
+	case <-ctx.Done():
+		done := make(chan struct{})
+		select {
+		case s.actions <- unwatchReq{watch: w, done: done}:
+			select {
+			case <-done:
+			case <-s.done:
+			}
+		case <-s.done:
+		}
+		// The run loop may have answered before it saw the unwatch.
+		select {
+		case res := <-w.result:
+			return res.matched, res.err
+		default:
+			return false, nil
+		}
+	case <-s.done:
+		select {
+		case res := <-w.result:
+			return res.matched, res.err
+		default:
+			return false, fmt.Errorf("session closed")
+		}
+	}
+}
+
+// evaluateWatches renders the visible screen once and answers every
+// satisfied watch, returning the ones still pending.
+func (s *Session) evaluateWatches(watches []*screenWatch) []*screenWatch {
+	if len(watches) == 0 {
+		return watches
+	}
+
+	dump, err := s.term.dumpScreen(terminalDumpFormat(protocol.DumpPlain))
+	if err != nil {
+		finishWatches(watches, watchResult{err: err})
+		return nil
+	}
+
+	now := time.Now()
+	screen := string(dump.Data)
+	atPrompt := s.commands.atPrompt()
+	kept := watches[:0]
+	for _, w := range watches {
+		if w.observe(screen, atPrompt, now) {
+			w.result <- watchResult{matched: true}
+			continue
+		}
+		kept = append(kept, w)
+	}
+	return kept
+}
+
+func finishWatches(watches []*screenWatch, res watchResult) {
+	for _, w := range watches {
+		w.result <- res
+	}
+}
+
+func removeWatch(watches []*screenWatch, target *screenWatch) []*screenWatch {
+	for i, w := range watches {
+		if w == target {
+			return append(watches[:i], watches[i+1:]...)
+		}
+	}
+	return watches
+}
+
+func nextStableDeadline(watches []*screenWatch) (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, w := range watches {
+		deadline, ok := w.stableDeadline()
+		if !ok {
+			continue
+		}
+		if !found || deadline.Before(next) {
+			next = deadline
+			found = true
+		}
+	}
+	return next, found
+}
+
+// armStableTimer points timer at the earliest stable deadline, returning
+// a nil channel when no watch is waiting on stability alone.
+func armStableTimer(timer *time.Timer, watches []*screenWatch) (*time.Timer, <-chan time.Time) {
+	if timer != nil {
+		timer.Stop()
+	}
+	deadline, ok := nextStableDeadline(watches)
+	if !ok {
+		return timer, nil
+	}
+	if timer == nil {
+		timer = time.NewTimer(time.Until(deadline))
+	} else {
+		timer.Reset(time.Until(deadline))
+	}
+	return timer, timer.C
+}
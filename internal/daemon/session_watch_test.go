@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/internal/protocol"
+	"gotest.tools/v3/assert"
+)
+
+func TestCompileScreenMatcher(t *testing.T) {
+	match, err := compileScreenMatcher("ready", false)
+	assert.NilError(t, err)
+	assert.Equal(t, match("daemon ready"), true)
+	assert.Equal(t, match("daemon waiting"), false)
+
+	match, err = compileScreenMatcher("^ready-[0-9]+$", true)
+	assert.NilError(t, err)
+	assert.Equal(t, match("ready-42"), true)
+	assert.Equal(t, match("ready-now"), false)
+
+	_, err = compileScreenMatcher("(", true)
+	assert.Error(t, err, "invalid regex: error parsing regexp: missing closing ): `(`")
+}
+
+func TestScreenRowContent(t *testing.T) {
+	content := "alpha\nbeta\ngamma"
+
+	assert.Equal(t, screenRowContent(content, -1), "alpha\nbeta\ngamma")
+	assert.Equal(t, screenRowContent(content, 0), "alpha")
+	assert.Equal(t, screenRowContent(content, 1), "beta")
+	assert.Equal(t, screenRowContent(content, 9), "")
+}
+
+func TestScreenWatchStableRequiresQuietPeriod(t *testing.T) {
+	w, err := newScreenWatch(&protocol.Watch{Pattern: "ready", Row: 1, Stable: 100})
+	assert.NilError(t, err)
+
+	start := time.Unix(1700000000, 0)
+	assert.Equal(t, w.observe("prompt\nready", false, start), false)
+
+	deadline, ok := w.stableDeadline()
+	assert.Equal(t, ok, true)
+	assert.Equal(t, deadline, start.Add(100*time.Millisecond))
+
+	// Changes outside the watched row do not restart the quiet period.
+	assert.Equal(t, w.observe("other\nready", false, start.Add(50*time.Millisecond)), false)
+	assert.Equal(t, w.observe("other\nready", false, start.Add(100*time.Millisecond)), true)
+
+	assert.Equal(t, w.observe("other\nready!", false, start.Add(150*time.Millisecond)), false)
+	deadline, ok = w.stableDeadline()
+	assert.Equal(t, ok, true)
+	assert.Equal(t, deadline, start.Add(250*time.Millisecond))
+}
+
+func TestSessionWatchMatchesFedOutput(t *testing.T) {
+	s := newSessionLoopHarness(t)
+
+	w, err := newScreenWatch(&protocol.Watch{Pattern: "ready-[0-9]+", Regex: true, Row: -1})
+	assert.NilError(t, err)
+
+	type result struct {
+		matched bool
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+		defer cancel()
+		matched, err := s.watch(ctx, w)
+		done <- result{matched: matched, err: err}
+	}()
+
+	s.ptyOut <- []byte("booting\r\n")
+	s.ptyOut <- []byte("ready-42\r\n")
+
+	select {
+	case res := <-done:
+		assert.NilError(t, res.err)
+		assert.Equal(t, res.matched, true)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not return after matching output")
+	}
+}
+
+func TestSessionWatchTimesOut(t *testing.T) {
+	s := newSessionLoopHarness(t)
+
+	w, err := newScreenWatch(&protocol.Watch{Pattern: "never", Row: -1})
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	matched, err := s.watch(ctx, w)
+	assert.NilError(t, err)
+	assert.Equal(t, matched, false)
+}
+
+func TestSessionWatchWaitsForStableScreen(t *testing.T) {
+	s := newSessionLoopHarness(t)
+
+	s.ptyOut <- []byte("settled")
+
+	w, err := newScreenWatch(&protocol.Watch{Row: -1, Stable: 50})
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	matched, err := s.watch(ctx, w)
+	assert.NilError(t, err)
+	assert.Equal(t, matched, true)
+	assert.Assert(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, w.content, "settled")
+}
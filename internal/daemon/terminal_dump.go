@@ -0,0 +1,229 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"code.selman.me/hauntty/libghostty"
+)
+
+// dumpRangeRows is about how many screen rows a streamed dump renders
+// at a time.
+var dumpRangeRows uint32 = 1024
+
+// htmlDumpBody opens the element libghostty wraps an HTML dump's rows
+// in; the palette style comes before it and its closing tag ends the
+// dump.
+const (
+	htmlDumpBody    = `<div style="font-family: monospace; white-space: pre;">`
+	htmlDumpBodyEnd = `</div>`
+)
+
+// writeDump renders format to w. A scrollback dump is rendered a range
+// of rows at a time and each range is written as soon as it is ready,
+// so the whole dump is never held in memory. The terminal is unlocked
+// between ranges: the dump ends at the row that was last when it
+// started, and rows trimmed from the scrollback meanwhile are skipped.
+func (t *terminalState) writeDump(w io.Writer, format terminalFormat) error {
+	if !format.scrollback || format.rows != nil {
+		dump, err := t.dumpScreen(format)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(dump.Data)
+		return err
+	}
+
+	var next, end *libghostty.TrackedGridRef
+	defer func() {
+		t.mu.Lock()
+		// A closed terminal took its tracked refs with it.
+		if !t.closed {
+			next.Close()
+			end.Close()
+		}
+		t.mu.Unlock()
+	}()
+	var tail []byte
+	for first := true; ; first = false {
+		r, err := t.nextDumpRange(next, end, format)
+		if err != nil {
+			return err
+		}
+		next = r.next
+		if first {
+			end = r.end
+		}
+		var out []byte
+		if first {
+			out, tail = r.head, r.tail
+		}
+		if len(r.body) > 0 {
+			if !first {
+				out = append(out, dumpLineSeparator(format)...)
+			}
+			out = append(out, r.body...)
+		}
+		if r.next == nil {
+			out = append(out, tail...)
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+		if r.next == nil {
+			return nil
+		}
+	}
+}
+
+type dumpRange struct {
+	// head and tail frame the whole dump; they are set on the first
+	// range only.
+	head, tail []byte
+	body       []byte
+	// next tracks the first row of the next range; nil after the last.
+	next *libghostty.TrackedGridRef
+	// end tracks the last row of the dump; set on the first range only.
+	end *libghostty.TrackedGridRef
+}
+
+// nextDumpRange renders the rows from start up to a row where the dump
+// can be split, going no further than end. It closes start. With no
+// end, it renders the first range from the oldest row and tracks the
+// current last row as the end of the dump.
+func (t *terminalState) nextDumpRange(start, end *libghostty.TrackedGridRef, format terminalFormat) (dumpRange, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return dumpRange{}, fmt.Errorf("session closed")
+	}
+
+	var from uint32
+	if start != nil {
+		point, ok, err := start.Point(libghostty.PointTagScreen)
+		start.Close()
+		if err != nil {
+			return dumpRange{}, err
+		}
+		// Otherwise the row was trimmed; resume at the oldest one left.
+		if ok {
+			from = point.Y
+		}
+	}
+	first := end == nil
+	total := t.screenRowsLocked()
+	if !first {
+		point, ok, err := end.Point(libghostty.PointTagScreen)
+		if err != nil {
+			return dumpRange{}, err
+		}
+		// A trimmed end row took every row of the dump still to come
+		// with it.
+		if !ok || point.Y < from {
+			return dumpRange{}, nil
+		}
+		total = point.Y + 1
+	}
+	to, err := t.dumpSplitLocked(from, total, format.unwrap)
+	if err != nil {
+		return dumpRange{}, err
+	}
+
+	last := to == total-1
+	rangeFormat := format
+	rangeFormat.rows = &screenRows{first: from, last: to}
+	rangeFormat.safe = false
+	dump, err := t.dumpScreenLocked(rangeFormat)
+	if err != nil {
+		return dumpRange{}, err
+	}
+	r := dumpRange{body: dump.Data}
+	if format.emit == libghostty.FormatterFormatHTML {
+		head, body, ok := bytes.Cut(r.body, []byte(htmlDumpBody))
+		body, found := bytes.CutSuffix(body, []byte(htmlDumpBodyEnd))
+		if !ok || !found {
+			return dumpRange{}, fmt.Errorf("unexpected HTML dump framing")
+		}
+		r.body = body
+		if first {
+			r.head = append(head, htmlDumpBody...)
+			r.tail = []byte(htmlDumpBodyEnd)
+		}
+	}
+	if first && format.safe {
+		r.tail = append(r.tail, "\x1b[0m"...)
+	}
+	if last {
+		return r, nil
+	}
+	if first {
+		if r.end, err = t.term.TrackGridRef(libghostty.Point{Tag: libghostty.PointTagScreen, Y: total - 1}); err != nil {
+			return dumpRange{}, err
+		}
+	}
+	if r.next, err = t.term.TrackGridRef(libghostty.Point{Tag: libghostty.PointTagScreen, Y: to + 1}); err != nil {
+		r.end.Close()
+		return dumpRange{}, err
+	}
+	return r, nil
+}
+
+// dumpSplitLocked picks the last row of the range starting at from:
+// about dumpRangeRows rows on, at a row the dump can be split after.
+// The formatter drops a selection's trailing blank rows and a
+// soft-wrapped row joins the next one when unwrapping, so a split row
+// has text and, when unwrapping, ends its line.
+func (t *terminalState) dumpSplitLocked(from, total uint32, unwrap bool) (uint32, error) {
+	target := from + dumpRangeRows - 1
+	if target >= total-1 {
+		return total - 1, nil
+	}
+	for row := target; row >= from; row-- {
+		ok, err := t.dumpSplitsAfterLocked(row, unwrap)
+		if err != nil || ok {
+			return row, err
+		}
+		if row == 0 {
+			break
+		}
+	}
+	for row := target + 1; row < total-1; row++ {
+		ok, err := t.dumpSplitsAfterLocked(row, unwrap)
+		if err != nil || ok {
+			return row, err
+		}
+	}
+	return total - 1, nil
+}
+
+func (t *terminalState) dumpSplitsAfterLocked(row uint32, unwrap bool) (bool, error) {
+	text, err := t.rowsTextLocked(row, row, false)
+	if err != nil || len(text) == 0 || !unwrap {
+		return len(text) > 0, err
+	}
+	// The next row's text shows up on its own line only when this row
+	// ends its line and the next row has text.
+	text, err = t.rowsTextLocked(row, row+1, true)
+	return strings.Contains(text, "\n"), err
+}
+
+func (t *terminalState) rowsTextLocked(first, last uint32, unwrap bool) (string, error) {
+	dump, err := t.dumpScreenLocked(terminalFormat{
+		emit:   libghostty.FormatterFormatPlain,
+		unwrap: unwrap,
+		rows:   &screenRows{first: first, last: last},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(dump.Data), nil
+}
+
+func dumpLineSeparator(format terminalFormat) string {
+	if format.emit == libghostty.FormatterFormatVT {
+		return "\r\n"
+	}
+	return "\n"
+}
@@ -0,0 +1,126 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"code.selman.me/hauntty/internal/protocol"
+	"code.selman.me/hauntty/libghostty"
+	"gotest.tools/v3/assert"
+)
+
+func setDumpRangeRows(t *testing.T, rows uint32) {
+	old := dumpRangeRows
+	dumpRangeRows = rows
+	t.Cleanup(func() { dumpRangeRows = old })
+}
+
+func TestWriteDumpMatchesWholeDump(t *testing.T) {
+	setDumpRangeRows(t, 4)
+	term, err := newTerminalState(20, 6, 1000)
+	assert.NilError(t, err)
+	defer term.close()
+
+	var out strings.Builder
+	for i := range 60 {
+		switch i % 9 {
+		case 1, 2:
+			out.WriteString("\r\n")
+		case 3:
+			fmt.Fprintf(&out, "\x1b[31mred %d", i)
+		case 4:
+			fmt.Fprintf(&out, " still red %d\x1b[0m\r\n", i)
+		case 5:
+			out.WriteString(strings.Repeat("w", 40) + "\r\n")
+		case 6:
+			out.WriteString(strings.Repeat("v", 20) + "\r\n\r\n")
+		case 7:
+			fmt.Fprintf(&out, "\x1b[44mbg %d   \x1b[0m\r\n", i)
+		default:
+			fmt.Fprintf(&out, "line %d  \r\n", i)
+		}
+	}
+	out.WriteString("\r\n\r\n$ ")
+	term.feed([]byte(out.String()))
+
+	for _, format := range []protocol.DumpFormat{protocol.DumpPlain, protocol.DumpVT, protocol.DumpHTML} {
+		for _, flags := range []protocol.DumpFormat{protocol.DumpFlagScrollback, protocol.DumpFlagScrollback | protocol.DumpFlagUnwrap} {
+			tf := terminalDumpFormat(format | flags)
+			whole, err := term.dumpScreen(tf)
+			assert.NilError(t, err)
+			var got bytes.Buffer
+			assert.NilError(t, term.writeDump(&got, tf))
+			assert.Equal(t, got.String(), string(whole.Data), "format %d flags %#x", format, flags)
+		}
+	}
+}
+
+func TestWriteDumpWritesEachRange(t *testing.T) {
+	setDumpRangeRows(t, 10)
+	term, err := newTerminalState(20, 5, 1000)
+	assert.NilError(t, err)
+	defer term.close()
+	for i := range 45 {
+		term.feed(fmt.Appendf(nil, "line %d\r\n", i))
+	}
+
+	var writes []string
+	w := writerFunc(func(p []byte) (int, error) {
+		writes = append(writes, string(p))
+		return len(p), nil
+	})
+	assert.NilError(t, term.writeDump(w, terminalFormat{emit: libghostty.FormatterFormatPlain, scrollback: true}))
+	assert.Equal(t, len(writes), 5)
+	assert.Equal(t, writes[0], "line 0\nline 1\nline 2\nline 3\nline 4\nline 5\nline 6\nline 7\nline 8\nline 9")
+	assert.Equal(t, writes[4], "\nline 40\nline 41\nline 42\nline 43\nline 44")
+}
+
+func TestWriteDumpEndsAtLastRowWhenStarted(t *testing.T) {
+	setDumpRangeRows(t, 5)
+	term, err := newTerminalState(20, 5, 1000)
+	assert.NilError(t, err)
+	defer term.close()
+	for i := range 30 {
+		term.feed(fmt.Appendf(nil, "old %d\r\n", i))
+	}
+
+	// Output keeps arriving while the dump is written.
+	var got bytes.Buffer
+	w := writerFunc(func(p []byte) (int, error) {
+		term.feed([]byte("new\r\n"))
+		return got.Write(p)
+	})
+	assert.NilError(t, term.writeDump(w, terminalFormat{emit: libghostty.FormatterFormatPlain, scrollback: true}))
+	lines := strings.Split(got.String(), "\n")
+	// The cursor's row was last, and the first new line went there.
+	assert.Equal(t, len(lines), 31)
+	assert.Equal(t, lines[29], "old 29")
+	assert.Equal(t, lines[30], "new")
+}
+
+func TestWriteDumpSkipsTrimmedRows(t *testing.T) {
+	setDumpRangeRows(t, 5)
+	term, err := newTerminalState(20, 5, 0)
+	assert.NilError(t, err)
+	defer term.close()
+	for i := range 30 {
+		term.feed(fmt.Appendf(nil, "old %d\r\n", i))
+	}
+
+	// Output scrolling the rows the dump has yet to reach out of the
+	// scrollback ends it early.
+	var got bytes.Buffer
+	w := writerFunc(func(p []byte) (int, error) {
+		term.feed([]byte(strings.Repeat("new\r\n", 10000)))
+		return got.Write(p)
+	})
+	assert.NilError(t, term.writeDump(w, terminalFormat{emit: libghostty.FormatterFormatPlain, scrollback: true}))
+	assert.Assert(t, strings.HasPrefix(got.String(), "old "), got.String())
+	assert.Assert(t, !strings.Contains(got.String(), "new"), got.String())
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -1,7 +1,9 @@
 package daemon
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net/url"
 	"strings"
 	"sync"
@@ -13,7 +15,9 @@ import (
 const continuationMaxBytes = 65 << 20
 
 type terminalState struct {
-	mu         sync.Mutex
+	mu sync.Mutex
+	// closed is set once term is freed.
+	closed     bool
 	term       *libghostty.Terminal
 	keyEncoder *libghostty.KeyEncoder
 	keyEvent   *libghostty.KeyEvent
@@ -32,6 +36,14 @@ type terminalFormat struct {
 	scrollback bool
 	full       bool
 	safe       bool
+	// rows, when set, limits the dump to these screen rows.
+	rows *screenRows
+}
+
+// screenRows is an inclusive range of screen rows, counted from the
+// oldest scrollback row.
+type screenRows struct {
+	first, last uint32
 }
 
 var terminalFormatVTFull = terminalFormat{
@@ -40,6 +52,12 @@ var terminalFormatVTFull = terminalFormat{
 	full:       true,
 }
 
+// terminalFormatVTScreen is terminalFormatVTFull without the scrollback.
+var terminalFormatVTScreen = terminalFormat{
+	emit: libghostty.FormatterFormatVT,
+	full: true,
+}
+
 func newTerminalState(cols, rows, scrollback uint32) (*terminalState, error) {
 	term, err := libghostty.NewTerminal(
 		libghostty.WithSize(uint16(cols), uint16(rows)),
@@ -53,12 +71,12 @@ func newTerminalState(cols, rows, scrollback uint32) (*terminalState, error) {
 }
 
 func wrapTerminalState(term *libghostty.Terminal) (*terminalState, error) {
-	encoder, err := libghostty.NewKeyEncoder()
+	encoder, err := libghostty.NewKeyEncoder(term)
 	if err != nil {
 		term.Close()
 		return nil, err
 	}
-	event, err := libghostty.NewKeyEvent()
+	event, err := libghostty.NewKeyEvent(term)
 	if err != nil {
 		encoder.Close()
 		term.Close()
@@ -67,28 +85,11 @@ func wrapTerminalState(term *libghostty.Terminal) (*terminalState, error) {
 	return &terminalState{term: term, keyEncoder: encoder, keyEvent: event}, nil
 }
 
+// restoreTerminalState rebuilds a saved terminal for a new process:
+// it cancels a sequence the old process left unfinished and resets the
+// screen and modes that program had set up.
 func restoreTerminalState(state *sessionState, size termSize, scrollback uint32) (*terminalState, error) {
-	decoder, err := libghostty.NewSnapshotDecoderBytes(state.Snapshot)
-	if err != nil {
-		return nil, err
-	}
-	defer decoder.Close()
-	if err := decoder.SetMaxContinuationBytes(continuationMaxBytes); err != nil {
-		return nil, err
-	}
-	if err := decoder.SetRetainContinuation(true); err != nil {
-		return nil, err
-	}
-	restored, err := decoder.Decode()
-	if err != nil {
-		return nil, err
-	}
-	scrollbackLimit := uint(scrollback)
-	if err := restored.SetScrollbackMaxLines(&scrollbackLimit); err != nil {
-		restored.Close()
-		return nil, err
-	}
-	term, err := wrapTerminalState(restored)
+	term, err := decodeTerminalState(state, scrollback, true)
 	if err != nil {
 		return nil, err
 	}
@@ -137,29 +138,46 @@ func (t *terminalState) resize(cols, rows uint32) error {
 func (t *terminalState) dumpScreen(format terminalFormat) (*screenDump, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	return t.dumpScreenLocked(format)
+}
+
+func (t *terminalState) dumpScreenLocked(format terminalFormat) (*screenDump, error) {
 	options := []libghostty.FormatterOption{
 		libghostty.WithFormatterFormat(format.emit),
 		libghostty.WithFormatterUnwrap(format.unwrap),
 		libghostty.WithFormatterTrim(true),
 	}
-	if !format.full && !format.scrollback {
+	switch {
+	case format.rows != nil:
 		cols, err := t.term.Cols()
 		if err != nil {
 			return nil, err
 		}
-		rows, err := t.term.Rows()
+		selection, err := t.selectionLocked(
+			libghostty.Point{Tag: libghostty.PointTagScreen, Y: format.rows.first},
+			libghostty.Point{Tag: libghostty.PointTagScreen, X: cols - 1, Y: format.rows.last},
+		)
 		if err != nil {
 			return nil, err
 		}
-		start, err := t.term.GridRef(libghostty.Point{Tag: libghostty.PointTagActive})
+		options = append(options, libghostty.WithFormatterSelection(selection))
+	case !format.scrollback:
+		cols, err := t.term.Cols()
 		if err != nil {
 			return nil, err
 		}
-		end, err := t.term.GridRef(libghostty.Point{Tag: libghostty.PointTagActive, X: cols - 1, Y: uint32(rows - 1)})
+		rows, err := t.term.Rows()
 		if err != nil {
 			return nil, err
 		}
-		options = append(options, libghostty.WithFormatterSelection(&libghostty.Selection{Start: *start, End: *end}))
+		selection, err := t.selectionLocked(
+			libghostty.Point{Tag: libghostty.PointTagActive},
+			libghostty.Point{Tag: libghostty.PointTagActive, X: cols - 1, Y: uint32(rows - 1)},
+		)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, libghostty.WithFormatterSelection(selection))
 	}
 	if format.emit == libghostty.FormatterFormatHTML {
 		options = append(options, libghostty.WithFormatterExtraPalette(true))
@@ -211,6 +229,137 @@ func (t *terminalState) dumpScreen(format terminalFormat) (*screenDump, error) {
 	}, nil
 }
 
+func (t *terminalState) selectionLocked(start, end libghostty.Point) (*libghostty.Selection, error) {
+	startRef, err := t.term.GridRef(start)
+	if err != nil {
+		return nil, err
+	}
+	endRef, err := t.term.GridRef(end)
+	if err != nil {
+		return nil, err
+	}
+	return &libghostty.Selection{Start: *startRef, End: *endRef}, nil
+}
+
+// takeHistory renders the rows that have scrolled off the active screen
+// as plain text and then erases them, so each row is returned once.
+func (t *terminalState) takeHistory() ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cols, err := t.term.Cols()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := t.term.Rows()
+	if err != nil {
+		return nil, err
+	}
+	history := t.screenRowsLocked() - uint32(rows)
+	if history == 0 {
+		return nil, nil
+	}
+	start, err := t.term.GridRef(libghostty.Point{Tag: libghostty.PointTagHistory})
+	if err != nil {
+		return nil, err
+	}
+	end, err := t.term.GridRef(libghostty.Point{Tag: libghostty.PointTagHistory, X: cols - 1, Y: history - 1})
+	if err != nil {
+		return nil, err
+	}
+	formatter, err := libghostty.NewFormatter(t.term,
+		libghostty.WithFormatterFormat(libghostty.FormatterFormatPlain),
+		libghostty.WithFormatterUnwrap(true),
+		libghostty.WithFormatterTrim(true),
+		libghostty.WithFormatterSelection(&libghostty.Selection{Start: *start, End: *end}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer formatter.Close()
+	data, err := formatter.Format()
+	if err != nil {
+		return nil, err
+	}
+	// ED 3 erases scrollback without touching the active screen.
+	t.term.VTWrite([]byte("\x1b[3J"))
+	return append(data, '\n'), nil
+}
+
+// screenRowsLocked counts history plus active rows by probing for the
+// last valid screen point.
+func (t *terminalState) screenRowsLocked() uint32 {
+	valid := func(y uint32) bool {
+		_, err := t.term.GridRef(libghostty.Point{Tag: libghostty.PointTagScreen, Y: y})
+		return err == nil
+	}
+	hi := uint32(1)
+	for valid(hi) {
+		hi *= 2
+	}
+	lo := hi / 2
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		if valid(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo + 1
+}
+
+type searchHit struct {
+	line uint32
+	text string
+}
+
+// search matches each unwrapped line of scrollback and screen, from the
+// oldest history row. It renders the lines a range of rows at a time,
+// as a streamed dump does, and matches each range with the terminal
+// unlocked. Lines are numbered from 1.
+func (t *terminalState) search(match func(string) bool) ([]searchHit, error) {
+	w := &searchWriter{match: match}
+	if err := t.writeDump(w, terminalFormat{
+		emit:       libghostty.FormatterFormatPlain,
+		unwrap:     true,
+		scrollback: true,
+	}); err != nil {
+		return nil, err
+	}
+	w.matchLine(w.partial)
+	return w.hits, nil
+}
+
+// searchWriter matches the lines of a plain dump as it is written. A
+// line split across writes waits in partial for the rest.
+type searchWriter struct {
+	match   func(string) bool
+	partial []byte
+	line    uint32
+	hits    []searchHit
+}
+
+func (w *searchWriter) Write(p []byte) (int, error) {
+	data := append(w.partial, p...)
+	for {
+		line, rest, ok := bytes.Cut(data, []byte("\n"))
+		if !ok {
+			break
+		}
+		w.matchLine(line)
+		data = rest
+	}
+	w.partial = bytes.Clone(data)
+	return len(p), nil
+}
+
+func (w *searchWriter) matchLine(line []byte) {
+	w.line++
+	if text := string(line); w.match(text) {
+		w.hits = append(w.hits, searchHit{line: w.line, text: text})
+	}
+}
+
 func (t *terminalState) snapshot() ([]byte, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -237,7 +386,9 @@ func (t *terminalState) encodeClientKey(keyCode protocol.KeyCode, mods protocol.
 	t.keyEvent.SetComposing(false)
 	t.keyEvent.SetUnshiftedCodepoint(rune(codepoint))
 	t.keyEvent.SetUTF8(string(text))
-	t.keyEncoder.SetOptFromTerminal(t.term)
+	if err := t.keyEncoder.SetOptFromTerminal(t.term); err != nil {
+		return nil, err
+	}
 	return t.keyEncoder.Encode(t.keyEvent)
 }
 
@@ -255,49 +406,201 @@ func (t *terminalState) cwd() (string, bool, error) {
 	return raw, true, nil
 }
 
+// trackCursor starts tracking the cell under the cursor and returns its
+// screen position.
+func (t *terminalState) trackCursor() (*libghostty.TrackedGridRef, libghostty.Point, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	col, err := t.term.CursorX()
+	if err != nil {
+		return nil, libghostty.Point{}, err
+	}
+	row, err := t.term.CursorY()
+	if err != nil {
+		return nil, libghostty.Point{}, err
+	}
+	ref, err := t.term.TrackGridRef(libghostty.Point{Tag: libghostty.PointTagActive, X: col, Y: uint32(row)})
+	if err != nil {
+		return nil, libghostty.Point{}, err
+	}
+	point, ok, err := ref.Point(libghostty.PointTagScreen)
+	if err == nil && !ok {
+		err = fmt.Errorf("cursor is off the screen")
+	}
+	if err != nil {
+		ref.Close()
+		return nil, libghostty.Point{}, err
+	}
+	return ref, point, nil
+}
+
+// screenText renders the screen cells from start up to, but not
+// including, end as plain text with soft-wrapped lines joined.
+func (t *terminalState) screenText(start, end libghostty.Point) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case end.X > 0:
+		end.X--
+	case end.Y > 0:
+		cols, err := t.term.Cols()
+		if err != nil {
+			return "", err
+		}
+		end.X, end.Y = cols-1, end.Y-1
+	default:
+		return "", nil
+	}
+	if end.Y < start.Y || end.Y == start.Y && end.X < start.X {
+		return "", nil
+	}
+	selection, err := t.selectionLocked(start, end)
+	if err != nil {
+		return "", err
+	}
+	formatter, err := libghostty.NewFormatter(t.term,
+		libghostty.WithFormatterFormat(libghostty.FormatterFormatPlain),
+		libghostty.WithFormatterUnwrap(true),
+		libghostty.WithFormatterTrim(true),
+		libghostty.WithFormatterSelection(selection),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer formatter.Close()
+	data, err := formatter.Format()
+	return string(data), err
+}
+
+// cursor returns the 0-based cursor position on the active screen.
+func (t *terminalState) cursor() (row, col uint16, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if row, err = t.term.CursorY(); err != nil {
+		return 0, 0, err
+	}
+	col, err = t.term.CursorX()
+	return row, col, err
+}
+
 func (t *terminalState) vtGround() (bool, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	return t.term.VTGround()
 }
 
+// mode reports whether mode is set; known is false for modes the
+// terminal does not implement.
+func (t *terminalState) mode(mode libghostty.Mode) (set, known bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.term.Mode(mode)
+}
+
+// colors returns the palette and the foreground and background colors.
+// The foreground and background are palette entries 7 and 0 until a
+// program sets them.
+func (t *terminalState) colors() (palette [256]libghostty.RGB, fg, bg libghostty.RGB, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if palette, err = t.term.Palette(); err != nil {
+		return palette, fg, bg, err
+	}
+	fg, ok, err := t.term.Foreground()
+	if err != nil {
+		return palette, fg, bg, err
+	}
+	if !ok {
+		fg = palette[7]
+	}
+	bg, ok, err = t.term.Background()
+	if err != nil {
+		return palette, fg, bg, err
+	}
+	if !ok {
+		bg = palette[0]
+	}
+	return palette, fg, bg, nil
+}
+
 func (t *terminalState) close() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.keyEvent.Close()
 	t.keyEncoder.Close()
 	t.term.Close()
+	t.closed = true
+}
+
+func dumpDeadTerminalState(w io.Writer, state *sessionState, scrollback uint32, format protocol.DumpFormat) error {
+	term, err := decodeDeadTerminalState(state, scrollback)
+	if err != nil {
+		return fmt.Errorf("dump dead terminal state: restore terminal: %w", err)
+	}
+	defer term.close()
+
+	if err := term.writeDump(w, terminalDumpFormat(format)); err != nil {
+		return fmt.Errorf("dump dead terminal state: dump screen: %w", err)
+	}
+	return nil
+}
+
+func searchDeadTerminalState(state *sessionState, scrollback uint32, match func(string) bool) ([]searchHit, error) {
+	term, err := decodeDeadTerminalState(state, scrollback)
+	if err != nil {
+		return nil, fmt.Errorf("search dead terminal state: restore terminal: %w", err)
+	}
+	defer term.close()
+	return term.search(match)
+}
+
+// decodeDeadTerminalState rebuilds a dead session's terminal as saved,
+// without the fixups restoreTerminalState applies to resume it.
+func decodeDeadTerminalState(state *sessionState, scrollback uint32) (*terminalState, error) {
+	return decodeTerminalState(state, scrollback, false)
 }
 
-func dumpDeadTerminalState(state *sessionState, scrollback uint32, format protocol.DumpFormat) ([]byte, error) {
+// adoptTerminalState rebuilds the terminal of a session handed over on
+// upgrade. Its process keeps running, so the screen, modes and any
+// sequence cut off by the snapshot are kept as they were.
+func adoptTerminalState(state *sessionState, size termSize, scrollback uint32) (*terminalState, error) {
+	term, err := decodeTerminalState(state, scrollback, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := term.resize(uint32(size.cols), uint32(size.rows)); err != nil {
+		term.close()
+		return nil, fmt.Errorf("resize adopted terminal state: %w", err)
+	}
+	return term, nil
+}
+
+// decodeTerminalState decodes state's snapshot. With retain set, a
+// sequence the snapshot cut off stays pending for the next output.
+func decodeTerminalState(state *sessionState, scrollback uint32, retain bool) (*terminalState, error) {
 	decoder, err := libghostty.NewSnapshotDecoderBytes(state.Snapshot)
 	if err != nil {
-		return nil, fmt.Errorf("dump dead terminal state: restore terminal: %w", err)
+		return nil, err
 	}
 	defer decoder.Close()
 	if err := decoder.SetMaxContinuationBytes(continuationMaxBytes); err != nil {
-		return nil, fmt.Errorf("dump dead terminal state: restore terminal: %w", err)
+		return nil, err
+	}
+	if retain {
+		if err := decoder.SetRetainContinuation(true); err != nil {
+			return nil, err
+		}
 	}
 	restored, err := decoder.Decode()
 	if err != nil {
-		return nil, fmt.Errorf("dump dead terminal state: restore terminal: %w", err)
+		return nil, err
 	}
 	scrollbackLimit := uint(scrollback)
 	if err := restored.SetScrollbackMaxLines(&scrollbackLimit); err != nil {
 		restored.Close()
-		return nil, fmt.Errorf("dump dead terminal state: restore terminal: %w", err)
-	}
-	term, err := wrapTerminalState(restored)
-	if err != nil {
-		return nil, fmt.Errorf("dump dead terminal state: restore terminal: %w", err)
-	}
-	defer term.close()
-
-	dump, err := term.dumpScreen(terminalDumpFormat(format))
-	if err != nil {
-		return nil, fmt.Errorf("dump dead terminal state: dump screen: %w", err)
+		return nil, err
 	}
-	return dump.Data, nil
+	return wrapTerminalState(restored)
 }
 
 func terminalDumpFormat(format protocol.DumpFormat) terminalFormat {
@@ -1,6 +1,9 @@
 package daemon
 
 import (
+	"bytes"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,9 +15,9 @@ import (
 func TestDumpDeadTerminalStateRestoresSnapshot(t *testing.T) {
 	state := snapshotSessionState(t, 20, 5, time.Unix(1700000000, 0), []byte("hello\r\nworld"))
 
-	data, err := dumpDeadTerminalState(state, 0, protocol.DumpPlain)
-	assert.NilError(t, err)
-	assert.DeepEqual(t, data, []byte("hello\nworld"))
+	var out bytes.Buffer
+	assert.NilError(t, dumpDeadTerminalState(&out, state, 0, protocol.DumpPlain))
+	assert.Equal(t, out.String(), "hello\nworld")
 }
 
 func TestRestoreTerminalStateResizesToRequestedSize(t *testing.T) {
@@ -78,3 +81,88 @@ func TestRestoreTerminalStateExitsAltScreen(t *testing.T) {
 		IsAltScreen: false,
 	})
 }
+
+func TestAdoptTerminalStateKeepsScreenAndContinuation(t *testing.T) {
+	state := snapshotSessionState(t, 20, 5, time.Unix(1700000000, 0), []byte("\x1b[?1049halt\x1b[31"))
+
+	term, err := adoptTerminalState(state, termSize{cols: 20, rows: 5}, 0)
+	assert.NilError(t, err)
+	defer term.close()
+
+	term.feed([]byte("mred"))
+	dump, err := term.dumpScreen(terminalFormat{emit: libghostty.FormatterFormatPlain})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, dump, &screenDump{
+		Data:        []byte("altred"),
+		CursorRow:   0,
+		CursorCol:   6,
+		IsAltScreen: true,
+	})
+}
+
+func TestTerminalFormatVTScreenLeavesOutScrollback(t *testing.T) {
+	term, err := newTerminalState(20, 3, 10000)
+	assert.NilError(t, err)
+	defer term.close()
+
+	term.feed([]byte("old\r\none\r\ntwo\r\nthree"))
+	full, err := term.dumpScreen(terminalFormatVTFull)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(full.Data), "old"))
+	screen, err := term.dumpScreen(terminalFormatVTScreen)
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(screen.Data), "old"), "%q", screen.Data)
+	assert.Assert(t, strings.Contains(string(screen.Data), "three"), "%q", screen.Data)
+}
+
+func TestTerminalStateSearchNumbersHistoryLines(t *testing.T) {
+	term, err := newTerminalState(20, 3, 100)
+	assert.NilError(t, err)
+	defer term.close()
+
+	term.feed([]byte("match one\r\nskip\r\nmatch two\r\nskip\r\nmatch three"))
+	hits, err := term.search(func(line string) bool { return strings.HasPrefix(line, "match") })
+	assert.NilError(t, err)
+	assert.Equal(t, len(hits), 3)
+	assert.Equal(t, hits[0], searchHit{line: 1, text: "match one"})
+	assert.Equal(t, hits[1], searchHit{line: 3, text: "match two"})
+	assert.Equal(t, hits[2], searchHit{line: 5, text: "match three"})
+}
+
+func TestTerminalStateSearchMatchesRangeByRange(t *testing.T) {
+	setDumpRangeRows(t, 4)
+	term, err := newTerminalState(10, 3, 1000)
+	assert.NilError(t, err)
+	defer term.close()
+	for i := range 30 {
+		switch i % 3 {
+		case 0:
+			term.feed(fmt.Appendf(nil, "match %d %s\r\n", i, strings.Repeat("w", 15)))
+		case 1:
+			term.feed([]byte("\r\n"))
+		default:
+			term.feed(fmt.Appendf(nil, "skip %d\r\n", i))
+		}
+	}
+
+	whole, err := term.dumpScreen(terminalFormat{emit: libghostty.FormatterFormatPlain, unwrap: true, scrollback: true})
+	assert.NilError(t, err)
+	var want []searchHit
+	for i, line := range strings.Split(string(whole.Data), "\n") {
+		if strings.HasPrefix(line, "match") {
+			want = append(want, searchHit{line: uint32(i + 1), text: line})
+		}
+	}
+	assert.Equal(t, len(want), 10)
+
+	// Matching runs with the terminal unlocked, so output keeps flowing.
+	hits, err := term.search(func(line string) bool {
+		term.feed([]byte("x"))
+		return strings.HasPrefix(line, "match")
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, len(hits), len(want))
+	for i := range want {
+		assert.Equal(t, hits[i], want[i])
+	}
+}
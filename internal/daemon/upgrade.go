@@ -0,0 +1,507 @@
+package daemon
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
+	"golang.org/x/sys/unix"
+)
+
+// handoverVersion identifies the handover format. Bump it with any
+// change an older daemon could not read.
+const handoverVersion = 1
+
+// maxHandoverFDs bounds the descriptors sent per message; Linux takes
+// at most 253.
+const maxHandoverFDs = 250
+
+// handover is what an upgrading daemon passes to the binary it execs.
+// It travels as JSON in a file sent ahead of the listener, the lock file
+// and one PTY master per session, in Sessions order.
+type handover struct {
+	Version   int               `json:"version"`
+	StartedAt time.Time         `json:"started_at"`
+	Sessions  []handoverSession `json:"sessions"`
+}
+
+type handoverSession struct {
+	Name      string    `json:"name"`
+	PID       uint32    `json:"pid"`
+	CreatedAt time.Time `json:"created_at"`
+	StartedAt time.Time `json:"started_at"`
+	// State is the terminal snapshot and launch parameters in the state
+	// file format.
+	State        []byte                   `json:"state"`
+	Scrollback   uint32                   `json:"scrollback"`
+	ResizePolicy config.ResizePolicy      `json:"resize_policy"`
+	Restart      handoverRestart          `json:"restart"`
+	Restarts     uint32                   `json:"restarts"`
+	SlowKicks    uint32                   `json:"slow_kicks"`
+	Monitor      protocol.MonitorSettings `json:"monitor"`
+	LogPath      string                   `json:"log_path,omitempty"`
+	LogFormat    protocol.LogFormat       `json:"log_format,omitempty"`
+	RecordPath   string                   `json:"record_path,omitempty"`
+	RecordStart  time.Time                `json:"record_start,omitzero"`
+	TempDir      string                   `json:"temp_dir,omitempty"`
+}
+
+type handoverRestart struct {
+	Mode       protocol.RestartPolicy `json:"mode"`
+	MaxRetries int                    `json:"max_retries"`
+	Backoff    time.Duration          `json:"backoff"`
+	MaxBackoff time.Duration          `json:"max_backoff"`
+}
+
+// freezeReq pauses a session for a daemon upgrade. The run loop stops
+// reading the PTY, applies what it read and replies with a snapshot. It
+// then waits on proceed: true finishes the log and the recording ahead
+// of the exec and replies again, and closing proceed resumes the
+// session. The new daemon appends to both files.
+type freezeReq struct {
+	result  chan<- freezeResp
+	proceed <-chan bool
+}
+
+func (freezeReq) isSessionAction() {}
+
+type freezeResp struct {
+	snapshot  []byte
+	logPath   string
+	logFormat protocol.LogFormat
+	// recordStart is when the recording at recordPath began; event
+	// times are relative to it.
+	recordPath  string
+	recordStart time.Time
+	// exited is set when the process exited before it was paused.
+	exited bool
+	err    error
+}
+
+type frozenSession struct {
+	sess    *Session
+	resp    freezeResp
+	result  chan freezeResp
+	proceed chan bool
+}
+
+func (s *Server) handleUpgrade(conn *protocol.Conn, msg *protocol.Upgrade) {
+	// On success the exec closes conn; there is no reply.
+	if err := s.upgrade(msg.Path); err != nil {
+		s.log.Warn("daemon upgrade failed", "path", msg.Path, "err", err)
+		s.writeError(conn, err.Error())
+	}
+}
+
+// upgrade execs the binary at path in place of this daemon, handing it
+// the listener and every live session. It returns only on failure,
+// with the sessions running on.
+func (s *Server) upgrade(path string) error {
+	if s.upgradeArgs == nil || !s.ownsSocket {
+		return fmt.Errorf("this daemon cannot upgrade in place")
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("upgrade binary %q is not an absolute path", path)
+	}
+	if err := unix.Access(path, unix.X_OK); err != nil {
+		return fmt.Errorf("upgrade binary %s: %w", path, err)
+	}
+	lnFile, err := listenerFile(s.listener)
+	if err != nil {
+		return err
+	}
+	defer lnFile.Close()
+
+	// Holding mu keeps sessions from being created or removed until the
+	// exec.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var frozen []*frozenSession
+	defer func() {
+		for _, f := range frozen {
+			close(f.proceed)
+		}
+	}()
+	for _, name := range slices.Sorted(maps.Keys(s.sessions)) {
+		f, err := freezeSession(s.sessions[name])
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			frozen = append(frozen, f)
+		}
+	}
+
+	h := &handover{Version: handoverVersion, StartedAt: s.startedAt}
+	files := []*os.File{lnFile, s.lockFile}
+	for _, f := range frozen {
+		hs, err := f.handover()
+		if err != nil {
+			return err
+		}
+		h.Sessions = append(h.Sessions, hs)
+		files = append(files, f.sess.process().ptmx)
+	}
+	recv, err := sendHandover(h, files)
+	if err != nil {
+		return err
+	}
+	defer recv.Close()
+
+	for _, f := range frozen {
+		f.proceed <- true
+		<-f.result
+	}
+	fd, err := rawFD(recv)
+	if err != nil {
+		return err
+	}
+	if _, err := unix.FcntlInt(uintptr(fd), unix.F_SETFD, 0); err != nil {
+		return fmt.Errorf("pass handover descriptor: %w", err)
+	}
+	args := append([]string{path}, s.upgradeArgs(fd)...)
+	s.log.Info("upgrading daemon", "path", path, "sessions", len(frozen))
+	err = syscall.Exec(path, args, os.Environ())
+	if len(frozen) > 0 {
+		s.log.Warn("session logs and recordings stopped for the failed upgrade")
+	}
+	return fmt.Errorf("exec %s: %w", path, err)
+}
+
+// freezeSession pauses sess for the upgrade. It returns nil for a
+// session that ended meanwhile.
+func freezeSession(sess *Session) (*frozenSession, error) {
+	result := make(chan freezeResp, 1)
+	proceed := make(chan bool)
+	select {
+	case sess.actions <- freezeReq{result: result, proceed: proceed}:
+	case <-sess.done:
+		return nil, nil
+	}
+	select {
+	case resp := <-result:
+		if resp.err != nil {
+			return nil, resp.err
+		}
+		if resp.exited {
+			return nil, nil
+		}
+		return &frozenSession{sess: sess, resp: resp, result: result, proceed: proceed}, nil
+	case <-sess.done:
+		return nil, nil
+	}
+}
+
+func (f *frozenSession) handover() (handoverSession, error) {
+	sess := f.sess
+	cols, rows := sess.size()
+	state, err := encodeState(&sessionState{
+		Cols:     cols,
+		Rows:     rows,
+		SavedAt:  time.Now(),
+		Snapshot: f.resp.snapshot,
+		CWD:      sess.launchCWD,
+		Command:  sess.command,
+		Env:      sess.env,
+	})
+	if err != nil {
+		return handoverSession{}, fmt.Errorf("session %q: %w", sess.Name, err)
+	}
+	p := sess.process()
+	monitor, _ := sess.monitor.state()
+	return handoverSession{
+		Name:         sess.Name,
+		PID:          p.pid,
+		CreatedAt:    sess.CreatedAt,
+		StartedAt:    p.startedAt,
+		State:        state,
+		Scrollback:   sess.scrollback,
+		ResizePolicy: sess.resizePolicy,
+		Restart: handoverRestart{
+			Mode:       sess.restart.mode,
+			MaxRetries: sess.restart.maxRetries,
+			Backoff:    sess.restart.backoff,
+			MaxBackoff: sess.restart.maxBackoff,
+		},
+		Restarts:    sess.restarts.Load(),
+		SlowKicks:   sess.slowKicks.Load(),
+		Monitor:     monitor,
+		LogPath:     f.resp.logPath,
+		LogFormat:   f.resp.logFormat,
+		RecordPath:  f.resp.recordPath,
+		RecordStart: f.resp.recordStart,
+		TempDir:     p.tempDir,
+	}, nil
+}
+
+// finishOutputs closes the session's log and recording once everything
+// queued is written. Only the run loop calls it.
+func (s *Session) finishOutputs() {
+	if s.logger != nil {
+		s.logger.finish()
+		<-s.logger.done
+		s.logger = nil
+	}
+	if s.recorder != nil {
+		s.recorder.finish()
+		<-s.recorder.done
+		s.recorder = nil
+	}
+}
+
+// adopt takes over the listener, the lock and the sessions an upgrading
+// daemon handed over.
+func (s *Server) adopt() error {
+	h, files, err := receiveHandover(s.handover)
+	s.handover.Close()
+	s.handover = nil
+	if err != nil {
+		return fmt.Errorf("daemon: receive handover: %w", err)
+	}
+	ln, err := net.FileListener(files[0])
+	files[0].Close()
+	if err != nil {
+		closeFiles(files[1:])
+		return fmt.Errorf("daemon: adopt listener: %w", err)
+	}
+	s.listener = ln
+	s.lockFile = files[1]
+	s.ownsSocket = true
+	if !h.StartedAt.IsZero() {
+		s.startedAt = h.StartedAt
+	}
+	if err := s.writePID(); err != nil {
+		s.log.Warn("rewrite pid file", "err", err)
+	}
+
+	for i, hs := range h.Sessions {
+		ptmx := files[2+i]
+		if err := s.adoptSession(hs, ptmx); err != nil {
+			s.log.Error("resume session after upgrade", "session", hs.Name, "err", err)
+			ptmx.Close()
+			reapAbandoned(hs.PID)
+		}
+	}
+	s.log.Info("daemon upgraded", "sessions", len(h.Sessions))
+	return nil
+}
+
+func (s *Server) adoptSession(hs handoverSession, ptmx *os.File) error {
+	state, err := decodeState(hs.State)
+	if err != nil {
+		return err
+	}
+	process, err := os.FindProcess(int(hs.PID))
+	if err != nil {
+		return err
+	}
+	if err := setNonblock(ptmx); err != nil {
+		s.log.Warn("pty nonblocking mode", "session", hs.Name, "err", err)
+	}
+	var log sessionLogSpec
+	if hs.LogPath != "" {
+		log = newSessionLogSpec(s.sessionLog, hs.Name, hs.LogPath, hs.LogFormat)
+	}
+	proc := &sessionProcess{
+		pid:       hs.PID,
+		ptmx:      ptmx,
+		process:   process,
+		tempDir:   hs.TempDir,
+		startedAt: hs.StartedAt,
+		done:      make(chan struct{}),
+	}
+	sess, err := adoptSession(s.ctx, state, proc, cmp.Or(hs.ResizePolicy, s.resizePolicy), sessionStartSpec{
+		name:       hs.Name,
+		command:    state.Command,
+		env:        state.Env,
+		cwd:        state.CWD,
+		size:       termSize{cols: state.Cols, rows: state.Rows},
+		scrollback: hs.Scrollback,
+		log:        log,
+		record:     sessionRecordSpec{path: hs.RecordPath, start: hs.RecordStart},
+		restart: restartPolicy{
+			mode:       hs.Restart.Mode,
+			maxRetries: hs.Restart.MaxRetries,
+			backoff:    hs.Restart.Backoff,
+			maxBackoff: hs.Restart.MaxBackoff,
+		},
+		events:     s.events,
+		daemonLog:  s.log,
+		slowClient: s.slowClient,
+		monitor:    hs.Monitor,
+	})
+	if err != nil {
+		return err
+	}
+	sess.CreatedAt = hs.CreatedAt
+	sess.restarts.Store(hs.Restarts)
+	sess.slowKicks.Store(hs.SlowKicks)
+	if !s.addSession(hs.Name, sess) {
+		sess.discard(s.ctx)
+		return fmt.Errorf("session already exists")
+	}
+	return nil
+}
+
+// reapAbandoned hangs up a handed-over process the daemon could not
+// resume and reaps it once it exits.
+func reapAbandoned(pid uint32) {
+	_ = syscall.Kill(-int(pid), syscall.SIGHUP)
+	if p, err := os.FindProcess(int(pid)); err == nil {
+		go func() { _, _ = p.Wait() }()
+	}
+}
+
+// sendHandover queues h and files on a new socket pair and returns its
+// receiving end, for receiveHandover in the new process.
+func sendHandover(h *handover, files []*os.File) (*os.File, error) {
+	payload, err := os.CreateTemp("", "hauntty-upgrade-*")
+	if err != nil {
+		return nil, fmt.Errorf("create handover file: %w", err)
+	}
+	defer payload.Close()
+	os.Remove(payload.Name())
+	if err := json.NewEncoder(payload).Encode(h); err != nil {
+		return nil, fmt.Errorf("write handover: %w", err)
+	}
+	// The receiver shares this file offset.
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("write handover: %w", err)
+	}
+
+	// A stream socket has no queue length limit, only a buffer that one
+	// byte per message barely dents.
+	syscall.ForkLock.RLock()
+	pair, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err == nil {
+		unix.CloseOnExec(pair[0])
+		unix.CloseOnExec(pair[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("handover socket: %w", err)
+	}
+	defer unix.Close(pair[0])
+	recv := os.NewFile(uintptr(pair[1]), "handover")
+
+	for chunk := range slices.Chunk(append([]*os.File{payload}, files...), maxHandoverFDs) {
+		fds := make([]int, len(chunk))
+		for i, f := range chunk {
+			if fds[i], err = rawFD(f); err != nil {
+				recv.Close()
+				return nil, err
+			}
+		}
+		if err := unix.Sendmsg(pair[0], []byte{0}, unix.UnixRights(fds...), nil, 0); err != nil {
+			recv.Close()
+			return nil, fmt.Errorf("send descriptors: %w", err)
+		}
+	}
+	return recv, nil
+}
+
+// receiveHandover reads what sendHandover queued on f. The files are
+// the listener, the lock file and the sessions' PTY masters.
+func receiveHandover(f *os.File) (*handover, []*os.File, error) {
+	fd, err := rawFD(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	var h *handover
+	var files []*os.File
+	fail := func(err error) (*handover, []*os.File, error) {
+		closeFiles(files)
+		return nil, nil, err
+	}
+	buf := make([]byte, 1)
+	oob := make([]byte, unix.CmsgSpace(maxHandoverFDs*4))
+	for h == nil || len(files) < 2+len(h.Sessions) {
+		// One byte at a time keeps each read to one message.
+		n, oobn, _, _, err := unix.Recvmsg(fd, buf, oob, 0)
+		if err != nil {
+			return fail(fmt.Errorf("receive descriptors: %w", err))
+		}
+		if n == 0 {
+			return fail(io.ErrUnexpectedEOF)
+		}
+		msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return fail(fmt.Errorf("receive descriptors: %w", err))
+		}
+		for _, m := range msgs {
+			fds, err := unix.ParseUnixRights(&m)
+			if err != nil {
+				return fail(fmt.Errorf("receive descriptors: %w", err))
+			}
+			for _, fd := range fds {
+				unix.CloseOnExec(fd)
+				files = append(files, os.NewFile(uintptr(fd), "handover"))
+			}
+		}
+		if h == nil && len(files) > 0 {
+			if h, err = readHandover(files[0]); err != nil {
+				return fail(err)
+			}
+			files[0].Close()
+			files = files[1:]
+		}
+	}
+	if len(files) != 2+len(h.Sessions) {
+		return fail(fmt.Errorf("received %d descriptors for %d sessions", len(files), len(h.Sessions)))
+	}
+	return h, files, nil
+}
+
+func readHandover(f *os.File) (*handover, error) {
+	var h handover
+	if err := json.NewDecoder(f).Decode(&h); err != nil {
+		return nil, fmt.Errorf("read handover: %w", err)
+	}
+	if h.Version != handoverVersion {
+		return nil, fmt.Errorf("unsupported handover version %d", h.Version)
+	}
+	return &h, nil
+}
+
+func listenerFile(ln net.Listener) (*os.File, error) {
+	filer, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("listener %T cannot be handed over", ln)
+	}
+	f, err := filer.File()
+	if err != nil {
+		return nil, fmt.Errorf("listener descriptor: %w", err)
+	}
+	return f, nil
+}
+
+// rawFD returns f's descriptor without f.Fd, which would switch f to
+// blocking mode.
+func rawFD(f *os.File) (int, error) {
+	fd := -1
+	err := controlFD(f, func(raw int) error {
+		fd = raw
+		return nil
+	})
+	if err != nil {
+		return -1, fmt.Errorf("descriptor of %s: %w", f.Name(), err)
+	}
+	return fd, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,168 @@
+package daemon
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.selman.me/hauntty/internal/asciicast"
+	"code.selman.me/hauntty/internal/config"
+	"code.selman.me/hauntty/internal/protocol"
+	"code.selman.me/hauntty/libghostty"
+	"gotest.tools/v3/assert"
+)
+
+func TestHandoverRoundtrip(t *testing.T) {
+	var readers, writers []*os.File
+	for range 3 {
+		r, w, err := os.Pipe()
+		assert.NilError(t, err)
+		defer r.Close()
+		defer w.Close()
+		readers = append(readers, r)
+		writers = append(writers, w)
+	}
+	h := &handover{
+		Version:   handoverVersion,
+		StartedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Sessions: []handoverSession{{
+			Name:    "work",
+			PID:     42,
+			State:   []byte("state"),
+			Restart: handoverRestart{Mode: protocol.RestartOnFailure, MaxRetries: 3},
+		}},
+	}
+
+	recv, err := sendHandover(h, readers)
+	assert.NilError(t, err)
+	defer recv.Close()
+	got, files, err := receiveHandover(recv)
+	assert.NilError(t, err)
+	defer closeFiles(files)
+
+	assert.DeepEqual(t, got, h)
+	assert.Equal(t, len(files), len(readers))
+	for i, f := range files {
+		_, err := writers[i].WriteString("fd")
+		assert.NilError(t, err)
+		buf := make([]byte, 2)
+		_, err = io.ReadFull(f, buf)
+		assert.NilError(t, err)
+		assert.Equal(t, string(buf), "fd")
+	}
+}
+
+func TestReceiveHandoverRejectsMissingDescriptors(t *testing.T) {
+	r, w, err := os.Pipe()
+	assert.NilError(t, err)
+	defer r.Close()
+	defer w.Close()
+	h := &handover{Version: handoverVersion, Sessions: []handoverSession{{Name: "work"}}}
+
+	recv, err := sendHandover(h, []*os.File{r})
+	assert.NilError(t, err)
+	defer recv.Close()
+	_, _, err = receiveHandover(recv)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestFreezeSessionSnapshotsAndResumes(t *testing.T) {
+	s, err := newSession(t.Context(), config.ResizePolicySmallest, sessionStartSpec{
+		name:    "work",
+		command: []string{"/bin/sh", "-c", "echo ready; exec cat"},
+		size:    termSize{cols: 80, rows: 24},
+	})
+	assert.NilError(t, err)
+	defer s.close(t.Context())
+	waitForScreen(t, s, "ready")
+
+	f, err := freezeSession(s)
+	assert.NilError(t, err)
+	assert.Assert(t, f != nil)
+	hs, err := f.handover()
+	assert.NilError(t, err)
+	state, err := decodeState(hs.State)
+	assert.NilError(t, err)
+	term, err := adoptTerminalState(state, termSize{cols: 80, rows: 24}, 0)
+	assert.NilError(t, err)
+	defer term.close()
+	dump, err := term.dumpScreen(terminalFormat{emit: libghostty.FormatterFormatPlain})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(dump.Data), "ready"), string(dump.Data))
+
+	// Closing proceed resumes the session, which reads the PTY again.
+	close(f.proceed)
+	assert.NilError(t, s.sendInput([]byte("resumed\n")))
+	waitForScreen(t, s, "resumed")
+	assert.Assert(t, s.isRunning())
+}
+
+func TestUpgradeHandsOverRecording(t *testing.T) {
+	s, err := newSession(t.Context(), config.ResizePolicySmallest, sessionStartSpec{
+		name:    "taped",
+		command: []string{"/bin/sh", "-c", "echo ready; exec cat"},
+		size:    termSize{cols: 80, rows: 24},
+	})
+	assert.NilError(t, err)
+	defer s.close(t.Context())
+	waitForScreen(t, s, "ready")
+	path := filepath.Join(t.TempDir(), "taped.cast")
+	assert.NilError(t, s.startRecording(path))
+
+	f, err := freezeSession(s)
+	assert.NilError(t, err)
+	hs, err := f.handover()
+	assert.NilError(t, err)
+	assert.Equal(t, hs.RecordPath, path)
+	f.proceed <- true
+	<-f.result
+	defer close(f.proceed)
+
+	// The new daemon picks the recording up where the old one stopped.
+	r, err := resumeSessionRecorder(hs.RecordPath, hs.RecordStart, slog.New(slog.DiscardHandler))
+	assert.NilError(t, err)
+	r.record([]byte("upgraded\r\n"))
+	r.finish()
+	<-r.done
+
+	file, err := os.Open(path)
+	assert.NilError(t, err)
+	defer file.Close()
+	dec, err := asciicast.NewDecoder(file)
+	assert.NilError(t, err)
+	assert.Equal(t, dec.Header.Title, "taped")
+	var events []asciicast.Event
+	for {
+		ev, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NilError(t, err)
+		events = append(events, ev)
+	}
+	assert.Equal(t, len(events), 2)
+	assert.Assert(t, strings.Contains(events[0].Data, "ready"), events[0].Data)
+	assert.Equal(t, events[1].Data, "upgraded\r\n")
+	assert.Assert(t, events[1].Time > 0)
+}
+
+func waitForScreen(t *testing.T, s *Session, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dump, err := s.dumpScreen(t.Context(), terminalFormat{emit: libghostty.FormatterFormatPlain})
+		assert.NilError(t, err)
+		if strings.Contains(string(dump.Data), want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("screen never showed %q:\n%s", want, dump.Data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,196 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+const (
+	// MinProtocolVersion is the oldest protocol version this build still
+	// speaks. Clients between it and ProtocolVersion are served in
+	// compatibility mode.
+	MinProtocolVersion uint8 = 8
+	// capabilityVersion is the first version whose handshake carries a
+	// version range and capabilities.
+	capabilityVersion uint8 = 9
+)
+
+// Capability names an optional protocol feature. New message types and
+// new message fields are gated on a capability both sides announced
+// during the handshake, rather than on a version bump.
+type Capability string
+
+const (
+	CapWatch  Capability = "watch"
+	CapLog    Capability = "log"
+	CapRecord Capability = "record"
+	CapSearch Capability = "search"
+	// CapClientInfo adds Protocol and Features to SessionClient.
+	CapClientInfo Capability = "client-info"
+	// CapChunkedDump streams dumps and attach screen dumps as DumpChunk
+	// messages ending in DumpEnd, instead of one frame, sent as the
+	// daemon renders them.
+	CapChunkedDump Capability = "chunked-dump"
+	CapEvents      Capability = "events"
+	// CapClientLag adds Resyncs and Dropped to SessionClient and
+	// SlowKicks to SessionStatus.
+	CapClientLag Capability = "client-lag"
+	// CapMonitor adds Monitor, monitor events and their Message, and
+	// session Alerts.
+	CapMonitor Capability = "monitor"
+	CapUpgrade Capability = "upgrade"
+	// CapSessionSize adds Cols, Rows, Xpixel, Ypixel and ResizePolicy to
+	// Create.
+	CapSessionSize Capability = "session-size"
+	// CapCommands adds History, Prompt to Watch and DumpFlagLastCommand.
+	CapCommands Capability = "commands"
+	// CapExec adds Exec.
+	CapExec Capability = "exec"
+)
+
+// Capabilities lists every capability this build supports.
+var Capabilities = []Capability{
+	CapWatch,
+	CapLog,
+	CapRecord,
+	CapSearch,
+	CapClientInfo,
+	CapChunkedDump,
+	CapEvents,
+	CapClientLag,
+	CapMonitor,
+	CapUpgrade,
+	CapSessionSize,
+	CapCommands,
+	CapExec,
+}
+
+// RequiredCapability returns the capability a message type is gated on.
+func RequiredCapability(t MessageType) (Capability, bool) {
+	switch t {
+	case TypeWatch, TypeWatchResponse:
+		return CapWatch, true
+	case TypeLog:
+		return CapLog, true
+	case TypeRecord:
+		return CapRecord, true
+	case TypeSearch, TypeSearchResponse:
+		return CapSearch, true
+	case TypeDumpChunk, TypeDumpEnd:
+		return CapChunkedDump, true
+	case TypeSubscribe, TypeEvent:
+		return CapEvents, true
+	case TypeMonitor:
+		return CapMonitor, true
+	case TypeUpgrade:
+		return CapUpgrade, true
+	case TypeHistory, TypeHistoryResponse:
+		return CapCommands, true
+	case TypeExec, TypeExecResponse:
+		return CapExec, true
+	default:
+		return "", false
+	}
+}
+
+// ErrNotNegotiated matches every CapabilityError.
+var ErrNotNegotiated = errors.New("capability not negotiated")
+
+// CapabilityError is returned when writing a message whose capability
+// the connection did not negotiate.
+type CapabilityError struct {
+	Type       MessageType
+	Capability Capability
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("peer does not support %q (message 0x%02x)", e.Capability, uint8(e.Type))
+}
+
+func (e *CapabilityError) Is(target error) bool {
+	return target == ErrNotNegotiated
+}
+
+// Hello is what a client announces when it connects.
+type Hello struct {
+	MinVersion   uint8
+	MaxVersion   uint8
+	Revision     string
+	Capabilities []Capability
+}
+
+// NewHello announces every version and capability this build supports.
+func NewHello(revision string) Hello {
+	return Hello{
+		MinVersion:   MinProtocolVersion,
+		MaxVersion:   ProtocolVersion,
+		Revision:     revision,
+		Capabilities: slices.Clone(Capabilities),
+	}
+}
+
+// Negotiate picks the highest version both this build and the client
+// speak, and the capabilities they share. A zero version means there is
+// no common version.
+func Negotiate(hello Hello) (uint8, []Capability) {
+	version := min(hello.MaxVersion, ProtocolVersion)
+	if version < max(hello.MinVersion, MinProtocolVersion) {
+		return 0, nil
+	}
+	// Clients older than capabilityVersion announce no capabilities and
+	// get none.
+	if version < capabilityVersion {
+		return version, nil
+	}
+	var caps []Capability
+	for _, c := range Capabilities {
+		if slices.Contains(hello.Capabilities, c) {
+			caps = append(caps, c)
+		}
+	}
+	return version, caps
+}
+
+// capabilitySet is what a connection negotiated. The zero value, used
+// by connections that never handshake, allows every capability.
+type capabilitySet struct {
+	negotiated bool
+	version    uint8
+	caps       []Capability
+}
+
+func negotiatedSet(version uint8, caps []Capability) capabilitySet {
+	return capabilitySet{negotiated: true, version: version, caps: slices.Clone(caps)}
+}
+
+func (s capabilitySet) has(c Capability) bool {
+	return !s.negotiated || slices.Contains(s.caps, c)
+}
+
+// legacy reports whether the peer speaks a version older than
+// capabilityVersion. Its messages end before every field added since,
+// including the ones that are not gated on a capability.
+func (s capabilitySet) legacy() bool {
+	return s.negotiated && s.version < capabilityVersion
+}
+
+func encodeCapabilities(e *Encoder, caps []Capability) error {
+	s := make([]string, len(caps))
+	for i, c := range caps {
+		s[i] = string(c)
+	}
+	return e.WriteStringSlice(s)
+}
+
+func decodeCapabilities(d *Decoder) ([]Capability, error) {
+	s, err := d.ReadStringSlice()
+	if err != nil {
+		return nil, err
+	}
+	caps := make([]Capability, len(s))
+	for i, c := range s {
+		caps[i] = Capability(c)
+	}
+	return caps, nil
+}
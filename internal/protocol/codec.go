@@ -4,17 +4,20 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"slices"
 	"sync"
 )
 
 const (
-	ProtocolVersion uint8  = 8
+	ProtocolVersion uint8  = 9
 	maxFrameSize    uint32 = 16 << 20 // 16MB
 )
 
 type Conn struct {
-	rw io.ReadWriter
-	wm sync.Mutex
+	rw      io.ReadWriter
+	wm      sync.Mutex
+	version uint8
+	caps    capabilitySet
 }
 
 func NewConn(rw io.ReadWriter) *Conn {
@@ -23,8 +26,13 @@ func NewConn(rw io.ReadWriter) *Conn {
 
 // Frame: [u32 length][u8 type][payload...]
 func (c *Conn) WriteMessage(msg Message) error {
+	if capability, ok := RequiredCapability(msg.Type()); ok && !c.caps.has(capability) {
+		return &CapabilityError{Type: msg.Type(), Capability: capability}
+	}
+
 	var buf bytes.Buffer
 	enc := NewEncoder(&buf)
+	enc.caps = c.caps
 
 	if err := enc.WriteU8(uint8(msg.Type())); err != nil {
 		return err
@@ -67,6 +75,7 @@ func (c *Conn) ReadMessage() (Message, error) {
 	}
 
 	frameDec := NewDecoder(bytes.NewReader(frame))
+	frameDec.caps = c.caps
 	msgType, err := frameDec.ReadU8()
 	if err != nil {
 		return nil, err
@@ -82,15 +91,32 @@ func (c *Conn) ReadMessage() (Message, error) {
 	return msg, nil
 }
 
-// Call before any concurrent use of the connection.
-func (c *Conn) Handshake(version uint8, revision string) (uint8, string, error) {
+// Handshake wire format. Client: [u8 max version][string revision], then
+// [u8 min version][string slice capabilities] when the max version is at
+// least capabilityVersion. Daemon: [u8 version][string revision], then
+// [string slice capabilities] when the chosen version is at least
+// capabilityVersion. Version 0 rejects the client. Older clients only
+// send the first two fields, so the daemon can still tell them apart.
+
+// Handshake announces hello and returns the version and revision the
+// daemon chose. Call before any concurrent use of the connection.
+func (c *Conn) Handshake(hello Hello) (uint8, string, error) {
 	enc := NewEncoder(c.rw)
-	if err := enc.WriteU8(version); err != nil {
+	if err := enc.WriteU8(hello.MaxVersion); err != nil {
 		return 0, "", err
 	}
-	if err := enc.WriteString(revision); err != nil {
+	if err := enc.WriteString(hello.Revision); err != nil {
 		return 0, "", err
 	}
+	if hello.MaxVersion >= capabilityVersion {
+		if err := enc.WriteU8(hello.MinVersion); err != nil {
+			return 0, "", err
+		}
+		if err := encodeCapabilities(enc, hello.Capabilities); err != nil {
+			return 0, "", err
+		}
+	}
+
 	dec := NewDecoder(c.rw)
 	serverVer, err := dec.ReadU8()
 	if err != nil {
@@ -100,30 +126,80 @@ func (c *Conn) Handshake(version uint8, revision string) (uint8, string, error)
 	if err != nil {
 		return 0, "", err
 	}
+	var caps []Capability
+	if serverVer >= capabilityVersion {
+		if caps, err = decodeCapabilities(dec); err != nil {
+			return 0, "", err
+		}
+	}
+	if serverVer != 0 {
+		c.version = serverVer
+		c.caps = negotiatedSet(serverVer, caps)
+	}
 	return serverVer, serverRev, nil
 }
 
-// Call before any concurrent use of the connection.
-func (c *Conn) AcceptHandshake() (uint8, string, error) {
+// AcceptHandshake reads a client's hello. Call before any concurrent use
+// of the connection.
+func (c *Conn) AcceptHandshake() (Hello, error) {
 	dec := NewDecoder(c.rw)
-	version, err := dec.ReadU8()
-	if err != nil {
-		return 0, "", err
+	var hello Hello
+	var err error
+	if hello.MaxVersion, err = dec.ReadU8(); err != nil {
+		return hello, err
 	}
-	revision, err := dec.ReadString()
-	if err != nil {
-		return 0, "", err
+	if hello.Revision, err = dec.ReadString(); err != nil {
+		return hello, err
 	}
-	return version, revision, nil
+	hello.MinVersion = hello.MaxVersion
+	if hello.MaxVersion >= capabilityVersion {
+		if hello.MinVersion, err = dec.ReadU8(); err != nil {
+			return hello, err
+		}
+		if hello.Capabilities, err = decodeCapabilities(dec); err != nil {
+			return hello, err
+		}
+	}
+	return hello, nil
 }
 
-// Call before any concurrent use of the connection.
-func (c *Conn) WriteVersionReply(version uint8, revision string) error {
+// WriteHandshakeReply answers a hello with the chosen version and
+// capabilities; version 0 rejects the client. Call before any concurrent
+// use of the connection.
+func (c *Conn) WriteHandshakeReply(version uint8, revision string, caps []Capability) error {
 	enc := NewEncoder(c.rw)
 	if err := enc.WriteU8(version); err != nil {
 		return err
 	}
-	return enc.WriteString(revision)
+	if err := enc.WriteString(revision); err != nil {
+		return err
+	}
+	if version >= capabilityVersion {
+		if err := encodeCapabilities(enc, caps); err != nil {
+			return err
+		}
+	}
+	if version != 0 {
+		c.version = version
+		c.caps = negotiatedSet(version, caps)
+	}
+	return nil
+}
+
+// Version returns the negotiated protocol version, 0 before a handshake.
+func (c *Conn) Version() uint8 {
+	return c.version
+}
+
+// Capabilities returns the negotiated capabilities, nil before a
+// handshake.
+func (c *Conn) Capabilities() []Capability {
+	return slices.Clone(c.caps.caps)
+}
+
+// Has reports whether the connection negotiated capability.
+func (c *Conn) Has(capability Capability) bool {
+	return c.caps.has(capability)
 }
 
 func newMessage(raw uint8) (Message, error) {
@@ -155,6 +231,24 @@ func newMessage(raw uint8) (Message, error) {
 		return &Status{}, nil
 	case TypeKick:
 		return &Kick{}, nil
+	case TypeWatch:
+		return &Watch{}, nil
+	case TypeLog:
+		return &Log{}, nil
+	case TypeRecord:
+		return &Record{}, nil
+	case TypeSearch:
+		return &Search{}, nil
+	case TypeSubscribe:
+		return &Subscribe{}, nil
+	case TypeMonitor:
+		return &Monitor{}, nil
+	case TypeUpgrade:
+		return &Upgrade{}, nil
+	case TypeHistory:
+		return &History{}, nil
+	case TypeExec:
+		return &Exec{}, nil
 	case TypeOK:
 		return &OK{}, nil
 	case TypeError:
@@ -177,6 +271,20 @@ func newMessage(raw uint8) (Message, error) {
 		return &StatusResponse{}, nil
 	case TypeCreated:
 		return &Created{}, nil
+	case TypeWatchResponse:
+		return &WatchResponse{}, nil
+	case TypeSearchResponse:
+		return &SearchResponse{}, nil
+	case TypeDumpChunk:
+		return &DumpChunk{}, nil
+	case TypeDumpEnd:
+		return &DumpEnd{}, nil
+	case TypeHistoryResponse:
+		return &HistoryResponse{}, nil
+	case TypeExecResponse:
+		return &ExecResponse{}, nil
+	case TypeEvent:
+		return &Event{}, nil
 	default:
 		return nil, fmt.Errorf("unknown message type: 0x%02x", t)
 	}
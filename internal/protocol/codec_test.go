@@ -28,6 +28,17 @@ func TestRoundTrip(t *testing.T) {
 			CWD:        "",
 			Scrollback: 0,
 			Force:      true,
+			Restart:    RestartOnFailure,
+		}},
+		{"CreateSized", &Create{
+			Name:         "batch",
+			Command:      []string{"make"},
+			Env:          []string{},
+			Cols:         120,
+			Rows:         40,
+			Xpixel:       960,
+			Ypixel:       640,
+			ResizePolicy: "fixed",
 		}},
 		{"CreateEmpty", &Create{
 			Name:    "",
@@ -115,8 +126,9 @@ func TestRoundTrip(t *testing.T) {
 		}},
 		{"Sessions", &Sessions{
 			Sessions: []Session{
-				{Name: "s1", State: SessionStateRunning, Cols: 80, Rows: 24, PID: 100, CreatedAt: 1700000000, SavedAt: 0, CWD: "/home/user/src", Clients: []SessionClient{}},
-				{Name: "s2", State: SessionStateDead, Cols: 120, Rows: 40, PID: 200, CreatedAt: 0, SavedAt: 1700000001, CWD: "", Clients: []SessionClient{}},
+				{Name: "s1", State: SessionStateRunning, Cols: 80, Rows: 24, PID: 100, CreatedAt: 1700000000, SavedAt: 0, CWD: "/home/user/src", Clients: []SessionClient{}, Command: []string{}},
+				{Name: "s2", State: SessionStateDead, Cols: 120, Rows: 40, PID: 200, CreatedAt: 0, SavedAt: 1700000001, CWD: "", Clients: []SessionClient{}, Exit: SessionExit{Exited: true, Code: 143, Signal: "SIGTERM", At: 1700000001}, Command: []string{"sleep", "60"}},
+				{Name: "s3", State: SessionStateRunning, Clients: []SessionClient{}, Command: []string{}, Alerts: AlertBell | AlertSilence},
 			},
 		}},
 		{"SessionsWithClients", &Sessions{
@@ -124,9 +136,10 @@ func TestRoundTrip(t *testing.T) {
 				{
 					Name: "s1", State: "running", Cols: 80, Rows: 24, PID: 100, CreatedAt: 1700000000, SavedAt: 0, CWD: "/tmp",
 					Clients: []SessionClient{
-						{ClientID: "1", ReadOnly: false, Version: "abc123"},
-						{ClientID: "2", ReadOnly: true, Version: "def456"},
+						{ClientID: "1", ReadOnly: false, Version: "abc123", PID: 4001, Protocol: 9, Features: []Capability{CapWatch, CapClientInfo}},
+						{ClientID: "2", ReadOnly: true, Version: "def456", PID: 4002, Protocol: 9, Features: []Capability{CapWatch, CapClientInfo}},
 					},
+					Command: []string{},
 				},
 			},
 		}},
@@ -137,6 +150,26 @@ func TestRoundTrip(t *testing.T) {
 		{"Exited/127", &Exited{ExitCode: 127}},
 		{"Exited/255", &Exited{ExitCode: 255}},
 		{"DumpResponse", &DumpResponse{Data: []byte("dump data")}},
+		{"DumpChunk", &DumpChunk{Data: []byte("chunk")}},
+		{"DumpEnd", &DumpEnd{}},
+		{"Subscribe", &Subscribe{Names: []string{"api", "db"}}},
+		{"EventAttached", &Event{Kind: EventAttached, Session: "api", Time: 1700000000, ClientID: "2", Dropped: 3}},
+		{"EventExited", &Event{Kind: EventExited, Session: "api", Time: 1700000001, Exit: SessionExit{Exited: true, Code: 143, Signal: "SIGTERM", At: 1700000001}}},
+		{"EventNotify", &Event{Kind: EventNotify, Session: "build", Time: 1700000002, Message: "build done"}},
+		{"Monitor", &Monitor{Name: "build", Settings: MonitorSettings{Bell: true, Silence: 30, Pattern: "FAIL|error"}}},
+		{"Upgrade", &Upgrade{Path: "/usr/local/bin/ht"}},
+		{"History", &History{Name: "work"}},
+		{"HistoryResponse", &HistoryResponse{
+			Commands: []ShellCommand{
+				{Command: "make test", StartedAt: 1700000000, FinishedAt: 1700000012, Duration: 12345, HasExit: true, ExitCode: 2, StartRow: 40, Rows: 18},
+				{Command: "sleep 100", StartedAt: 1700000020},
+				{Command: "ls", StartedAt: 1699990000, FinishedAt: 1699990000, Duration: 8, HasExit: true, Trimmed: true},
+			},
+			AtPrompt: true,
+		}},
+		{"Exec", &Exec{Name: "work", Command: "make test", Timeout: 60000}},
+		{"ExecResponse", &ExecResponse{HasExit: true, ExitCode: 2}},
+		{"ExecResponseTimedOut", &ExecResponse{TimedOut: true}},
 		{"PruneResponse", &PruneResponse{Count: 3}},
 		{"ClientsChanged", &ClientsChanged{Count: 2, Cols: 80, Rows: 24}},
 		{"ClientsChangedSingle", &ClientsChanged{Count: 1, Cols: 120, Rows: 40}},
@@ -157,9 +190,12 @@ func TestRoundTrip(t *testing.T) {
 				PID:   12389,
 				CWD:   "/home/user/project",
 				Clients: []SessionClient{
-					{ClientID: "1", ReadOnly: false, Version: "abc123def456"},
-					{ClientID: "2", ReadOnly: true, Version: "abc123def456"},
+					{ClientID: "1", ReadOnly: false, Version: "abc123def456", PID: 4001, Protocol: 9, Features: []Capability{CapWatch, CapClientInfo}},
+					{ClientID: "2", ReadOnly: true, Version: "abc123def456", PID: 4002, Protocol: 9, Features: []Capability{CapWatch, CapClientInfo}},
 				},
+				Command: []string{"/bin/zsh"},
+				Monitor: MonitorSettings{Activity: true, Silence: 10},
+				Alerts:  AlertActivity,
 			},
 		}},
 		{"StatusResponseNoSession", &StatusResponse{
@@ -182,6 +218,7 @@ func TestRoundTrip(t *testing.T) {
 				Name:    "s",
 				State:   "running",
 				Clients: []SessionClient{},
+				Command: []string{},
 			},
 		}},
 	}
@@ -200,69 +237,293 @@ func TestRoundTrip(t *testing.T) {
 	}
 }
 
-func TestHandshake(t *testing.T) {
+func newPipeConns() (client, server *Conn) {
 	cr, sw := io.Pipe()
 	sr, cw := io.Pipe()
 
-	clientConn := NewConn(struct {
+	client = NewConn(struct {
 		io.Reader
 		io.Writer
 	}{cr, cw})
-	serverConn := NewConn(struct {
+	server = NewConn(struct {
 		io.Reader
 		io.Writer
 	}{sr, sw})
+	return client, server
+}
 
-	var serverVer uint8
-	var serverRev string
+func TestHandshake(t *testing.T) {
+	clientConn, serverConn := newPipeConns()
+
+	var hello Hello
 	var serverErr error
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		serverVer, serverRev, serverErr = serverConn.AcceptHandshake()
+		hello, serverErr = serverConn.AcceptHandshake()
 		if serverErr == nil {
-			serverErr = serverConn.WriteVersionReply(serverVer, serverRev)
+			version, caps := Negotiate(hello)
+			serverErr = serverConn.WriteHandshakeReply(version, "server-rev", caps)
 		}
 	}()
 
-	accepted, rev, err := clientConn.Handshake(ProtocolVersion, "abc123")
+	clientHello := NewHello("abc123")
+	clientHello.Capabilities = []Capability{CapSearch, CapWatch, "teleport"}
+	accepted, rev, err := clientConn.Handshake(clientHello)
 	assert.NilError(t, err)
 	assert.Equal(t, accepted, ProtocolVersion)
-	assert.Equal(t, rev, "abc123")
+	assert.Equal(t, rev, "server-rev")
+	assert.DeepEqual(t, clientConn.Capabilities(), []Capability{CapWatch, CapSearch})
 
 	<-done
 	assert.NilError(t, serverErr)
-	assert.Equal(t, serverVer, ProtocolVersion)
-	assert.Equal(t, serverRev, "abc123")
+	assert.DeepEqual(t, hello, clientHello)
+	assert.Equal(t, serverConn.Version(), ProtocolVersion)
+	assert.DeepEqual(t, serverConn.Capabilities(), []Capability{CapWatch, CapSearch})
+	assert.Assert(t, serverConn.Has(CapSearch))
+	assert.Assert(t, !serverConn.Has(CapLog))
 }
 
-func TestHandshakeVersionMismatch(t *testing.T) {
-	cr, sw := io.Pipe()
-	sr, cw := io.Pipe()
-
-	clientConn := NewConn(struct {
-		io.Reader
-		io.Writer
-	}{cr, cw})
+func TestHandshakeV8Client(t *testing.T) {
+	// A version 8 ht writes [u8 8][string revision] and reads back
+	// [u8 version][string revision], nothing more.
+	hello := []byte{8, 0, 2, 'v', '8'}
+	var reply bytes.Buffer
 	serverConn := NewConn(struct {
 		io.Reader
 		io.Writer
-	}{sr, sw})
+	}{bytes.NewReader(hello), &reply})
+
+	got, err := serverConn.AcceptHandshake()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, Hello{MinVersion: 8, MaxVersion: 8, Revision: "v8"})
+
+	version, caps := Negotiate(got)
+	assert.Equal(t, version, uint8(8))
+	assert.NilError(t, serverConn.WriteHandshakeReply(version, "new", caps))
+	assert.DeepEqual(t, reply.Bytes(), []byte{8, 0, 3, 'n', 'e', 'w'})
+	assert.Equal(t, serverConn.Version(), uint8(8))
+	assert.Assert(t, !serverConn.Has(CapWatch))
+	assert.Assert(t, serverConn.caps.legacy())
+}
+
+func TestLegacyWireFormat(t *testing.T) {
+	legacy := negotiatedSet(8, nil)
+	encode := func(msg Message) []byte {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.caps = legacy
+		assert.NilError(t, msg.encode(enc))
+		return buf.Bytes()
+	}
+	// want writes fields the way a version 8 build did.
+	want := func(write func(e *Encoder)) []byte {
+		var buf bytes.Buffer
+		write(NewEncoder(&buf))
+		return buf.Bytes()
+	}
+	clients := []SessionClient{{ClientID: "1", Version: "abc", PID: 4001, Protocol: 9, Features: []Capability{CapWatch}}}
+	writeClients := func(e *Encoder) {
+		_ = e.WriteU32(1)
+		_ = e.WriteString("1")
+		_ = e.WriteBool(false)
+		_ = e.WriteString("abc")
+	}
+
+	sessions := &Sessions{Sessions: []Session{{
+		Name: "s", State: SessionStateRunning, Cols: 80, Rows: 24, PID: 100, CWD: "/tmp", Clients: clients,
+		Exit: SessionExit{Exited: true, Code: 3}, Command: []string{"make"}, Alerts: AlertBell,
+	}}}
+	assert.DeepEqual(t, encode(sessions), want(func(e *Encoder) {
+		_ = e.WriteU32(1)
+		_ = e.WriteString("s")
+		_ = e.WriteString("running")
+		_ = e.WriteU16(80)
+		_ = e.WriteU16(24)
+		_ = e.WriteU32(100)
+		_ = e.WriteU32(0)
+		_ = e.WriteU32(0)
+		_ = e.WriteString("/tmp")
+		writeClients(e)
+	}))
+
+	status := &StatusResponse{Daemon: DaemonStatus{PID: 1, Version: "new"}, Session: &SessionStatus{
+		Name: "s", State: SessionStateRunning, Cols: 80, Rows: 24, PID: 100, CWD: "/tmp", Clients: clients,
+		Command: []string{"make"}, Restart: RestartAlways, Restarts: 2,
+	}}
+	assert.DeepEqual(t, encode(status), want(func(e *Encoder) {
+		_ = e.WriteU32(1)
+		_ = e.WriteU32(0)
+		_ = e.WriteString("")
+		_ = e.WriteU32(0)
+		_ = e.WriteU32(0)
+		_ = e.WriteString("new")
+		_ = e.WriteU8(1)
+		_ = e.WriteString("s")
+		_ = e.WriteString("running")
+		_ = e.WriteU16(80)
+		_ = e.WriteU16(24)
+		_ = e.WriteU32(100)
+		_ = e.WriteString("/tmp")
+		writeClients(e)
+	}))
+
+	// Requests from a version 8 client end where that build stopped.
+	create := want(func(e *Encoder) {
+		_ = e.WriteString("s")
+		_ = e.WriteStringSlice([]string{"make"})
+		_ = e.WriteStringSlice([]string{})
+		_ = e.WriteString("/tmp")
+		_ = e.WriteU32(1000)
+		_ = e.WriteBool(true)
+	})
+	dec := NewDecoder(bytes.NewReader(create))
+	dec.caps = legacy
+	var gotCreate Create
+	assert.NilError(t, gotCreate.decode(dec))
+	assert.DeepEqual(t, gotCreate, Create{Name: "s", Command: []string{"make"}, Env: []string{}, CWD: "/tmp", Scrollback: 1000, Force: true})
+
+	attach := &Attach{Name: "s", Command: []string{}, Env: []string{}, Cols: 80, Rows: 24, Scrollback: 1000, LogPath: "/tmp/s.log"}
+	dec = NewDecoder(bytes.NewReader(encode(attach)))
+	dec.caps = legacy
+	var gotAttach Attach
+	assert.NilError(t, gotAttach.decode(dec))
+	assert.DeepEqual(t, gotAttach, Attach{Name: "s", Command: []string{}, Env: []string{}, Cols: 80, Rows: 24, Scrollback: 1000})
+}
+
+func TestHandshakeVersionMismatch(t *testing.T) {
+	clientConn, serverConn := newPipeConns()
 
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		_, _, _ = serverConn.AcceptHandshake()
-		_ = serverConn.WriteVersionReply(0, "")
+		_, _ = serverConn.AcceptHandshake()
+		_ = serverConn.WriteHandshakeReply(0, "", nil)
 	}()
 
-	accepted, _, err := clientConn.Handshake(ProtocolVersion, "abc123")
+	accepted, _, err := clientConn.Handshake(NewHello("abc123"))
 	assert.NilError(t, err)
 	assert.Equal(t, accepted, uint8(0))
+	assert.Equal(t, clientConn.Version(), uint8(0))
 
 	<-done
 }
 
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name        string
+		hello       Hello
+		wantVersion uint8
+		wantCaps    []Capability
+	}{
+		{"current", NewHello("r"), ProtocolVersion, Capabilities},
+		{"newer client", Hello{MinVersion: 9, MaxVersion: 40, Capabilities: []Capability{CapLog, "future"}}, ProtocolVersion, []Capability{CapLog}},
+		{"legacy", Hello{MinVersion: 8, MaxVersion: 8}, 8, nil},
+		{"too old", Hello{MinVersion: 7, MaxVersion: 7}, 0, nil},
+		{"too new", Hello{MinVersion: 30, MaxVersion: 40}, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, caps := Negotiate(tt.hello)
+			assert.Equal(t, version, tt.wantVersion)
+			assert.DeepEqual(t, caps, tt.wantCaps)
+		})
+	}
+}
+
+func TestWriteMessageRequiresCapability(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewConn(&buf)
+	c.caps = negotiatedSet(ProtocolVersion, []Capability{CapLog})
+
+	err := c.WriteMessage(&Search{Pattern: "x"})
+	assert.Error(t, err, `peer does not support "search" (message 0x11)`)
+	assert.Equal(t, buf.Len(), 0)
+	assert.NilError(t, c.WriteMessage(&Log{Name: "s"}))
+}
+
+func TestSessionClientInfoRequiresCapability(t *testing.T) {
+	msg := &StatusResponse{Session: &SessionStatus{
+		Name:  "s",
+		State: SessionStateRunning,
+		Clients: []SessionClient{
+			{ClientID: "1", Version: "abc", PID: 1, Protocol: 9, Features: []Capability{CapWatch}},
+		},
+		Command: []string{},
+	}}
+
+	var buf bytes.Buffer
+	c := NewConn(&buf)
+	c.caps = negotiatedSet(ProtocolVersion, nil)
+	assert.NilError(t, c.WriteMessage(msg))
+	got, err := c.ReadMessage()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got.(*StatusResponse).Session.Clients, []SessionClient{
+		{ClientID: "1", Version: "abc", PID: 1},
+	})
+}
+
+func TestClientLagRequiresCapability(t *testing.T) {
+	msg := &StatusResponse{Session: &SessionStatus{
+		Name:  "s",
+		State: SessionStateRunning,
+		Clients: []SessionClient{
+			{ClientID: "1", Protocol: 9, Features: []Capability{}, Resyncs: 2, Dropped: 1 << 33},
+		},
+		Command:   []string{},
+		SlowKicks: 3,
+	}}
+
+	for _, tc := range []struct {
+		name string
+		caps []Capability
+		want *SessionStatus
+	}{
+		{"negotiated", []Capability{CapClientInfo, CapClientLag}, msg.Session},
+		{"without client-lag", []Capability{CapClientInfo}, &SessionStatus{
+			Name:    "s",
+			State:   SessionStateRunning,
+			Clients: []SessionClient{{ClientID: "1", Protocol: 9, Features: []Capability{}}},
+			Command: []string{},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			c := NewConn(&buf)
+			c.caps = negotiatedSet(ProtocolVersion, tc.caps)
+			assert.NilError(t, c.WriteMessage(msg))
+			got, err := c.ReadMessage()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got.(*StatusResponse).Session, tc.want)
+		})
+	}
+}
+
+func TestCreateSizeRequiresCapability(t *testing.T) {
+	msg := &Create{Name: "s", Command: []string{}, Env: []string{}, Cols: 120, Rows: 40, ResizePolicy: "fixed"}
+
+	var buf bytes.Buffer
+	c := NewConn(&buf)
+	c.caps = negotiatedSet(ProtocolVersion, nil)
+	assert.NilError(t, c.WriteMessage(msg))
+	got, err := c.ReadMessage()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, &Create{Name: "s", Command: []string{}, Env: []string{}})
+}
+
+func TestWatchPromptRequiresCapability(t *testing.T) {
+	msg := &Watch{Name: "s", Pattern: "$", Row: -1, Timeout: 1000, Prompt: true}
+
+	var buf bytes.Buffer
+	c := NewConn(&buf)
+	c.caps = negotiatedSet(ProtocolVersion, []Capability{CapWatch})
+	assert.NilError(t, c.WriteMessage(msg))
+	got, err := c.ReadMessage()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, &Watch{Name: "s", Pattern: "$", Row: -1, Timeout: 1000})
+}
+
 func TestUnknownMessageType(t *testing.T) {
 	var buf bytes.Buffer
 	enc := NewEncoder(&buf)
@@ -348,3 +609,8 @@ func TestMultipleMessages(t *testing.T) {
 		assert.DeepEqual(t, got, orig)
 	}
 }
+
+func TestMonitorAlertNames(t *testing.T) {
+	assert.DeepEqual(t, MonitorAlerts(0).Names(), []string{})
+	assert.Equal(t, (AlertBell | AlertSilence | AlertMatch).String(), "bell,silence,match")
+}
@@ -10,5 +10,78 @@ const (
 	DumpHTML           DumpFormat = 2    // HTML with inline CSS colors.
 	DumpFlagUnwrap     DumpFormat = 0x10 // Bit 4: join soft-wrapped lines.
 	DumpFlagScrollback DumpFormat = 0x20 // Bit 5: include scrollback history.
-	DumpFormatMask     DumpFormat = 0x0F // Bits 0-3: format selector.
+	// Bit 6: only the output of the last command the shell reported.
+	// Daemons without CapCommands ignore it, so check first.
+	DumpFlagLastCommand DumpFormat = 0x40
+	DumpFormatMask      DumpFormat = 0x0F // Bits 0-3: format selector.
 )
+
+// DumpChunkSize bounds the data carried by one DumpChunk.
+const DumpChunkSize = 256 << 10
+
+// WriteDump answers a Dump request with data: a DumpChunk stream with
+// CapChunkedDump, a single DumpResponse otherwise.
+func (c *Conn) WriteDump(data []byte) error {
+	w := c.DumpWriter()
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// DumpWriter answers a Dump request with a dump written as it is
+// rendered. With CapChunkedDump every Write goes out as DumpChunk
+// messages and Close sends DumpEnd; otherwise the dump is collected and
+// Close sends it as one DumpResponse. A dump that fails part way ends
+// with an Error instead of Close.
+type DumpWriter struct {
+	c      *Conn
+	stream bool
+	buf    []byte
+}
+
+func (c *Conn) DumpWriter() *DumpWriter {
+	return &DumpWriter{c: c, stream: c.streamsDumps()}
+}
+
+func (w *DumpWriter) Write(p []byte) (int, error) {
+	if !w.stream {
+		w.buf = append(w.buf, p...)
+		return len(p), nil
+	}
+	for data := p; len(data) > 0; {
+		n := min(len(data), DumpChunkSize)
+		if err := w.c.WriteMessage(&DumpChunk{Data: data[:n]}); err != nil {
+			return len(p) - len(data), err
+		}
+		data = data[n:]
+	}
+	return len(p), nil
+}
+
+func (w *DumpWriter) Close() error {
+	if !w.stream {
+		return w.c.WriteMessage(&DumpResponse{Data: w.buf})
+	}
+	return w.c.WriteMessage(&DumpEnd{})
+}
+
+// WriteAttached writes m. With CapChunkedDump the screen dump follows
+// Attached as a chunk stream rather than inside it.
+func (c *Conn) WriteAttached(m *Attached) error {
+	if !c.streamsDumps() {
+		return c.WriteMessage(m)
+	}
+	head := *m
+	head.ScreenDump = nil
+	if err := c.WriteMessage(&head); err != nil {
+		return err
+	}
+	return c.WriteDump(m.ScreenDump)
+}
+
+// streamsDumps reports whether dumps go out as chunk streams. Connections
+// that never handshake keep the single-frame form.
+func (c *Conn) streamsDumps() bool {
+	return c.caps.negotiated && c.caps.has(CapChunkedDump)
+}
@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func readAll(t *testing.T, c *Conn, buf *bytes.Buffer) []Message {
+	t.Helper()
+	var msgs []Message
+	for buf.Len() > 0 {
+		msg, err := c.ReadMessage()
+		assert.NilError(t, err)
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestWriteDumpStreamsChunks(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 2*DumpChunkSize+10)
+
+	var buf bytes.Buffer
+	c := NewConn(&buf)
+	c.caps = negotiatedSet(ProtocolVersion, []Capability{CapChunkedDump})
+	assert.NilError(t, c.WriteDump(data))
+
+	msgs := readAll(t, c, &buf)
+	assert.Equal(t, len(msgs), 4)
+	var got []byte
+	for _, msg := range msgs[:3] {
+		chunk := msg.(*DumpChunk)
+		assert.Assert(t, len(chunk.Data) <= DumpChunkSize)
+		got = append(got, chunk.Data...)
+	}
+	assert.Assert(t, bytes.Equal(got, data))
+	assert.DeepEqual(t, msgs[3], &DumpEnd{})
+}
+
+func TestWriteDumpEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewConn(&buf)
+	c.caps = negotiatedSet(ProtocolVersion, []Capability{CapChunkedDump})
+	assert.NilError(t, c.WriteDump(nil))
+
+	assert.DeepEqual(t, readAll(t, c, &buf), []Message{&DumpEnd{}})
+}
+
+func TestWriteDumpWithoutCapability(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewConn(&buf)
+	c.caps = negotiatedSet(ProtocolVersion, nil)
+	assert.NilError(t, c.WriteDump([]byte("screen")))
+
+	assert.DeepEqual(t, readAll(t, c, &buf), []Message{&DumpResponse{Data: []byte("screen")}})
+}
+
+func TestDumpWriterSendsEachWrite(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewConn(&buf)
+	c.caps = negotiatedSet(ProtocolVersion, []Capability{CapChunkedDump})
+	w := c.DumpWriter()
+	_, err := w.Write([]byte("one"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, readAll(t, c, &buf), []Message{&DumpChunk{Data: []byte("one")}})
+	_, err = w.Write([]byte("two"))
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+
+	assert.DeepEqual(t, readAll(t, c, &buf), []Message{&DumpChunk{Data: []byte("two")}, &DumpEnd{}})
+}
+
+func TestDumpWriterWithoutCapability(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewConn(&buf)
+	c.caps = negotiatedSet(ProtocolVersion, nil)
+	w := c.DumpWriter()
+	_, err := w.Write([]byte("one"))
+	assert.NilError(t, err)
+	_, err = w.Write([]byte("two"))
+	assert.NilError(t, err)
+	assert.Equal(t, buf.Len(), 0)
+	assert.NilError(t, w.Close())
+
+	assert.DeepEqual(t, readAll(t, c, &buf), []Message{&DumpResponse{Data: []byte("onetwo")}})
+}
+
+func TestWriteAttachedStreamsScreenDump(t *testing.T) {
+	attached := &Attached{Name: "s", ClientID: "1", Cols: 80, Rows: 24, ScreenDump: []byte("screen")}
+
+	var buf bytes.Buffer
+	c := NewConn(&buf)
+	c.caps = negotiatedSet(ProtocolVersion, []Capability{CapChunkedDump})
+	assert.NilError(t, c.WriteAttached(attached))
+
+	assert.DeepEqual(t, readAll(t, c, &buf), []Message{
+		&Attached{Name: "s", ClientID: "1", Cols: 80, Rows: 24, ScreenDump: []byte{}},
+		&DumpChunk{Data: []byte("screen")},
+		&DumpEnd{},
+	})
+	assert.DeepEqual(t, attached.ScreenDump, []byte("screen"))
+}
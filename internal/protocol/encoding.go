@@ -7,8 +7,9 @@ import (
 )
 
 type Encoder struct {
-	w   io.Writer
-	buf [4]byte
+	w    io.Writer
+	buf  [8]byte
+	caps capabilitySet
 }
 
 func NewEncoder(w io.Writer) *Encoder {
@@ -33,6 +34,12 @@ func (e *Encoder) WriteU32(v uint32) error {
 	return err
 }
 
+func (e *Encoder) WriteU64(v uint64) error {
+	binary.BigEndian.PutUint64(e.buf[:8], v)
+	_, err := e.w.Write(e.buf[:8])
+	return err
+}
+
 func (e *Encoder) WriteI32(v int32) error {
 	binary.BigEndian.PutUint32(e.buf[:4], uint32(v))
 	_, err := e.w.Write(e.buf[:4])
@@ -87,8 +94,9 @@ func (e *Encoder) WriteBytes(v []byte) error {
 }
 
 type Decoder struct {
-	r   io.Reader
-	buf [4]byte
+	r    io.Reader
+	buf  [8]byte
+	caps capabilitySet
 }
 
 func NewDecoder(r io.Reader) *Decoder {
@@ -116,6 +124,13 @@ func (d *Decoder) ReadU32() (uint32, error) {
 	return binary.BigEndian.Uint32(d.buf[:4]), nil
 }
 
+func (d *Decoder) ReadU64() (uint64, error) {
+	if _, err := io.ReadFull(d.r, d.buf[:8]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(d.buf[:8]), nil
+}
+
 func (d *Decoder) ReadI32() (int32, error) {
 	if _, err := io.ReadFull(d.r, d.buf[:4]); err != nil {
 		return 0, err
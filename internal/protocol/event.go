@@ -0,0 +1,156 @@
+package protocol
+
+// EventKind identifies a session or client lifecycle event.
+type EventKind uint8
+
+const (
+	EventCreated   EventKind = 1
+	EventRestored  EventKind = 2
+	EventExited    EventKind = 3 // Exit is set.
+	EventRestarted EventKind = 4 // The restart policy reran the command.
+	EventAttached  EventKind = 5
+	EventDetached  EventKind = 6
+	EventKicked    EventKind = 7
+
+	// Monitor events need CapMonitor. They fire only while no client is
+	// attached.
+	EventBell     EventKind = 8
+	EventActivity EventKind = 9
+	EventSilence  EventKind = 10
+	EventNotify   EventKind = 11 // An OSC 9 or 777 notification; Message is its text.
+	EventMatch    EventKind = 12 // Message is the output line that matched.
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventCreated:
+		return "created"
+	case EventRestored:
+		return "restored"
+	case EventExited:
+		return "exited"
+	case EventRestarted:
+		return "restarted"
+	case EventAttached:
+		return "attached"
+	case EventDetached:
+		return "detached"
+	case EventKicked:
+		return "kicked"
+	case EventBell:
+		return "bell"
+	case EventActivity:
+		return "activity"
+	case EventSilence:
+		return "silence"
+	case EventNotify:
+		return "notify"
+	case EventMatch:
+		return "match"
+	default:
+		return "unknown"
+	}
+}
+
+// Subscribe turns a control connection into an event stream: the daemon
+// replies OK and then sends Event messages until the connection closes.
+// Empty Names subscribes to every session.
+type Subscribe struct {
+	Names []string
+}
+
+func (m *Subscribe) Type() MessageType { return TypeSubscribe }
+
+func (m *Subscribe) encode(e *Encoder) error {
+	return e.WriteStringSlice(m.Names)
+}
+
+func (m *Subscribe) decode(d *Decoder) error {
+	var err error
+	m.Names, err = d.ReadStringSlice()
+	return err
+}
+
+type Event struct {
+	Kind    EventKind
+	Session string
+	// Time is Unix seconds.
+	Time uint32
+	// PID is the session's process for created, restored and restarted.
+	PID uint32
+	// ClientID is set for attached, detached and kicked.
+	ClientID string
+	Exit     SessionExit
+	// Dropped counts the events this subscriber lost since the previous
+	// one it received, because it fell behind.
+	Dropped uint32
+	// Message is set for notify and match. It is on the wire only with
+	// CapMonitor.
+	Message string
+}
+
+// IsMonitor reports whether k is a monitor event.
+func (k EventKind) IsMonitor() bool {
+	return k >= EventBell && k <= EventMatch
+}
+
+func (m *Event) Type() MessageType { return TypeEvent }
+
+func (m *Event) encode(e *Encoder) error {
+	if err := e.WriteU8(uint8(m.Kind)); err != nil {
+		return err
+	}
+	if err := e.WriteString(m.Session); err != nil {
+		return err
+	}
+	if err := e.WriteU32(m.Time); err != nil {
+		return err
+	}
+	if err := e.WriteU32(m.PID); err != nil {
+		return err
+	}
+	if err := e.WriteString(m.ClientID); err != nil {
+		return err
+	}
+	if err := encodeSessionExit(e, m.Exit); err != nil {
+		return err
+	}
+	if err := e.WriteU32(m.Dropped); err != nil {
+		return err
+	}
+	if !e.caps.has(CapMonitor) {
+		return nil
+	}
+	return e.WriteString(m.Message)
+}
+
+func (m *Event) decode(d *Decoder) error {
+	kind, err := d.ReadU8()
+	if err != nil {
+		return err
+	}
+	m.Kind = EventKind(kind)
+	if m.Session, err = d.ReadString(); err != nil {
+		return err
+	}
+	if m.Time, err = d.ReadU32(); err != nil {
+		return err
+	}
+	if m.PID, err = d.ReadU32(); err != nil {
+		return err
+	}
+	if m.ClientID, err = d.ReadString(); err != nil {
+		return err
+	}
+	if m.Exit, err = decodeSessionExit(d); err != nil {
+		return err
+	}
+	if m.Dropped, err = d.ReadU32(); err != nil {
+		return err
+	}
+	if !d.caps.has(CapMonitor) {
+		return nil
+	}
+	m.Message, err = d.ReadString()
+	return err
+}
@@ -0,0 +1,10 @@
+package protocol
+
+// LogFormat selects what a session logger writes to disk.
+type LogFormat uint8
+
+const (
+	LogDefault LogFormat = 0 // Daemon's configured format.
+	LogRaw     LogFormat = 1 // PTY byte stream as received.
+	LogPlain   LogFormat = 2 // Plain text lines rendered by the formatter.
+)
@@ -6,31 +6,47 @@ import "fmt"
 type MessageType uint8
 
 const (
-	TypeAttach  MessageType = 0x01
-	TypeInput   MessageType = 0x02
-	TypeResize  MessageType = 0x03
-	TypeDetach  MessageType = 0x04
-	TypeList    MessageType = 0x05
-	TypeKill    MessageType = 0x06
-	TypeSend    MessageType = 0x07
-	TypeDump    MessageType = 0x08
-	TypePrune   MessageType = 0x09
-	TypeSendKey MessageType = 0x0A
-	TypeCreate  MessageType = 0x0B
-	TypeStatus  MessageType = 0x0C
-	TypeKick    MessageType = 0x0D
-
-	TypeOK             MessageType = 0x80
-	TypeError          MessageType = 0x81
-	TypeOutput         MessageType = 0x82
-	TypeAttached       MessageType = 0x83
-	TypeSessions       MessageType = 0x84
-	TypeExited         MessageType = 0x85
-	TypeDumpResponse   MessageType = 0x86
-	TypePruneResponse  MessageType = 0x87
-	TypeClientsChanged MessageType = 0x88
-	TypeStatusResponse MessageType = 0x89
-	TypeCreated        MessageType = 0x8A
+	TypeAttach    MessageType = 0x01
+	TypeInput     MessageType = 0x02
+	TypeResize    MessageType = 0x03
+	TypeDetach    MessageType = 0x04
+	TypeList      MessageType = 0x05
+	TypeKill      MessageType = 0x06
+	TypeSend      MessageType = 0x07
+	TypeDump      MessageType = 0x08
+	TypePrune     MessageType = 0x09
+	TypeSendKey   MessageType = 0x0A
+	TypeCreate    MessageType = 0x0B
+	TypeStatus    MessageType = 0x0C
+	TypeKick      MessageType = 0x0D
+	TypeWatch     MessageType = 0x0E
+	TypeLog       MessageType = 0x0F
+	TypeRecord    MessageType = 0x10
+	TypeSearch    MessageType = 0x11
+	TypeSubscribe MessageType = 0x12
+	TypeMonitor   MessageType = 0x13
+	TypeUpgrade   MessageType = 0x14
+	TypeHistory   MessageType = 0x15
+	TypeExec      MessageType = 0x16
+
+	TypeOK              MessageType = 0x80
+	TypeError           MessageType = 0x81
+	TypeOutput          MessageType = 0x82
+	TypeAttached        MessageType = 0x83
+	TypeSessions        MessageType = 0x84
+	TypeExited          MessageType = 0x85
+	TypeDumpResponse    MessageType = 0x86
+	TypePruneResponse   MessageType = 0x87
+	TypeClientsChanged  MessageType = 0x88
+	TypeStatusResponse  MessageType = 0x89
+	TypeCreated         MessageType = 0x8A
+	TypeWatchResponse   MessageType = 0x8B
+	TypeSearchResponse  MessageType = 0x8C
+	TypeDumpChunk       MessageType = 0x8D
+	TypeDumpEnd         MessageType = 0x8E
+	TypeEvent           MessageType = 0x8F
+	TypeHistoryResponse MessageType = 0x90
+	TypeExecResponse    MessageType = 0x91
 )
 
 type Message interface {
@@ -43,6 +59,16 @@ type SessionClient struct {
 	ClientID string
 	ReadOnly bool
 	Version  string
+	PID      uint32
+	// Protocol and Features are what the client negotiated; they are on
+	// the wire only with CapClientInfo.
+	Protocol uint8
+	Features []Capability
+	// Resyncs counts how often the client fell behind and was sent a
+	// fresh screen, and Dropped the output bytes it skipped. They are on
+	// the wire only with both CapClientInfo and CapClientLag.
+	Resyncs uint32
+	Dropped uint64
 }
 
 type SessionState string
@@ -62,6 +88,23 @@ type Session struct {
 	SavedAt   uint32
 	CWD       string
 	Clients   []SessionClient
+	Exit      SessionExit
+	// Command is the command the session was started with; empty means
+	// the default shell.
+	Command []string
+	// Alerts are the monitor alerts raised since a client last attached.
+	// They are on the wire only with CapMonitor.
+	Alerts MonitorAlerts
+}
+
+// SessionExit describes how a session's process exited. The other
+// fields are set only when Exited is true.
+type SessionExit struct {
+	Exited bool
+	Code   int32
+	// Signal names the signal that killed the process, e.g. "SIGTERM".
+	Signal string
+	At     uint32
 }
 
 type DaemonStatus struct {
@@ -81,6 +124,17 @@ type SessionStatus struct {
 	PID     uint32
 	CWD     string
 	Clients []SessionClient
+	Exit    SessionExit
+	Command []string
+	Restart RestartPolicy
+	// Restarts counts how often the restart policy has rerun the command.
+	Restarts uint32
+	// SlowKicks counts clients kicked for falling behind the session's
+	// output. It is on the wire only with CapClientLag.
+	SlowKicks uint32
+	// Monitor and Alerts are on the wire only with CapMonitor.
+	Monitor MonitorSettings
+	Alerts  MonitorAlerts
 }
 
 func encodeSessionClients(e *Encoder, clients []SessionClient) error {
@@ -98,10 +152,63 @@ func encodeSessionClients(e *Encoder, clients []SessionClient) error {
 		if err := e.WriteString(c.Version); err != nil {
 			return err
 		}
+		if e.caps.legacy() {
+			continue
+		}
+		if err := e.WriteU32(c.PID); err != nil {
+			return err
+		}
+		if !e.caps.has(CapClientInfo) {
+			continue
+		}
+		if err := e.WriteU8(c.Protocol); err != nil {
+			return err
+		}
+		if err := encodeCapabilities(e, c.Features); err != nil {
+			return err
+		}
+		if !e.caps.has(CapClientLag) {
+			continue
+		}
+		if err := e.WriteU32(c.Resyncs); err != nil {
+			return err
+		}
+		if err := e.WriteU64(c.Dropped); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+func encodeSessionExit(e *Encoder, exit SessionExit) error {
+	if err := e.WriteBool(exit.Exited); err != nil {
+		return err
+	}
+	if err := e.WriteI32(exit.Code); err != nil {
+		return err
+	}
+	if err := e.WriteString(exit.Signal); err != nil {
+		return err
+	}
+	return e.WriteU32(exit.At)
+}
+
+func decodeSessionExit(d *Decoder) (SessionExit, error) {
+	var exit SessionExit
+	var err error
+	if exit.Exited, err = d.ReadBool(); err != nil {
+		return exit, err
+	}
+	if exit.Code, err = d.ReadI32(); err != nil {
+		return exit, err
+	}
+	if exit.Signal, err = d.ReadString(); err != nil {
+		return exit, err
+	}
+	exit.At, err = d.ReadU32()
+	return exit, err
+}
+
 func decodeSessionClients(d *Decoder) ([]SessionClient, error) {
 	count, err := d.ReadU32()
 	if err != nil {
@@ -122,6 +229,30 @@ func decodeSessionClients(d *Decoder) ([]SessionClient, error) {
 		if c.Version, err = d.ReadString(); err != nil {
 			return nil, err
 		}
+		if d.caps.legacy() {
+			continue
+		}
+		if c.PID, err = d.ReadU32(); err != nil {
+			return nil, err
+		}
+		if !d.caps.has(CapClientInfo) {
+			continue
+		}
+		if c.Protocol, err = d.ReadU8(); err != nil {
+			return nil, err
+		}
+		if c.Features, err = decodeCapabilities(d); err != nil {
+			return nil, err
+		}
+		if !d.caps.has(CapClientLag) {
+			continue
+		}
+		if c.Resyncs, err = d.ReadU32(); err != nil {
+			return nil, err
+		}
+		if c.Dropped, err = d.ReadU64(); err != nil {
+			return nil, err
+		}
 	}
 	return clients, nil
 }
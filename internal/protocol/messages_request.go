@@ -7,6 +7,17 @@ type Create struct {
 	CWD        string
 	Scrollback uint32
 	Force      bool
+	LogPath    string
+	LogFormat  LogFormat
+	Restart    RestartPolicy
+	// Cols and Rows are zero for the daemon's default size. They and the
+	// fields after them are on the wire only with CapSessionSize.
+	Cols   uint16
+	Rows   uint16
+	Xpixel uint16
+	Ypixel uint16
+	// ResizePolicy is empty for the daemon's configured policy.
+	ResizePolicy string
 }
 
 func (m *Create) Type() MessageType { return TypeCreate }
@@ -27,7 +38,37 @@ func (m *Create) encode(e *Encoder) error {
 	if err := e.WriteU32(m.Scrollback); err != nil {
 		return err
 	}
-	return e.WriteBool(m.Force)
+	if err := e.WriteBool(m.Force); err != nil {
+		return err
+	}
+	if e.caps.legacy() {
+		return nil
+	}
+	if err := e.WriteString(m.LogPath); err != nil {
+		return err
+	}
+	if err := e.WriteU8(uint8(m.LogFormat)); err != nil {
+		return err
+	}
+	if err := e.WriteU8(uint8(m.Restart)); err != nil {
+		return err
+	}
+	if !e.caps.has(CapSessionSize) {
+		return nil
+	}
+	if err := e.WriteU16(m.Cols); err != nil {
+		return err
+	}
+	if err := e.WriteU16(m.Rows); err != nil {
+		return err
+	}
+	if err := e.WriteU16(m.Xpixel); err != nil {
+		return err
+	}
+	if err := e.WriteU16(m.Ypixel); err != nil {
+		return err
+	}
+	return e.WriteString(m.ResizePolicy)
 }
 
 func (m *Create) decode(d *Decoder) error {
@@ -47,7 +88,41 @@ func (m *Create) decode(d *Decoder) error {
 	if m.Scrollback, err = d.ReadU32(); err != nil {
 		return err
 	}
-	m.Force, err = d.ReadBool()
+	if m.Force, err = d.ReadBool(); err != nil {
+		return err
+	}
+	if d.caps.legacy() {
+		return nil
+	}
+	if m.LogPath, err = d.ReadString(); err != nil {
+		return err
+	}
+	format, err := d.ReadU8()
+	if err != nil {
+		return err
+	}
+	m.LogFormat = LogFormat(format)
+	restart, err := d.ReadU8()
+	if err != nil {
+		return err
+	}
+	m.Restart = RestartPolicy(restart)
+	if !d.caps.has(CapSessionSize) {
+		return nil
+	}
+	if m.Cols, err = d.ReadU16(); err != nil {
+		return err
+	}
+	if m.Rows, err = d.ReadU16(); err != nil {
+		return err
+	}
+	if m.Xpixel, err = d.ReadU16(); err != nil {
+		return err
+	}
+	if m.Ypixel, err = d.ReadU16(); err != nil {
+		return err
+	}
+	m.ResizePolicy, err = d.ReadString()
 	return err
 }
 
@@ -63,6 +138,8 @@ type Attach struct {
 	ReadOnly   bool
 	Restore    bool
 	Scrollback uint32
+	LogPath    string
+	LogFormat  LogFormat
 }
 
 func (m *Attach) Type() MessageType { return TypeAttach }
@@ -98,7 +175,16 @@ func (m *Attach) encode(e *Encoder) error {
 	if err := e.WriteBool(m.Restore); err != nil {
 		return err
 	}
-	return e.WriteU32(m.Scrollback)
+	if err := e.WriteU32(m.Scrollback); err != nil {
+		return err
+	}
+	if e.caps.legacy() {
+		return nil
+	}
+	if err := e.WriteString(m.LogPath); err != nil {
+		return err
+	}
+	return e.WriteU8(uint8(m.LogFormat))
 }
 
 func (m *Attach) decode(d *Decoder) error {
@@ -133,7 +219,18 @@ func (m *Attach) decode(d *Decoder) error {
 	if m.Restore, err = d.ReadBool(); err != nil {
 		return err
 	}
-	m.Scrollback, err = d.ReadU32()
+	if m.Scrollback, err = d.ReadU32(); err != nil {
+		return err
+	}
+	if d.caps.legacy() {
+		return nil
+	}
+	if m.LogPath, err = d.ReadString(); err != nil {
+		return err
+	}
+	var format uint8
+	format, err = d.ReadU8()
+	m.LogFormat = LogFormat(format)
 	return err
 }
 
@@ -323,6 +420,26 @@ func (m *Prune) Type() MessageType       { return TypePrune }
 func (m *Prune) encode(_ *Encoder) error { return nil }
 func (m *Prune) decode(_ *Decoder) error { return nil }
 
+// Upgrade asks the daemon to re-exec the ht binary at Path, handing its
+// listener and sessions to the new process. The exec closes the
+// connection; the daemon replies only with an Error when it cannot
+// upgrade.
+type Upgrade struct {
+	Path string
+}
+
+func (m *Upgrade) Type() MessageType { return TypeUpgrade }
+
+func (m *Upgrade) encode(e *Encoder) error {
+	return e.WriteString(m.Path)
+}
+
+func (m *Upgrade) decode(d *Decoder) error {
+	var err error
+	m.Path, err = d.ReadString()
+	return err
+}
+
 type Kick struct {
 	Name     string
 	ClientID string
@@ -361,3 +478,246 @@ func (m *Status) decode(d *Decoder) error {
 	m.Name, err = d.ReadString()
 	return err
 }
+
+// Watch blocks until the screen matches Pattern. A negative Row matches
+// the whole screen; Timeout and Stable are in milliseconds.
+type Watch struct {
+	Name    string
+	Pattern string
+	Regex   bool
+	Row     int32
+	// Timeout and Stable are milliseconds; a zero Timeout waits until
+	// the session exits or the client hangs up.
+	Timeout uint32
+	Stable  uint32
+	// Prompt also requires the shell to be at a prompt. It is on the
+	// wire only with CapCommands.
+	Prompt bool
+}
+
+func (m *Watch) Type() MessageType { return TypeWatch }
+
+func (m *Watch) encode(e *Encoder) error {
+	if err := e.WriteString(m.Name); err != nil {
+		return err
+	}
+	if err := e.WriteString(m.Pattern); err != nil {
+		return err
+	}
+	if err := e.WriteBool(m.Regex); err != nil {
+		return err
+	}
+	if err := e.WriteI32(m.Row); err != nil {
+		return err
+	}
+	if err := e.WriteU32(m.Timeout); err != nil {
+		return err
+	}
+	if err := e.WriteU32(m.Stable); err != nil {
+		return err
+	}
+	if !e.caps.has(CapCommands) {
+		return nil
+	}
+	return e.WriteBool(m.Prompt)
+}
+
+func (m *Watch) decode(d *Decoder) error {
+	var err error
+	if m.Name, err = d.ReadString(); err != nil {
+		return err
+	}
+	if m.Pattern, err = d.ReadString(); err != nil {
+		return err
+	}
+	if m.Regex, err = d.ReadBool(); err != nil {
+		return err
+	}
+	if m.Row, err = d.ReadI32(); err != nil {
+		return err
+	}
+	if m.Timeout, err = d.ReadU32(); err != nil {
+		return err
+	}
+	if m.Stable, err = d.ReadU32(); err != nil {
+		return err
+	}
+	if !d.caps.has(CapCommands) {
+		return nil
+	}
+	m.Prompt, err = d.ReadBool()
+	return err
+}
+
+// Log starts or stops logging a live session's output. Path and Format
+// are ignored when Enable is false.
+type Log struct {
+	Name   string
+	Enable bool
+	Path   string
+	Format LogFormat
+}
+
+func (m *Log) Type() MessageType { return TypeLog }
+
+func (m *Log) encode(e *Encoder) error {
+	if err := e.WriteString(m.Name); err != nil {
+		return err
+	}
+	if err := e.WriteBool(m.Enable); err != nil {
+		return err
+	}
+	if err := e.WriteString(m.Path); err != nil {
+		return err
+	}
+	return e.WriteU8(uint8(m.Format))
+}
+
+func (m *Log) decode(d *Decoder) error {
+	var err error
+	if m.Name, err = d.ReadString(); err != nil {
+		return err
+	}
+	if m.Enable, err = d.ReadBool(); err != nil {
+		return err
+	}
+	if m.Path, err = d.ReadString(); err != nil {
+		return err
+	}
+	var format uint8
+	format, err = d.ReadU8()
+	m.Format = LogFormat(format)
+	return err
+}
+
+// Record starts or stops an asciicast v2 recording of a live session.
+// Path is ignored when Enable is false.
+type Record struct {
+	Name   string
+	Enable bool
+	Path   string
+}
+
+func (m *Record) Type() MessageType { return TypeRecord }
+
+func (m *Record) encode(e *Encoder) error {
+	if err := e.WriteString(m.Name); err != nil {
+		return err
+	}
+	if err := e.WriteBool(m.Enable); err != nil {
+		return err
+	}
+	return e.WriteString(m.Path)
+}
+
+func (m *Record) decode(d *Decoder) error {
+	var err error
+	if m.Name, err = d.ReadString(); err != nil {
+		return err
+	}
+	if m.Enable, err = d.ReadBool(); err != nil {
+		return err
+	}
+	m.Path, err = d.ReadString()
+	return err
+}
+
+// Search looks for Pattern in the screen and scrollback of the named
+// sessions, or of every live session when Names is empty. Dead adds the
+// saved state of dead sessions to an unnamed search.
+type Search struct {
+	Pattern string
+	Regex   bool
+	Names   []string
+	Dead    bool
+	// Limit caps the matches returned; zero leaves only the response
+	// size cap.
+	Limit uint32
+}
+
+func (m *Search) Type() MessageType { return TypeSearch }
+
+func (m *Search) encode(e *Encoder) error {
+	if err := e.WriteString(m.Pattern); err != nil {
+		return err
+	}
+	if err := e.WriteBool(m.Regex); err != nil {
+		return err
+	}
+	if err := e.WriteStringSlice(m.Names); err != nil {
+		return err
+	}
+	if err := e.WriteBool(m.Dead); err != nil {
+		return err
+	}
+	return e.WriteU32(m.Limit)
+}
+
+func (m *Search) decode(d *Decoder) error {
+	var err error
+	if m.Pattern, err = d.ReadString(); err != nil {
+		return err
+	}
+	if m.Regex, err = d.ReadBool(); err != nil {
+		return err
+	}
+	if m.Names, err = d.ReadStringSlice(); err != nil {
+		return err
+	}
+	if m.Dead, err = d.ReadBool(); err != nil {
+		return err
+	}
+	m.Limit, err = d.ReadU32()
+	return err
+}
+
+// History asks for the commands a live session's shell reported
+// through OSC 133 marks.
+type History struct {
+	Name string
+}
+
+func (m *History) Type() MessageType { return TypeHistory }
+
+func (m *History) encode(e *Encoder) error {
+	return e.WriteString(m.Name)
+}
+
+func (m *History) decode(d *Decoder) error {
+	var err error
+	m.Name, err = d.ReadString()
+	return err
+}
+
+// Exec runs Command at the prompt of a live session's shell, which must
+// report OSC 133 marks, and waits for it to finish. Timeout is in
+// milliseconds; 0 waits as long as the session runs.
+type Exec struct {
+	Name    string
+	Command string
+	Timeout uint32
+}
+
+func (m *Exec) Type() MessageType { return TypeExec }
+
+func (m *Exec) encode(e *Encoder) error {
+	if err := e.WriteString(m.Name); err != nil {
+		return err
+	}
+	if err := e.WriteString(m.Command); err != nil {
+		return err
+	}
+	return e.WriteU32(m.Timeout)
+}
+
+func (m *Exec) decode(d *Decoder) error {
+	var err error
+	if m.Name, err = d.ReadString(); err != nil {
+		return err
+	}
+	if m.Command, err = d.ReadString(); err != nil {
+		return err
+	}
+	m.Timeout, err = d.ReadU32()
+	return err
+}
@@ -25,6 +25,14 @@ func TestRequestMessageTypes(t *testing.T) {
 		{"Prune", &Prune{}, TypePrune},
 		{"Kick", &Kick{}, TypeKick},
 		{"Status", &Status{}, TypeStatus},
+		{"Watch", &Watch{}, TypeWatch},
+		{"Log", &Log{}, TypeLog},
+		{"Record", &Record{}, TypeRecord},
+		{"Search", &Search{}, TypeSearch},
+		{"Subscribe", &Subscribe{}, TypeSubscribe},
+		{"Monitor", &Monitor{}, TypeMonitor},
+		{"History", &History{}, TypeHistory},
+		{"Exec", &Exec{}, TypeExec},
 	}
 
 	for _, tt := range tests {
@@ -42,6 +50,8 @@ func TestCreateEncodeDecode(t *testing.T) {
 		CWD:        "/home/user",
 		Scrollback: 10000,
 		Force:      true,
+		LogPath:    "/tmp/test-session.log",
+		LogFormat:  LogPlain,
 	}
 
 	got := roundTrip(t, message).(*Create)
@@ -61,6 +71,8 @@ func TestAttachEncodeDecode(t *testing.T) {
 		ReadOnly:   true,
 		Restore:    false,
 		Scrollback: 5000,
+		LogPath:    "/tmp/session-1.log",
+		LogFormat:  LogRaw,
 	}
 
 	got := roundTrip(t, message).(*Attach)
@@ -87,3 +99,54 @@ func TestSendKeyEncodeDecode(t *testing.T) {
 	got := roundTrip(t, message).(*SendKey)
 	assert.DeepEqual(t, got, message)
 }
+
+func TestWatchEncodeDecode(t *testing.T) {
+	message := &Watch{
+		Name:    "session-1",
+		Pattern: "ready-[0-9]+",
+		Regex:   true,
+		Row:     -1,
+		Timeout: 5000,
+		Stable:  250,
+		Prompt:  true,
+	}
+
+	got := roundTrip(t, message).(*Watch)
+	assert.DeepEqual(t, got, message)
+}
+
+func TestLogEncodeDecode(t *testing.T) {
+	message := &Log{
+		Name:   "session-1",
+		Enable: true,
+		Path:   "/var/log/session-1.log",
+		Format: LogPlain,
+	}
+
+	got := roundTrip(t, message).(*Log)
+	assert.DeepEqual(t, got, message)
+}
+
+func TestRecordEncodeDecode(t *testing.T) {
+	message := &Record{
+		Name:   "session-1",
+		Enable: true,
+		Path:   "/tmp/session-1.cast",
+	}
+
+	got := roundTrip(t, message).(*Record)
+	assert.DeepEqual(t, got, message)
+}
+
+func TestSearchEncodeDecode(t *testing.T) {
+	message := &Search{
+		Pattern: "err(or)?",
+		Regex:   true,
+		Names:   []string{"build", "test"},
+		Dead:    true,
+		Limit:   100,
+	}
+
+	got := roundTrip(t, message).(*Search)
+	assert.DeepEqual(t, got, message)
+}
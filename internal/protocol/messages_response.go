@@ -181,6 +181,21 @@ func (m *Sessions) encode(e *Encoder) error {
 		if err := encodeSessionClients(e, s.Clients); err != nil {
 			return err
 		}
+		if e.caps.legacy() {
+			continue
+		}
+		if err := encodeSessionExit(e, s.Exit); err != nil {
+			return err
+		}
+		if err := e.WriteStringSlice(s.Command); err != nil {
+			return err
+		}
+		if !e.caps.has(CapMonitor) {
+			continue
+		}
+		if err := e.WriteU8(uint8(s.Alerts)); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -225,6 +240,23 @@ func (m *Sessions) decode(d *Decoder) error {
 		if s.Clients, err = decodeSessionClients(d); err != nil {
 			return err
 		}
+		if d.caps.legacy() {
+			continue
+		}
+		if s.Exit, err = decodeSessionExit(d); err != nil {
+			return err
+		}
+		if s.Command, err = d.ReadStringSlice(); err != nil {
+			return err
+		}
+		if !d.caps.has(CapMonitor) {
+			continue
+		}
+		alerts, err := d.ReadU8()
+		if err != nil {
+			return err
+		}
+		s.Alerts = MonitorAlerts(alerts)
 	}
 	return nil
 }
@@ -261,6 +293,32 @@ func (m *DumpResponse) decode(d *Decoder) error {
 	return err
 }
 
+// DumpChunk carries part of a streamed dump; see Conn.WriteDump.
+type DumpChunk struct {
+	Data []byte
+}
+
+func (m *DumpChunk) Type() MessageType { return TypeDumpChunk }
+
+func (m *DumpChunk) encode(e *Encoder) error {
+	return e.WriteBytes(m.Data)
+}
+
+func (m *DumpChunk) decode(d *Decoder) error {
+	var err error
+	m.Data, err = d.ReadBytes()
+	return err
+}
+
+// DumpEnd terminates a streamed dump.
+type DumpEnd struct{}
+
+func (m *DumpEnd) Type() MessageType { return TypeDumpEnd }
+
+func (m *DumpEnd) encode(_ *Encoder) error { return nil }
+
+func (m *DumpEnd) decode(_ *Decoder) error { return nil }
+
 type PruneResponse struct {
 	Count uint32
 }
@@ -357,7 +415,36 @@ func (m *StatusResponse) encode(e *Encoder) error {
 	if err := e.WriteString(m.Session.CWD); err != nil {
 		return err
 	}
-	return encodeSessionClients(e, m.Session.Clients)
+	if err := encodeSessionClients(e, m.Session.Clients); err != nil {
+		return err
+	}
+	if e.caps.legacy() {
+		return nil
+	}
+	if err := encodeSessionExit(e, m.Session.Exit); err != nil {
+		return err
+	}
+	if err := e.WriteStringSlice(m.Session.Command); err != nil {
+		return err
+	}
+	if err := e.WriteU8(uint8(m.Session.Restart)); err != nil {
+		return err
+	}
+	if err := e.WriteU32(m.Session.Restarts); err != nil {
+		return err
+	}
+	if e.caps.has(CapClientLag) {
+		if err := e.WriteU32(m.Session.SlowKicks); err != nil {
+			return err
+		}
+	}
+	if !e.caps.has(CapMonitor) {
+		return nil
+	}
+	if err := encodeMonitorSettings(e, m.Session.Monitor); err != nil {
+		return err
+	}
+	return e.WriteU8(uint8(m.Session.Alerts))
 }
 
 func (m *StatusResponse) decode(d *Decoder) error {
@@ -408,6 +495,258 @@ func (m *StatusResponse) decode(d *Decoder) error {
 	if m.Session.CWD, err = d.ReadString(); err != nil {
 		return err
 	}
-	m.Session.Clients, err = decodeSessionClients(d)
+	if m.Session.Clients, err = decodeSessionClients(d); err != nil {
+		return err
+	}
+	if d.caps.legacy() {
+		return nil
+	}
+	if m.Session.Exit, err = decodeSessionExit(d); err != nil {
+		return err
+	}
+	if m.Session.Command, err = d.ReadStringSlice(); err != nil {
+		return err
+	}
+	restart, err := d.ReadU8()
+	if err != nil {
+		return err
+	}
+	m.Session.Restart = RestartPolicy(restart)
+	if m.Session.Restarts, err = d.ReadU32(); err != nil {
+		return err
+	}
+	if d.caps.has(CapClientLag) {
+		if m.Session.SlowKicks, err = d.ReadU32(); err != nil {
+			return err
+		}
+	}
+	if !d.caps.has(CapMonitor) {
+		return nil
+	}
+	if m.Session.Monitor, err = decodeMonitorSettings(d); err != nil {
+		return err
+	}
+	alerts, err := d.ReadU8()
+	if err != nil {
+		return err
+	}
+	m.Session.Alerts = MonitorAlerts(alerts)
+	return nil
+}
+
+type WatchResponse struct {
+	Matched bool
+}
+
+func (m *WatchResponse) Type() MessageType { return TypeWatchResponse }
+
+func (m *WatchResponse) encode(e *Encoder) error {
+	return e.WriteBool(m.Matched)
+}
+
+func (m *WatchResponse) decode(d *Decoder) error {
+	var err error
+	m.Matched, err = d.ReadBool()
+	return err
+}
+
+// SearchMatch is one matching line. Line counts unwrapped lines from the
+// oldest scrollback line, starting at 1.
+type SearchMatch struct {
+	Session string
+	Line    uint32
+	Text    string
+}
+
+type SearchResponse struct {
+	Matches []SearchMatch
+	// Truncated is set when matches were left out to keep to the
+	// request's Limit or to the response size cap.
+	Truncated bool
+}
+
+func (m *SearchResponse) Type() MessageType { return TypeSearchResponse }
+
+func (m *SearchResponse) encode(e *Encoder) error {
+	if err := e.WriteU32(uint32(len(m.Matches))); err != nil {
+		return err
+	}
+	for i := range m.Matches {
+		match := &m.Matches[i]
+		if err := e.WriteString(match.Session); err != nil {
+			return err
+		}
+		if err := e.WriteU32(match.Line); err != nil {
+			return err
+		}
+		if err := e.WriteString(match.Text); err != nil {
+			return err
+		}
+	}
+	return e.WriteBool(m.Truncated)
+}
+
+func (m *SearchResponse) decode(d *Decoder) error {
+	count, err := d.ReadU32()
+	if err != nil {
+		return err
+	}
+	if count > maxFrameSize {
+		return fmt.Errorf("match count %d exceeds maximum", count)
+	}
+	m.Matches = make([]SearchMatch, count)
+	for i := range m.Matches {
+		match := &m.Matches[i]
+		if match.Session, err = d.ReadString(); err != nil {
+			return err
+		}
+		if match.Line, err = d.ReadU32(); err != nil {
+			return err
+		}
+		if match.Text, err = d.ReadString(); err != nil {
+			return err
+		}
+	}
+	m.Truncated, err = d.ReadBool()
+	return err
+}
+
+// ShellCommand is one command a shell reported through OSC 133 marks.
+// StartedAt and FinishedAt are Unix seconds, FinishedAt 0 while the
+// command runs, and Duration is in milliseconds. Shells do not always
+// report an exit status, so ExitCode is set only with HasExit. The
+// output spans Rows screen rows from StartRow, counted from the oldest
+// scrollback row; Trimmed is set once it has left the scrollback.
+type ShellCommand struct {
+	Command    string
+	StartedAt  uint32
+	FinishedAt uint32
+	Duration   uint32
+	HasExit    bool
+	ExitCode   int32
+	StartRow   uint32
+	Rows       uint32
+	Trimmed    bool
+}
+
+type HistoryResponse struct {
+	Commands []ShellCommand
+	// AtPrompt is set while the shell waits for input at its prompt.
+	AtPrompt bool
+}
+
+func (m *HistoryResponse) Type() MessageType { return TypeHistoryResponse }
+
+func (m *HistoryResponse) encode(e *Encoder) error {
+	if err := e.WriteU32(uint32(len(m.Commands))); err != nil {
+		return err
+	}
+	for i := range m.Commands {
+		c := &m.Commands[i]
+		if err := e.WriteString(c.Command); err != nil {
+			return err
+		}
+		if err := e.WriteU32(c.StartedAt); err != nil {
+			return err
+		}
+		if err := e.WriteU32(c.FinishedAt); err != nil {
+			return err
+		}
+		if err := e.WriteU32(c.Duration); err != nil {
+			return err
+		}
+		if err := e.WriteBool(c.HasExit); err != nil {
+			return err
+		}
+		if err := e.WriteI32(c.ExitCode); err != nil {
+			return err
+		}
+		if err := e.WriteU32(c.StartRow); err != nil {
+			return err
+		}
+		if err := e.WriteU32(c.Rows); err != nil {
+			return err
+		}
+		if err := e.WriteBool(c.Trimmed); err != nil {
+			return err
+		}
+	}
+	return e.WriteBool(m.AtPrompt)
+}
+
+func (m *HistoryResponse) decode(d *Decoder) error {
+	count, err := d.ReadU32()
+	if err != nil {
+		return err
+	}
+	if count > maxFrameSize {
+		return fmt.Errorf("command count %d exceeds maximum", count)
+	}
+	m.Commands = make([]ShellCommand, count)
+	for i := range m.Commands {
+		c := &m.Commands[i]
+		if c.Command, err = d.ReadString(); err != nil {
+			return err
+		}
+		if c.StartedAt, err = d.ReadU32(); err != nil {
+			return err
+		}
+		if c.FinishedAt, err = d.ReadU32(); err != nil {
+			return err
+		}
+		if c.Duration, err = d.ReadU32(); err != nil {
+			return err
+		}
+		if c.HasExit, err = d.ReadBool(); err != nil {
+			return err
+		}
+		if c.ExitCode, err = d.ReadI32(); err != nil {
+			return err
+		}
+		if c.StartRow, err = d.ReadU32(); err != nil {
+			return err
+		}
+		if c.Rows, err = d.ReadU32(); err != nil {
+			return err
+		}
+		if c.Trimmed, err = d.ReadBool(); err != nil {
+			return err
+		}
+	}
+	m.AtPrompt, err = d.ReadBool()
+	return err
+}
+
+// ExecResponse reports how an Exec'd command finished. The command's
+// output follows as a dump, written with WriteDump. ExitCode is set only
+// with HasExit; TimedOut means the command was still running when the
+// timeout elapsed, and no output follows.
+type ExecResponse struct {
+	HasExit  bool
+	ExitCode int32
+	TimedOut bool
+}
+
+func (m *ExecResponse) Type() MessageType { return TypeExecResponse }
+
+func (m *ExecResponse) encode(e *Encoder) error {
+	if err := e.WriteBool(m.HasExit); err != nil {
+		return err
+	}
+	if err := e.WriteI32(m.ExitCode); err != nil {
+		return err
+	}
+	return e.WriteBool(m.TimedOut)
+}
+
+func (m *ExecResponse) decode(d *Decoder) error {
+	var err error
+	if m.HasExit, err = d.ReadBool(); err != nil {
+		return err
+	}
+	if m.ExitCode, err = d.ReadI32(); err != nil {
+		return err
+	}
+	m.TimedOut, err = d.ReadBool()
 	return err
 }
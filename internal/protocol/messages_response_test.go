@@ -23,6 +23,13 @@ func TestResponseMessageTypes(t *testing.T) {
 		{"ClientsChanged", &ClientsChanged{}, TypeClientsChanged},
 		{"StatusResponse", &StatusResponse{}, TypeStatusResponse},
 		{"Created", &Created{}, TypeCreated},
+		{"WatchResponse", &WatchResponse{}, TypeWatchResponse},
+		{"SearchResponse", &SearchResponse{}, TypeSearchResponse},
+		{"DumpChunk", &DumpChunk{}, TypeDumpChunk},
+		{"DumpEnd", &DumpEnd{}, TypeDumpEnd},
+		{"Event", &Event{}, TypeEvent},
+		{"HistoryResponse", &HistoryResponse{}, TypeHistoryResponse},
+		{"ExecResponse", &ExecResponse{}, TypeExecResponse},
 	}
 
 	for _, tt := range tests {
@@ -73,8 +80,9 @@ func TestSessionsEncodeDecode(t *testing.T) {
 				SavedAt:   0,
 				CWD:       "/home/user",
 				Clients: []SessionClient{
-					{ClientID: "c1", ReadOnly: false, Version: "abc123"},
+					{ClientID: "c1", ReadOnly: false, Version: "abc123", PID: 4001, Protocol: 9, Features: []Capability{CapWatch, CapClientInfo}},
 				},
+				Command: []string{"npm", "run", "dev"},
 			},
 			{
 				Name:      "s2",
@@ -86,6 +94,8 @@ func TestSessionsEncodeDecode(t *testing.T) {
 				SavedAt:   950,
 				CWD:       "/tmp",
 				Clients:   []SessionClient{},
+				Exit:      SessionExit{Exited: true, Code: 137, Signal: "SIGKILL", At: 960},
+				Command:   []string{"make", "test"},
 			},
 		},
 	}
@@ -94,6 +104,13 @@ func TestSessionsEncodeDecode(t *testing.T) {
 	assert.DeepEqual(t, got, message)
 }
 
+func TestWatchResponseEncodeDecode(t *testing.T) {
+	message := &WatchResponse{Matched: true}
+
+	got := roundTrip(t, message).(*WatchResponse)
+	assert.DeepEqual(t, got, message)
+}
+
 func TestClientsChangedEncodeDecode(t *testing.T) {
 	message := &ClientsChanged{
 		Count: 3,
@@ -123,8 +140,12 @@ func TestStatusResponseEncodeDecode(t *testing.T) {
 			PID:   5678,
 			CWD:   "/home/user",
 			Clients: []SessionClient{
-				{ClientID: "c1", ReadOnly: false, Version: "abc123"},
+				{ClientID: "c1", ReadOnly: false, Version: "abc123", PID: 4001, Protocol: 9, Features: []Capability{CapWatch, CapClientInfo}},
 			},
+			Exit:     SessionExit{Exited: true, Code: 1, At: 1700000000},
+			Command:  []string{"make"},
+			Restart:  RestartAlways,
+			Restarts: 2,
 		},
 	}
 
@@ -143,3 +164,13 @@ func TestStatusResponseNilSession(t *testing.T) {
 	got := roundTrip(t, message).(*StatusResponse)
 	assert.DeepEqual(t, got, message)
 }
+
+func TestSearchResponseEncodeDecode(t *testing.T) {
+	message := &SearchResponse{Matches: []SearchMatch{
+		{Session: "build", Line: 12, Text: "error: missing semicolon"},
+		{Session: "old", Line: 1, Text: "error"},
+	}, Truncated: true}
+
+	got := roundTrip(t, message).(*SearchResponse)
+	assert.DeepEqual(t, got, message)
+}
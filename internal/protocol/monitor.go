@@ -0,0 +1,105 @@
+package protocol
+
+import "strings"
+
+// MonitorSettings choose what a session reports while no client is
+// attached.
+type MonitorSettings struct {
+	Bell     bool
+	Activity bool
+	// Silence is how many seconds without output after activity raise a
+	// silence alert; 0 disables it.
+	Silence uint32
+	// Pattern is a regular expression matched against each line of
+	// output; empty disables it.
+	Pattern string
+}
+
+// MonitorAlerts is a set of alerts a session raised while no client was
+// attached.
+type MonitorAlerts uint8
+
+const (
+	AlertBell MonitorAlerts = 1 << iota
+	AlertActivity
+	AlertSilence
+	AlertMatch
+)
+
+var alertNames = []struct {
+	alert MonitorAlerts
+	name  string
+}{
+	{AlertBell, "bell"},
+	{AlertActivity, "activity"},
+	{AlertSilence, "silence"},
+	{AlertMatch, "match"},
+}
+
+// Names lists the alerts in a, e.g. ["bell", "silence"].
+func (a MonitorAlerts) Names() []string {
+	names := []string{}
+	for _, n := range alertNames {
+		if a&n.alert != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+func (a MonitorAlerts) String() string {
+	return strings.Join(a.Names(), ",")
+}
+
+// Monitor replaces the named session's monitor settings.
+type Monitor struct {
+	Name     string
+	Settings MonitorSettings
+}
+
+func (m *Monitor) Type() MessageType { return TypeMonitor }
+
+func (m *Monitor) encode(e *Encoder) error {
+	if err := e.WriteString(m.Name); err != nil {
+		return err
+	}
+	return encodeMonitorSettings(e, m.Settings)
+}
+
+func (m *Monitor) decode(d *Decoder) error {
+	var err error
+	if m.Name, err = d.ReadString(); err != nil {
+		return err
+	}
+	m.Settings, err = decodeMonitorSettings(d)
+	return err
+}
+
+func encodeMonitorSettings(e *Encoder, s MonitorSettings) error {
+	if err := e.WriteBool(s.Bell); err != nil {
+		return err
+	}
+	if err := e.WriteBool(s.Activity); err != nil {
+		return err
+	}
+	if err := e.WriteU32(s.Silence); err != nil {
+		return err
+	}
+	return e.WriteString(s.Pattern)
+}
+
+func decodeMonitorSettings(d *Decoder) (MonitorSettings, error) {
+	var s MonitorSettings
+	var err error
+	if s.Bell, err = d.ReadBool(); err != nil {
+		return s, err
+	}
+	if s.Activity, err = d.ReadBool(); err != nil {
+		return s, err
+	}
+	if s.Silence, err = d.ReadU32(); err != nil {
+		return s, err
+	}
+	s.Pattern, err = d.ReadString()
+	return s, err
+}
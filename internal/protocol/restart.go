@@ -0,0 +1,24 @@
+package protocol
+
+// RestartPolicy selects when a session reruns its command after it exits.
+type RestartPolicy uint8
+
+const (
+	RestartDefault   RestartPolicy = 0 // Daemon's configured policy.
+	RestartNever     RestartPolicy = 1
+	RestartOnFailure RestartPolicy = 2 // Nonzero exit code or killed by a signal.
+	RestartAlways    RestartPolicy = 3
+)
+
+func (p RestartPolicy) String() string {
+	switch p {
+	case RestartNever:
+		return "never"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartAlways:
+		return "always"
+	default:
+		return "default"
+	}
+}
@@ -128,13 +128,13 @@ func New(t testing.TB, command []string, opts ...Option) *Term {
 		t.Fatalf("termtest: new terminal: %v", err)
 	}
 
-	keyEncoder, err := libghostty.NewKeyEncoder()
+	keyEncoder, err := libghostty.NewKeyEncoder(term)
 	if err != nil {
 		term.Close()
 		t.Fatalf("termtest: new key encoder: %v", err)
 	}
 
-	keyEvent, err := libghostty.NewKeyEvent()
+	keyEvent, err := libghostty.NewKeyEvent(term)
 	if err != nil {
 		keyEncoder.Close()
 		term.Close()
@@ -238,7 +238,9 @@ func (tm *Term) Key(key libghostty.Key, mods libghostty.Mods) {
 
 	tm.keyEvent.SetUnshiftedCodepoint(codepoint)
 	tm.keyEvent.SetUTF8("")
-	tm.keyEncoder.SetOptFromTerminal(tm.term)
+	if err := tm.keyEncoder.SetOptFromTerminal(tm.term); err != nil {
+		tm.t.Fatalf("termtest: key encoder options: %v", err)
+	}
 
 	data, err := tm.keyEncoder.Encode(tm.keyEvent)
 	if err != nil {
@@ -15,22 +15,12 @@ func (t *Terminal) GridRef(point Point) (*GridRef, error) {
 	t.rt.mu.Lock()
 	defer t.rt.mu.Unlock()
 
-	pointPtr, err := t.rt.alloc(pointSize)
+	pointPtr, err := t.rt.point(point)
 	if err != nil {
 		return nil, err
 	}
 	defer t.rt.free(pointPtr, pointSize)
 
-	pointData, err := t.rt.bytes(pointPtr, pointSize)
-	if err != nil {
-		return nil, err
-	}
-
-	clear(pointData)
-	binary.LittleEndian.PutUint32(pointData, uint32(point.Tag))
-	binary.LittleEndian.PutUint16(pointData[8:], point.X)
-	binary.LittleEndian.PutUint32(pointData[12:], point.Y)
-
 	refPtr, err := t.rt.alloc(gridRefSize)
 	if err != nil {
 		return nil, err
@@ -64,3 +54,25 @@ func (t *Terminal) GridRef(point Point) (*GridRef, error) {
 
 	return ref, nil
 }
+
+// point writes point into freshly allocated wasm memory; the caller
+// frees it.
+func (r *wasmRuntime) point(point Point) (uint32, error) {
+	ptr, err := r.alloc(pointSize)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := r.bytes(ptr, pointSize)
+	if err != nil {
+		r.free(ptr, pointSize)
+		return 0, err
+	}
+
+	clear(data)
+	binary.LittleEndian.PutUint32(data, uint32(point.Tag))
+	binary.LittleEndian.PutUint16(data[8:], point.X)
+	binary.LittleEndian.PutUint32(data[12:], point.Y)
+
+	return ptr, nil
+}
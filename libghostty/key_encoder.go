@@ -1,6 +1,9 @@
 package libghostty
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"fmt"
+)
 
 type KeyEncoder struct {
 	rt  *wasmRuntime
@@ -41,8 +44,10 @@ const (
 	KeyEncoderOptBackarrowKeyMode        KeyEncoderOption = 7
 )
 
-func NewKeyEncoder() (*KeyEncoder, error) {
-	rt := sharedRuntime()
+// NewKeyEncoder allocates an encoder in t's runtime. The encoder can only
+// be used with t and with key events created for t.
+func NewKeyEncoder(t *Terminal) (*KeyEncoder, error) {
+	rt := t.rt
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
 
@@ -125,14 +130,23 @@ func (enc *KeyEncoder) SetOptOptionAsAlt(value OptionAsAlt) {
 	enc.rt.mod.Xghostty_key_encoder_setopt(int32(enc.ptr), int32(KeyEncoderOptMacOSOptionAsAlt), int32(ptr))
 }
 
-func (enc *KeyEncoder) SetOptFromTerminal(t *Terminal) {
+func (enc *KeyEncoder) SetOptFromTerminal(t *Terminal) error {
+	if t.rt != enc.rt {
+		return fmt.Errorf("libghostty: terminal belongs to a different runtime")
+	}
+
 	enc.rt.mu.Lock()
 	defer enc.rt.mu.Unlock()
 
 	enc.rt.mod.Xghostty_key_encoder_setopt_from_terminal(int32(enc.ptr), int32(t.ptr))
+	return nil
 }
 
 func (enc *KeyEncoder) Encode(event *KeyEvent) ([]byte, error) {
+	if event.rt != enc.rt {
+		return nil, fmt.Errorf("libghostty: key event belongs to a different runtime")
+	}
+
 	enc.rt.mu.Lock()
 	defer enc.rt.mu.Unlock()
 
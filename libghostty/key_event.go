@@ -213,8 +213,10 @@ const (
 	KeyPaste              Key = 175
 )
 
-func NewKeyEvent() (*KeyEvent, error) {
-	rt := sharedRuntime()
+// NewKeyEvent allocates a key event in t's runtime for use with key
+// encoders created for t.
+func NewKeyEvent(t *Terminal) (*KeyEvent, error) {
+	rt := t.rt
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
 
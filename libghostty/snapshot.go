@@ -68,7 +68,7 @@ func newSnapshotDecoderBytes(data []byte) (*SnapshotDecoder, error) {
 		return nil, err
 	}
 
-	rt := sharedRuntime()
+	rt := newWasmRuntime()
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
 
@@ -51,7 +51,7 @@ func NewTerminal(opts ...TerminalOption) (*Terminal, error) {
 		opt(&cfg)
 	}
 
-	rt := sharedRuntime()
+	rt := newWasmRuntime()
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
 
@@ -9,9 +9,24 @@ const (
 	terminalDataCursorY      = 4
 	terminalDataActiveScreen = 6
 	terminalDataPwd          = 13
+	terminalDataForeground   = 18
+	terminalDataBackground   = 19
+	terminalDataPalette      = 21
+	terminalDataMode         = 37
 	terminalDataVTGround     = 38
 )
 
+// Mode is a DEC private mode number, or an ANSI mode number with
+// ModeANSI set.
+type Mode uint16
+
+const ModeANSI Mode = 0x8000
+
+// RGB is a color with 8 bits per channel.
+type RGB struct {
+	R, G, B uint8
+}
+
 type TerminalScreen int
 
 const (
@@ -101,6 +116,111 @@ func (t *Terminal) VTGround() (bool, error) {
 	return value[0] != 0, nil
 }
 
+// Mode reports whether mode is set. known is false for modes the
+// terminal does not implement.
+func (t *Terminal) Mode(mode Mode) (set, known bool, err error) {
+	t.rt.mu.Lock()
+	defer t.rt.mu.Unlock()
+
+	ptr, err := t.rt.alloc(4)
+	if err != nil {
+		return false, false, err
+	}
+	defer t.rt.free(ptr, 4)
+
+	config := make([]byte, 4)
+	binary.LittleEndian.PutUint16(config, uint16(mode))
+	if err := t.rt.put(ptr, config); err != nil {
+		return false, false, err
+	}
+
+	result := t.rt.mod.Xghostty_terminal_get(int32(t.ptr), terminalDataMode, int32(ptr))
+	if err := resultError(result); err != nil {
+		if ghosttyErr, ok := err.(*Error); ok && ghosttyErr.Result == ResultInvalidValue {
+			return false, false, nil
+		}
+
+		return false, false, err
+	}
+
+	value, err := t.rt.bytes(ptr, 4)
+	if err != nil {
+		return false, false, err
+	}
+
+	return value[2] != 0, true, nil
+}
+
+// Foreground returns the foreground color a program set with OSC 10.
+// ok is false while it is unset.
+func (t *Terminal) Foreground() (RGB, bool, error) {
+	return t.getColor(terminalDataForeground)
+}
+
+// Background is Foreground for the background color (OSC 11).
+func (t *Terminal) Background() (RGB, bool, error) {
+	return t.getColor(terminalDataBackground)
+}
+
+// Palette returns the 256-color palette, including changes made with
+// OSC 4.
+func (t *Terminal) Palette() ([256]RGB, error) {
+	var palette [256]RGB
+	const size = uint32(len(palette) * 3)
+
+	t.rt.mu.Lock()
+	defer t.rt.mu.Unlock()
+
+	ptr, err := t.rt.alloc(size)
+	if err != nil {
+		return palette, err
+	}
+	defer t.rt.free(ptr, size)
+
+	result := t.rt.mod.Xghostty_terminal_get(int32(t.ptr), terminalDataPalette, int32(ptr))
+	if err := resultError(result); err != nil {
+		return palette, err
+	}
+
+	value, err := t.rt.bytes(ptr, size)
+	if err != nil {
+		return palette, err
+	}
+
+	for i := range palette {
+		palette[i] = RGB{R: value[i*3], G: value[i*3+1], B: value[i*3+2]}
+	}
+
+	return palette, nil
+}
+
+func (t *Terminal) getColor(data int32) (RGB, bool, error) {
+	t.rt.mu.Lock()
+	defer t.rt.mu.Unlock()
+
+	ptr, err := t.rt.alloc(3)
+	if err != nil {
+		return RGB{}, false, err
+	}
+	defer t.rt.free(ptr, 3)
+
+	result := t.rt.mod.Xghostty_terminal_get(int32(t.ptr), data, int32(ptr))
+	if err := resultError(result); err != nil {
+		if ghosttyErr, ok := err.(*Error); ok && ghosttyErr.Result == ResultNoValue {
+			return RGB{}, false, nil
+		}
+
+		return RGB{}, false, err
+	}
+
+	value, err := t.rt.bytes(ptr, 3)
+	if err != nil {
+		return RGB{}, false, err
+	}
+
+	return RGB{R: value[0], G: value[1], B: value[2]}, true, nil
+}
+
 func (t *Terminal) getUint16(data int32) (uint16, error) {
 	t.rt.mu.Lock()
 	defer t.rt.mu.Unlock()
@@ -0,0 +1,84 @@
+package libghostty
+
+import "encoding/binary"
+
+const pointCoordinateSize = 8
+
+// TrackedGridRef follows a cell as the terminal scrolls, reflows and
+// trims its scrollback. It belongs to the terminal that created it and
+// must be closed before that terminal is.
+type TrackedGridRef struct {
+	rt  *wasmRuntime
+	ptr uint32
+}
+
+// TrackGridRef starts tracking the cell at point.
+func (t *Terminal) TrackGridRef(point Point) (*TrackedGridRef, error) {
+	t.rt.mu.Lock()
+	defer t.rt.mu.Unlock()
+
+	pointPtr, err := t.rt.point(point)
+	if err != nil {
+		return nil, err
+	}
+	defer t.rt.free(pointPtr, pointSize)
+
+	ptr, err := t.rt.opaque(func(slot uint32) int32 {
+		return t.rt.mod.Xghostty_terminal_grid_ref_track(int32(t.ptr), int32(pointPtr), int32(slot))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TrackedGridRef{rt: t.rt, ptr: ptr}, nil
+}
+
+// Point returns where the tracked cell is now, in the coordinates of
+// tag. It reports false once the cell has been trimmed from the
+// scrollback, or when it lies outside the region tag names.
+func (r *TrackedGridRef) Point(tag PointTag) (Point, bool, error) {
+	r.rt.mu.Lock()
+	defer r.rt.mu.Unlock()
+
+	if r.rt.mod.Xghostty_tracked_grid_ref_has_value(int32(r.ptr)) == 0 {
+		return Point{}, false, nil
+	}
+
+	outPtr, err := r.rt.alloc(pointCoordinateSize)
+	if err != nil {
+		return Point{}, false, err
+	}
+	defer r.rt.free(outPtr, pointCoordinateSize)
+
+	result := r.rt.mod.Xghostty_tracked_grid_ref_point(int32(r.ptr), int32(tag), int32(outPtr))
+	if err := resultError(result); err != nil {
+		if ghosttyErr, ok := err.(*Error); ok && ghosttyErr.Result == ResultNoValue {
+			return Point{}, false, nil
+		}
+
+		return Point{}, false, err
+	}
+
+	out, err := r.rt.bytes(outPtr, pointCoordinateSize)
+	if err != nil {
+		return Point{}, false, err
+	}
+
+	return Point{
+		Tag: tag,
+		X:   binary.LittleEndian.Uint16(out),
+		Y:   binary.LittleEndian.Uint32(out[4:]),
+	}, true, nil
+}
+
+func (r *TrackedGridRef) Close() {
+	if r == nil || r.ptr == 0 {
+		return
+	}
+
+	r.rt.mu.Lock()
+	defer r.rt.mu.Unlock()
+
+	r.rt.mod.Xghostty_tracked_grid_ref_free(int32(r.ptr))
+	r.ptr = 0
+}
@@ -10,21 +10,17 @@ import (
 
 //go:generate sh -c "cd internal/wasmvt && shasum -a 256 -c ghostty-vt-small.wasm.sha256 && go tool wasm2go -unsafe -nanbox -pkg wasmvt -o vt.generated.go ghostty-vt-small.wasm"
 
+// wasmRuntime is one instance of the VT module. Each Terminal owns its
+// own runtime, so terminals never contend on each other's lock; objects
+// derived from a terminal (formatters, key encoders, key events) live in
+// the same instance because they exchange pointers into its memory.
 type wasmRuntime struct {
 	mu  sync.Mutex
 	mod *wasmvt.Module
 }
 
-var runtimeState struct {
-	once sync.Once
-	rt   *wasmRuntime
-}
-
-func sharedRuntime() *wasmRuntime {
-	runtimeState.once.Do(func() {
-		runtimeState.rt = &wasmRuntime{mod: wasmvt.New()}
-	})
-	return runtimeState.rt
+func newWasmRuntime() *wasmRuntime {
+	return &wasmRuntime{mod: wasmvt.New()}
 }
 
 func (r *wasmRuntime) alloc(length uint32) (uint32, error) {
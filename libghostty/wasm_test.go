@@ -1,7 +1,10 @@
 package libghostty_test
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 
 	"code.selman.me/hauntty/libghostty"
@@ -128,6 +131,53 @@ func TestTerminalPwd(t *testing.T) {
 	assert.Equal(t, pwd, "file://localhost/tmp/example")
 }
 
+func TestTerminalMode(t *testing.T) {
+	term := newTerminal(t, 80, 24)
+	term.VTWrite([]byte("\x1b[?1049h\x1b[?7l\x1b[4h"))
+
+	tests := []struct {
+		mode  libghostty.Mode
+		set   bool
+		known bool
+	}{
+		{1049, true, true},
+		{7, false, true},
+		{25, true, true},
+		{4 | libghostty.ModeANSI, true, true},
+		{9999, false, false},
+	}
+	for _, tt := range tests {
+		set, known, err := term.Mode(tt.mode)
+		assert.NilError(t, err)
+		assert.Equal(t, set, tt.set, "mode %d", tt.mode)
+		assert.Equal(t, known, tt.known, "mode %d", tt.mode)
+	}
+}
+
+func TestTerminalColors(t *testing.T) {
+	term := newTerminal(t, 80, 24)
+
+	_, ok, err := term.Background()
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+
+	term.VTWrite([]byte("\x1b]11;rgb:10/20/30\x07\x1b]10;#aabbcc\x1b\\\x1b]4;1;rgb:01/02/03\x07"))
+
+	bg, ok, err := term.Background()
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, bg, libghostty.RGB{R: 0x10, G: 0x20, B: 0x30})
+
+	fg, ok, err := term.Foreground()
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, fg, libghostty.RGB{R: 0xaa, G: 0xbb, B: 0xcc})
+
+	palette, err := term.Palette()
+	assert.NilError(t, err)
+	assert.Equal(t, palette[1], libghostty.RGB{R: 1, G: 2, B: 3})
+}
+
 func TestFormatterSelection(t *testing.T) {
 	term := newTerminal(t, 5, 2)
 	term.VTWrite([]byte("one\r\ntwo"))
@@ -148,6 +198,44 @@ func TestFormatterSelection(t *testing.T) {
 	assert.DeepEqual(t, formatted, []byte("two"))
 }
 
+func TestTrackedGridRef(t *testing.T) {
+	term, err := libghostty.NewTerminal(libghostty.WithSize(10, 3), libghostty.WithMaxScrollbackLines(0))
+	assert.NilError(t, err)
+	t.Cleanup(term.Close)
+	term.VTWrite([]byte("one\r\ntwo\r\nthree"))
+
+	ref, err := term.TrackGridRef(libghostty.Point{Tag: libghostty.PointTagActive, X: 2, Y: 1})
+	assert.NilError(t, err)
+	defer ref.Close()
+
+	point, ok, err := ref.Point(libghostty.PointTagActive)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, point, libghostty.Point{Tag: libghostty.PointTagActive, X: 2, Y: 1})
+
+	term.VTWrite([]byte("\r\nfour"))
+	point, ok, err = ref.Point(libghostty.PointTagActive)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, point.Y, uint32(0))
+
+	term.VTWrite([]byte("\r\nfive"))
+	_, ok, err = ref.Point(libghostty.PointTagActive)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+	point, ok, err = ref.Point(libghostty.PointTagScreen)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, point.Y, uint32(1))
+
+	for range 10000 {
+		term.VTWrite([]byte("\r\nmore"))
+	}
+	_, ok, err = ref.Point(libghostty.PointTagScreen)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+}
+
 func TestFormatterBuf(t *testing.T) {
 	term := newTerminal(t, 80, 24)
 	term.VTWrite([]byte("hello"))
@@ -180,11 +268,11 @@ func TestFormatterBuf(t *testing.T) {
 func TestKeyEncoder(t *testing.T) {
 	term := newTerminal(t, 80, 24)
 
-	encoder, err := libghostty.NewKeyEncoder()
+	encoder, err := libghostty.NewKeyEncoder(term)
 	assert.NilError(t, err)
 	defer encoder.Close()
 
-	event, err := libghostty.NewKeyEvent()
+	event, err := libghostty.NewKeyEvent(term)
 	assert.NilError(t, err)
 	defer event.Close()
 
@@ -205,7 +293,7 @@ func TestKeyEncoder(t *testing.T) {
 	assert.Equal(t, event.UnshiftedCodepoint(), 'c')
 	assert.Equal(t, event.UTF8(), "")
 
-	encoder.SetOptFromTerminal(term)
+	assert.NilError(t, encoder.SetOptFromTerminal(term))
 	data, err := encoder.Encode(event)
 	assert.NilError(t, err)
 	assert.DeepEqual(t, data, []byte{3})
@@ -214,7 +302,7 @@ func TestKeyEncoder(t *testing.T) {
 	event.SetKey(libghostty.KeyArrowUp)
 	event.SetMods(libghostty.ModCtrl | libghostty.ModShift)
 	event.SetUnshiftedCodepoint(0)
-	encoder.SetOptFromTerminal(term)
+	assert.NilError(t, encoder.SetOptFromTerminal(term))
 
 	data, err = encoder.Encode(event)
 	assert.NilError(t, err)
@@ -266,3 +354,51 @@ func TestSnapshotRetainsUnfinishedContinuation(t *testing.T) {
 	)
 	assert.DeepEqual(t, formatted, []byte("beforered"))
 }
+
+func TestKeyEncoderRejectsOtherTerminal(t *testing.T) {
+	term := newTerminal(t, 80, 24)
+	other := newTerminal(t, 80, 24)
+
+	encoder, err := libghostty.NewKeyEncoder(term)
+	assert.NilError(t, err)
+	defer encoder.Close()
+
+	event, err := libghostty.NewKeyEvent(other)
+	assert.NilError(t, err)
+	defer event.Close()
+
+	assert.ErrorContains(t, encoder.SetOptFromTerminal(other), "different runtime")
+
+	_, err = encoder.Encode(event)
+	assert.ErrorContains(t, err, "different runtime")
+}
+
+func BenchmarkTerminalFeedSessions(b *testing.B) {
+	chunk := bytes.Repeat([]byte("\x1b[32mhello\x1b[0m world 0123456789\r\n"), 1024)
+
+	for _, sessions := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("sessions=%d", sessions), func(b *testing.B) {
+			terms := make([]*libghostty.Terminal, sessions)
+			for i := range terms {
+				term, err := libghostty.NewTerminal(
+					libghostty.WithSize(120, 40),
+					libghostty.WithMaxScrollbackLines(1000),
+				)
+				assert.NilError(b, err)
+				b.Cleanup(term.Close)
+				terms[i] = term
+			}
+
+			b.SetBytes(int64(len(chunk) * sessions))
+			for b.Loop() {
+				var wg sync.WaitGroup
+				for _, term := range terms {
+					wg.Go(func() {
+						term.VTWrite(chunk)
+					})
+				}
+				wg.Wait()
+			}
+		})
+	}
+}